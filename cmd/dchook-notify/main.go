@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -29,6 +30,9 @@ const (
 	subcommandStatus = "status"
 	subcommandList   = "list"
 
+	signatureSchemeDchook  = "dchook"
+	signatureSchemeRFC9421 = "rfc9421"
+
 	exitSuccess = 0
 
 	exitConfigError  = 1 // Missing URL, secret file, invalid algorithm
@@ -39,6 +43,7 @@ const (
 	exitUnauthorized       = 41 // 401
 	exitForbidden          = 43 // 403
 	exitNotFound           = 44 // 404
+	exitConflict           = 49 // 409
 	exitPayloadTooLarge    = 13 // 413
 	exitRateLimited        = 29 // 429
 	exitServerError        = 50 // 500
@@ -50,15 +55,88 @@ var (
 	version = "dev"
 	commit  = "unknown"
 
-	url         = flag.String("u", "", "Webhook endpoint URL")
-	secretFile  = flag.String("s", "", "Path to webhook secret file")
-	algorithm   = flag.String("a", "", "Hash algorithm (sha256, sha384, sha512)")
+	url        = flag.String("u", "", "Webhook endpoint URL")
+	secretFile = flag.String("s", "", "Path to webhook secret file")
+	keyFile    = flag.String("k", "", "Path to Ed25519 private key file")
+	keyID      = flag.String(
+		"key-id",
+		"",
+		"Key ID of the secret in the listener key set, or the client name",
+	)
+	algorithm = flag.String("a", "", "Signature algorithm (sha256, sha384, sha512, ed25519)")
+	project   = flag.String("project", "", "Project to deploy on a listener with several projects")
+	services  = flag.String("services", "", "Comma-separated services to deploy (default: all)")
+	profiles  = flag.String("profiles", "", "Comma-separated compose profiles to enable")
+	images    = flag.String("images", "", "Comma-separated service=image@digest pins")
+	ref       = flag.String("ref", "", "Git branch or tag to deploy (default: the listener ref)")
+	override  = flag.String("override", "", "Reason to deploy outside the listener schedule")
+	scheme    = flag.String(
+		"signature-scheme",
+		"",
+		"Signature scheme (dchook or rfc9421)",
+	)
+	caCertFile     = flag.String("cacert", "", "Path to CA bundle for verifying the listener (PEM)")
+	clientCertFile = flag.String("cert", "", "Path to TLS client certificate file (PEM)")
+	clientKeyFile  = flag.String("key", "", "Path to TLS client private key file (PEM)")
+	oidcAudience   = flag.String(
+		"oidc-audience",
+		"",
+		"Authenticate with a CI OIDC token for this audience instead of signing",
+	)
+	otlpEndpoint = flag.String(
+		"otlp-endpoint",
+		"",
+		"OTLP/HTTP endpoint to export the deploy trace to (e.g. http://localhost:4318)",
+	)
 	quiet       = flag.Bool("q", false, "Quiet mode (suppress output, return only exit code)")
 	jsonOutput  = flag.Bool("j", false, "JSON output mode (machine-readable)")
 	showVersion = flag.Bool("version", false, "Show version information")
 	showHelp    = flag.Bool("help", false, "Show help message")
 )
 
+// signer signs requests with either a shared HMAC secret or an Ed25519 private key.
+// Signatures carry the key ID, if any. If message is set, requests are signed with
+// RFC 9421 message signatures instead of dchook signatures. If bearerToken is set,
+// requests are not signed but carry the OIDC token instead.
+type signer struct {
+	algorithm   string
+	secret      string
+	keyID       string
+	privateKey  ed25519.PrivateKey
+	message     *dchook.MessageSigner
+	bearerToken string
+}
+
+func (s *signer) sign(payload []byte) string {
+	if s.algorithm == dchook.AlgorithmEd25519 {
+		return dchook.FormatSignatureKeyID(
+			dchook.GenerateEd25519Signature(payload, s.privateKey),
+			s.keyID,
+		)
+	}
+	return dchook.FormatSignatureKeyID(
+		dchook.GenerateSignature(payload, s.secret, s.algorithm),
+		s.keyID,
+	)
+}
+
+// signRequest adds RFC 9421 Signature-Input and Signature headers to the request.
+func (s *signer) signRequest(req *http.Request, body []byte) error {
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	return s.message.Sign(req, body, nonce, time.Now())
+}
+
+func newNonce() (string, error) {
+	nonceBytes := make([]byte, nonceSize)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return "", fmt.Errorf("error generating nonce: %w", err)
+	}
+	return hex.EncodeToString(nonceBytes), nil
+}
+
 func haltf(code int, format string, args ...any) {
 	if !*quiet {
 		//nolint:gosec
@@ -152,12 +230,54 @@ Options:
 Note that -q takes precedence over -j.
 
 Environment Variables:
-  DCHOOK_URL           *    Webhook endpoint URL
-  DCHOOK_SECRET_FILE   *    Path to webhook secret file
-  DCHOOK_ALGORITHM          Hash algorithm: sha256, sha384, sha512
-                            (default: sha256)
-
-Variables marked with * are required.
+  DCHOOK_URL              *    Webhook endpoint URL
+  DCHOOK_SECRET_FILE      +    Path to webhook secret file
+  DCHOOK_PRIVATE_KEY_FILE +    Path to Ed25519 private key file
+  DCHOOK_ALGORITHM             Signature algorithm: sha256, sha384, sha512,
+                               ed25519 (default: sha256, or ed25519 when only
+                               a private key file is provided)
+  DCHOOK_KEY_ID                Key ID of the secret in the listener key set
+                               (HMAC only), or the name of the client in the
+                               listener client registry
+  DCHOOK_PROJECT               Project to deploy or query on a listener that
+                               manages several projects (default: the
+                               default project)
+  DCHOOK_SERVICES              Comma-separated services to pull and restart,
+                               if permitted by the listener (default: all
+                               services)
+  DCHOOK_PROFILES              Comma-separated compose profiles to enable in
+                               addition to the listener default profiles, if
+                               permitted by the listener
+  DCHOOK_IMAGES                Comma-separated service=repository@digest
+                               pins of service images, if permitted by the
+                               listener
+  DCHOOK_REF                   Git branch or tag to check out before
+                               deploying, if permitted by the listener
+                               (default: the listener ref)
+  DCHOOK_OVERRIDE              Reason to deploy outside the listener
+                               deployment windows or during a freeze, if
+                               permitted by the listener; recorded on the
+                               deployment
+  DCHOOK_SIGNATURE_SCHEME      Signature scheme: dchook or rfc9421 (RFC 9421
+                               HTTP Message Signatures, sha256 or ed25519
+                               only) (default: dchook)
+  DCHOOK_OIDC_AUDIENCE         Authenticate with a GitHub Actions OIDC token
+                               for this audience instead of signing
+  DCHOOK_OIDC_TOKEN            Authenticate with this OIDC token instead of
+                               signing (e.g. a GitLab CI ID token)
+  DCHOOK_TLS_CACERT            Path to CA bundle for verifying the listener
+                               certificate (PEM) (default: system roots)
+  DCHOOK_TLS_CERT              Path to TLS client certificate file (PEM)
+  DCHOOK_TLS_KEY               Path to TLS client private key file (PEM)
+                               (required with DCHOOK_TLS_CERT)
+  DCHOOK_OTLP_ENDPOINT         OTLP/HTTP endpoint to export the deploy request
+                               span to (e.g. http://localhost:4318)
+  TRACEPARENT                  W3C trace context of the calling CI job; the
+                               deploy request continues this trace
+
+Variables marked with * are required. Unless an OIDC token is used, one of the
+variables marked with + is required: the private key file for ed25519, the
+secret file otherwise.
 
 Examples:
   # Deploy with environment variables
@@ -180,7 +300,41 @@ Examples:
 
   # With password manager (process substitution)
   %s -s <(pass show webhook-secret) deploy payload.json
-`, progName, progName, progName, progName, progName, progName, progName)
+
+  # Sign with an Ed25519 private key
+  %s -k /path/to/deploy.key deploy payload.json
+
+  # Sign with a named secret from the listener key set during rotation
+  %s -s /path/to/new-secret -key-id 2026-10 deploy payload.json
+
+  # Deploy a project on a listener that manages several projects
+  %s -project shop deploy payload.json
+
+  # Deploy only the web and worker services
+  %s -services web,worker deploy payload.json
+
+  # Deploy with the worker compose profile enabled
+  %s -profiles worker deploy payload.json
+
+  # Deploy the web service pinned to the image digest that CI pushed
+  %s -services web -images web=ghcr.io/org/web@sha256:<digest> deploy payload.json
+
+  # Deploy the compose files of a release tag
+  %s -ref v1.2.0 deploy payload.json
+
+  # Deploy an emergency fix during a change freeze
+  %s -override "Fix checkout outage (INC-42)" deploy payload.json
+
+  # Sign with RFC 9421 HTTP Message Signatures
+  %s -signature-scheme rfc9421 deploy payload.json
+
+  # Authenticate with a GitHub Actions OIDC token (requires id-token: write)
+  %s -oidc-audience https://hook.example.com deploy payload.json
+
+  # Connect with a TLS client certificate to a listener with a private CA
+  %s -cacert ca.pem -cert client.pem -key client.key deploy payload.json
+`, progName, progName, progName, progName, progName, progName, progName, progName, progName,
+		progName, progName, progName, progName, progName, progName, progName, progName, progName)
 }
 
 func deployCommand(args []string) {
@@ -189,7 +343,7 @@ func deployCommand(args []string) {
 		os.Exit(exitConfigError)
 	}
 
-	baseURL, secret, algo := getConfig()
+	baseURL, requestSigner := getConfig()
 
 	bodyFile := args[0]
 	payloadBody, err := readPayloadBody(bodyFile)
@@ -205,12 +359,33 @@ func deployCommand(args []string) {
 		payload = string(payloadBody)
 	}
 
+	metadata := map[string]any{
+		"version":   version,
+		"commit":    commit,
+		"timestamp": strconv.FormatInt(time.Now().UnixMicro(), 10),
+	}
+	if projectName := getProject(); projectName != "" {
+		metadata["project"] = projectName
+	}
+	if serviceNames := getServices(); len(serviceNames) > 0 {
+		metadata["services"] = serviceNames
+	}
+	if profileNames := getProfiles(); len(profileNames) > 0 {
+		metadata["profiles"] = profileNames
+	}
+	if imagePins := getImages(); len(imagePins) > 0 {
+		metadata["images"] = imagePins
+	}
+	//nolint:errcheck // Optional
+	if gitRef, _ := dchook.FlagValue(*ref, "DCHOOK_REF", "-ref"); gitRef != "" {
+		metadata["ref"] = gitRef
+	}
+	//nolint:errcheck // Optional
+	if reason, _ := dchook.FlagValue(*override, "DCHOOK_OVERRIDE", "-override"); reason != "" {
+		metadata["override"] = reason
+	}
 	envelope := map[string]any{
-		"dchook": map[string]any{
-			"version":   version,
-			"commit":    commit,
-			"timestamp": strconv.FormatInt(time.Now().UnixMicro(), 10),
-		},
+		"dchook":  metadata,
 		"payload": payload,
 	}
 
@@ -219,12 +394,10 @@ func deployCommand(args []string) {
 		haltf(exitPayloadError, "Error serializing envelope: %v", err)
 	}
 
-	signature := dchook.GenerateSignature(body, secret, algo)
-
 	req, err := http.NewRequestWithContext(
 		context.Background(),
 		http.MethodPost,
-		baseURL+"/deploy",
+		baseURL+deployPath(),
 		strings.NewReader(string(body)),
 	)
 	if err != nil {
@@ -233,10 +406,22 @@ func deployCommand(args []string) {
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Dchook-Signature", signature)
 
-	client := &http.Client{}
+	switch {
+	case requestSigner.bearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+requestSigner.bearerToken)
+	case requestSigner.message != nil:
+		if err := requestSigner.signRequest(req, body); err != nil {
+			haltf(exitRequestError, "Error signing request: %v", err)
+		}
+	default:
+		req.Header.Set("Dchook-Signature", requestSigner.sign(body))
+	}
+
+	client := newHTTPClient()
+	endTrace := traceRequest(req)
 	resp, err := client.Do(req) //nolint:gosec // Controlled input
+	endTrace(resp, err)
 	if err != nil {
 		haltf(exitRequestError, "Error sending webhook: %v", err)
 	}
@@ -307,6 +492,8 @@ func handleDeployResponse(resp *http.Response, respBody []byte) {
 			haltf(exitForbidden, "%s", msg)
 		case http.StatusNotFound:
 			haltf(exitNotFound, "%s", msg)
+		case http.StatusConflict:
+			haltf(exitConflict, "%s", msg)
 		case http.StatusRequestEntityTooLarge:
 			haltf(exitPayloadTooLarge, "%s", msg)
 		case http.StatusTooManyRequests:
@@ -340,7 +527,7 @@ func handleAcceptedDeploy(resp *http.Response, respBody []byte) {
 	}
 }
 
-func getConfig() (string, string, string) {
+func getConfig() (string, *signer) {
 	webhookURL, err := dchook.FlagValue(*url, "DCHOOK_URL", "-u")
 	if err != nil {
 		haltf(exitConfigError, "%v", err)
@@ -364,66 +551,155 @@ func getConfig() (string, string, string) {
 	// Strip trailing slash to avoid double slashes when constructing paths
 	webhookURL = strings.TrimSuffix(webhookURL, "/")
 
-	secretFilePath, err := dchook.FlagValue(*secretFile, "DCHOOK_SECRET_FILE", "-s")
-	if err != nil {
-		haltf(exitConfigError, "%v", err)
+	if requestSigner := getOIDCSigner(); requestSigner != nil {
+		return webhookURL, requestSigner
 	}
 
-	secret, err := dchook.ReadSecretFileLax(secretFilePath)
+	requestSigner := getSigner()
+	requestSigner.message = getMessageSigner(requestSigner)
+	return webhookURL, requestSigner
+}
+
+// getOIDCSigner returns a signer with a CI OIDC token if an OIDC audience or token is
+// configured, or nil to sign requests.
+func getOIDCSigner() *signer {
+	//nolint:errcheck // Optional
+	audience, _ := dchook.FlagValue(*oidcAudience, "DCHOOK_OIDC_AUDIENCE", "-oidc-audience")
+	if audience == "" && os.Getenv("DCHOOK_OIDC_TOKEN") == "" {
+		return nil
+	}
+
+	token, err := fetchOIDCToken(audience)
 	if err != nil {
-		haltf(exitConfigError, "%v", err)
+		haltf(exitConfigError, "Error: %v", err)
+	}
+	return &signer{bearerToken: token}
+}
+
+// getMessageSigner returns an RFC 9421 message signer for the signer if the rfc9421
+// signature scheme is selected, or nil for dchook signatures.
+func getMessageSigner(requestSigner *signer) *dchook.MessageSigner {
+	//nolint:errcheck // Optional
+	signatureScheme, _ := dchook.FlagValue(*scheme, "DCHOOK_SIGNATURE_SCHEME", "")
+
+	switch signatureScheme {
+	case "", signatureSchemeDchook:
+		return nil
+	case signatureSchemeRFC9421:
+	default:
+		haltf(
+			exitConfigError,
+			"Error: Invalid signature scheme '%s' (must be %s or %s)",
+			signatureScheme,
+			signatureSchemeDchook,
+			signatureSchemeRFC9421,
+		)
+	}
+
+	messageAlgorithm := dchook.MessageAlgorithm(requestSigner.algorithm)
+	if messageAlgorithm == "" {
+		haltf(
+			exitConfigError,
+			"Error: RFC 9421 signatures require the %s or %s algorithm",
+			dchook.AlgorithmSHA256,
+			dchook.AlgorithmEd25519,
+		)
 	}
 
+	return &dchook.MessageSigner{
+		Algorithm:  messageAlgorithm,
+		KeyID:      requestSigner.keyID,
+		Secret:     requestSigner.secret,
+		PrivateKey: requestSigner.privateKey,
+	}
+}
+
+func getSigner() *signer {
+	//nolint:errcheck // Optional
+	privateKeyFilePath, _ := dchook.FlagValue(*keyFile, "DCHOOK_PRIVATE_KEY_FILE", "-k")
+
 	algo, err := dchook.FlagValue(*algorithm, "DCHOOK_ALGORITHM", "-a")
 	if err != nil {
 		algo = dchook.AlgorithmSHA256
+
+		//nolint:errcheck // Optional
+		if secretFilePath, _ := dchook.FlagValue(
+			*secretFile,
+			"DCHOOK_SECRET_FILE",
+			"-s",
+		); secretFilePath == "" && privateKeyFilePath != "" {
+			algo = dchook.AlgorithmEd25519
+		}
 	}
 
-	if algo != dchook.AlgorithmSHA256 && algo != dchook.AlgorithmSHA384 &&
-		algo != dchook.AlgorithmSHA512 {
+	//nolint:errcheck // Optional
+	signatureKeyID, _ := dchook.FlagValue(*keyID, "DCHOOK_KEY_ID", "-key-id")
+	if signatureKeyID != "" {
+		if err := dchook.ValidateKeyID(signatureKeyID); err != nil {
+			haltf(exitConfigError, "Error: %v", err)
+		}
+	}
+
+	if algo == dchook.AlgorithmEd25519 {
+		if privateKeyFilePath == "" {
+			haltf(
+				exitConfigError,
+				"%v: DCHOOK_PRIVATE_KEY_FILE environment variable or -k flag is required",
+				dchook.ErrFlagRequired,
+			)
+		}
+
+		privateKey, err := dchook.ReadPrivateKeyFileLax(privateKeyFilePath)
+		if err != nil {
+			haltf(exitConfigError, "%v", err)
+		}
+
+		return &signer{algorithm: algo, privateKey: privateKey, keyID: signatureKeyID}
+	}
+
+	if !dchook.IsHMACAlgorithm(algo) {
 		haltf(
 			exitConfigError,
-			"Error: Invalid algorithm '%s' (must be %s, %s, or %s)",
+			"Error: Invalid algorithm '%s' (must be %s, %s, %s, or %s)",
 			algo,
 			dchook.AlgorithmSHA256,
 			dchook.AlgorithmSHA384,
 			dchook.AlgorithmSHA512,
+			dchook.AlgorithmEd25519,
 		)
 	}
 
-	return webhookURL, secret, algo
-}
-
-func makeStatusRequest(endpoint, payload, secret, algo string) {
-	timestamp := strconv.FormatInt(time.Now().UnixMicro(), 10)
+	secretFilePath, err := dchook.FlagValue(*secretFile, "DCHOOK_SECRET_FILE", "-s")
+	if err != nil {
+		haltf(exitConfigError, "%v", err)
+	}
 
-	var signaturePayload string
-	var nonce string
-	if payload != "" {
-		signaturePayload = timestamp + ":" + payload
-	} else {
-		nonceBytes := make([]byte, nonceSize)
-		if _, err := rand.Read(nonceBytes); err != nil {
-			haltf(exitRequestError, "Error generating nonce: %v", err)
-		}
-		nonce = hex.EncodeToString(nonceBytes)
-		signaturePayload = timestamp + ":" + nonce
+	secret, err := dchook.ReadSecretFileLax(secretFilePath)
+	if err != nil {
+		haltf(exitConfigError, "%v", err)
 	}
 
-	signature := dchook.GenerateSignature([]byte(signaturePayload), secret, algo)
+	return &signer{algorithm: algo, secret: secret, keyID: signatureKeyID}
+}
 
+func makeStatusRequest(endpoint, payload string, requestSigner *signer) {
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, endpoint, nil)
 	if err != nil {
 		haltf(exitRequestError, "Error creating request: %v", err)
 	}
 
-	req.Header.Set("X-Dchook-Timestamp", timestamp)
-	req.Header.Set("X-Dchook-Signature", signature)
-	if nonce != "" {
-		req.Header.Set("X-Dchook-Nonce", nonce)
+	switch {
+	case requestSigner.bearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+requestSigner.bearerToken)
+	case requestSigner.message != nil:
+		if err := requestSigner.signRequest(req, nil); err != nil {
+			haltf(exitRequestError, "Error signing request: %v", err)
+		}
+	default:
+		setStatusSignature(req, payload, requestSigner)
 	}
 
-	client := &http.Client{}
+	client := newHTTPClient()
 	resp, err := client.Do(req) //nolint:gosec // Controlled input
 	if err != nil {
 		haltf(exitRequestError, "Error sending request: %v", err)
@@ -446,6 +722,8 @@ func makeStatusRequest(endpoint, payload, secret, algo string) {
 		switch resp.StatusCode {
 		case http.StatusUnauthorized:
 			haltf(exitUnauthorized, "%s", msg)
+		case http.StatusForbidden:
+			haltf(exitForbidden, "%s", msg)
 		case http.StatusNotFound:
 			haltf(exitNotFound, "%s", msg)
 		default:
@@ -454,15 +732,44 @@ func makeStatusRequest(endpoint, payload, secret, algo string) {
 	}
 }
 
+// setStatusSignature adds the X-Dchook-* headers signing `timestamp:payload`, or
+// `timestamp:nonce` if there is no payload.
+func setStatusSignature(req *http.Request, payload string, requestSigner *signer) {
+	timestamp := strconv.FormatInt(time.Now().UnixMicro(), 10)
+
+	var signaturePayload string
+	var nonce string
+	if payload != "" {
+		signaturePayload = timestamp + ":" + payload
+	} else {
+		var err error
+		nonce, err = newNonce()
+		if err != nil {
+			haltf(exitRequestError, "Error: %v", err)
+		}
+		signaturePayload = timestamp + ":" + nonce
+	}
+
+	req.Header.Set("X-Dchook-Timestamp", timestamp)
+	req.Header.Set("X-Dchook-Signature", requestSigner.sign([]byte(signaturePayload)))
+	if nonce != "" {
+		req.Header.Set("X-Dchook-Nonce", nonce)
+	}
+}
+
 func statusCommand(args []string) {
 	if len(args) != 1 {
 		fmt.Fprintf(os.Stderr, "Usage: dchook-notify status <deployment-id>\n")
 		os.Exit(exitConfigError)
 	}
 
-	baseURL, secret, algo := getConfig()
+	baseURL, requestSigner := getConfig()
 	deploymentID := args[0]
-	makeStatusRequest(baseURL+"/deploy/status/"+deploymentID, deploymentID, secret, algo)
+	makeStatusRequest(
+		baseURL+deployPath()+"/status/"+deploymentID,
+		deploymentID,
+		requestSigner,
+	)
 }
 
 func listCommand(args []string) {
@@ -471,6 +778,82 @@ func listCommand(args []string) {
 		os.Exit(exitConfigError)
 	}
 
-	baseURL, secret, algo := getConfig()
-	makeStatusRequest(baseURL+"/deploy/status", "", secret, algo)
+	baseURL, requestSigner := getConfig()
+	makeStatusRequest(baseURL+deployPath()+"/status/", "", requestSigner)
+}
+
+// getProject returns the project name from -project or DCHOOK_PROJECT, or an empty
+// string for the default project. Project names are lowercase letters, digits, dashes,
+// and underscores.
+func getProject() string {
+	//nolint:errcheck // Optional
+	projectName, _ := dchook.FlagValue(*project, "DCHOOK_PROJECT", "-project")
+	if strings.Trim(projectName, "abcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		haltf(exitConfigError, "Error: invalid project name %q", projectName)
+	}
+	return projectName
+}
+
+// getServices returns the services to deploy from -services or DCHOOK_SERVICES, or nil
+// for all services.
+func getServices() []string {
+	//nolint:errcheck // Optional
+	value, _ := dchook.FlagValue(*services, "DCHOOK_SERVICES", "-services")
+
+	var serviceNames []string
+	for svc := range strings.SplitSeq(value, ",") {
+		if svc = strings.TrimSpace(svc); svc != "" {
+			serviceNames = append(serviceNames, svc)
+		}
+	}
+	return serviceNames
+}
+
+// getProfiles returns the compose profiles to enable from -profiles or DCHOOK_PROFILES,
+// or nil for the listener default profiles.
+func getProfiles() []string {
+	//nolint:errcheck // Optional
+	value, _ := dchook.FlagValue(*profiles, "DCHOOK_PROFILES", "-profiles")
+
+	var profileNames []string
+	for profile := range strings.SplitSeq(value, ",") {
+		if profile = strings.TrimSpace(profile); profile != "" {
+			profileNames = append(profileNames, profile)
+		}
+	}
+	return profileNames
+}
+
+// getImages returns the image pins from -images or DCHOOK_IMAGES, as a map of service
+// to `repository@digest` image reference, or nil if no images are pinned.
+func getImages() map[string]string {
+	//nolint:errcheck // Optional
+	value, _ := dchook.FlagValue(*images, "DCHOOK_IMAGES", "-images")
+
+	var imagePins map[string]string
+	for entry := range strings.SplitSeq(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		svc, image, found := strings.Cut(entry, "=")
+		if !found || svc == "" || !strings.Contains(image, "@") {
+			haltf(exitConfigError, "Error: invalid image pin %q (want service=image@digest)", entry)
+		}
+
+		if imagePins == nil {
+			imagePins = make(map[string]string)
+		}
+		imagePins[svc] = image
+	}
+	return imagePins
+}
+
+// deployPath returns the path of the deploy endpoint: `/deploy`, or `/deploy/{project}`
+// for a project.
+func deployPath() string {
+	if projectName := getProject(); projectName != "" {
+		return "/deploy/" + projectName
+	}
+	return "/deploy"
 }
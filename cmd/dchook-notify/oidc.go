@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"time"
+)
+
+const (
+	oidcRequestTimeout = 30 * time.Second
+	maxOIDCResponse    = 64 * 1024
+)
+
+var (
+	errOIDCUnavailable = errors.New(
+		"no OIDC token: set DCHOOK_OIDC_TOKEN or run in GitHub Actions with " +
+			"id-token: write permission",
+	)
+	errOIDCResponse = errors.New("invalid OIDC token response")
+)
+
+// fetchOIDCToken returns the OIDC token from DCHOOK_OIDC_TOKEN, which CI systems such as
+// GitLab can set directly, or requests one for the audience from the GitHub Actions
+// token endpoint.
+func fetchOIDCToken(audience string) (string, error) {
+	if token := os.Getenv("DCHOOK_OIDC_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", errOIDCUnavailable
+	}
+
+	endpoint, err := neturl.Parse(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errOIDCResponse, err)
+	}
+
+	if audience != "" {
+		query := endpoint.Query()
+		query.Set("audience", audience)
+		endpoint.RawQuery = query.Encode()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating OIDC token request: %w", err)
+	}
+	req.Header.Set("Authorization", "bearer "+requestToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req) //nolint:gosec // Controlled input
+	if err != nil {
+		return "", fmt.Errorf("error requesting OIDC token: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // Best effort close in defer
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d", errOIDCResponse, resp.StatusCode)
+	}
+
+	var tokenResponse struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponse)).
+		Decode(&tokenResponse); err != nil {
+		return "", fmt.Errorf("%w: %w", errOIDCResponse, err)
+	}
+
+	if tokenResponse.Value == "" {
+		return "", fmt.Errorf("%w: empty token", errOIDCResponse)
+	}
+	return tokenResponse.Value, nil
+}
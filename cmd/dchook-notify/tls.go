@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+// newHTTPClient returns an HTTP client for the listener that verifies the listener
+// certificate with the configured CA bundle, if any, and presents the configured client
+// certificate, if any.
+func newHTTPClient() *http.Client {
+	//nolint:errcheck // Optional
+	caCertPath, _ := dchook.FlagValue(*caCertFile, "DCHOOK_TLS_CACERT", "-cacert")
+	//nolint:errcheck // Optional
+	certPath, _ := dchook.FlagValue(*clientCertFile, "DCHOOK_TLS_CERT", "-cert")
+	//nolint:errcheck // Optional
+	keyPath, _ := dchook.FlagValue(*clientKeyFile, "DCHOOK_TLS_KEY", "-key")
+
+	if caCertPath == "" && certPath == "" && keyPath == "" {
+		return &http.Client{}
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caCertPath != "" {
+		pool, err := dchook.ReadCertPoolLax(caCertPath)
+		if err != nil {
+			haltf(exitConfigError, "Error: %v", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certPath != "" || keyPath != "" {
+		if certPath == "" || keyPath == "" {
+			haltf(exitConfigError, "Error: -cert and -key must be used together")
+		}
+
+		certificate, err := dchook.ReadCertificateLax(certPath, keyPath)
+		if err != nil {
+			haltf(exitConfigError, "Error: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{*certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+// traceExportTimeout bounds how long the span is sent to the collector before exiting.
+const traceExportTimeout = 5 * time.Second
+
+var errDeployRejected = errors.New("deployment rejected")
+
+// traceRequest starts a client span for the deploy request when `-otlp-endpoint` or
+// DCHOOK_OTLP_ENDPOINT is set, as a child of the W3C trace context in TRACEPARENT, if
+// any, and propagates it to the listener in the traceparent header. Without an OTLP
+// endpoint, TRACEPARENT is propagated unchanged. The returned function ends the span
+// with the response status or error and sends it to the collector.
+func traceRequest(req *http.Request) func(resp *http.Response, err error) {
+	//nolint:errcheck // Optional
+	endpoint, _ := dchook.FlagValue(*otlpEndpoint, "DCHOOK_OTLP_ENDPOINT", "-otlp-endpoint")
+	parent, parentErr := dchook.ParseTraceparent(os.Getenv("TRACEPARENT"))
+
+	if endpoint == "" {
+		if parentErr == nil {
+			req.Header.Set(dchook.TraceparentHeader, parent.Traceparent())
+		}
+		return func(*http.Response, error) {}
+	}
+
+	exporter, err := dchook.NewOTLPExporter(endpoint, "dchook-notify", version)
+	if err != nil {
+		haltf(exitConfigError, "Error: %v", err)
+	}
+
+	ctx := req.Context()
+	if parentErr == nil {
+		ctx = dchook.ContextWithRemoteSpanContext(ctx, parent)
+	}
+
+	tracer := dchook.NewTracer(exporter, nil)
+	_, span := tracer.Start(ctx, "dchook-notify deploy", dchook.SpanKindClient)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.full", req.URL.String())
+	req.Header.Set(dchook.TraceparentHeader, span.SpanContext().Traceparent())
+
+	return func(resp *http.Response, err error) {
+		if err != nil {
+			span.SetError(err)
+		} else {
+			span.SetAttribute("http.response.status_code", resp.StatusCode)
+			if resp.StatusCode != dchook.DeployAcceptedStatus {
+				span.SetError(fmt.Errorf("%w: status %d", errDeployRejected, resp.StatusCode))
+			}
+		}
+		span.End()
+
+		ctx, cancel := context.WithTimeout(context.Background(), traceExportTimeout)
+		defer cancel()
+
+		if err := tracer.Shutdown(ctx); err != nil && !*quiet {
+			fmt.Fprintf(os.Stderr, "Warning: failed to export trace: %v\n", err)
+		}
+	}
+}
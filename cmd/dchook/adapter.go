@@ -5,24 +5,87 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	dockerVersionTimeout = 5 * time.Second
+
+	// dockerConfigTimeout bounds the `docker compose config` commands that deploy
+	// requests wait for, such as listing services.
+	dockerConfigTimeout = 10 * time.Second
+
+	// dockerStopDelay is how long an interrupted docker command has to stop before it is
+	// killed.
+	dockerStopDelay = 10 * time.Second
+
+	// shellSafeChars are the characters that formatCommand does not quote.
+	shellSafeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-+=@%:,./"
+)
+
+var (
+	errCheckoutFailed = errors.New("git checkout failed")
+	errPullFailed     = errors.New("docker compose pull failed")
+	errUpFailed       = errors.New("docker compose up failed")
 )
 
-const dockerVersionTimeout = 5 * time.Second
+// writeDeploymentFile writes the content of a file generated for a deployment to a new
+// temporary file named after the pattern (see os.CreateTemp). Returns the path of the
+// file, which the caller must remove.
+func writeDeploymentFile(pattern, content string) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create deployment file: %w", err)
+	}
+
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()           //nolint:errcheck,gosec // Already failing
+		os.Remove(file.Name()) //nolint:errcheck,gosec // Already failing
+		return "", fmt.Errorf("failed to write deployment file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name()) //nolint:errcheck,gosec // Already failing
+		return "", fmt.Errorf("failed to write deployment file: %w", err)
+	}
+	return file.Name(), nil
+}
 
 // ContainerAdapter manages container deployments.
 type ContainerAdapter interface {
 	Available() error
-	Deploy(deployment *Deployment, history *DeploymentHistory)
+	Deploy(
+		ctx context.Context,
+		deployment *Deployment,
+		history *DeploymentHistory,
+		tracker *DeploymentTracker,
+	) error
+	Images(ctx context.Context) ([]string, error)
+	Services(ctx context.Context, profiles []string) ([]string, error)
 }
 
 // DockerComposeAdapter implements ContainerAdapter using docker compose.
 type DockerComposeAdapter struct {
-	ComposeFile    string
+	ComposeFile string
+	// OverlayFiles are compose files applied over ComposeFile, in order.
+	OverlayFiles   []string
 	ProjectName    string
 	ExceptServices []string
+	// EnvFiles are passed to docker compose with --env-file, in order, if set.
+	EnvFiles []string
+	// Profiles are enabled with --profile, in order.
+	Profiles []string
+	// OverrideFile is a compose file applied over the compose and overlay files, if set.
+	OverrideFile string
+	// Git updates the compose file directory before each deployment, if set.
+	Git *gitSource
 }
 
 func (d *DockerComposeAdapter) Available() error {
@@ -43,19 +106,77 @@ func (d *DockerComposeAdapter) Available() error {
 	return nil
 }
 
-func (d *DockerComposeAdapter) Deploy(deployment *Deployment, history *DeploymentHistory) {
-	go func() {
+// Deploy pulls and restarts the services asynchronously with the tracker, in a span
+// that continues the trace of the request span in ctx, after checking out the compose
+// file directory from git if a git source is configured. The work tree stays locked
+// until the services are restarted, so concurrent deployments of the directory cannot
+// check out another commit in between. Pinned images and payload variables are applied
+// with generated files that are removed when the deployment finishes. ctx is not used
+// for cancellation; running docker commands are stopped if the deployment is
+// interrupted by shutdown.
+func (d *DockerComposeAdapter) Deploy(
+	ctx context.Context,
+	deployment *Deployment,
+	history *DeploymentHistory,
+	tracker *DeploymentTracker,
+) error {
+	_, span := dchook.StartSpan(ctx, "deployment")
+	span.SetAttribute("dchook.deployment.id", deployment.ID)
+
+	err := tracker.Go(deployment.ID, func(ctx context.Context) {
+		ctx = dchook.ContextWithSpan(ctx, span)
+		defer span.End()
+
+		if d.Git != nil {
+			unlock := d.Git.lock()
+			defer unlock()
+
+			if !d.executeCheckout(ctx, deployment) {
+				history.Update(deployment.ID, func(d *Deployment) {
+					d.Status = statusFailed
+					d.Ref = deployment.Ref
+					d.Commit = deployment.Commit
+					d.Checkout = deployment.Checkout
+				})
+				span.SetAttribute("dchook.deployment.status", statusFailed)
+				span.SetError(errCheckoutFailed)
+				return
+			}
+
+			history.Update(deployment.ID, func(d *Deployment) {
+				d.Ref = deployment.Ref
+				d.Commit = deployment.Commit
+				d.Checkout = deployment.Checkout
+			})
+		}
+
+		adapter, cleanup, err := d.forDeployment(deployment)
+		if err != nil {
+			slog.Error("deployment failed", "deployment_id", deployment.ID, "error", err)
+			deployment.Pull = &DeploymentResult{ExitCode: 1, Output: err.Error()}
+			history.Update(deployment.ID, func(d *Deployment) {
+				d.Status = statusFailed
+				d.Pull = deployment.Pull
+			})
+			span.SetAttribute("dchook.deployment.status", statusFailed)
+			span.SetError(err)
+			return
+		}
+		defer cleanup()
+
 		// Update status to pulling
 		history.Update(deployment.ID, func(d *Deployment) {
 			d.Status = statusPulling
 		})
 
 		// Pull
-		if !d.executePull(deployment) {
+		if !adapter.executePull(ctx, deployment) {
 			history.Update(deployment.ID, func(d *Deployment) {
 				d.Status = statusFailed
 				d.Pull = deployment.Pull
 			})
+			span.SetAttribute("dchook.deployment.status", statusFailed)
+			span.SetError(errPullFailed)
 			return
 		}
 
@@ -66,7 +187,7 @@ func (d *DockerComposeAdapter) Deploy(deployment *Deployment, history *Deploymen
 		})
 
 		// Restart
-		d.executeRestart(deployment)
+		adapter.executeRestart(ctx, deployment)
 
 		// Update final status
 		status := statusComplete
@@ -77,12 +198,191 @@ func (d *DockerComposeAdapter) Deploy(deployment *Deployment, history *Deploymen
 			d.Status = status
 			d.Restart = deployment.Restart
 		})
-	}()
+
+		span.SetAttribute("dchook.deployment.status", status)
+		if status == statusFailed {
+			span.SetError(errUpFailed)
+		}
+	})
+	if err != nil {
+		span.SetError(err)
+		span.End()
+	}
+	return err
+}
+
+// forDeployment returns the adapter for the deployment: a copy that enables the profiles
+// selected by the deployment and applies an override file pinning the images of the
+// deployment and an env file with its payload variables, if any. cleanup removes the
+// generated files.
+func (d *DockerComposeAdapter) forDeployment(
+	deployment *Deployment,
+) (*DockerComposeAdapter, func(), error) {
+	adapter := d.withProfiles(deployment.Profiles)
+	var files []string
+	cleanup := func() {
+		for _, file := range files {
+			os.Remove(file) //nolint:errcheck,gosec // Best effort cleanup
+		}
+	}
+
+	if len(deployment.Images) > 0 {
+		overrideFile, err := writeImageOverride(deployment.ID, deployment.Images)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, overrideFile)
+		adapter.OverrideFile = overrideFile
+	}
+
+	if len(deployment.Environment) > 0 {
+		envFile, err := writeEnvFile(deployment.ID, deployment.Environment)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		files = append(files, envFile)
+		adapter.EnvFiles = append(d.defaultEnvFiles(), envFile)
+	}
+	return adapter, cleanup, nil
+}
+
+// withProfiles returns a copy of the adapter that enables the profiles after the default
+// profiles.
+func (d *DockerComposeAdapter) withProfiles(profiles []string) *DockerComposeAdapter {
+	adapter := *d
+	if len(profiles) > 0 {
+		adapter.Profiles = slices.Clone(d.Profiles)
+		for _, profile := range profiles {
+			if !slices.Contains(adapter.Profiles, profile) {
+				adapter.Profiles = append(adapter.Profiles, profile)
+			}
+		}
+	}
+	return &adapter
+}
+
+// composeFiles returns the compose file and the overlay files, in order.
+func (d *DockerComposeAdapter) composeFiles() []string {
+	return append([]string{d.ComposeFile}, d.OverlayFiles...)
+}
+
+// defaultEnvFiles returns the env files that docker compose reads without --env-file:
+// the configured env files, or `.env` in the compose file directory if it exists.
+// Passing --env-file replaces the default `.env`, so it must be passed explicitly.
+func (d *DockerComposeAdapter) defaultEnvFiles() []string {
+	if len(d.EnvFiles) > 0 {
+		return slices.Clone(d.EnvFiles)
+	}
+
+	envFile := filepath.Join(filepath.Dir(d.ComposeFile), ".env")
+	if info, err := os.Stat(envFile); err == nil && info.Mode().IsRegular() {
+		return []string{envFile}
+	}
+	return nil
+}
+
+// executeCheckout checks out the ref of the deployment, or the configured ref, and
+// validates the new commit with validateCheckout. The caller must hold the lock of the
+// work tree.
+func (d *DockerComposeAdapter) executeCheckout(
+	ctx context.Context,
+	deployment *Deployment,
+) bool {
+	ctx, span := dchook.StartSpan(ctx, "git checkout")
+	defer span.End()
+
+	if deployment.Ref == "" {
+		deployment.Ref = d.Git.ref
+	}
+
+	start := time.Now()
+	commit, output, err := d.Git.checkout(ctx, deployment.Ref, func(ctx context.Context) error {
+		return d.validateCheckout(ctx, deployment)
+	})
+	duration := time.Since(start)
+
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else {
+			exitCode = 1
+		}
+	}
+
+	deployment.Commit = commit
+	deployment.Checkout = &DeploymentResult{
+		ExitCode:   exitCode,
+		Output:     string(output),
+		DurationMs: duration.Milliseconds(),
+	}
+
+	span.SetAttribute("vcs.ref.head.name", deployment.Ref)
+	span.SetAttribute("vcs.ref.head.revision", commit)
+	span.SetError(err)
+
+	if err != nil {
+		slog.Error(
+			"deployment checkout failed",
+			"deployment_id",
+			deployment.ID,
+			"ref",
+			deployment.Ref,
+			"commit",
+			commit,
+			"output",
+			string(output),
+			"error",
+			err,
+		)
+		return false
+	}
+
+	slog.Info(
+		"deployment checkout complete",
+		"deployment_id",
+		deployment.ID,
+		"ref",
+		deployment.Ref,
+		"commit",
+		commit,
+		"duration_ms",
+		duration.Milliseconds(),
+	)
+	return true
 }
 
-func (d *DockerComposeAdapter) executePull(deployment *Deployment) bool {
+// validateCheckout validates the compose and overlay files of a checked out commit, and
+// that the services that the deployment selects or pins images for are deployed by
+// them. Deploy requests are checked against these files here rather than when they are
+// received, as the files depend on the ref that is checked out.
+func (d *DockerComposeAdapter) validateCheckout(
+	ctx context.Context,
+	deployment *Deployment,
+) error {
+	if _, err := validateComposeFiles(d.composeFiles()); err != nil {
+		return err
+	}
+
+	if len(deployment.Services) == 0 && len(deployment.Images) == 0 {
+		return nil
+	}
+
+	deployed, err := d.Services(ctx, deployment.Profiles)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errComposeServices, err)
+	}
+	return checkDeployed(deployed, deployment.Services, deployment.Images)
+}
+
+func (d *DockerComposeAdapter) executePull(ctx context.Context, deployment *Deployment) bool {
+	ctx, span := dchook.StartSpan(ctx, "docker compose pull")
+	defer span.End()
+
 	start := time.Now()
-	pullOutput, pullErr := d.pull()
+	pullOutput, pullErr := d.pull(ctx, deployment.Services)
 	pullDuration := time.Since(start)
 
 	pullExitCode := 0
@@ -101,13 +401,18 @@ func (d *DockerComposeAdapter) executePull(deployment *Deployment) bool {
 		DurationMs: pullDuration.Milliseconds(),
 	}
 
+	pullCommand := d.formatCommand(append([]string{"pull"}, deployment.Services...)...)
+	span.SetAttribute("process.command_line", pullCommand)
+	span.SetAttribute("process.exit.code", pullExitCode)
+	span.SetError(pullErr)
+
 	if pullErr != nil {
 		slog.Error(
 			"deployment pull failed",
 			"deployment_id",
 			deployment.ID,
 			"command",
-			d.formatCommand("pull"),
+			pullCommand,
 			"exit_code",
 			pullExitCode,
 			"output",
@@ -122,15 +427,20 @@ func (d *DockerComposeAdapter) executePull(deployment *Deployment) bool {
 		"deployment pull complete",
 		"deployment_id",
 		deployment.ID,
+		"command",
+		pullCommand,
 		"duration_ms",
 		pullDuration.Milliseconds(),
 	)
 	return true
 }
 
-func (d *DockerComposeAdapter) executeRestart(deployment *Deployment) {
+func (d *DockerComposeAdapter) executeRestart(ctx context.Context, deployment *Deployment) {
+	ctx, span := dchook.StartSpan(ctx, "docker compose up")
+	defer span.End()
+
 	start := time.Now()
-	upOutput, upErr := d.restart()
+	upOutput, upErr := d.restart(ctx, deployment.Services)
 	upDuration := time.Since(start)
 
 	upExitCode := 0
@@ -149,13 +459,20 @@ func (d *DockerComposeAdapter) executeRestart(deployment *Deployment) {
 		DurationMs: upDuration.Milliseconds(),
 	}
 
+	upCommand := d.formatCommand(
+		append([]string{"up", "-d", "--remove-orphans"}, deployment.Services...)...,
+	)
+	span.SetAttribute("process.command_line", upCommand)
+	span.SetAttribute("process.exit.code", upExitCode)
+	span.SetError(upErr)
+
 	if upErr != nil {
 		slog.Error(
 			"deployment up failed",
 			"deployment_id",
 			deployment.ID,
 			"command",
-			d.formatCommand("up", "-d", "--remove-orphans"),
+			upCommand,
 			"exit_code",
 			upExitCode,
 			"output",
@@ -168,6 +485,8 @@ func (d *DockerComposeAdapter) executeRestart(deployment *Deployment) {
 			"deployment complete",
 			"deployment_id",
 			deployment.ID,
+			"command",
+			upCommand,
 			"pull_duration_ms",
 			deployment.Pull.DurationMs,
 			"up_duration_ms",
@@ -176,15 +495,21 @@ func (d *DockerComposeAdapter) executeRestart(deployment *Deployment) {
 	}
 }
 
-func (d *DockerComposeAdapter) pull() ([]byte, error) {
-	return d.runDocker("pull")
+// pull pulls the images of the selected services, or of all services if none are
+// selected.
+func (d *DockerComposeAdapter) pull(ctx context.Context, services []string) ([]byte, error) {
+	return d.runDocker(ctx, append([]string{"pull"}, services...)...)
 }
 
-func (d *DockerComposeAdapter) restart() ([]byte, error) {
+// restart recreates the selected services, or all services without excepted services if
+// none are selected.
+func (d *DockerComposeAdapter) restart(ctx context.Context, services []string) ([]byte, error) {
 	args := []string{"up", "-d", "--remove-orphans"}
 
-	if len(d.ExceptServices) > 0 {
-		services, err := d.getServices()
+	if len(services) > 0 {
+		args = append(args, services...)
+	} else if len(d.ExceptServices) > 0 {
+		services, err := d.getServices(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get services: %w", err)
 		}
@@ -195,11 +520,43 @@ func (d *DockerComposeAdapter) restart() ([]byte, error) {
 		}
 	}
 
-	return d.runDocker(args...)
+	return d.runDocker(ctx, args...)
+}
+
+// Images returns the images used by the services in the compose file. The command is
+// stopped when ctx is done or after dockerConfigTimeout.
+func (d *DockerComposeAdapter) Images(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dockerConfigTimeout)
+	defer cancel()
+
+	return d.configList(ctx, "--images")
+}
+
+// Services returns the services deployed from the compose file with the default profiles
+// and the profiles enabled, without excepted services. The command is stopped when ctx
+// is done or after dockerConfigTimeout.
+func (d *DockerComposeAdapter) Services(
+	ctx context.Context,
+	profiles []string,
+) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dockerConfigTimeout)
+	defer cancel()
+
+	services, err := d.withProfiles(profiles).getServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return d.filterServices(services), nil
+}
+
+func (d *DockerComposeAdapter) getServices(ctx context.Context) ([]string, error) {
+	return d.configList(ctx, "--services")
 }
 
-func (d *DockerComposeAdapter) getServices() ([]string, error) {
-	output, err := d.runDocker("config", "--services")
+// configList runs `docker compose config` with a listing flag and returns its output
+// lines.
+func (d *DockerComposeAdapter) configList(ctx context.Context, flag string) ([]string, error) {
+	output, err := d.runDocker(ctx, "config", flag)
 	if err != nil {
 		return nil, err
 	}
@@ -229,11 +586,17 @@ func (d *DockerComposeAdapter) filterServices(services []string) []string {
 	return filtered
 }
 
-func (d *DockerComposeAdapter) runDocker(commandArgs ...string) ([]byte, error) {
+func (d *DockerComposeAdapter) runDocker(
+	ctx context.Context,
+	commandArgs ...string,
+) ([]byte, error) {
 	args := d.buildArgs(commandArgs...)
 
 	//nolint:gosec // parameters do docker compose are validated
-	cmd := exec.CommandContext(context.Background(), "docker", args...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	// Give docker compose a chance to stop cleanly when the deployment is interrupted.
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = dockerStopDelay
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return output, fmt.Errorf("docker compose command failed: %w", err)
@@ -241,15 +604,41 @@ func (d *DockerComposeAdapter) runDocker(commandArgs ...string) ([]byte, error)
 	return output, nil
 }
 
+// formatCommand formats the resolved docker command for logs, quoting arguments for a
+// POSIX shell where needed.
 func (d *DockerComposeAdapter) formatCommand(commandArgs ...string) string {
 	args := append([]string{"docker"}, d.buildArgs(commandArgs...)...)
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
 	return strings.Join(args, " ")
 }
 
 func (d *DockerComposeAdapter) buildArgs(commandArgs ...string) []string {
-	args := []string{"compose", "-f", d.ComposeFile}
+	args := []string{"compose"}
+	for _, envFile := range d.EnvFiles {
+		args = append(args, "--env-file", envFile)
+	}
+	for _, composeFile := range d.composeFiles() {
+		args = append(args, "-f", composeFile)
+	}
+	if d.OverrideFile != "" {
+		args = append(args, "-f", d.OverrideFile)
+	}
 	if d.ProjectName != "" {
 		args = append(args, "-p", d.ProjectName)
 	}
+	for _, profile := range d.Profiles {
+		args = append(args, "--profile", profile)
+	}
 	return append(args, commandArgs...)
 }
+
+// shellQuote single-quotes the argument unless it only has characters that a POSIX
+// shell does not interpret.
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, shellSafeChars) == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"context"
+	"sync/atomic"
+)
+
 // MockAdapter implements ContainerAdapter for testing.
 type MockAdapter struct {
 	AvailableErr  error
@@ -7,13 +12,33 @@ type MockAdapter struct {
 	PullErr       error
 	RestartOutput []byte
 	RestartErr    error
+	ImageList     []string
+	ImagesErr     error
+	ServiceList   []string
+	ServicesErr   error
+	// ServicesCalls counts the calls to Services.
+	ServicesCalls atomic.Int32
 }
 
 func (m *MockAdapter) Available() error {
 	return m.AvailableErr
 }
 
-func (m *MockAdapter) Deploy(deployment *Deployment, history *DeploymentHistory) {
+func (m *MockAdapter) Images(_ context.Context) ([]string, error) {
+	return m.ImageList, m.ImagesErr
+}
+
+func (m *MockAdapter) Services(_ context.Context, _ []string) ([]string, error) {
+	m.ServicesCalls.Add(1)
+	return m.ServiceList, m.ServicesErr
+}
+
+func (m *MockAdapter) Deploy(
+	_ context.Context,
+	deployment *Deployment,
+	history *DeploymentHistory,
+	_ *DeploymentTracker,
+) error {
 	deployment.Pull = &DeploymentResult{
 		ExitCode:   0,
 		Output:     string(m.PullOutput),
@@ -22,7 +47,7 @@ func (m *MockAdapter) Deploy(deployment *Deployment, history *DeploymentHistory)
 	if m.PullErr != nil {
 		deployment.Pull.ExitCode = 1
 		history.Add(*deployment)
-		return
+		return nil
 	}
 
 	deployment.Restart = &DeploymentResult{
@@ -35,4 +60,5 @@ func (m *MockAdapter) Deploy(deployment *Deployment, history *DeploymentHistory)
 	}
 
 	history.Add(*deployment)
+	return nil
 }
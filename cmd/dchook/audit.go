@@ -0,0 +1,308 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+// Audit log events.
+const (
+	auditAuthFailed          = "auth_failed"
+	auditAccessDenied        = "access_denied"
+	auditReplayDetected      = "replay_detected"
+	auditVersionMismatch     = "version_mismatch"
+	auditBadRequest          = "bad_request"
+	auditIPBanned            = "ip_banned"
+	auditScheduleClosed      = "schedule_closed"
+	auditDeploymentTriggered = "deployment_triggered"
+	auditDeploymentFinished  = "deployment_finished"
+
+	endpointStatus = "status"
+
+	// auditAlgorithmToken is recorded as the algorithm of requests that authenticate with
+	// a plain token or password instead of a signature.
+	auditAlgorithmToken = "token"
+
+	auditFileMode = 0o600
+
+	// maxAuditRecordSize bounds the length of a record line when verifying.
+	maxAuditRecordSize = 1 << 20
+)
+
+var (
+	errAuditChain   = errors.New("audit log chain broken")
+	errAuditRecord  = errors.New("invalid audit log record")
+	errAuditCommand = errors.New("unknown audit command")
+)
+
+// auditGenesisHash is the previous hash of the first record in an audit log.
+var auditGenesisHash = strings.Repeat("0", 2*sha256.Size)
+
+// AuditRecord is a line in the audit log. PrevHash is the SHA-256 of the previous line
+// (without the newline), so that a deleted, inserted, reordered, or edited record breaks
+// the chain.
+type AuditRecord struct {
+	Sequence     uint64 `json:"seq"`
+	Time         string `json:"time"`
+	Event        string `json:"event"`
+	Endpoint     string `json:"endpoint,omitempty"`
+	IP           string `json:"ip,omitempty"`
+	IPSource     string `json:"ip_source,omitempty"`
+	Identity     string `json:"identity,omitempty"`
+	TLSSubject   string `json:"tls_subject,omitempty"`
+	KeyID        string `json:"key_id,omitempty"`
+	Algorithm    string `json:"algorithm,omitempty"`
+	DeploymentID string `json:"deployment_id,omitempty"`
+	Status       string `json:"status,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	PrevHash     string `json:"prev_hash"`
+}
+
+// AuditLog appends hash-chained records of authentication and deployment events to a
+// file. A nil AuditLog records nothing.
+type AuditLog struct {
+	mutex    sync.Mutex
+	file     *os.File
+	sequence uint64
+	lastHash string
+}
+
+// OpenAuditLog opens the audit log for appending, creating it with mode 0600 if needed,
+// and continues the chain from its last record. Returns an error if the existing chain
+// is broken.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	//nolint:gosec // The audit log path is configured by the operator
+	file, err := os.OpenFile(
+		filepath.Clean(path),
+		os.O_RDWR|os.O_APPEND|os.O_CREATE,
+		auditFileMode,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+
+	records, lastHash, err := verifyAuditLog(file)
+	if err != nil {
+		file.Close() //nolint:errcheck,gosec // The audit log is unusable
+		return nil, fmt.Errorf("%q: %w", path, err)
+	}
+
+	return &AuditLog{file: file, sequence: records, lastHash: lastHash}, nil
+}
+
+// Record appends the record with the next sequence number, the current time, and the
+// hash of the previous record. Write errors are logged.
+func (a *AuditLog) Record(record AuditRecord) {
+	if a == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	record.Sequence = a.sequence + 1
+	record.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	record.PrevHash = a.lastHash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("failed to encode audit record", "event", record.Event, "error", err)
+		return
+	}
+
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		slog.Error("failed to write audit record", "event", record.Event, "error", err)
+		return
+	}
+	if err := a.file.Sync(); err != nil {
+		slog.Error("failed to sync audit log", "error", err)
+	}
+
+	a.sequence = record.Sequence
+	a.lastHash = hashAuditLine(line)
+}
+
+// Head returns the number of records and the hash of the last record.
+func (a *AuditLog) Head() (uint64, string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.sequence, a.lastHash
+}
+
+// Close closes the audit log file.
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.file.Close() //nolint:wrapcheck // Closing the file is the only operation
+}
+
+// loadAuditLog opens the audit log from `--audit-log` or DCHOOK_AUDIT_LOG. Returns nil
+// if the audit log is not configured.
+func loadAuditLog() (*AuditLog, error) {
+	//nolint:errcheck // Optional
+	path, _ := dchook.FlagValue(*auditLogFile, "DCHOOK_AUDIT_LOG", "--audit-log")
+	if path == "" {
+		return nil, nil
+	}
+
+	auditLog, err := OpenAuditLog(path)
+	if err != nil {
+		return nil, err
+	}
+
+	records, head := auditLog.Head()
+	slog.Info("audit log opened", "path", path, "records", records, "head", head)
+	return auditLog, nil
+}
+
+// failed returns a copy of the record for the failure event, with the reason.
+func (record AuditRecord) failed(event, reason string) AuditRecord {
+	record.Event = event
+	record.Reason = reason
+	return record
+}
+
+// recordFailure records a failed request from the client IP of the record with the
+// limiter, and writes the record and the resulting ban, if any, to the audit log.
+func (cfg *HandlerConfig) recordFailure(limiter *dchook.RateLimiter, record AuditRecord) {
+	banned := limiter.RecordFailure(record.IP)
+	cfg.audit.Record(record)
+
+	if banned {
+		cfg.audit.Record(AuditRecord{
+			Event:    auditIPBanned,
+			Endpoint: record.Endpoint,
+			IP:       record.IP,
+			IPSource: record.IPSource,
+			Reason:   record.Event,
+		})
+	}
+}
+
+// verifyAuditLog checks that each record has the next sequence number and the hash of
+// the previous record. Returns the number of records and the hash of the last record.
+func verifyAuditLog(reader io.Reader) (uint64, string, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxAuditRecordSize)
+
+	var records uint64
+	lastHash := auditGenesisHash
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		records++
+
+		var record AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return 0, "", fmt.Errorf("%w: line %d: %w", errAuditRecord, records, err)
+		}
+
+		if record.Sequence != records {
+			return 0, "", fmt.Errorf(
+				"%w: line %d has sequence %d",
+				errAuditChain,
+				records,
+				record.Sequence,
+			)
+		}
+
+		if record.PrevHash != lastHash {
+			return 0, "", fmt.Errorf(
+				"%w: line %d does not follow the previous record",
+				errAuditChain,
+				records,
+			)
+		}
+		lastHash = hashAuditLine(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, "", fmt.Errorf("read audit log: %w", err)
+	}
+	return records, lastHash, nil
+}
+
+// signatureAuditEvent returns the audit event for a failed signature or token check.
+func signatureAuditEvent(err error) string {
+	switch {
+	case errors.Is(err, errOIDCReplay), errors.Is(err, errMessageSignatureReplay):
+		return auditReplayDetected
+	case errors.Is(err, errOIDCNotPermitted):
+		return auditAccessDenied
+	default:
+		return auditAuthFailed
+	}
+}
+
+func hashAuditLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditCommand runs `dchook audit verify [file]`, which verifies the audit log chain of
+// the file, or of the audit log set by flag, environment variable, or configuration
+// file, and reports the number of records and the hash of the last record. Returns the
+// exit code.
+func auditCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "verify" || len(args) > 2 {
+		//nolint:errcheck,gosec // Writing to stderr
+		fmt.Fprintf(stderr, "Error: %v\nUsage: dchook audit verify [file]\n", errAuditCommand)
+		return 1
+	}
+
+	var path string
+	if len(args) == 2 {
+		path = args[1]
+	} else {
+		if _, err := loadConfigFile(); err != nil {
+			//nolint:errcheck,gosec // Writing to stderr
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+
+		//nolint:errcheck // Optional
+		path, _ = dchook.FlagValue(*auditLogFile, "DCHOOK_AUDIT_LOG", "--audit-log")
+	}
+
+	if path == "" {
+		//nolint:errcheck,gosec // Writing to stderr
+		fmt.Fprintln(stderr, "Error: no audit log file given or configured")
+		return 1
+	}
+
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		//nolint:errcheck,gosec // Writing to stderr
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer file.Close() //nolint:errcheck // Best effort close in defer
+
+	records, lastHash, err := verifyAuditLog(file)
+	if err != nil {
+		//nolint:errcheck,gosec // Writing to stderr
+		fmt.Fprintf(stderr, "✗ %s: %v\n", path, err)
+		return 1
+	}
+
+	//nolint:errcheck,gosec // Writing to stdout
+	fmt.Fprintf(stdout, "✓ %s: %d records, head %s\n", path, records, lastHash)
+	return 0
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func readAuditRecords(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []AuditRecord
+	for line := range strings.SplitSeq(strings.TrimSpace(string(data)), "\n") {
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLogChain(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog.Record(AuditRecord{Event: auditAuthFailed, IP: "192.0.2.1"})
+	auditLog.Record(AuditRecord{Event: auditIPBanned, IP: "192.0.2.1"})
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != auditFileMode {
+		t.Errorf("mode = %o, want %o", mode, auditFileMode)
+	}
+
+	// Reopening continues the chain.
+	auditLog, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog.Record(AuditRecord{Event: auditDeploymentTriggered, DeploymentID: "abc"})
+	records, head := auditLog.Head()
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if records != 3 {
+		t.Errorf("records = %d, want 3", records)
+	}
+
+	written := readAuditRecords(t, path)
+	if len(written) != 3 {
+		t.Fatalf("got %d records, want 3", len(written))
+	}
+	if written[0].PrevHash != auditGenesisHash {
+		t.Errorf("first prev_hash = %q, want genesis hash", written[0].PrevHash)
+	}
+	for i, record := range written {
+		if record.Sequence != uint64(i+1) {
+			t.Errorf("record %d seq = %d", i, record.Sequence)
+		}
+		if record.Time == "" {
+			t.Errorf("record %d has no time", i)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := auditCommand([]string{"verify", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("audit verify = %d, stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "3 records, head "+head) {
+		t.Errorf("audit verify output = %q", stdout.String())
+	}
+}
+
+func TestVerifyAuditLogTampering(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		auditLog.Record(AuditRecord{Event: auditAuthFailed, IP: ip})
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSpace(string(data)), "\n")
+
+	tests := []struct {
+		name   string
+		tamper func([]string) []string
+		want   error
+	}{
+		{
+			name: "edited",
+			tamper: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], "192.0.2.2", "192.0.2.9", 1)
+				return lines
+			},
+			want: errAuditChain,
+		},
+		{
+			name: "deleted",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+			want: errAuditChain,
+		},
+		{
+			name: "reordered",
+			tamper: func(lines []string) []string {
+				lines[0], lines[1] = lines[1], lines[0]
+				return lines
+			},
+			want: errAuditChain,
+		},
+		{
+			name: "garbled",
+			tamper: func(lines []string) []string {
+				lines[2] = "not json\n"
+				return lines
+			},
+			want: errAuditRecord,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			tampered := testCase.tamper(append([]string(nil), lines...))
+			_, _, err := verifyAuditLog(strings.NewReader(strings.Join(tampered, "")))
+			if !errors.Is(err, testCase.want) {
+				t.Errorf("verifyAuditLog() error = %v, want %v", err, testCase.want)
+			}
+
+			tamperedPath := filepath.Join(t.TempDir(), "audit.log")
+			if err := os.WriteFile(
+				tamperedPath,
+				[]byte(strings.Join(tampered, "")),
+				auditFileMode,
+			); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := OpenAuditLog(tamperedPath); !errors.Is(err, testCase.want) {
+				t.Errorf("OpenAuditLog() error = %v, want %v", err, testCase.want)
+			}
+		})
+	}
+}
+
+func TestAuditCommandUsage(t *testing.T) {
+	t.Parallel()
+
+	var stdout, stderr bytes.Buffer
+	if code := auditCommand([]string{"check"}, &stdout, &stderr); code != 1 {
+		t.Errorf("audit check = %d, want 1", code)
+	}
+	if !strings.Contains(stderr.String(), "Usage: dchook audit verify") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+}
+
+func TestDeployHandlerAudit(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() }) //nolint:errcheck,gosec // Test cleanup
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		secret:            "test-secret",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+		deployments:       NewDeploymentTracker(),
+		audit:             auditLog,
+	}
+	store := NewConfigStore(cfg, nil)
+	limiter := dchook.NewRateLimiter(10, time.Minute, 2, time.Hour, time.Hour)
+	handler := createDeployHandler(store, limiter)
+
+	for range 2 {
+		request := httptest.NewRequest(http.MethodPost, "/deploy", strings.NewReader("{}"))
+		request.RemoteAddr = "192.0.2.1:1234"
+		request.Header.Set("Dchook-Signature", "sha256:"+strings.Repeat("0", 64))
+		handler(httptest.NewRecorder(), request)
+	}
+
+	records := readAuditRecords(t, path)
+	events := make([]string, 0, len(records))
+	for _, record := range records {
+		events = append(events, record.Event)
+		if record.IP != "192.0.2.1" || record.Endpoint != endpointDeploy {
+			t.Errorf("record = %+v, want deploy record for 192.0.2.1", record)
+		}
+	}
+
+	want := []string{auditAuthFailed, auditAuthFailed, auditIPBanned}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+	if records[0].Algorithm != dchook.AlgorithmSHA256 {
+		t.Errorf("algorithm = %q, want %q", records[0].Algorithm, dchook.AlgorithmSHA256)
+	}
+}
+
+func TestWebhookHandlerAudit(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() }) //nolint:errcheck,gosec // Test cleanup
+
+	secret := "webhook-secret"
+	push := `{"object_kind":"push","ref":"refs/heads/main"}`
+
+	github, cfg := newForgeTestHandler(t, githubReceiver, &forgeConfig{
+		rules:  []eventRule{{event: "push"}},
+		secret: secret,
+	}, nil)
+	cfg.audit = auditLog
+	sendForgeRequest(github, "/deploy/github", push, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-GitHub-Delivery":   "d-1",
+		"X-Hub-Signature-256": "sha256=" + strings.Repeat("0", 64),
+	})
+
+	gitlab, cfg := newForgeTestHandler(t, gitlabReceiver, &forgeConfig{
+		rules:  []eventRule{{event: "push"}},
+		secret: secret,
+	}, nil)
+	cfg.audit = auditLog
+	sendForgeRequest(gitlab, "/deploy/gitlab", push, map[string]string{
+		"X-Gitlab-Event":      "Push Hook",
+		"X-Gitlab-Event-Uuid": "u-1",
+		"X-Gitlab-Token":      secret,
+	})
+
+	cfg.registrySecret = secret
+	cfg.adapter = &MockAdapter{ImageList: []string{"localhost:5000/app:latest"}}
+	registry := createRegistryHandler(
+		NewConfigStore(cfg, nil),
+		dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour),
+	)
+	body := `{"events":[{"id":"e-1","action":"push","target":{"repository":"app",` +
+		`"tag":"latest"},"request":{"host":"localhost:5000"}}]}`
+	sendForgeRequest(registry, "/deploy/registry", body, map[string]string{
+		"Authorization": "Bearer " + secret,
+	})
+
+	body = strings.Replace(body, "e-1", "e-2", 1)
+	timestamp := strconv.FormatInt(time.Now().UnixMicro(), 10)
+	sendForgeRequest(registry, "/deploy/registry", body, map[string]string{
+		"X-Dchook-Timestamp": timestamp,
+		"Dchook-Signature": dchook.GenerateSignature(
+			[]byte(timestamp+":"+body),
+			secret,
+			dchook.AlgorithmSHA256,
+		),
+	})
+
+	want := []AuditRecord{
+		{Event: auditAuthFailed, Identity: "github", Algorithm: dchook.AlgorithmSHA256},
+		{Event: auditDeploymentTriggered, Identity: "gitlab", Algorithm: auditAlgorithmToken},
+		{
+			Event:     auditDeploymentTriggered,
+			Identity:  registrySourceDistribution,
+			Algorithm: auditAlgorithmToken,
+		},
+		{
+			Event:     auditDeploymentTriggered,
+			Identity:  registrySourceDistribution,
+			Algorithm: dchook.AlgorithmSHA256,
+		},
+	}
+
+	records := readAuditRecords(t, path)
+	if len(records) != len(want) {
+		t.Fatalf("records = %+v, want %d records", records, len(want))
+	}
+	for i, record := range records {
+		if record.Event != want[i].Event || record.Identity != want[i].Identity ||
+			record.Algorithm != want[i].Algorithm {
+			t.Errorf(
+				"record %d = %s %q %q, want %s %q %q",
+				i,
+				record.Event,
+				record.Identity,
+				record.Algorithm,
+				want[i].Event,
+				want[i].Identity,
+				want[i].Algorithm,
+			)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	actionDeploy   = "deploy"
+	actionStatus   = "status"
+	actionList     = "list"
+	actionOverride = "override"
+
+	credentialHMAC    = "hmac:"
+	credentialEd25519 = "ed25519:"
+
+	minClientFields = 3
+	maxClientFields = 4
+)
+
+var (
+	errClientLine         = errors.New("client line must be \"name credential actions [cidrs]\"")
+	errClientDuplicate    = errors.New("duplicate client name")
+	errClientCredential   = errors.New("credential must be hmac:<secret> or ed25519:<public-key>")
+	errUnknownAction      = errors.New("unknown action")
+	errClientNetwork      = errors.New("invalid client network")
+	errClientsEmpty       = errors.New("clients file contains no clients")
+	errClientKeySetName   = errors.New("client name is also a key set key ID")
+	errClientNotPermitted = errors.New("client is not permitted")
+)
+
+// client is a registered client identity. Clients sign requests with their name as the
+// key ID and may only perform their allowed actions from their allowed networks.
+type client struct {
+	name      string
+	secret    string
+	publicKey ed25519.PublicKey
+	actions   map[string]bool
+	// networks limits the client to source addresses in these prefixes, if any.
+	networks []netip.Prefix
+}
+
+// permits checks if the client may perform the action from the IP address.
+func (c *client) permits(action, ip string) bool {
+	if !c.actions[action] {
+		return false
+	}
+
+	if len(c.networks) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, network := range c.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks that the client named by the key ID may perform the action from the
+// IP address and returns the client name. Signatures that are not from a registered
+// client (the secret file, key set, or public key file) may perform every action.
+func (cfg *HandlerConfig) authorize(keyID, action, ip string) (string, error) {
+	c, found := cfg.clients[keyID]
+	if !found {
+		return "", nil
+	}
+
+	if !c.permits(action, ip) {
+		return c.name, fmt.Errorf(
+			"%w: %q may not %s from %s",
+			errClientNotPermitted,
+			c.name,
+			action,
+			ip,
+		)
+	}
+	return c.name, nil
+}
+
+// parseClients parses client registry data into a map of client name to client.
+//
+// Each non-blank line that does not start with `#` holds a name, a credential, a
+// comma-separated list of actions (deploy, status, list, override), and optionally a
+// comma-separated list of allowed source networks (CIDR prefixes or addresses),
+// separated by whitespace. Names must be valid key IDs and unique. Credentials are
+// `hmac:<secret>` or `ed25519:<public-key>`, where the public key is the base64 PKIX
+// body of a PEM public key.
+func parseClients(data string) (map[string]*client, error) {
+	clients := make(map[string]*client)
+
+	for number, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < minClientFields || len(fields) > maxClientFields {
+			return nil, fmt.Errorf("line %d: %w", number+1, errClientLine)
+		}
+
+		c, err := parseClient(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+
+		if _, exists := clients[c.name]; exists {
+			return nil, fmt.Errorf("line %d: %w: %q", number+1, errClientDuplicate, c.name)
+		}
+		clients[c.name] = c
+	}
+
+	if len(clients) == 0 {
+		return nil, errClientsEmpty
+	}
+	return clients, nil
+}
+
+func parseClient(fields []string) (*client, error) {
+	if err := dchook.ValidateKeyID(fields[0]); err != nil {
+		return nil, err
+	}
+
+	c := &client{name: fields[0], actions: make(map[string]bool)}
+
+	switch credential := fields[1]; {
+	case strings.HasPrefix(credential, credentialHMAC):
+		c.secret = strings.TrimPrefix(credential, credentialHMAC)
+		if c.secret == "" {
+			return nil, errClientCredential
+		}
+	case strings.HasPrefix(credential, credentialEd25519):
+		publicKey, err := parseClientPublicKey(strings.TrimPrefix(credential, credentialEd25519))
+		if err != nil {
+			return nil, err
+		}
+		c.publicKey = publicKey
+	default:
+		return nil, errClientCredential
+	}
+
+	for action := range strings.SplitSeq(fields[2], ",") {
+		switch action {
+		case actionDeploy, actionStatus, actionList, actionOverride:
+			c.actions[action] = true
+		default:
+			return nil, fmt.Errorf("%w: %q", errUnknownAction, action)
+		}
+	}
+
+	if len(fields) == maxClientFields {
+		for network := range strings.SplitSeq(fields[3], ",") {
+			prefix, err := parseNetwork(network)
+			if err != nil {
+				return nil, err
+			}
+			c.networks = append(c.networks, prefix)
+		}
+	}
+	return c, nil
+}
+
+func parseClientPublicKey(value string) (ed25519.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errClientCredential, err)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errClientCredential, err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: not an Ed25519 key (got %T)", errClientCredential, key)
+	}
+	return publicKey, nil
+}
+
+// parseNetwork parses a CIDR prefix or a single address.
+func parseNetwork(value string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%w: %q", errClientNetwork, value)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// readClients reads the client registry. Returns nil if no clients file is configured.
+func readClients() (map[string]*client, error) {
+	//nolint:errcheck // Optional
+	clientsFilePath, _ := dchook.FlagValue(*clientsFile, "DCHOOK_CLIENTS_FILE", "--clients")
+	if clientsFilePath == "" {
+		return nil, nil //nolint:nilnil // Not configured
+	}
+
+	data, err := dchook.ReadSecretFileStrict(clientsFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clients: %w", err)
+	}
+
+	clients, err := parseClients(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clients file %q: %w", clientsFilePath, err)
+	}
+	return clients, nil
+}
+
+// checkClientNames checks that no client name is also a key ID in the key set, so that
+// a key ID always selects one credential.
+func checkClientNames(clients map[string]*client, secrets map[string]string) error {
+	for name := range clients {
+		if _, exists := secrets[name]; exists {
+			return fmt.Errorf("%w: %q", errClientKeySetName, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestParseClients(t *testing.T) {
+	t.Parallel()
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedKey := base64.StdEncoding.EncodeToString(der)
+
+	clients, err := parseClients(`
+# name credential actions [cidrs]
+ci        hmac:ci-secret          deploy,status,list
+dashboard hmac:dashboard-secret   status,list         10.0.0.0/8,192.0.2.7
+release   ed25519:` + encodedKey + `   deploy
+`)
+	if err != nil {
+		t.Fatalf("parseClients() error = %v", err)
+	}
+
+	if len(clients) != 3 {
+		t.Fatalf("parseClients() = %d clients, want 3", len(clients))
+	}
+
+	if c := clients["ci"]; c.secret != "ci-secret" || !c.actions[actionDeploy] {
+		t.Errorf("ci = %+v", c)
+	}
+
+	dashboard := clients["dashboard"]
+	if dashboard.actions[actionDeploy] || !dashboard.actions[actionList] ||
+		len(dashboard.networks) != 2 || dashboard.networks[1].Bits() != 32 {
+		t.Errorf("dashboard = %+v", dashboard)
+	}
+
+	if !clients["release"].publicKey.Equal(publicKey) {
+		t.Errorf("release public key = %x, want %x", clients["release"].publicKey, publicKey)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{"empty", "# nothing\n", errClientsEmpty},
+		{"missing actions", "ci hmac:secret", errClientLine},
+		{"extra fields", "ci hmac:secret deploy 10.0.0.0/8 extra", errClientLine},
+		{"duplicate", "ci hmac:a deploy\nci hmac:b status", errClientDuplicate},
+		{"invalid name", "c/i hmac:secret deploy", dchook.ErrInvalidKeyID},
+		{"unknown credential", "ci secret deploy", errClientCredential},
+		{"empty secret", "ci hmac: deploy", errClientCredential},
+		{"invalid public key", "ci ed25519:AAAA deploy", errClientCredential},
+		{"unknown action", "ci hmac:secret deploy,restart", errUnknownAction},
+		{"invalid network", "ci hmac:secret deploy 10.0.0.0/33", errClientNetwork},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := parseClients(testCase.data); !errors.Is(err, testCase.wantErr) {
+				t.Errorf("parseClients() error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandlerConfigAuthorize(t *testing.T) {
+	t.Parallel()
+
+	clients, err := parseClients(`
+ci        hmac:ci-secret          deploy,status,list
+dashboard hmac:dashboard-secret   status,list         10.0.0.0/8,2001:db8::/32
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &HandlerConfig{clients: clients, secrets: map[string]string{"2026-10": "secret"}}
+
+	tests := []struct {
+		name       string
+		keyID      string
+		action     string
+		ip         string
+		wantClient string
+		wantErr    bool
+	}{
+		{"default secret", "", actionDeploy, "192.0.2.1", "", false},
+		{"key set secret", "2026-10", actionDeploy, "192.0.2.1", "", false},
+		{"deploy client", "ci", actionDeploy, "192.0.2.1", "ci", false},
+		{"read-only list", "dashboard", actionList, "10.1.2.3", "dashboard", false},
+		{"read-only status", "dashboard", actionStatus, "2001:db8::1", "dashboard", false},
+		{"read-only deploy", "dashboard", actionDeploy, "10.1.2.3", "dashboard", true},
+		{"outside network", "dashboard", actionList, "192.0.2.1", "dashboard", true},
+		{"mapped address", "dashboard", actionList, "::ffff:10.1.2.3", "dashboard", false},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			clientName, err := cfg.authorize(testCase.keyID, testCase.action, testCase.ip)
+			if clientName != testCase.wantClient || (err != nil) != testCase.wantErr {
+				t.Errorf(
+					"authorize() = %q, %v, want %q, error %v",
+					clientName,
+					err,
+					testCase.wantClient,
+					testCase.wantErr,
+				)
+			}
+		})
+	}
+}
+
+func TestHandlerClientPermissions(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients, err := parseClients(`
+ci        hmac:ci-secret          deploy,status,list
+dashboard hmac:dashboard-secret   status,list
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		version:           "v1.0.0",
+		commit:            "abc",
+		clients:           clients,
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+	}
+	store := NewConfigStore(cfg, nil)
+	limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+	deployHandler := createDeployHandler(store, limiter)
+	statusHandler := createStatusHandler(store, limiter)
+
+	deploy := func(clientName, secret string) int {
+		body := []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+			strconv.FormatInt(time.Now().UnixMicro(), 10) + `"},"payload":{}}`)
+		req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+		req.Header.Set("Dchook-Signature", dchook.FormatSignatureKeyID(
+			dchook.GenerateSignature(body, secret, dchook.AlgorithmSHA256),
+			clientName,
+		))
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		deployHandler(w, req)
+		return w.Code
+	}
+
+	list := func(clientName, secret string) int {
+		timestamp := strconv.FormatInt(time.Now().UnixMicro(), 10)
+		req := httptest.NewRequest(http.MethodGet, "/deploy/status/", nil)
+		req.Header.Set("X-Dchook-Timestamp", timestamp)
+		req.Header.Set("X-Dchook-Nonce", clientName+"-nonce")
+		req.Header.Set("X-Dchook-Signature", dchook.FormatSignatureKeyID(
+			dchook.GenerateSignature(
+				[]byte(timestamp+":"+clientName+"-nonce"),
+				secret,
+				dchook.AlgorithmSHA256,
+			),
+			clientName,
+		))
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		statusHandler(w, req)
+		return w.Code
+	}
+
+	if code := deploy("dashboard", "dashboard-secret"); code != http.StatusForbidden {
+		t.Errorf("read-only deploy status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	if code := deploy("dashboard", "ci-secret"); code != http.StatusUnauthorized {
+		t.Errorf("wrong credential status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if code := deploy("ci", "ci-secret"); code != dchook.DeployAcceptedStatus {
+		t.Errorf("deploy status = %d, want %d", code, dchook.DeployAcceptedStatus)
+	}
+
+	if code := list("dashboard", "dashboard-secret"); code != http.StatusOK {
+		t.Errorf("read-only list status = %d, want %d", code, http.StatusOK)
+	}
+
+	deployments := cfg.history.List()
+	if len(deployments) == 0 || deployments[0].Client != "ci" {
+		t.Errorf("deployments = %+v, want client ci", deployments)
+	}
+}
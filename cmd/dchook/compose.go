@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// allProfiles in the allowed profiles permits the selection of any profile.
+const allProfiles = "*"
+
+var (
+	errComposeFileMissing = errors.New("at least one compose file is required")
+	errEnvFileSymlink     = errors.New("env file must not be a symlink")
+	errEnvFileNotAbsolute = errors.New("env file must be an absolute path")
+	errEnvFileNotRegular  = errors.New("env file must be a regular file")
+	errProfileName        = errors.New("invalid compose profile")
+	errProfileSelection   = errors.New("profile selection is not enabled")
+	errProfileNotAllowed  = errors.New("profile is not allowed")
+)
+
+// newComposeAdapter creates the compose adapter for comma-separated compose files, env
+// files, and default profiles. The first compose file is the base file and the others
+// are applied over it in order. Each compose and env file must be an absolute path to a
+// regular file that is not a symlink.
+func newComposeAdapter(composeFiles, envFiles, profiles string) (*DockerComposeAdapter, error) {
+	composeFilePaths, err := validateComposeFiles(splitList(composeFiles))
+	if err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+
+	var envFilePaths []string
+	if envFiles := splitList(envFiles); len(envFiles) > 0 {
+		envFilePaths, err = validateEnvFiles(envFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	profileNames, err := parseProfiles(profiles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compose profiles: %w", err)
+	}
+
+	return &DockerComposeAdapter{
+		ComposeFile:  composeFilePaths[0],
+		OverlayFiles: composeFilePaths[1:],
+		EnvFiles:     envFilePaths,
+		Profiles:     profileNames,
+	}, nil
+}
+
+// validateComposeFiles validates each path with validateComposeFile. Returns the
+// resolved paths, in order.
+func validateComposeFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, errComposeFileMissing
+	}
+
+	resolved := make([]string, 0, len(paths))
+	for _, path := range paths {
+		resolvedPath, err := validateComposeFile(path)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, resolvedPath)
+	}
+	return resolved, nil
+}
+
+var envFileErrors = fileKindErrors{
+	name:        "env file",
+	symlink:     errEnvFileSymlink,
+	notAbsolute: errEnvFileNotAbsolute,
+	notRegular:  errEnvFileNotRegular,
+}
+
+// validateEnvFiles validates each env file with the same rules as compose files. Returns
+// the resolved paths.
+func validateEnvFiles(paths []string) ([]string, error) {
+	resolved := make([]string, 0, len(paths))
+	for _, path := range paths {
+		resolvedPath, err := validateRegularFile(path, envFileErrors)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, resolvedPath)
+	}
+	return resolved, nil
+}
+
+// parseProfiles parses comma-separated compose profile names. `*` enables or allows all
+// profiles.
+func parseProfiles(value string) ([]string, error) {
+	profiles := splitList(value)
+	for _, profile := range profiles {
+		if profile != allProfiles && !composeServicePattern.MatchString(profile) {
+			return nil, fmt.Errorf("%w: %q", errProfileName, profile)
+		}
+	}
+	return profiles, nil
+}
+
+// selectProfiles validates the profiles selected by a deploy request against the allowed
+// profiles. Returns the sorted profiles without duplicates, or nil if no profiles are
+// selected, which deploys with the default profiles.
+func (cfg *HandlerConfig) selectProfiles(selected []string) ([]string, error) {
+	if len(selected) == 0 {
+		return nil, nil
+	}
+
+	if len(cfg.allowedProfiles) == 0 {
+		return nil, errProfileSelection
+	}
+
+	anyProfile := slices.Contains(cfg.allowedProfiles, allProfiles)
+	for _, profile := range selected {
+		if !composeServicePattern.MatchString(profile) {
+			return nil, fmt.Errorf("%w: %q", errProfileName, profile)
+		}
+
+		if !anyProfile && !slices.Contains(cfg.allowedProfiles, profile) {
+			return nil, fmt.Errorf("%w: %q", errProfileNotAllowed, profile)
+		}
+	}
+
+	profiles := slices.Clone(selected)
+	slices.Sort(profiles)
+	return slices.Compact(profiles), nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNewComposeAdapter(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"compose.yml", "compose.prod.yml", "app.env", "prod env"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(
+		filepath.Join(dir, "compose.prod.yml"),
+		filepath.Join(dir, "link.yml"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(
+		filepath.Join(dir, "app.env"),
+		filepath.Join(dir, "link.env"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	base, overlay := filepath.Join(dir, "compose.yml"), filepath.Join(dir, "compose.prod.yml")
+	appEnv, prodEnv := filepath.Join(dir, "app.env"), filepath.Join(dir, "prod env")
+
+	adapter, err := newComposeAdapter(base+", "+overlay, appEnv+","+prodEnv, "worker,debug")
+	if err != nil {
+		t.Fatalf("newComposeAdapter() error = %v", err)
+	}
+	adapter.ProjectName = "app"
+
+	if adapter.ComposeFile != base || !slices.Equal(adapter.OverlayFiles, []string{overlay}) ||
+		!slices.Equal(adapter.EnvFiles, []string{appEnv, prodEnv}) ||
+		!slices.Equal(adapter.Profiles, []string{"worker", "debug"}) {
+		t.Errorf("adapter = %+v", adapter)
+	}
+
+	want := "docker compose --env-file " + appEnv + " --env-file '" + prodEnv + "' -f " + base +
+		" -f " + overlay + " -p app --profile worker --profile debug up -d"
+	if command := adapter.formatCommand("up", "-d"); command != want {
+		t.Errorf("formatCommand() = %q, want %q", command, want)
+	}
+
+	tests := []struct {
+		name         string
+		composeFiles string
+		envFiles     string
+		profiles     string
+		want         error
+	}{
+		{"no compose file", " , ", "", "", errComposeFileMissing},
+		{"missing overlay", base + "," + filepath.Join(dir, "missing.yml"), "", "", os.ErrNotExist},
+		{"symlink overlay", base + "," + filepath.Join(dir, "link.yml"), "", "", errComposeSymlink},
+		{"env file directory", base, dir, "", errEnvFileNotRegular},
+		{"symlink env file", base, filepath.Join(dir, "link.env"), "", errEnvFileSymlink},
+		{"invalid profile", base, "", "worker,-debug", errProfileName},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := newComposeAdapter(testCase.composeFiles, testCase.envFiles, testCase.profiles)
+			if !errors.Is(err, testCase.want) {
+				t.Errorf("newComposeAdapter() error = %v, want %v", err, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSelectProfiles(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		allowed  []string
+		selected []string
+		want     []string
+		wantErr  error
+	}{
+		{"no selection", nil, nil, nil, nil},
+		{"selection disabled", nil, []string{"worker"}, nil, errProfileSelection},
+		{"allowed", []string{"worker", "debug"}, []string{"worker"}, []string{"worker"}, nil},
+		{
+			"sorted",
+			[]string{"worker", "debug"},
+			[]string{"worker", "debug", "worker"},
+			[]string{"debug", "worker"},
+			nil,
+		},
+		{"not allowed", []string{"worker"}, []string{"debug"}, nil, errProfileNotAllowed},
+		{"any profile", []string{allProfiles}, []string{"debug"}, []string{"debug"}, nil},
+		{"wildcard", []string{allProfiles}, []string{allProfiles}, nil, errProfileName},
+		{"option", []string{allProfiles}, []string{"--debug"}, nil, errProfileName},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &HandlerConfig{allowedProfiles: testCase.allowed}
+			profiles, err := cfg.selectProfiles(testCase.selected)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("selectProfiles() error = %v, want %v", err, testCase.wantErr)
+			}
+			if !slices.Equal(profiles, testCase.want) {
+				t.Errorf("selectProfiles() = %v, want %v", profiles, testCase.want)
+			}
+		})
+	}
+}
+
+func TestDeploymentProfiles(t *testing.T) {
+	t.Parallel()
+
+	adapter := &DockerComposeAdapter{
+		ComposeFile: "/opt/app/compose.yml",
+		Profiles:    []string{"worker"},
+	}
+	deployment := &Deployment{ID: "abc123", Profiles: []string{"debug", "worker"}}
+
+	deployed, cleanup, err := adapter.forDeployment(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	if !slices.Equal(deployed.Profiles, []string{"worker", "debug"}) {
+		t.Errorf("Profiles = %v, want the default profiles first", deployed.Profiles)
+	}
+	if !slices.Equal(adapter.Profiles, []string{"worker"}) {
+		t.Errorf("default Profiles = %v, want [worker]", adapter.Profiles)
+	}
+
+	want := "docker compose -f /opt/app/compose.yml --profile worker --profile debug pull"
+	if command := deployed.formatCommand("pull"); command != want {
+		t.Errorf("formatCommand() = %q, want %q", command, want)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"/opt/app/compose.yml", "/opt/app/compose.yml"},
+		{"--remove-orphans", "--remove-orphans"},
+		{"/opt/my app/compose.yml", "'/opt/my app/compose.yml'"},
+		{"it's", `'it'\''s'`},
+		{"*", "'*'"},
+		{"", "''"},
+	}
+
+	for _, testCase := range tests {
+		if got := shellQuote(testCase.arg); got != testCase.want {
+			t.Errorf("shellQuote(%q) = %q, want %q", testCase.arg, got, testCase.want)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	envPrefix = "DCHOOK_"
+
+	minRateLimitWindow   = time.Second
+	maxRateLimitWindow   = 24 * time.Hour
+	minDeployMaxFailures = 1
+	maxDeployMaxFailures = 100
+	minBanDuration       = time.Minute
+	maxBanDuration       = 30 * 24 * time.Hour
+	minStatusMaxRequests = 1
+	maxStatusMaxRequests = 1000
+	minHTTPTimeout       = time.Second
+	maxHTTPTimeout       = 10 * time.Minute
+	maxHTTPIdleTimeout   = time.Hour
+	minHistorySize       = 1
+	maxHistorySize       = 1000
+)
+
+var (
+	errConfigNotAbsolute = errors.New("configuration file must be an absolute path")
+	errConfigSetting     = errors.New("unknown setting")
+	errSettingRange      = errors.New("setting out of range")
+)
+
+// configSettings are the settings that may be set in the configuration file, by
+// environment variable.
+var configSettings = []string{
+	"DCHOOK_SECRET_FILE",
+	"DCHOOK_KEYSET_FILE",
+	"DCHOOK_PUBLIC_KEY_FILE",
+	"DCHOOK_CLIENTS_FILE",
+	"DCHOOK_OIDC_JWKS_FILE",
+	"DCHOOK_OIDC_ISSUER",
+	"DCHOOK_OIDC_AUDIENCE",
+	"DCHOOK_OIDC_RULES_FILE",
+	"DCHOOK_COMPOSE_FILE",
+	"DCHOOK_COMPOSE_PROJECT",
+	"DCHOOK_ENV_FILES",
+	"DCHOOK_COMPOSE_PROFILES",
+	"DCHOOK_ALLOWED_PROFILES",
+	"DCHOOK_EXCEPT_SERVICES",
+	"DCHOOK_ALLOWED_SERVICES",
+	"DCHOOK_IMAGE_REPOSITORIES",
+	"DCHOOK_PAYLOAD_ENV_FILE",
+	"DCHOOK_GIT_REF",
+	"DCHOOK_GIT_REFS",
+	"DCHOOK_GIT_REMOTE",
+	"DCHOOK_GIT_VERIFY_COMMITS",
+	"DCHOOK_SCHEDULE_FILE",
+	"DCHOOK_SCHEDULE_MODE",
+	"DCHOOK_PROJECTS_FILE",
+	"DCHOOK_BIND_ADDRESS",
+	"DCHOOK_PORT",
+	"DCHOOK_SOCKET_OWNER",
+	"DCHOOK_SOCKET_MODE",
+	"DCHOOK_TRUSTED_PROXIES",
+	"DCHOOK_IP_HEADERS",
+	"DCHOOK_PROXY_PROTOCOL",
+	"DCHOOK_TLS_CERT",
+	"DCHOOK_TLS_KEY",
+	"DCHOOK_TLS_CLIENT_CA",
+	"DCHOOK_TLS_CLIENT_SUBJECTS",
+	"DCHOOK_ALLOWED_ALGORITHMS",
+	"DCHOOK_GITHUB_EVENTS",
+	"DCHOOK_GITHUB_SECRET_FILE",
+	"DCHOOK_GITLAB_EVENTS",
+	"DCHOOK_GITLAB_SECRET_FILE",
+	"DCHOOK_GITEA_EVENTS",
+	"DCHOOK_GITEA_SECRET_FILE",
+	"DCHOOK_FORGEJO_EVENTS",
+	"DCHOOK_FORGEJO_SECRET_FILE",
+	"DCHOOK_REGISTRY_SECRET_FILE",
+	"DCHOOK_SIGNATURE_SCHEME",
+	"DCHOOK_WATCH_INTERVAL",
+	"DCHOOK_SHUTDOWN_TIMEOUT",
+	"DCHOOK_METRICS_ADDRESS",
+	"DCHOOK_OTLP_ENDPOINT",
+	"DCHOOK_AUDIT_LOG",
+	"DCHOOK_RATE_LIMIT_WINDOW",
+	"DCHOOK_DEPLOY_MAX_FAILURES",
+	"DCHOOK_DEPLOY_BAN_DURATION",
+	"DCHOOK_STATUS_MAX_REQUESTS",
+	"DCHOOK_HTTP_READ_TIMEOUT",
+	"DCHOOK_HTTP_WRITE_TIMEOUT",
+	"DCHOOK_HTTP_IDLE_TIMEOUT",
+	"DCHOOK_HISTORY_SIZE",
+}
+
+// serverLimits are the rate limiter, HTTP timeout, and deployment history parameters.
+// They are read at startup and are not changed on reload.
+type serverLimits struct {
+	rateLimitWindow   time.Duration
+	deployMaxFailures int
+	deployBanDuration time.Duration
+	statusMaxRequests int
+	httpReadTimeout   time.Duration
+	httpWriteTimeout  time.Duration
+	httpIdleTimeout   time.Duration
+	historySize       int
+}
+
+// loadConfigFile reads the configuration file from `--config` or DCHOOK_CONFIG, if set,
+// and uses it for settings that are not set by flag or environment variable. Returns the
+// path of the configuration file, or an error if it cannot be read or has unknown
+// settings.
+func loadConfigFile() (string, error) {
+	//nolint:errcheck // Optional
+	path, _ := dchook.FlagValue(*configPath, "DCHOOK_CONFIG", "--config")
+	if path == "" {
+		dchook.SetConfigFile(nil)
+		return "", nil
+	}
+
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%w: %q", errConfigNotAbsolute, path)
+	}
+
+	file, err := dchook.ReadConfigFile(path, envPrefix)
+	if err != nil {
+		return "", err //nolint:wrapcheck // Already wrapped with the path
+	}
+
+	for _, envVar := range file.EnvVars() {
+		if !slices.Contains(configSettings, envVar) {
+			key := strings.ToLower(strings.TrimPrefix(envVar, envPrefix))
+			return "", fmt.Errorf("%q: %w %q", path, errConfigSetting, key)
+		}
+	}
+
+	dchook.SetConfigFile(file)
+	return path, nil
+}
+
+// reloadHandlerConfig re-reads the configuration file, if any, and loads the handler
+// configuration.
+func reloadHandlerConfig() (*HandlerConfig, error) {
+	if _, err := loadConfigFile(); err != nil {
+		return nil, err
+	}
+	return loadHandlerConfig()
+}
+
+// loadServerLimits reads the limits from their environment variables or the
+// configuration file, checking each against its bounds.
+func loadServerLimits() (serverLimits, error) {
+	defaultRateLimitWindow, err := time.ParseDuration(rateLimitWindow)
+	if err != nil {
+		return serverLimits{}, fmt.Errorf(
+			"invalid rate limit window %q: %w",
+			rateLimitWindow,
+			err,
+		)
+	}
+
+	var limits serverLimits
+	for _, setting := range []struct {
+		envVar string
+		parse  func(string) error
+	}{
+		{"DCHOOK_RATE_LIMIT_WINDOW", durationSetting(
+			&limits.rateLimitWindow,
+			defaultRateLimitWindow,
+			minRateLimitWindow,
+			maxRateLimitWindow,
+		)},
+		{"DCHOOK_DEPLOY_MAX_FAILURES", intSetting(
+			&limits.deployMaxFailures,
+			deployMaxFailures,
+			minDeployMaxFailures,
+			maxDeployMaxFailures,
+		)},
+		{"DCHOOK_DEPLOY_BAN_DURATION", durationSetting(
+			&limits.deployBanDuration,
+			deployBanDuration,
+			minBanDuration,
+			maxBanDuration,
+		)},
+		{"DCHOOK_STATUS_MAX_REQUESTS", intSetting(
+			&limits.statusMaxRequests,
+			statusMaxRequests,
+			minStatusMaxRequests,
+			maxStatusMaxRequests,
+		)},
+		{"DCHOOK_HTTP_READ_TIMEOUT", durationSetting(
+			&limits.httpReadTimeout,
+			httpReadTimeout,
+			minHTTPTimeout,
+			maxHTTPTimeout,
+		)},
+		{"DCHOOK_HTTP_WRITE_TIMEOUT", durationSetting(
+			&limits.httpWriteTimeout,
+			httpWriteTimeout,
+			minHTTPTimeout,
+			maxHTTPTimeout,
+		)},
+		{"DCHOOK_HTTP_IDLE_TIMEOUT", durationSetting(
+			&limits.httpIdleTimeout,
+			httpIdleTimeout,
+			minHTTPTimeout,
+			maxHTTPIdleTimeout,
+		)},
+		{"DCHOOK_HISTORY_SIZE", intSetting(
+			&limits.historySize,
+			maxDeployments,
+			minHistorySize,
+			maxHistorySize,
+		)},
+	} {
+		if err := setting.parse(dchook.EnvValue(setting.envVar)); err != nil {
+			return serverLimits{}, fmt.Errorf("%s: %w", setting.envVar, err)
+		}
+	}
+
+	return limits, nil
+}
+
+// durationSetting returns a function that parses a duration into target, or sets the
+// default if the value is empty.
+func durationSetting(
+	target *time.Duration,
+	fallback, minimum, maximum time.Duration,
+) func(string) error {
+	return func(value string) error {
+		if value == "" {
+			*target = fallback
+			return nil
+		}
+
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", value, err)
+		}
+
+		if duration < minimum || duration > maximum {
+			return fmt.Errorf(
+				"%w: %s is not between %s and %s",
+				errSettingRange,
+				value,
+				minimum,
+				maximum,
+			)
+		}
+
+		*target = duration
+		return nil
+	}
+}
+
+// intSetting returns a function that parses an integer into target, or sets the default
+// if the value is empty.
+func intSetting(target *int, fallback, minimum, maximum int) func(string) error {
+	return func(value string) error {
+		if value == "" {
+			*target = fallback
+			return nil
+		}
+
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid number %q: %w", value, err)
+		}
+
+		if number < minimum || number > maximum {
+			return fmt.Errorf(
+				"%w: %d is not between %d and %d",
+				errSettingRange,
+				number,
+				minimum,
+				maximum,
+			)
+		}
+
+		*target = number
+		return nil
+	}
+}
+
+// checkConfigCommand runs `dchook check-config`, which loads and validates the
+// configuration without starting the server: the configuration file, secrets and keys,
+// the compose file and project name, and the other settings. Returns the exit code.
+func checkConfigCommand(stdout, stderr io.Writer) int {
+	path, err := loadConfigFile()
+	if err != nil {
+		//nolint:errcheck,gosec // Writing to stderr
+		fmt.Fprintf(stderr, "✗ configuration file: %v\n", err)
+		return 1
+	}
+
+	if path != "" {
+		//nolint:errcheck,gosec // Writing to stdout
+		fmt.Fprintf(stdout, "✓ configuration file: %s\n", path)
+	}
+
+	failed := false
+	for _, check := range []struct {
+		name  string
+		check func() error
+	}{
+		{"handler configuration", func() error {
+			_, err := loadHandlerConfig()
+			return err
+		}},
+		{"proxy configuration", func() error {
+			_, err := loadProxyConfig()
+			return err
+		}},
+		{"limits", func() error {
+			_, err := loadServerLimits()
+			return err
+		}},
+		{"watch interval", func() error {
+			_, err := parseWatchInterval()
+			return err
+		}},
+		{"shutdown timeout", func() error {
+			_, err := parseShutdownTimeout()
+			return err
+		}},
+		{"tracing configuration", func() error {
+			tracer, _, err := loadTracer()
+			tracer.Shutdown(context.Background()) //nolint:errcheck,gosec // No spans
+			return err
+		}},
+		{"audit log", checkAuditLog},
+	} {
+		if err := check.check(); err != nil {
+			failed = true
+			//nolint:errcheck,gosec // Writing to stderr
+			fmt.Fprintf(stderr, "✗ %s: %v\n", check.name, err)
+			continue
+		}
+
+		//nolint:errcheck,gosec // Writing to stdout
+		fmt.Fprintf(stdout, "✓ %s\n", check.name)
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// checkAuditLog verifies the chain of the configured audit log without creating it.
+func checkAuditLog() error {
+	//nolint:errcheck // Optional
+	path, _ := dchook.FlagValue(*auditLogFile, "DCHOOK_AUDIT_LOG", "--audit-log")
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Open(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer file.Close() //nolint:errcheck // Best effort close in defer
+
+	_, _, err = verifyAuditLog(file)
+	return err
+}
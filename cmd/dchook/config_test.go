@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dchook.toml")
+	content := `compose_file = "/opt/app/compose.yml"
+
+[deploy]
+max_failures = 5
+ban_duration = "2h"
+
+[http]
+read_timeout = "30s"
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DCHOOK_CONFIG", path)
+	t.Setenv("DCHOOK_HTTP_READ_TIMEOUT", "20s")
+	t.Cleanup(func() { dchook.SetConfigFile(nil) })
+
+	loaded, err := loadConfigFile()
+	if err != nil || loaded != path {
+		t.Fatalf("loadConfigFile() = %q, %v", loaded, err)
+	}
+
+	limits, err := loadServerLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limits.deployMaxFailures != 5 || limits.deployBanDuration != 2*time.Hour {
+		t.Errorf(
+			"deploy limits = %d, %s, want file values",
+			limits.deployMaxFailures,
+			limits.deployBanDuration,
+		)
+	}
+	if limits.httpReadTimeout != 20*time.Second {
+		t.Errorf("httpReadTimeout = %s, want environment value", limits.httpReadTimeout)
+	}
+	if limits.historySize != maxDeployments || limits.httpIdleTimeout != httpIdleTimeout {
+		t.Errorf("limits = %+v, want defaults for unset settings", limits)
+	}
+
+	if err := os.WriteFile(path, []byte("compose_files = \"/a.yml\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfigFile(); !errors.Is(err, errConfigSetting) {
+		t.Errorf("loadConfigFile() error = %v, want %v", err, errConfigSetting)
+	}
+
+	t.Setenv("DCHOOK_CONFIG", "dchook.toml")
+	if _, err := loadConfigFile(); !errors.Is(err, errConfigNotAbsolute) {
+		t.Errorf("loadConfigFile() error = %v, want %v", err, errConfigNotAbsolute)
+	}
+}
+
+func TestSettingBounds(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr error
+		want    int
+	}{
+		{"default", "", nil, 10},
+		{"value", "25", nil, 25},
+		{"minimum", "1", nil, 1},
+		{"below minimum", "0", errSettingRange, 0},
+		{"above maximum", "1001", errSettingRange, 0},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got int
+			err := intSetting(&got, 10, minHistorySize, maxHistorySize)(testCase.value)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("intSetting() error = %v, want %v", err, testCase.wantErr)
+			}
+			if err == nil && got != testCase.want {
+				t.Errorf("intSetting() = %d, want %d", got, testCase.want)
+			}
+		})
+	}
+
+	var duration time.Duration
+	parse := durationSetting(&duration, time.Hour, minBanDuration, maxBanDuration)
+	if err := parse("30s"); !errors.Is(err, errSettingRange) {
+		t.Errorf("durationSetting(30s) error = %v, want %v", err, errSettingRange)
+	}
+	if err := parse("forever"); err == nil {
+		t.Error("durationSetting(forever) should fail")
+	}
+}
+
+func TestDeploymentHistorySize(t *testing.T) {
+	t.Parallel()
+
+	history := NewDeploymentHistoryWithSize(3)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		history.Add(Deployment{ID: id})
+	}
+
+	if got := len(history.List()); got != 3 {
+		t.Errorf("List() returned %d deployments, want 3", got)
+	}
+	if _, found := history.Get("a"); found {
+		t.Error("oldest deployment should have been replaced")
+	}
+	if _, found := history.Get("d"); !found {
+		t.Error("newest deployment should be kept")
+	}
+}
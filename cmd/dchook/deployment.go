@@ -11,9 +11,10 @@ import (
 )
 
 const (
-	maxDeployments   = 10
-	deploymentIDSize = 6 // bytes for random deployment ID
+	maxDeployments   = 10 // default deployment history size
+	deploymentIDSize = 6  // bytes for random deployment ID
 
+	statusScheduled  = "scheduled"
 	statusPending    = "pending"
 	statusPulling    = "pulling"
 	statusRestarting = "restarting"
@@ -27,24 +28,43 @@ type DeploymentResult struct {
 	DurationMs int64  `json:"duration_ms"`
 }
 
+// Deployment is a deployment and its results. Status is "scheduled", "pending",
+// "pulling", "restarting", "complete", or "failed".
 type Deployment struct {
-	ID        string            `json:"id"`
-	Timestamp time.Time         `json:"timestamp"`
-	Status    string            `json:"status"` // "pending", "pulling", "restarting", "complete", "failed"
-	Request   json.RawMessage   `json:"request,omitempty"`
-	Pull      *DeploymentResult `json:"pull,omitempty"`
-	Restart   *DeploymentResult `json:"restart,omitempty"`
+	ID           string              `json:"id"`
+	Timestamp    time.Time           `json:"timestamp"`
+	Status       string              `json:"status"`
+	Client       string              `json:"client,omitempty"`
+	TraceID      string              `json:"trace_id,omitempty"`
+	Request      json.RawMessage     `json:"request,omitempty"`
+	Services     []string            `json:"services,omitempty"`
+	Profiles     []string            `json:"profiles,omitempty"`
+	Images       map[string]string   `json:"images,omitempty"`
+	Environment  map[string]string   `json:"environment,omitempty"`
+	Ref          string              `json:"ref,omitempty"`
+	ScheduledFor *time.Time          `json:"scheduled_for,omitempty"`
+	Override     *DeploymentOverride `json:"override,omitempty"`
+	Commit       string              `json:"commit,omitempty"`
+	Checkout     *DeploymentResult   `json:"checkout,omitempty"`
+	Pull         *DeploymentResult   `json:"pull,omitempty"`
+	Restart      *DeploymentResult   `json:"restart,omitempty"`
 }
 
 type DeploymentHistory struct {
 	mutex       sync.RWMutex
-	deployments [maxDeployments]Deployment
-	count       int // Number of deployments stored (0-10)
-	next        int // Next write position (0-9)
+	deployments []Deployment
+	count       int // Number of deployments stored (0-size)
+	next        int // Next write position (0-size-1)
 }
 
 func NewDeploymentHistory() *DeploymentHistory {
-	return &DeploymentHistory{}
+	return NewDeploymentHistoryWithSize(maxDeployments)
+}
+
+// NewDeploymentHistoryWithSize creates a history that keeps the most recent size
+// deployments.
+func NewDeploymentHistoryWithSize(size int) *DeploymentHistory {
+	return &DeploymentHistory{deployments: make([]Deployment, max(size, 1))}
 }
 
 func (h *DeploymentHistory) Add(d Deployment) {
@@ -52,8 +72,8 @@ func (h *DeploymentHistory) Add(d Deployment) {
 	defer h.mutex.Unlock()
 
 	h.deployments[h.next] = d
-	h.next = (h.next + 1) % maxDeployments
-	if h.count < maxDeployments {
+	h.next = (h.next + 1) % len(h.deployments)
+	if h.count < len(h.deployments) {
 		h.count++
 	}
 }
@@ -145,6 +165,11 @@ func (h *DeploymentHistory) LastDeployment() *time.Time {
 	return nil
 }
 
+// Size returns the number of deployments the history keeps.
+func (h *DeploymentHistory) Size() int {
+	return len(h.deployments)
+}
+
 func generateDeploymentID() string {
 	b := make([]byte, deploymentIDSize)
 	if _, err := rand.Read(b); err != nil {
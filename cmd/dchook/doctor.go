@@ -0,0 +1,672 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+
+	// doctorDockerTimeout bounds each docker command run by doctor. Resolving an image may
+	// query its registry.
+	doctorDockerTimeout = 30 * time.Second
+
+	clockReferenceTimeout = 10 * time.Second
+
+	// clockSkewWarning is the clock offset reported as a warning. Offsets beyond
+	// dchook.MaxRequestSkew fail.
+	clockSkewWarning = 10 * time.Second
+
+	defaultDockerSocket = "/var/run/docker.sock"
+	dockerGroup         = "docker"
+)
+
+var (
+	errDoctorCommand      = errors.New("unexpected argument")
+	errNoSecretFiles      = errors.New("no secret or key files are configured")
+	errClockReference     = errors.New("clock reference request failed")
+	errClockReferenceURL  = errors.New("clock reference must be an http or https URL")
+	errClockReferenceDate = errors.New("clock reference response has no Date header")
+)
+
+// doctorCheck is the result of one doctor check.
+type doctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// doctorReport is the result of all doctor checks. Status is `fail` if any check failed,
+// `warn` if any check warned, and `pass` otherwise.
+type doctorReport struct {
+	Status string        `json:"status"`
+	Checks []doctorCheck `json:"checks"`
+}
+
+// dockerConfig is the part of the Docker client configuration that records registry
+// credentials.
+type dockerConfig struct {
+	Auths       map[string]json.RawMessage `json:"auths"`
+	CredHelpers map[string]string          `json:"credHelpers"`
+	CredsStore  string                     `json:"credsStore"`
+}
+
+// doctorCommand runs `dchook doctor`, which checks that this host can run dchook: the
+// startup checks, the compose configuration and its images, registry credentials, Docker
+// access, clock skew, and the user. Returns the exit code, which is 1 if any check
+// failed.
+func doctorCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	clockReference := flags.String(
+		"clock-reference",
+		"",
+		"URL whose Date header is used to check clock skew",
+	)
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() > 0 {
+		//nolint:errcheck,gosec // Writing to stderr
+		fmt.Fprintf(stderr, "Error: %v %q\n", errDoctorCommand, flags.Arg(0))
+		return 2
+	}
+
+	report := runDoctor(*clockReference)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		//nolint:errcheck,gosec // Writing to stdout
+		encoder.Encode(report)
+	} else {
+		report.write(stdout)
+	}
+
+	if report.Status == doctorFail {
+		return 1
+	}
+	return 0
+}
+
+// runDoctor runs the doctor checks. The checks that need Docker or a valid compose file
+// are skipped if those checks fail. Clock skew is only checked with a reference URL.
+func runDoctor(clockReference string) doctorReport {
+	report := doctorReport{Status: doctorPass}
+
+	if path, err := loadConfigFile(); err != nil {
+		report.fail("configuration file", err, "Fix the configuration file setting.")
+	} else if path != "" {
+		report.pass("configuration file", path)
+	}
+
+	report.checkSecretFiles()
+	report.checkCertFiles()
+	adapter := report.checkComposeFile()
+	report.checkProjects()
+	report.checkDockerSocket()
+	report.checkDockerGroup()
+
+	dockerAvailable := true
+	if err := (&DockerComposeAdapter{}).Available(); err != nil {
+		dockerAvailable = false
+		report.fail(
+			"docker",
+			err,
+			"Install Docker with the compose plugin; see the docker socket check for "+
+				"access problems.",
+		)
+	} else {
+		report.pass("docker", "docker and docker compose are available")
+	}
+
+	if adapter != nil && dockerAvailable && report.checkComposeConfig(adapter) {
+		images := report.checkImages(adapter)
+		report.checkCredentials(images, dockerConfigPath())
+	}
+
+	if clockReference != "" {
+		report.checkClock(clockReference)
+	}
+
+	report.checkUser()
+	return report
+}
+
+func (report *doctorReport) pass(name, message string) {
+	report.add(doctorCheck{Name: name, Status: doctorPass, Message: message})
+}
+
+func (report *doctorReport) warn(name, message, hint string) {
+	report.add(doctorCheck{Name: name, Status: doctorWarn, Message: message, Hint: hint})
+}
+
+func (report *doctorReport) fail(name string, err error, hint string) {
+	report.add(doctorCheck{Name: name, Status: doctorFail, Message: err.Error(), Hint: hint})
+}
+
+// add appends a check, raising the report status to the check status.
+func (report *doctorReport) add(check doctorCheck) {
+	report.Checks = append(report.Checks, check)
+
+	switch {
+	case check.Status == doctorFail:
+		report.Status = doctorFail
+	case check.Status == doctorWarn && report.Status == doctorPass:
+		report.Status = doctorWarn
+	}
+}
+
+// write prints the report with one line per check, followed by its remediation hint.
+func (report *doctorReport) write(w io.Writer) {
+	counts := make(map[string]int)
+	for _, check := range report.Checks {
+		counts[check.Status]++
+
+		symbol := "✓"
+		switch check.Status {
+		case doctorWarn:
+			symbol = "!"
+		case doctorFail:
+			symbol = "✗"
+		}
+
+		line := symbol + " " + check.Name
+		if check.Message != "" {
+			line += ": " + check.Message
+		}
+		//nolint:errcheck,gosec // Writing to stdout
+		fmt.Fprintln(w, line)
+
+		if check.Hint != "" {
+			//nolint:errcheck,gosec // Writing to stdout
+			fmt.Fprintf(w, "  hint: %s\n", check.Hint)
+		}
+	}
+
+	//nolint:errcheck,gosec // Writing to stdout
+	fmt.Fprintf(
+		w,
+		"\n%d passed, %d warned, %d failed\n",
+		counts[doctorPass],
+		counts[doctorWarn],
+		counts[doctorFail],
+	)
+}
+
+// checkSecretFiles reads each configured secret and key file with the startup checks.
+func (report *doctorReport) checkSecretFiles() {
+	configured := false
+	for _, setting := range secretFileSettings {
+		path := setting.value()
+		if path == "" {
+			continue
+		}
+
+		// The TLS key does not authenticate deploy requests.
+		configured = configured || setting.flagVal != tlsKeyFile
+		name := strings.ToLower(strings.TrimPrefix(setting.envVar, envPrefix))
+
+		data, err := dchook.ReadSecretFileStrict(path)
+		if err == nil && data == "" {
+			err = fmt.Errorf("%w: %q", errSecretEmpty, path)
+		}
+		if err != nil {
+			report.fail(
+				name,
+				err,
+				"Use an absolute path to a regular file, not a symlink, readable only "+
+					"by the dchook user (mode 0600 or 0400).",
+			)
+			continue
+		}
+		report.pass(name, path)
+	}
+
+	if !configured {
+		report.fail(
+			"secrets",
+			errNoSecretFiles,
+			"Set a secret (-s), key set (--keyset), public key (-k), clients "+
+				"(--clients), or OIDC JWKS (--oidc-jwks) file.",
+		)
+	}
+}
+
+// checkCertFiles reads each configured TLS certificate and client CA file with the
+// startup checks.
+func (report *doctorReport) checkCertFiles() {
+	for _, setting := range certFileSettings {
+		path := setting.value()
+		if path == "" {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimPrefix(setting.envVar, envPrefix))
+		if _, err := dchook.ReadCertFileStrict(path); err != nil {
+			report.fail(
+				name,
+				err,
+				"Use an absolute path to a regular file or a symlink to one.",
+			)
+			continue
+		}
+		report.pass(name, path)
+	}
+}
+
+// checkComposeFile validates the compose files, env files, profiles, and project name.
+// Returns the compose adapter, or nil if any is invalid.
+func (report *doctorReport) checkComposeFile() *DockerComposeAdapter {
+	paths, err := dchook.FlagValue(*composeFile, "DCHOOK_COMPOSE_FILE", "-c")
+	if err != nil {
+		report.fail("compose file", err, "Set the compose file with -c or DCHOOK_COMPOSE_FILE.")
+		return nil
+	}
+
+	//nolint:errcheck // Optional
+	envFilePaths, _ := dchook.FlagValue(*envFiles, "DCHOOK_ENV_FILES", "--env-files")
+
+	adapter, err := newComposeAdapter(
+		paths,
+		envFilePaths,
+		dchook.EnvValue("DCHOOK_COMPOSE_PROFILES"),
+	)
+	if err != nil {
+		report.fail(
+			"compose file",
+			err,
+			"Use absolute paths to regular compose and env files, not symlinks, and "+
+				"valid profile names.",
+		)
+		return nil
+	}
+	report.pass("compose file", strings.Join(adapter.composeFiles(), ", "))
+
+	//nolint:errcheck // Optional
+	projectName, _ := dchook.FlagValue(*composeProject, "DCHOOK_COMPOSE_PROJECT", "--project")
+	if err := validateProjectName(projectName); err != nil {
+		report.fail(
+			"compose project",
+			err,
+			"Use lowercase letters, digits, dashes, and underscores, starting with a "+
+				"letter or digit.",
+		)
+		return nil
+	}
+
+	adapter.ProjectName = projectName
+	return adapter
+}
+
+// checkProjects reads the projects file, if any, with the compose file and secret checks
+// of each project.
+func (report *doctorReport) checkProjects() {
+	projects, err := readProjects()
+	if err != nil {
+		report.fail(
+			"projects",
+			err,
+			"Fix the projects file; each line is \"name compose-file [option=value...]\".",
+		)
+		return
+	}
+
+	if len(projects) > 0 {
+		report.pass("projects", strings.Join(slices.Sorted(maps.Keys(projects)), ", "))
+	}
+}
+
+// checkDockerSocket connects to the Docker socket from DOCKER_HOST or the default path.
+// Remote Docker hosts are not checked.
+func (report *doctorReport) checkDockerSocket() {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	path := defaultDockerSocket
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		socket, found := strings.CutPrefix(host, "unix://")
+		if !found {
+			report.pass("docker socket", "DOCKER_HOST is "+host)
+			return
+		}
+		path = socket
+	}
+
+	conn, err := net.DialTimeout("unix", path, dockerVersionTimeout)
+	if err != nil {
+		hint := "Check that the Docker daemon is running, or set DOCKER_HOST."
+		if errors.Is(err, os.ErrPermission) {
+			hint = "Add the dchook user to the group that owns the socket (usually " +
+				"docker) with `usermod -aG docker dchook`."
+		}
+		report.fail("docker socket", err, hint)
+		return
+	}
+	conn.Close() //nolint:errcheck,gosec // Connection test only
+
+	report.pass("docker socket", path)
+}
+
+// checkDockerGroup checks that a non-root user is in the docker group, both in the group
+// database and in this process. A new group membership only applies to new logins.
+func (report *doctorReport) checkDockerGroup() {
+	if os.Geteuid() <= 0 {
+		return
+	}
+
+	group, err := user.LookupGroup(dockerGroup)
+	if err != nil {
+		// Without a docker group (rootless Docker or a remote host), the socket check
+		// covers access.
+		return
+	}
+
+	gid, err := strconv.Atoi(group.Gid)
+	if err != nil {
+		return
+	}
+
+	groups, err := os.Getgroups()
+	if err == nil && (slices.Contains(groups, gid) || os.Getegid() == gid) {
+		report.pass("docker group", "member of "+dockerGroup)
+		return
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		return
+	}
+
+	if ids, err := current.GroupIds(); err == nil && slices.Contains(ids, group.Gid) {
+		report.warn(
+			"docker group",
+			current.Username+" is a member of "+dockerGroup+", but not in this session",
+			"Log in again or restart the service to pick up the group membership.",
+		)
+		return
+	}
+
+	report.warn(
+		"docker group",
+		current.Username+" is not a member of "+dockerGroup,
+		"Add the user with `usermod -aG docker "+current.Username+"` unless the "+
+			"Docker socket is shared another way.",
+	)
+}
+
+// checkComposeConfig runs `docker compose config -q`. Returns true if the compose
+// configuration is valid.
+func (report *doctorReport) checkComposeConfig(adapter *DockerComposeAdapter) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorDockerTimeout)
+	defer cancel()
+
+	if output, err := adapter.runDocker(ctx, "config", "-q"); err != nil {
+		report.fail(
+			"compose config",
+			commandError(err, output),
+			"Fix the errors reported by `"+adapter.formatCommand("config", "-q")+"`.",
+		)
+		return false
+	}
+
+	report.pass("compose config", "compose configuration is valid")
+	return true
+}
+
+// checkImages checks that each service image exists locally or can be resolved from its
+// registry. Returns the images.
+func (report *doctorReport) checkImages(adapter *DockerComposeAdapter) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorDockerTimeout)
+	defer cancel()
+
+	images, err := adapter.configList(ctx, "--images")
+	if err != nil {
+		report.fail("images", err, "Fix the errors reported by `docker compose config`.")
+		return nil
+	}
+
+	for _, image := range images {
+		if err := resolveImage(image); err != nil {
+			report.fail(
+				"image "+image,
+				err,
+				"Check the image name and tag, that the registry is reachable, and that "+
+					"the dchook user has credentials for private images.",
+			)
+			continue
+		}
+		report.pass("image "+image, "resolved")
+	}
+	return images
+}
+
+// checkCredentials checks the Docker client configuration for credentials for each
+// image registry. Missing credentials are a warning, since public images do not need
+// them.
+func (report *doctorReport) checkCredentials(images []string, configPath string) {
+	if len(images) == 0 {
+		return
+	}
+
+	hosts, err := readDockerCredentials(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		report.warn(
+			"registry credentials",
+			"no Docker config at "+configPath,
+			"Run `docker login` as the dchook user if any images are private.",
+		)
+		return
+	} else if err != nil {
+		report.fail("registry credentials", err, "Fix or remove the Docker config file.")
+		return
+	}
+
+	var checked []string
+	for _, image := range images {
+		host := parseImageReference(image).host
+		if slices.Contains(checked, host) {
+			continue
+		}
+		checked = append(checked, host)
+
+		name := "registry credentials " + host
+		if hosts[host] {
+			report.pass(name, "found in "+configPath)
+			continue
+		}
+		report.warn(
+			name,
+			"none in "+configPath,
+			"Run `docker login "+host+"` as the dchook user if the images are private.",
+		)
+	}
+}
+
+// checkClock compares the local clock with the Date header of the reference URL.
+func (report *doctorReport) checkClock(reference string) {
+	skew, err := clockSkew(reference)
+	if err != nil {
+		report.fail("clock", err, "Use a reachable http or https URL for --clock-reference.")
+		return
+	}
+
+	message := fmt.Sprintf("%s ahead of %s", skew, reference)
+	if skew < 0 {
+		message = fmt.Sprintf("%s behind %s", -skew, reference)
+	}
+
+	hint := fmt.Sprintf(
+		"Enable time synchronization (such as chrony or systemd-timesyncd); requests "+
+			"signed more than %s in the future or %s in the past are rejected.",
+		dchook.MaxRequestSkew,
+		dchook.MaxRequestAge,
+	)
+
+	switch skew = skew.Abs(); {
+	case skew > dchook.MaxRequestSkew:
+		report.add(doctorCheck{Name: "clock", Status: doctorFail, Message: message, Hint: hint})
+	case skew > clockSkewWarning:
+		report.warn("clock", message, hint)
+	default:
+		report.pass("clock", message)
+	}
+}
+
+// checkUser warns when running as root, since dchook only needs Docker access.
+func (report *doctorReport) checkUser() {
+	if os.Geteuid() == 0 {
+		report.warn(
+			"user",
+			"running as root",
+			"Run dchook as an unprivileged user in the docker group.",
+		)
+		return
+	}
+
+	report.pass("user", "not running as root")
+}
+
+// resolveImage checks that image is available locally or that its manifest can be read
+// from its registry.
+func resolveImage(image string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorDockerTimeout)
+	defer cancel()
+
+	//nolint:gosec // Images are read from the validated compose file
+	if exec.CommandContext(ctx, "docker", "image", "inspect", image).Run() == nil {
+		return nil
+	}
+
+	//nolint:gosec // Images are read from the validated compose file
+	output, err := exec.CommandContext(ctx, "docker", "manifest", "inspect", image).
+		CombinedOutput()
+	if err != nil {
+		return commandError(err, output)
+	}
+	return nil
+}
+
+// commandError adds the last line of command output to err.
+func commandError(err error, output []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return fmt.Errorf("%w: %s", err, last)
+	}
+	return err
+}
+
+// dockerConfigPath returns the path of the Docker client configuration file, from
+// DOCKER_CONFIG or in ~/.docker.
+func dockerConfigPath() string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = "~"
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// readDockerCredentials returns the registry hosts with credentials in the Docker client
+// configuration: the hosts in auths and credHelpers. With a credential store, auths
+// only has the host names.
+func readDockerCredentials(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("read Docker config: %w", err)
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid Docker config %q: %w", path, err)
+	}
+
+	hosts := make(map[string]bool)
+	for server := range config.Auths {
+		hosts[registryServerHost(server)] = true
+	}
+	for server := range config.CredHelpers {
+		hosts[registryServerHost(server)] = true
+	}
+	return hosts, nil
+}
+
+// registryServerHost returns the registry host of a Docker config server key, which may
+// be a URL such as `https://index.docker.io/v1/`.
+func registryServerHost(server string) string {
+	if parsed, err := url.Parse(server); err == nil && parsed.Host != "" {
+		server = parsed.Host
+	}
+	host, _, _ := strings.Cut(server, "/")
+	return normalizeRegistryHost(host)
+}
+
+// clockSkew returns how far the local clock is ahead of the Date header of the reference
+// URL, measured at the midpoint of the request.
+func clockSkew(reference string) (time.Duration, error) {
+	parsed, err := url.Parse(reference)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return 0, fmt.Errorf("%w: %q", errClockReferenceURL, reference)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clockReferenceTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, reference, nil)
+	if err != nil {
+		return 0, fmt.Errorf("clock reference request: %w", err)
+	}
+
+	start := time.Now()
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", errClockReference, err)
+	}
+	elapsed := time.Since(start)
+	response.Body.Close() //nolint:errcheck,gosec // HEAD response has no body
+
+	date := response.Header.Get("Date")
+	if date == "" {
+		return 0, errClockReferenceDate
+	}
+
+	referenceTime, err := http.ParseTime(date)
+	if err != nil {
+		return 0, fmt.Errorf("clock reference Date %q: %w", date, err)
+	}
+
+	// The Date header has one second resolution, so the result is rounded.
+	local := start.Add(elapsed / 2)
+	return local.Sub(referenceTime).Round(time.Second), nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoctorReport(t *testing.T) {
+	t.Parallel()
+
+	report := doctorReport{Status: doctorPass}
+	report.pass("compose file", "/opt/app/compose.yml")
+	if report.Status != doctorPass {
+		t.Errorf("Status = %q, want %q", report.Status, doctorPass)
+	}
+
+	report.warn("user", "running as root", "Run as another user.")
+	if report.Status != doctorWarn {
+		t.Errorf("Status = %q, want %q", report.Status, doctorWarn)
+	}
+
+	report.fail("docker", errors.New("docker version check failed"), "Install Docker.")
+	report.warn("clock", "15s ahead", "")
+	if report.Status != doctorFail {
+		t.Errorf("Status = %q, want %q", report.Status, doctorFail)
+	}
+
+	var output bytes.Buffer
+	report.write(&output)
+	for _, want := range []string{
+		"✓ compose file: /opt/app/compose.yml\n",
+		"! user: running as root\n  hint: Run as another user.\n",
+		"✗ docker: docker version check failed\n  hint: Install Docker.\n",
+		"\n1 passed, 2 warned, 1 failed\n",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("write() = %q, want it to contain %q", output.String(), want)
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded doctorReport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Status != doctorFail || len(decoded.Checks) != 4 ||
+		decoded.Checks[2].Hint != "Install Docker." {
+		t.Errorf("JSON report = %s", data)
+	}
+}
+
+func TestDoctorSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretPath, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keySetPath := filepath.Join(dir, "keyset")
+	if err := os.WriteFile(keySetPath, []byte("ci secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	report := doctorReport{Status: doctorPass}
+	report.checkSecretFiles()
+	if len(report.Checks) != 1 || report.Checks[0].Name != "secrets" ||
+		report.Status != doctorFail {
+		t.Errorf("checks without secret files = %+v", report.Checks)
+	}
+
+	t.Setenv("DCHOOK_SECRET_FILE", secretPath)
+	t.Setenv("DCHOOK_KEYSET_FILE", keySetPath)
+
+	report = doctorReport{Status: doctorPass}
+	report.checkSecretFiles()
+
+	want := []doctorCheck{
+		{Name: "secret_file", Status: doctorPass},
+		{Name: "keyset_file", Status: doctorFail},
+	}
+	if len(report.Checks) != len(want) {
+		t.Fatalf("checks = %+v, want %d checks", report.Checks, len(want))
+	}
+	for i, check := range report.Checks {
+		if check.Name != want[i].Name || check.Status != want[i].Status {
+			t.Errorf("check %d = %+v, want %s %s", i, check, want[i].Name, want[i].Status)
+		}
+	}
+}
+
+func TestDoctorCertFiles(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "server.crt")
+	if err := os.WriteFile(certPath, []byte("certificate\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	certLink := filepath.Join(dir, "live.crt")
+	if err := os.Symlink(certPath, certLink); err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "server.key")
+	if err := os.WriteFile(keyPath, []byte("key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DCHOOK_TLS_CERT", certLink)
+	t.Setenv("DCHOOK_TLS_KEY", keyPath)
+	t.Setenv("DCHOOK_TLS_CLIENT_CA", "ca.crt")
+
+	// TLS files do not authenticate deploy requests.
+	report := doctorReport{Status: doctorPass}
+	report.checkSecretFiles()
+	if len(report.Checks) != 2 || report.Checks[0].Name != "tls_key" ||
+		report.Checks[1].Name != "secrets" || report.Status != doctorFail {
+		t.Errorf("checks with only TLS files = %+v", report.Checks)
+	}
+
+	report = doctorReport{Status: doctorPass}
+	report.checkCertFiles()
+
+	want := []doctorCheck{
+		{Name: "tls_cert", Status: doctorPass},
+		{Name: "tls_client_ca", Status: doctorFail},
+	}
+	if len(report.Checks) != len(want) {
+		t.Fatalf("checks = %+v, want %d checks", report.Checks, len(want))
+	}
+	for i, check := range report.Checks {
+		if check.Name != want[i].Name || check.Status != want[i].Status {
+			t.Errorf("check %d = %+v, want %s %s", i, check, want[i].Name, want[i].Status)
+		}
+	}
+}
+
+func TestReadDockerCredentials(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	config := `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNz"},
+    "ghcr.io": {}
+  },
+  "credHelpers": {"123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"},
+  "credsStore": "desktop"
+}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := readDockerCredentials(path)
+	if err != nil {
+		t.Fatalf("readDockerCredentials() error = %v", err)
+	}
+
+	for _, host := range []string{
+		"docker.io",
+		"ghcr.io",
+		"123456789012.dkr.ecr.us-east-1.amazonaws.com",
+	} {
+		if !hosts[host] {
+			t.Errorf("hosts = %v, want %q", hosts, host)
+		}
+	}
+	if hosts["quay.io"] {
+		t.Error("hosts should not include quay.io")
+	}
+
+	if _, err := readDockerCredentials(filepath.Join(dir, "missing.json")); !errors.Is(
+		err,
+		os.ErrNotExist,
+	) {
+		t.Errorf("readDockerCredentials(missing) error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readDockerCredentials(path); err == nil {
+		t.Error("readDockerCredentials(invalid) should fail")
+	}
+}
+
+func TestCheckCredentials(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"auths": {"ghcr.io": {}}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	report := doctorReport{Status: doctorPass}
+	report.checkCredentials([]string{"ghcr.io/org/app:1", "ghcr.io/org/worker", "nginx"}, path)
+
+	if len(report.Checks) != 2 {
+		t.Fatalf("checks = %+v, want one per registry", report.Checks)
+	}
+	if check := report.Checks[0]; check.Name != "registry credentials ghcr.io" ||
+		check.Status != doctorPass {
+		t.Errorf("ghcr.io check = %+v", check)
+	}
+	if check := report.Checks[1]; check.Name != "registry credentials docker.io" ||
+		check.Status != doctorWarn {
+		t.Errorf("docker.io check = %+v", check)
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		offset     time.Duration
+		wantStatus string
+	}{
+		{"in sync", 0, doctorPass},
+		{"drifting", 30 * time.Second, doctorWarn},
+		{"behind reference", -2 * time.Minute, doctorFail},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, _ *http.Request) {
+					reference := time.Now().Add(-testCase.offset)
+					w.Header().Set("Date", reference.UTC().Format(http.TimeFormat))
+				},
+			))
+			defer server.Close()
+
+			skew, err := clockSkew(server.URL)
+			if err != nil {
+				t.Fatalf("clockSkew() error = %v", err)
+			}
+			if (skew - testCase.offset).Abs() > 2*time.Second {
+				t.Errorf("clockSkew() = %s, want about %s", skew, testCase.offset)
+			}
+
+			report := doctorReport{Status: doctorPass}
+			report.checkClock(server.URL)
+			if report.Status != testCase.wantStatus {
+				t.Errorf("checkClock() = %+v, want %s", report.Checks, testCase.wantStatus)
+			}
+		})
+	}
+}
+
+func TestClockSkewErrors(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header()["Date"] = nil
+	}))
+	defer server.Close()
+
+	if _, err := clockSkew(server.URL); !errors.Is(err, errClockReferenceDate) {
+		t.Errorf("clockSkew() error = %v, want %v", err, errClockReferenceDate)
+	}
+
+	if _, err := clockSkew("ftp://example.com/"); !errors.Is(err, errClockReferenceURL) {
+		t.Errorf("clockSkew() error = %v, want %v", err, errClockReferenceURL)
+	}
+}
@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const payloadVariableFields = 3
+
+var (
+	errPayloadEnvLine  = errors.New("payload environment line must be \"variable field pattern\"")
+	errPayloadEnvName  = errors.New("invalid compose variable name")
+	errPayloadEnvField = errors.New("invalid payload field")
+	errPayloadEnvDup   = errors.New("duplicate compose variable")
+	errPayloadEnvEmpty = errors.New("payload environment file contains no variables")
+	errPayloadValue    = errors.New("payload value does not match the variable pattern")
+	errPayloadType     = errors.New("payload value must be a string, number, or boolean")
+
+	// composeVariablePattern matches a compose interpolation variable name.
+	composeVariablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// payloadVariable sets a compose variable from a field of the deploy request payload.
+// The value must match the pattern in full.
+type payloadVariable struct {
+	name    string
+	field   []string
+	pattern *regexp.Regexp
+}
+
+// parsePayloadEnv parses payload environment data into a list of variables, in order.
+//
+// Each non-blank line that does not start with `#` holds a compose variable name, a
+// payload field, and a regular expression that values must match in full, separated by
+// whitespace. Fields are dot-separated paths into payload objects (`build.version`).
+// Patterns may not contain whitespace; use `\s` or `[ ]` instead.
+func parsePayloadEnv(data string) ([]payloadVariable, error) {
+	var variables []payloadVariable
+	names := make(map[string]bool)
+
+	for number, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != payloadVariableFields {
+			return nil, fmt.Errorf("line %d: %w", number+1, errPayloadEnvLine)
+		}
+
+		variable, err := parsePayloadVariable(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+
+		if names[variable.name] {
+			return nil, fmt.Errorf("line %d: %w: %q", number+1, errPayloadEnvDup, variable.name)
+		}
+		names[variable.name] = true
+		variables = append(variables, variable)
+	}
+
+	if len(variables) == 0 {
+		return nil, errPayloadEnvEmpty
+	}
+	return variables, nil
+}
+
+func parsePayloadVariable(fields []string) (payloadVariable, error) {
+	if !composeVariablePattern.MatchString(fields[0]) {
+		return payloadVariable{}, fmt.Errorf("%w: %q", errPayloadEnvName, fields[0])
+	}
+
+	field := strings.Split(fields[1], ".")
+	if slices.Contains(field, "") {
+		return payloadVariable{}, fmt.Errorf("%w: %q", errPayloadEnvField, fields[1])
+	}
+
+	pattern, err := regexp.Compile(`^(?:` + fields[2] + `)$`)
+	if err != nil {
+		return payloadVariable{}, fmt.Errorf("invalid pattern for %q: %w", fields[0], err)
+	}
+
+	return payloadVariable{name: fields[0], field: field, pattern: pattern}, nil
+}
+
+// readPayloadEnv reads the payload environment file at path. Returns nil if path is
+// empty.
+func readPayloadEnv(path string) ([]payloadVariable, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := dchook.ReadSecretFileStrict(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payload environment: %w", err)
+	}
+
+	variables, err := parsePayloadEnv(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload environment file %q: %w", path, err)
+	}
+	return variables, nil
+}
+
+// payloadEnvironment resolves the payload variables from the deploy request payload.
+// Variables whose field is not in the payload are not set. Values may not contain
+// newlines or single quotes, which cannot be written to an env file literally. Returns
+// nil if no variables are set.
+func (cfg *HandlerConfig) payloadEnvironment(payload any) (map[string]string, error) {
+	var environment map[string]string
+
+	for _, variable := range cfg.payloadEnv {
+		raw, found := payloadField(payload, variable.field)
+		if !found {
+			continue
+		}
+
+		value, err := payloadValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, variable.name)
+		}
+
+		if !variable.pattern.MatchString(value) || strings.ContainsAny(value, "\r\n'") {
+			return nil, fmt.Errorf("%w: %s=%q", errPayloadValue, variable.name, value)
+		}
+
+		if environment == nil {
+			environment = make(map[string]string)
+		}
+		environment[variable.name] = value
+	}
+	return environment, nil
+}
+
+// payloadField looks up a dot-separated field path in the payload objects.
+func payloadField(payload any, field []string) (any, bool) {
+	value := payload
+	for _, key := range field {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+// payloadValue formats a scalar payload value as a compose variable value.
+func payloadValue(value any) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case float64, bool:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", errPayloadType, err)
+		}
+		return string(data), nil
+	default:
+		return "", errPayloadType
+	}
+}
+
+// writeEnvFile writes the environment to a compose env file with single-quoted, literal
+// values. Returns the path of the file, which the caller must remove.
+func writeEnvFile(deploymentID string, environment map[string]string) (string, error) {
+	var envFile strings.Builder
+	envFile.WriteString("# Generated by dchook for deployment " + deploymentID + "\n")
+	for _, name := range slices.Sorted(maps.Keys(environment)) {
+		envFile.WriteString(name + "='" + environment[name] + "'\n")
+	}
+
+	return writeDeploymentFile("dchook-env-*.env", envFile.String())
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePayloadEnv(t *testing.T) {
+	t.Parallel()
+
+	variables, err := parsePayloadEnv(`# variable  field          pattern
+APP_VERSION   version        v[0-9]+\.[0-9]+\.[0-9]+
+GIT_SHA       build.commit   [0-9a-f]{40}
+`)
+	if err != nil {
+		t.Fatalf("parsePayloadEnv() error = %v", err)
+	}
+
+	if len(variables) != 2 || variables[0].name != "APP_VERSION" ||
+		!slices.Equal(variables[1].field, []string{"build", "commit"}) {
+		t.Fatalf("parsePayloadEnv() = %+v", variables)
+	}
+	if variables[0].pattern.MatchString("v1.2.3-rc1") {
+		t.Error("patterns should match the whole value")
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"empty", "# no variables\n", errPayloadEnvEmpty},
+		{"missing pattern", "APP_VERSION version\n", errPayloadEnvLine},
+		{"invalid name", "APP-VERSION version .*\n", errPayloadEnvName},
+		{"empty field", "APP_VERSION build..version .*\n", errPayloadEnvField},
+		{"duplicate", "A version .*\nA commit .*\n", errPayloadEnvDup},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := parsePayloadEnv(testCase.data); !errors.Is(err, testCase.want) {
+				t.Errorf("parsePayloadEnv() error = %v, want %v", err, testCase.want)
+			}
+		})
+	}
+
+	if _, err := parsePayloadEnv("APP_VERSION version v[0-9\n"); err == nil {
+		t.Error("parsePayloadEnv() should reject invalid patterns")
+	}
+}
+
+func TestPayloadEnvironment(t *testing.T) {
+	t.Parallel()
+
+	variables, err := parsePayloadEnv(`APP_VERSION version    v[0-9.]+
+GIT_SHA     build.sha  [0-9a-f]{7,40}
+REPLICAS    replicas   [0-9]+
+MESSAGE     message    .*
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &HandlerConfig{payloadEnv: variables}
+
+	tests := []struct {
+		name    string
+		payload string
+		want    map[string]string
+		wantErr error
+	}{
+		{"no variables", `{}`, nil, nil},
+		{"text payload", `"v1.2.3"`, nil, nil},
+		{
+			"variables",
+			`{"version":"v1.2.3","build":{"sha":"abc1234"},"replicas":3}`,
+			map[string]string{"APP_VERSION": "v1.2.3", "GIT_SHA": "abc1234", "REPLICAS": "3"},
+			nil,
+		},
+		{"null value", `{"version":null}`, nil, nil},
+		{"pattern mismatch", `{"version":"1.2.3"}`, nil, errPayloadValue},
+		{"object value", `{"build":{"sha":{}}}`, nil, errPayloadType},
+		{"newline", `{"message":"one\ntwo"}`, nil, errPayloadValue},
+		{"single quote", `{"message":"it's"}`, nil, errPayloadValue},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			var payload any
+			if err := json.Unmarshal([]byte(testCase.payload), &payload); err != nil {
+				t.Fatal(err)
+			}
+
+			environment, err := cfg.payloadEnvironment(payload)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("payloadEnvironment() error = %v, want %v", err, testCase.wantErr)
+			}
+			if !maps.Equal(environment, testCase.want) {
+				t.Errorf("payloadEnvironment() = %v, want %v", environment, testCase.want)
+			}
+		})
+	}
+}
+
+func TestDeploymentEnvFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	composeFile := filepath.Join(dir, "compose.yml")
+	adapter := &DockerComposeAdapter{ComposeFile: composeFile}
+	deployment := &Deployment{
+		ID:          "abc123",
+		Environment: map[string]string{"GIT_SHA": "abc1234", "APP_VERSION": "v1.2.3"},
+	}
+
+	deployed, cleanup, err := adapter.forDeployment(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	if len(deployed.EnvFiles) != 1 {
+		t.Fatalf("EnvFiles = %v, want the generated env file", deployed.EnvFiles)
+	}
+
+	data, err := os.ReadFile(deployed.EnvFiles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Generated by dchook for deployment abc123\nAPP_VERSION='v1.2.3'\nGIT_SHA='abc1234'\n"
+	if string(data) != want {
+		t.Errorf("env file = %q, want %q", data, want)
+	}
+
+	command := deployed.formatCommand("up", "-d")
+	if !strings.HasPrefix(command, "docker compose --env-file "+deployed.EnvFiles[0]+" -f ") {
+		t.Errorf("formatCommand() = %q, want the env file before the compose file", command)
+	}
+
+	// An existing .env in the compose directory is passed before the generated file, as
+	// --env-file replaces it.
+	dotEnv := filepath.Join(dir, ".env")
+	if err := os.WriteFile(dotEnv, []byte("APP_VERSION=v0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	deployed, cleanup, err = adapter.forDeployment(deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	if len(deployed.EnvFiles) != 2 || deployed.EnvFiles[0] != dotEnv {
+		t.Errorf("EnvFiles = %v, want %s first", deployed.EnvFiles, dotEnv)
+	}
+}
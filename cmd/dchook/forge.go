@@ -0,0 +1,526 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	eventPing     = "ping"
+	eventPush     = "push"
+	eventTagPush  = "tag_push"
+	eventPipeline = "pipeline"
+
+	// pipelineSuccess is the only pipeline status that can trigger a deployment.
+	pipelineSuccess = "success"
+
+	branchRefPrefix = "refs/heads/"
+	tagRefPrefix    = "refs/tags/"
+
+	// deliveryTrackingWindow is how long forge delivery IDs and body hashes are
+	// remembered. Forge payloads have no signed timestamp, so this is longer than
+	// replayTrackingWindow.
+	deliveryTrackingWindow = 24 * time.Hour
+)
+
+var (
+	errEventRuleEmpty   = errors.New("event rule must name an event")
+	errEventRuleInvalid = errors.New("event rule contains invalid character")
+	errEventRulesNone   = errors.New("no event rules")
+	errForgeNeedsSHA256 = errors.New("webhooks require the sha256 algorithm")
+	errForgeNeedsSecret = errors.New("webhooks require a secret file")
+	errForgeSecretEmpty = errors.New("webhook secret file is empty")
+)
+
+// eventRule matches a forge webhook event by name, an optional qualifier, and an
+// optional project. The qualifier is the branch for push events, the tag for tag push
+// events, the ref for pipeline events, and the action for all other events. An empty
+// qualifier or project matches any. Pipeline events only match once the pipeline (the
+// event action) has succeeded, so a rule like `pipeline:main` deploys once per
+// successful pipeline rather than on every status change.
+type eventRule struct {
+	event     string
+	qualifier string
+	project   string
+}
+
+// forgeEvent is the part of a forge webhook used to match event rules.
+type forgeEvent struct {
+	event   string
+	action  string
+	ref     string
+	project string
+}
+
+// forgeConfig is the configuration for one forge's webhooks.
+type forgeConfig struct {
+	rules  []eventRule
+	secret string
+}
+
+// forgeReceiver describes how webhooks from one forge are authenticated and interpreted.
+type forgeReceiver struct {
+	// name identifies the forge in configuration, logs, and recorded requests.
+	name string
+	// hmacSHA256 is set if the forge signs the body with HMAC-SHA256. Otherwise the
+	// secret is sent as a plain token and must be configured separately.
+	hmacSHA256 bool
+	// authenticate checks the request against the forge secret.
+	authenticate func(r *http.Request, body []byte, secret string, allowed map[string]bool) bool
+	// delivery returns the unique delivery ID used for replay detection.
+	delivery func(r *http.Request) string
+	// parse extracts the event from the request.
+	parse func(r *http.Request, body []byte) (forgeEvent, error)
+}
+
+// algorithm returns the algorithm recorded in the audit log for requests from the forge.
+func (receiver *forgeReceiver) algorithm() string {
+	if receiver.hmacSHA256 {
+		return dchook.AlgorithmSHA256
+	}
+	return auditAlgorithmToken
+}
+
+// deliveryNonces returns the nonces that identify a delivery: the delivery ID and, for
+// forges that sign the body, the body hash. Delivery IDs are not signed, so a captured
+// delivery could otherwise be replayed with a new ID.
+func (receiver *forgeReceiver) deliveryNonces(delivery string, body []byte) []string {
+	nonces := []string{receiver.name + ":" + delivery}
+	if receiver.hmacSHA256 {
+		sum := sha256.Sum256(body)
+		nonces = append(nonces, receiver.name+":sha256:"+hex.EncodeToString(sum[:]))
+	}
+	return nonces
+}
+
+// seenAnyNonce checks if any of the nonces has been recorded.
+func seenAnyNonce(limiter *dchook.RateLimiter, nonces []string) bool {
+	for _, nonce := range nonces {
+		if limiter.SeenNonce(nonce) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordNonces records the nonces of an accepted deployment. Returns false if any of
+// them was already recorded by a concurrent delivery.
+func recordNonces(limiter *dchook.RateLimiter, nonces []string) bool {
+	recorded := true
+	for _, nonce := range nonces {
+		if !limiter.CheckNonce(nonce) {
+			recorded = false
+		}
+	}
+	return recorded
+}
+
+// webhookEvent is recorded as the Deployment request for deployments triggered by
+// third-party webhooks, so that status queries show what triggered them.
+type webhookEvent struct {
+	Source   string          `json:"source"`
+	Event    string          `json:"event"`
+	Delivery string          `json:"delivery,omitempty"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// parseEventRules parses a comma-separated list of `event[:qualifier][@project]` rules,
+// such as `push:main@org/app,registry_package:published`.
+func parseEventRules(value string) ([]eventRule, error) {
+	var rules []eventRule
+	for item := range strings.SplitSeq(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		for _, r := range item {
+			if r <= ' ' || r == 127 {
+				return nil, fmt.Errorf("%w: %q", errEventRuleInvalid, item)
+			}
+		}
+
+		rest, project, _ := strings.Cut(item, "@")
+		event, qualifier, _ := strings.Cut(rest, ":")
+		if event == "" {
+			return nil, fmt.Errorf("%w: %q", errEventRuleEmpty, item)
+		}
+
+		rules = append(rules, eventRule{event: event, qualifier: qualifier, project: project})
+	}
+
+	if len(rules) == 0 {
+		return nil, errEventRulesNone
+	}
+	return rules, nil
+}
+
+// matchesEvent checks if the event matches any of the rules.
+func matchesEvent(rules []eventRule, event forgeEvent) bool {
+	for _, rule := range rules {
+		if rule.event != event.event {
+			continue
+		}
+
+		if rule.project != "" && rule.project != event.project {
+			continue
+		}
+
+		if event.event == eventPipeline && event.action != pipelineSuccess {
+			continue
+		}
+
+		if rule.qualifier == "" {
+			return true
+		}
+
+		var matched bool
+		switch event.event {
+		case eventPush:
+			matched = event.ref == branchRefPrefix+rule.qualifier
+		case eventTagPush:
+			matched = event.ref == tagRefPrefix+rule.qualifier
+		case eventPipeline:
+			matched = event.ref == rule.qualifier
+		default:
+			matched = event.action == rule.qualifier
+		}
+
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// loadForgeConfig reads the event rules and secret for a forge from the flag values or
+// the DCHOOK_<FORGE>_EVENTS and DCHOOK_<FORGE>_SECRET_FILE environment variables.
+// Returns nil if no events are configured. The secret defaults to the webhook secret for
+// forges that sign payloads with HMAC-SHA256; it is never sent as a plain token.
+func loadForgeConfig(
+	receiver *forgeReceiver,
+	eventsFlag, secretFlag, defaultSecret string,
+	allowedAlgorithms map[string]bool,
+) (*forgeConfig, error) {
+	envPrefix := "DCHOOK_" + strings.ToUpper(receiver.name)
+
+	//nolint:errcheck // Optional
+	events, _ := dchook.FlagValue(eventsFlag, envPrefix+"_EVENTS", "")
+	if events == "" {
+		return nil, nil //nolint:nilnil // Not configured
+	}
+
+	rules, err := parseEventRules(events)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s events: %w", receiver.name, err)
+	}
+
+	if receiver.hmacSHA256 && !allowedAlgorithms[dchook.AlgorithmSHA256] {
+		return nil, fmt.Errorf("%s %w", receiver.name, errForgeNeedsSHA256)
+	}
+
+	//nolint:errcheck // Optional
+	secretFilePath, _ := dchook.FlagValue(secretFlag, envPrefix+"_SECRET_FILE", "")
+	if secretFilePath == "" {
+		if !receiver.hmacSHA256 || defaultSecret == "" {
+			return nil, fmt.Errorf("%s %w", receiver.name, errForgeNeedsSecret)
+		}
+		return &forgeConfig{rules: rules, secret: defaultSecret}, nil
+	}
+
+	secret, err := dchook.ReadSecretFileStrict(secretFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s secret: %w", receiver.name, err)
+	}
+
+	if secret == "" {
+		return nil, fmt.Errorf("%w: %q", errForgeSecretEmpty, secretFilePath)
+	}
+	return &forgeConfig{rules: rules, secret: secret}, nil
+}
+
+// createForgeHandler handles webhooks from a forge at /deploy/<forge>. The request is
+// authenticated with the forge secret, replays are detected with the forge delivery ID
+// and, for signed bodies, the body hash, and a deployment is started for events that
+// match the configured rules. Delivery IDs are recorded only for accepted deployments,
+// so forge retries of rejected events are not treated as replays.
+func createForgeHandler(
+	store *ConfigStore,
+	limiter *dchook.RateLimiter,
+	receiver *forgeReceiver,
+) http.HandlerFunc {
+	path := "/deploy/" + receiver.name
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := store.Load()
+		forge := cfg.forges[receiver.name]
+
+		// Exact path match; not found unless enabled
+		if r.URL.Path != path || forge == nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		audit, body, ok := cfg.readWebhook(w, r, limiter, receiver.name)
+		if !ok {
+			return
+		}
+		audit.Identity, audit.Algorithm = receiver.name, receiver.algorithm()
+
+		delivery, nonces, ok := cfg.authenticateForge(w, r, limiter, receiver, forge, audit, body)
+		if !ok {
+			return
+		}
+
+		event, ok := cfg.matchForgeEvent(w, r, limiter, receiver, forge, audit, body, delivery)
+		if !ok {
+			return
+		}
+
+		request, err := json.Marshal(webhookEvent{
+			Source:   receiver.name,
+			Event:    event.event,
+			Delivery: delivery,
+			Payload:  json.RawMessage(body),
+		})
+		if err != nil {
+			slog.Error("failed to encode deployment request", "error", err)
+			cfg.metrics.DeployRequest(receiver.name, outcomeError)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		cfg.acceptDeployment(w, r, limiter, &deployRequest{
+			endpoint: receiver.name,
+			request:  request,
+			audit:    audit,
+			claim: func() bool {
+				return cfg.claimDelivery(w, limiter, receiver, audit, delivery, nonces)
+			},
+			logArgs: func(deployOptions) []any {
+				return []any{
+					"source",
+					receiver.name,
+					"event",
+					event.event,
+					"action",
+					event.action,
+					"ref",
+					event.ref,
+					"project",
+					event.project,
+					"delivery",
+					delivery,
+					"ip",
+					audit.IP,
+					"ip_source",
+					audit.IPSource,
+				}
+			},
+		})
+	}
+}
+
+// readWebhook checks that a webhook or notification for the endpoint can be handled and
+// reads its body. Returns the audit record and the body of the request, or responds to
+// the request and returns false.
+func (cfg *HandlerConfig) readWebhook(
+	w http.ResponseWriter,
+	r *http.Request,
+	limiter *dchook.RateLimiter,
+	endpoint string,
+) (AuditRecord, []byte, bool) {
+	if !cfg.dockerAvailable {
+		cfg.metrics.DeployRequest(endpoint, outcomeUnavailable)
+		http.Error(w, "Service unavailable: Docker not accessible", http.StatusServiceUnavailable)
+		return AuditRecord{}, nil, false
+	}
+
+	if cfg.deployments.Draining() {
+		cfg.metrics.DeployRequest(endpoint, outcomeUnavailable)
+		http.Error(w, "Service unavailable: shutting down", http.StatusServiceUnavailable)
+		return AuditRecord{}, nil, false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, dchook.MaxRequestBodySize)
+
+	ip, ipSource, err := extractClientIP(cfg.ipExtractor, r)
+	if err != nil {
+		cfg.metrics.DeployRequest(endpoint, outcomeBadRequest)
+		http.Error(w, "Bad request: invalid client address", http.StatusBadRequest)
+		return AuditRecord{}, nil, false
+	}
+	audit := AuditRecord{Endpoint: endpoint, IP: ip, IPSource: ipSource}
+
+	if limiter.IsBanned(ip) {
+		//nolint:gosec // slog does not have log injection
+		slog.Warn("banned IP attempted access", "ip", ip, "ip_source", ipSource)
+		cfg.metrics.DeployRequest(endpoint, outcomeBanned)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return AuditRecord{}, nil, false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		//nolint:gosec // slog does not have log injection
+		slog.Warn("failed to read request body", "ip", ip, "error", err)
+		cfg.recordFailure(limiter, audit.failed(auditBadRequest, "unreadable body"))
+		cfg.metrics.DeployRequest(endpoint, outcomeBadRequest)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return AuditRecord{}, nil, false
+	}
+	return audit, body, true
+}
+
+// authenticateForge checks a webhook against the forge secret and checks that its
+// delivery has not been deployed. Returns the delivery ID and the nonces to record when
+// the deployment is accepted, or responds to the request and returns false.
+func (cfg *HandlerConfig) authenticateForge(
+	w http.ResponseWriter,
+	r *http.Request,
+	limiter *dchook.RateLimiter,
+	receiver *forgeReceiver,
+	forge *forgeConfig,
+	audit AuditRecord,
+	body []byte,
+) (string, []string, bool) {
+	verifySpan := startVerifySpan(r, receiver.name)
+	if !receiver.authenticate(r, body, forge.secret, cfg.allowedAlgorithms) {
+		endVerifySpan(verifySpan, errSignatureInvalid)
+		//nolint:gosec // slog does not have taint injection
+		slog.Warn("invalid signature", "source", receiver.name, "ip", audit.IP)
+		cfg.recordFailure(limiter, audit.failed(auditAuthFailed, "invalid signature"))
+		cfg.metrics.DeployRequest(receiver.name, outcomeBadSignature)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", nil, false
+	}
+	endVerifySpan(verifySpan, nil)
+
+	// A delivery is recorded only once the deployment is accepted, so that redeliveries
+	// of rejected events can still deploy, but a recorded one is a replay.
+	delivery := receiver.delivery(r)
+	nonces := receiver.deliveryNonces(delivery, body)
+	if delivery == "" || seenAnyNonce(limiter, nonces) {
+		//nolint:gosec // slog does not have taint injection
+		slog.Warn(
+			"replay attack detected",
+			"source",
+			receiver.name,
+			"ip",
+			audit.IP,
+			"delivery",
+			delivery,
+		)
+		cfg.recordFailure(limiter, audit.failed(auditReplayDetected, "replayed delivery"))
+		cfg.metrics.DeployRequest(receiver.name, outcomeReplay)
+		http.Error(w, "Invalid or replayed delivery", http.StatusBadRequest)
+		return "", nil, false
+	}
+	return delivery, nonces, true
+}
+
+// matchForgeEvent parses the event of a webhook and checks that it matches the rules of
+// the forge. Returns the event, or responds to the request (with pong for pings) and
+// returns false.
+func (cfg *HandlerConfig) matchForgeEvent(
+	w http.ResponseWriter,
+	r *http.Request,
+	limiter *dchook.RateLimiter,
+	receiver *forgeReceiver,
+	forge *forgeConfig,
+	audit AuditRecord,
+	body []byte,
+	delivery string,
+) (forgeEvent, bool) {
+	event, err := receiver.parse(r, body)
+	if err != nil {
+		//nolint:gosec // slog does not have taint injection
+		slog.Warn("invalid JSON payload", "source", receiver.name, "ip", audit.IP, "error", err)
+		cfg.recordFailure(limiter, audit.failed(auditBadRequest, "invalid JSON payload"))
+		cfg.metrics.DeployRequest(receiver.name, outcomeBadRequest)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return forgeEvent{}, false
+	}
+
+	if event.event == eventPing {
+		//nolint:gosec // slog does not have taint injection
+		slog.Info("ping received", "source", receiver.name, "ip", audit.IP, "delivery", delivery)
+		cfg.metrics.DeployRequest(receiver.name, outcomeIgnored)
+		if _, err := fmt.Fprintf(w, "pong\n"); err != nil {
+			slog.Error("failed to write response", "error", err)
+		}
+		return forgeEvent{}, false
+	}
+
+	if !matchesEvent(forge.rules, event) {
+		//nolint:gosec // slog does not have taint injection
+		slog.Info(
+			"event ignored",
+			"source",
+			receiver.name,
+			"event",
+			event.event,
+			"action",
+			event.action,
+			"ref",
+			event.ref,
+			"project",
+			event.project,
+			"delivery",
+			delivery,
+		)
+		cfg.metrics.DeployRequest(receiver.name, outcomeIgnored)
+		if _, err := fmt.Fprintf(w, "Event ignored\n"); err != nil {
+			slog.Error("failed to write response", "error", err)
+		}
+		return forgeEvent{}, false
+	}
+	return event, true
+}
+
+// claimDelivery records the nonces of a delivery whose deployment is accepted. Returns
+// false, ignoring the delivery, if a concurrent delivery recorded them first.
+func (cfg *HandlerConfig) claimDelivery(
+	w http.ResponseWriter,
+	limiter *dchook.RateLimiter,
+	receiver *forgeReceiver,
+	audit AuditRecord,
+	delivery string,
+	nonces []string,
+) bool {
+	if recordNonces(limiter, nonces) {
+		return true
+	}
+
+	//nolint:gosec // slog does not have taint injection
+	slog.Info(
+		"delivery already deployed",
+		"source",
+		receiver.name,
+		"ip",
+		audit.IP,
+		"delivery",
+		delivery,
+	)
+	cfg.metrics.DeployRequest(receiver.name, outcomeIgnored)
+	if _, err := fmt.Fprintf(w, "Event ignored\n"); err != nil {
+		slog.Error("failed to write response", "error", err)
+	}
+	return false
+}
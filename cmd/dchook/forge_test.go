@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestParseEventRules(t *testing.T) {
+	t.Parallel()
+
+	rules, err := parseEventRules(
+		"push:main, registry_package:published,workflow_run,pipeline:main@group/app,package@org",
+	)
+	if err != nil {
+		t.Fatalf("parseEventRules() error = %v", err)
+	}
+
+	want := []eventRule{
+		{event: "push", qualifier: "main"},
+		{event: "registry_package", qualifier: "published"},
+		{event: "workflow_run"},
+		{event: "pipeline", qualifier: "main", project: "group/app"},
+		{event: "package", project: "org"},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("parseEventRules() = %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("parseEventRules()[%d] = %v, want %v", i, rules[i], want[i])
+		}
+	}
+
+	for _, invalid := range []string{"", ":main", "push:ma in", ",", "@org/app"} {
+		if _, err := parseEventRules(invalid); err == nil {
+			t.Errorf("parseEventRules(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestMatchesEvent(t *testing.T) {
+	t.Parallel()
+
+	rules := []eventRule{
+		{event: "push", qualifier: "main"},
+		{event: "tag_push", qualifier: "v1"},
+		{event: "pipeline", qualifier: "main", project: "group/app"},
+		{event: "registry_package", qualifier: "published"},
+		{event: "release", project: "org/app"},
+	}
+
+	tests := []struct {
+		name  string
+		event forgeEvent
+		want  bool
+	}{
+		{"push to branch", forgeEvent{event: "push", ref: "refs/heads/main"}, true},
+		{"push to other branch", forgeEvent{event: "push", ref: "refs/heads/feature"}, false},
+		{"push to tag", forgeEvent{event: "push", ref: "refs/tags/main"}, false},
+		{"tag push", forgeEvent{event: "tag_push", ref: "refs/tags/v1"}, true},
+		{
+			"successful pipeline",
+			forgeEvent{event: "pipeline", action: "success", ref: "main", project: "group/app"},
+			true,
+		},
+		{
+			"running pipeline",
+			forgeEvent{event: "pipeline", action: "running", ref: "main", project: "group/app"},
+			false,
+		},
+		{
+			"pipeline in other project",
+			forgeEvent{event: "pipeline", action: "success", ref: "main", project: "group/x"},
+			false,
+		},
+		{"package published", forgeEvent{event: "registry_package", action: "published"}, true},
+		{"package updated", forgeEvent{event: "registry_package", action: "updated"}, false},
+		{"release in project", forgeEvent{event: "release", project: "org/app"}, true},
+		{"release in other project", forgeEvent{event: "release", project: "org/x"}, false},
+		{"other event", forgeEvent{event: "issues", action: "opened"}, false},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := matchesEvent(rules, testCase.event); got != testCase.want {
+				t.Errorf("matchesEvent(%+v) = %v, want %v", testCase.event, got, testCase.want)
+			}
+		})
+	}
+}
+
+func newForgeTestHandler(
+	t *testing.T,
+	receiver *forgeReceiver,
+	forge *forgeConfig,
+	limiter *dchook.RateLimiter,
+) (http.HandlerFunc, *HandlerConfig) {
+	t.Helper()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		allowedAlgorithms: map[string]bool{"sha256": true},
+		forges:            map[string]*forgeConfig{receiver.name: forge},
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+	}
+	if limiter == nil {
+		limiter = dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+	}
+	return createForgeHandler(NewConfigStore(cfg, nil), limiter, receiver), cfg
+}
+
+func sendForgeRequest(
+	handler http.HandlerFunc,
+	path, body string,
+	headers map[string]string,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(body)))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.RemoteAddr = "192.0.2.1:12345"
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestGitLabHandler(t *testing.T) {
+	t.Parallel()
+
+	token := "gitlab-token"
+	handler, cfg := newForgeTestHandler(t, gitlabReceiver, &forgeConfig{
+		rules:  []eventRule{{event: "pipeline", qualifier: "main", project: "group/app"}},
+		secret: token,
+	}, nil)
+
+	pipeline := func(status string) string {
+		return `{"object_kind":"pipeline","object_attributes":{"ref":"main","status":"` +
+			status + `"},"project":{"path_with_namespace":"group/app"}}`
+	}
+
+	send := func(uuid, body, secret string) *httptest.ResponseRecorder {
+		return sendForgeRequest(handler, "/deploy/gitlab", body, map[string]string{
+			"X-Gitlab-Event":      "Pipeline Hook",
+			"X-Gitlab-Event-Uuid": uuid,
+			"X-Gitlab-Token":      secret,
+		})
+	}
+
+	if w := send("u-1", pipeline("running"), token); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), "ignored") {
+		t.Errorf("running pipeline status = %d (%q), want ignored", w.Code, w.Body.String())
+	}
+
+	if w := send("u-2", pipeline("success"), token); w.Code != dchook.DeployAcceptedStatus {
+		t.Errorf("successful pipeline status = %d, want %d", w.Code, dchook.DeployAcceptedStatus)
+	}
+
+	if w := send("u-2", pipeline("success"), token); w.Code != http.StatusBadRequest {
+		t.Errorf("replayed delivery status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w := send("u-3", pipeline("success"), "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	deployments := cfg.history.List()
+	if len(deployments) == 0 {
+		t.Fatal("no deployment recorded")
+	}
+	if !strings.Contains(string(deployments[0].Request), `"source":"gitlab"`) {
+		t.Errorf("deployment request = %s, want GitLab source", deployments[0].Request)
+	}
+}
+
+func TestGitLabHandlerRetry(t *testing.T) {
+	t.Parallel()
+
+	token := "gitlab-token"
+	// One deployment per window and a ban on the first failure.
+	limiter := dchook.NewRateLimiter(1, 50*time.Millisecond, 1, time.Hour, time.Hour)
+	handler, _ := newForgeTestHandler(t, gitlabReceiver, &forgeConfig{
+		rules:  []eventRule{{event: "push", qualifier: "main"}},
+		secret: token,
+	}, limiter)
+
+	send := func(uuid, after string) int {
+		body := `{"object_kind":"push","ref":"refs/heads/main","after":"` + after + `"}`
+		return sendForgeRequest(handler, "/deploy/gitlab", body, map[string]string{
+			"X-Gitlab-Event":      "Push Hook",
+			"X-Gitlab-Event-Uuid": uuid,
+			"X-Gitlab-Token":      token,
+		}).Code
+	}
+
+	if code := send("u-1", "a1"); code != dchook.DeployAcceptedStatus {
+		t.Errorf("first push status = %d, want %d", code, dchook.DeployAcceptedStatus)
+	}
+
+	if code := send("u-2", "b2"); code != http.StatusTooManyRequests {
+		t.Errorf("rate limited push status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// GitLab retries failed hooks with the same event UUID.
+	time.Sleep(60 * time.Millisecond)
+	if code := send("u-2", "b2"); code != dchook.DeployAcceptedStatus {
+		t.Errorf("retried push status = %d, want %d", code, dchook.DeployAcceptedStatus)
+	}
+	if limiter.IsBanned("192.0.2.1") {
+		t.Error("retry of a rejected hook should not ban GitLab")
+	}
+}
+
+func TestGiteaHandler(t *testing.T) {
+	t.Parallel()
+
+	secret := "gitea-secret"
+	sign := func(body string) string {
+		return strings.TrimPrefix(
+			dchook.GenerateSignature([]byte(body), secret, "sha256"),
+			"sha256:",
+		)
+	}
+
+	pushMain := `{"ref":"refs/heads/main","repository":{"full_name":"org/app"}}`
+	packageCreated := `{"action":"created","package":{"name":"app","owner":{"login":"org"}}}`
+
+	for _, receiver := range []*forgeReceiver{giteaReceiver, forgejoReceiver} {
+		t.Run(receiver.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler, cfg := newForgeTestHandler(t, receiver, &forgeConfig{
+				rules: []eventRule{
+					{event: "push", qualifier: "main", project: "org/app"},
+					{event: "package", qualifier: "created", project: "org/app"},
+				},
+				secret: secret,
+			}, nil)
+
+			prefix := "X-" + strings.ToUpper(receiver.name[:1]) + receiver.name[1:] + "-"
+			send := func(event, delivery, body, signature string) *httptest.ResponseRecorder {
+				return sendForgeRequest(handler, "/deploy/"+receiver.name, body, map[string]string{
+					prefix + "Event":     event,
+					prefix + "Delivery":  delivery,
+					prefix + "Signature": signature,
+				})
+			}
+
+			if w := send("push", "d-1", pushMain, sign(pushMain)); w.Code !=
+				dchook.DeployAcceptedStatus {
+				t.Errorf("push status = %d, want %d", w.Code, dchook.DeployAcceptedStatus)
+			}
+
+			if w := send("package", "d-2", packageCreated, sign(packageCreated)); w.Code !=
+				dchook.DeployAcceptedStatus {
+				t.Errorf("package status = %d, want %d", w.Code, dchook.DeployAcceptedStatus)
+			}
+
+			if w := send("push", "d-3", pushMain, sign(packageCreated)); w.Code !=
+				http.StatusUnauthorized {
+				t.Errorf("bad signature status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+
+			deployments := cfg.history.List()
+			if len(deployments) == 0 {
+				t.Fatal("no deployment recorded")
+			}
+			source := `"source":"` + receiver.name + `"`
+			if !strings.Contains(string(deployments[0].Request), source) {
+				t.Errorf("deployment request = %s, want %s", deployments[0].Request, source)
+			}
+		})
+	}
+}
+
+func TestLoadForgeConfig(t *testing.T) {
+	t.Parallel()
+
+	allowed := map[string]bool{"sha256": true}
+
+	forge, err := loadForgeConfig(giteaReceiver, "push:main", "", "default", allowed)
+	if err != nil {
+		t.Fatalf("loadForgeConfig() error = %v", err)
+	}
+	if forge.secret != "default" {
+		t.Errorf("loadForgeConfig().secret = %q, want the default secret", forge.secret)
+	}
+
+	// GitLab tokens are sent in plain text and never default to the webhook secret.
+	if _, err := loadForgeConfig(gitlabReceiver, "push", "", "default", allowed); err == nil {
+		t.Error("loadForgeConfig() for GitLab without a token file should fail")
+	}
+
+	if _, err := loadForgeConfig(
+		giteaReceiver,
+		"push",
+		"",
+		"default",
+		map[string]bool{"ed25519": true},
+	); err == nil {
+		t.Error("loadForgeConfig() for Gitea without sha256 should fail")
+	}
+}
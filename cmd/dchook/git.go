@@ -0,0 +1,285 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	defaultGitRemote = "origin"
+
+	gitCheckTimeout = 5 * time.Second
+)
+
+var (
+	errGitRef           = errors.New("invalid git ref")
+	errGitRefPattern    = errors.New("invalid git ref pattern")
+	errGitRemote        = errors.New("invalid git remote")
+	errGitRefMissing    = errors.New("a git ref is required with git refs or a git remote")
+	errGitRefSelection  = errors.New("git ref selection is not enabled")
+	errGitRefNotAllowed = errors.New("git ref is not allowed")
+	errGitVerifyCommits = errors.New("invalid DCHOOK_GIT_VERIFY_COMMITS")
+	errGitWorkTree      = errors.New("compose file directory is not a git work tree")
+
+	// gitRefPattern matches branch and tag names that are safe to pass to git: no
+	// options, revision syntax, or path components that git rejects.
+	gitRefPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/-]*$`)
+
+	// workTrees serializes the deployments of each git work tree. It is shared by every
+	// configuration so that deployments started before and after a reload do not overlap.
+	workTrees = &workTreeLocks{locks: make(map[string]*sync.Mutex)}
+)
+
+// workTreeLocks holds a mutex per work tree directory.
+type workTreeLocks struct {
+	mutex sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock locks the work tree in the directory, waiting for running deployments of the work
+// tree. Returns the function that unlocks it.
+func (w *workTreeLocks) lock(directory string) func() {
+	w.mutex.Lock()
+	lock, found := w.locks[directory]
+	if !found {
+		lock = &sync.Mutex{}
+		w.locks[directory] = lock
+	}
+	w.mutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// gitSource updates the git work tree holding the compose file before each deployment:
+// it fetches a ref from the remote, optionally verifies the signature of its commit, and
+// checks the commit out. The ref is the ref selected by the deploy request, which must
+// match one of the allowed patterns, or the configured ref.
+type gitSource struct {
+	directory string
+	remote    string
+	ref       string
+	// refs are path.Match patterns of refs that deploy requests may select.
+	refs []string
+	// verify requires commits to have a valid signature (`git verify-commit`).
+	verify bool
+}
+
+// newGitSource creates the git source for the work tree holding the compose file,
+// validating the settings and that the directory is in a git work tree.
+func newGitSource(
+	composeFilePath, remote, ref string,
+	refs []string,
+	verify bool,
+) (*gitSource, error) {
+	if remote == "" {
+		remote = defaultGitRemote
+	}
+
+	if strings.HasPrefix(remote, "-") {
+		return nil, fmt.Errorf("%w: %q", errGitRemote, remote)
+	}
+
+	if err := validateGitRef(ref); err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range refs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", errGitRefPattern, pattern, err)
+		}
+	}
+
+	directory, err := filepath.Abs(filepath.Dir(composeFilePath))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", errGitWorkTree, composeFilePath, err)
+	}
+
+	source := &gitSource{
+		directory: directory,
+		remote:    remote,
+		ref:       ref,
+		refs:      refs,
+		verify:    verify,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitCheckTimeout)
+	defer cancel()
+
+	if _, err := source.runGit(ctx, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", errGitWorkTree, source.directory, err)
+	}
+	return source, nil
+}
+
+// allows checks if deploy requests may select the ref: the configured ref or a ref
+// matching one of the allowed patterns.
+func (g *gitSource) allows(ref string) bool {
+	if ref == g.ref {
+		return true
+	}
+
+	return slices.ContainsFunc(g.refs, func(pattern string) bool {
+		matched, err := path.Match(pattern, ref)
+		return err == nil && matched
+	})
+}
+
+// lock locks the work tree for a deployment, from checkout to restart, across
+// configuration reloads. Returns the function that unlocks it.
+func (g *gitSource) lock() func() {
+	return workTrees.lock(g.directory)
+}
+
+// checkout fetches the ref (or the configured ref if empty) from the remote and checks
+// out its commit, discarding local changes in the work tree, and then validates the new
+// work tree with validate. If validate fails, the previous commit is checked out again,
+// so that the work tree is not left at a commit that was not deployed. Returns the
+// commit and the output of the git commands. The caller must hold the lock of the work
+// tree.
+func (g *gitSource) checkout(
+	ctx context.Context,
+	ref string,
+	validate func(context.Context) error,
+) (string, []byte, error) {
+	if ref == "" {
+		ref = g.ref
+	}
+
+	var output bytes.Buffer
+
+	fetchOutput, err := g.runGit(ctx, "fetch", "--no-tags", "--force", "--", g.remote, ref)
+	output.Write(fetchOutput)
+	if err != nil {
+		return "", output.Bytes(), err
+	}
+
+	commitOutput, err := g.runGit(ctx, "rev-parse", "--verify", "FETCH_HEAD^{commit}")
+	if err != nil {
+		output.Write(commitOutput)
+		return "", output.Bytes(), err
+	}
+	commit := strings.TrimSpace(string(commitOutput))
+
+	if g.verify {
+		verifyOutput, err := g.runGit(ctx, "verify-commit", commit)
+		output.Write(verifyOutput)
+		if err != nil {
+			return commit, output.Bytes(), err
+		}
+	}
+
+	// A work tree without commits has nothing to restore.
+	//nolint:errcheck // Optional
+	previousOutput, _ := g.runGit(ctx, "rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	previous := strings.TrimSpace(string(previousOutput))
+
+	checkoutOutput, err := g.runGit(ctx, "checkout", "--force", "--detach", commit)
+	output.Write(checkoutOutput)
+	if err != nil {
+		return commit, output.Bytes(), err
+	}
+
+	if err := validate(ctx); err != nil {
+		output.WriteString(err.Error() + "\n")
+		if previous != "" && previous != commit {
+			restoreOutput, restoreErr := g.runGit(ctx, "checkout", "--force", "--detach", previous)
+			output.Write(restoreOutput)
+			err = errors.Join(err, restoreErr)
+		}
+		return commit, output.Bytes(), err
+	}
+	return commit, output.Bytes(), nil
+}
+
+func (g *gitSource) runGit(ctx context.Context, args ...string) ([]byte, error) {
+	//nolint:gosec // refs and remotes are validated
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", g.directory}, args...)...)
+	// Fail instead of waiting for credentials.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = dockerStopDelay
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return output, nil
+}
+
+// selectRef validates the git ref selected by a deploy request against the allowed refs.
+// Returns an empty string if no ref is selected, which deploys the configured ref.
+func (cfg *HandlerConfig) selectRef(ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+
+	if cfg.git == nil {
+		return "", errGitRefSelection
+	}
+
+	if err := validateGitRef(ref); err != nil {
+		return "", err
+	}
+
+	if !cfg.git.allows(ref) {
+		return "", fmt.Errorf("%w: %q", errGitRefNotAllowed, ref)
+	}
+	return ref, nil
+}
+
+// validateGitRef checks that the ref is a branch or tag name that can be passed to git.
+func validateGitRef(ref string) error {
+	if !gitRefPattern.MatchString(ref) || strings.Contains(ref, "..") ||
+		strings.Contains(ref, "//") || strings.HasSuffix(ref, "/") ||
+		strings.HasSuffix(ref, ".") || strings.HasSuffix(ref, ".lock") {
+		return fmt.Errorf("%w: %q", errGitRef, ref)
+	}
+	return nil
+}
+
+// parseGitVerifyCommits parses DCHOOK_GIT_VERIFY_COMMITS.
+func parseGitVerifyCommits() (bool, error) {
+	value := dchook.EnvValue("DCHOOK_GIT_VERIFY_COMMITS")
+	if value == "" {
+		return false, nil
+	}
+
+	verify, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%w: %q", errGitVerifyCommits, value)
+	}
+	return verify, nil
+}
+
+// loadGitSource creates the git source for the compose file from the ref, allowed refs,
+// and remote settings. Returns nil if no ref is configured.
+func loadGitSource(composeFilePath, remote, ref, refs string) (*gitSource, error) {
+	if ref == "" {
+		if refs != "" || remote != "" {
+			return nil, errGitRefMissing
+		}
+		return nil, nil //nolint:nilnil // Not configured
+	}
+
+	verify, err := parseGitVerifyCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	return newGitSource(composeFilePath, remote, ref, splitList(refs), verify)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitRepository creates a bare repository with a `main` branch and a `v1.0.0` tag, each
+// with a different compose file, and a clone of it. Returns the compose file path in the
+// clone.
+func gitRepository(t *testing.T) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	seed := filepath.Join(dir, "seed")
+	work := filepath.Join(dir, "work")
+
+	runTestGit(t, dir, "init", "--quiet", "--bare", remote)
+	runTestGit(t, dir, "init", "--quiet", "--initial-branch=main", seed)
+
+	commit := func(content string) {
+		composeFile := filepath.Join(seed, "compose.yml")
+		if err := os.WriteFile(composeFile, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		runTestGit(t, seed, "add", "compose.yml")
+		runTestGit(t, seed, "commit", "--quiet", "-m", content)
+	}
+
+	commit("# release\n")
+	runTestGit(t, seed, "tag", "v1.0.0")
+	commit("# main\n")
+	runTestGit(t, seed, "push", "--quiet", remote, "main", "v1.0.0")
+
+	runTestGit(t, dir, "clone", "--quiet", remote, work)
+	return filepath.Join(work, "compose.yml")
+}
+
+func runTestGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.CommandContext(context.Background(), "git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(
+		os.Environ(),
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_AUTHOR_NAME=dchook",
+		"GIT_AUTHOR_EMAIL=dchook@example.com",
+		"GIT_COMMITTER_NAME=dchook",
+		"GIT_COMMITTER_EMAIL=dchook@example.com",
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestGitSourceCheckout(t *testing.T) {
+	t.Parallel()
+
+	composeFile := gitRepository(t)
+	work := filepath.Dir(composeFile)
+	remote := filepath.Join(filepath.Dir(work), "remote.git")
+
+	source, err := newGitSource(composeFile, "", "main", []string{"v*"}, false)
+	if err != nil {
+		t.Fatalf("newGitSource() error = %v", err)
+	}
+
+	// Local changes are discarded.
+	if err := os.WriteFile(composeFile, []byte("# local\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testCase := range []struct {
+		ref     string
+		content string
+	}{
+		{"v1.0.0", "# release\n"},
+		{"", "# main\n"},
+	} {
+		commit, output, err := source.checkout(context.Background(), testCase.ref, validOK)
+		if err != nil {
+			t.Fatalf("checkout(%q) error = %v\n%s", testCase.ref, err, output)
+		}
+
+		ref := testCase.ref
+		if ref == "" {
+			ref = "main"
+		}
+		if want := runTestGit(t, remote, "rev-parse", ref+"^{commit}"); commit != want {
+			t.Errorf("checkout(%q) commit = %s, want %s", testCase.ref, commit, want)
+		}
+
+		data, err := os.ReadFile(composeFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != testCase.content {
+			t.Errorf("checkout(%q) = %q, want %q", testCase.ref, data, testCase.content)
+		}
+	}
+
+	if _, _, err := source.checkout(context.Background(), "missing", validOK); err == nil {
+		t.Error("checkout() should fail for a missing ref")
+	}
+
+	source.verify = true
+	if _, _, err := source.checkout(context.Background(), "main", validOK); err == nil {
+		t.Error("checkout() should fail for an unsigned commit when verifying")
+	}
+}
+
+func validOK(context.Context) error {
+	return nil
+}
+
+func TestGitSourceCheckoutRestore(t *testing.T) {
+	t.Parallel()
+
+	composeFile := gitRepository(t)
+	work := filepath.Dir(composeFile)
+
+	source, err := newGitSource(composeFile, "", "main", []string{"v*"}, false)
+	if err != nil {
+		t.Fatalf("newGitSource() error = %v", err)
+	}
+
+	previous, output, err := source.checkout(context.Background(), "main", validOK)
+	if err != nil {
+		t.Fatalf("checkout() error = %v\n%s", err, output)
+	}
+
+	errInvalid := errors.New("invalid compose file")
+	_, output, err = source.checkout(
+		context.Background(),
+		"v1.0.0",
+		func(context.Context) error { return errInvalid },
+	)
+	if !errors.Is(err, errInvalid) {
+		t.Fatalf("checkout() error = %v, want %v", err, errInvalid)
+	}
+	if !strings.Contains(string(output), errInvalid.Error()) {
+		t.Errorf("checkout() output = %q, want the validation error", output)
+	}
+
+	// The work tree is restored to the previous commit.
+	if head := runTestGit(t, work, "rev-parse", "HEAD"); head != previous {
+		t.Errorf("HEAD = %s after a failed validation, want %s", head, previous)
+	}
+	data, err := os.ReadFile(composeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "# main\n" {
+		t.Errorf("compose file = %q after a failed validation, want %q", data, "# main\n")
+	}
+}
+
+func TestExecuteCheckout(t *testing.T) {
+	t.Parallel()
+
+	composeFile := gitRepository(t)
+	source, err := newGitSource(composeFile, "", "main", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adapter := &DockerComposeAdapter{ComposeFile: composeFile, Git: source}
+	deployment := &Deployment{ID: "abc123"}
+	if !adapter.executeCheckout(context.Background(), deployment) {
+		t.Fatalf("executeCheckout() failed: %+v", deployment.Checkout)
+	}
+
+	if deployment.Ref != "main" || len(deployment.Commit) != 40 ||
+		deployment.Checkout == nil || deployment.Checkout.ExitCode != 0 {
+		t.Errorf("deployment = %+v, want the main commit", deployment)
+	}
+
+	// The compose file of the checked out commit is validated.
+	seed := filepath.Join(filepath.Dir(filepath.Dir(composeFile)), "seed")
+	runTestGit(t, seed, "rm", "--quiet", "compose.yml")
+	runTestGit(t, seed, "commit", "--quiet", "-m", "remove compose file")
+	runTestGit(t, seed, "push", "--quiet", "../remote.git", "main")
+
+	deployment = &Deployment{ID: "def456"}
+	if adapter.executeCheckout(context.Background(), deployment) {
+		t.Error("executeCheckout() should fail without a compose file")
+	}
+	if deployment.Checkout == nil || deployment.Checkout.ExitCode == 0 ||
+		!strings.Contains(deployment.Checkout.Output, "compose file") {
+		t.Errorf("checkout = %+v, want a compose file error", deployment.Checkout)
+	}
+	if _, err := os.Stat(composeFile); err != nil {
+		t.Errorf("compose file should be restored after a failed checkout: %v", err)
+	}
+}
+
+func TestGitSourceLock(t *testing.T) {
+	t.Parallel()
+
+	composeFile := gitRepository(t)
+
+	// A reload creates a new git source for the same work tree.
+	current, err := newGitSource(composeFile, "", "main", nil, false)
+	if err != nil {
+		t.Fatalf("newGitSource() error = %v", err)
+	}
+	reloaded, err := newGitSource(composeFile, "", "main", nil, false)
+	if err != nil {
+		t.Fatalf("newGitSource() error = %v", err)
+	}
+
+	unlock := current.lock()
+	locked := make(chan struct{})
+	go func() {
+		defer close(locked)
+		reloaded.lock()()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("lock() should wait for the running deployment of the work tree")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("lock() should return once the work tree is unlocked")
+	}
+}
+
+func TestNewGitSourceErrors(t *testing.T) {
+	t.Parallel()
+
+	composeFile := filepath.Join(t.TempDir(), "compose.yml")
+
+	tests := []struct {
+		name   string
+		remote string
+		ref    string
+		refs   []string
+		want   error
+	}{
+		{"option remote", "--upload-pack=sh", "main", nil, errGitRemote},
+		{"invalid ref", "", "-main", nil, errGitRef},
+		{"invalid pattern", "", "main", []string{"v["}, errGitRefPattern},
+		{"not a work tree", "", "main", nil, errGitWorkTree},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := newGitSource(composeFile, testCase.remote, testCase.ref, testCase.refs, false)
+			if !errors.Is(err, testCase.want) {
+				t.Errorf("newGitSource() error = %v, want %v", err, testCase.want)
+			}
+		})
+	}
+
+	if _, err := loadGitSource(composeFile, "", "", "v*"); !errors.Is(err, errGitRefMissing) {
+		t.Errorf("loadGitSource() error = %v, want %v", err, errGitRefMissing)
+	}
+}
+
+func TestSelectRef(t *testing.T) {
+	t.Parallel()
+
+	source := &gitSource{ref: "main", refs: []string{"v*", "release/*"}}
+
+	tests := []struct {
+		name   string
+		source *gitSource
+		ref    string
+		want   string
+		err    error
+	}{
+		{"no ref", source, "", "", nil},
+		{"configured ref", source, "main", "main", nil},
+		{"tag pattern", source, "v1.2.3", "v1.2.3", nil},
+		{"branch pattern", source, "release/2026-10", "release/2026-10", nil},
+		{"not allowed", source, "feature/x", "", errGitRefNotAllowed},
+		{"pattern does not cross slashes", source, "release/a/b", "", errGitRefNotAllowed},
+		{"revision syntax", source, "v1..main", "", errGitRef},
+		{"option", source, "-v1", "", errGitRef},
+		{"selection disabled", nil, "main", "", errGitRefSelection},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &HandlerConfig{git: testCase.source}
+			ref, err := cfg.selectRef(testCase.ref)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("selectRef() error = %v, want %v", err, testCase.err)
+			}
+			if ref != testCase.want {
+				t.Errorf("selectRef() = %q, want %q", ref, testCase.want)
+			}
+		})
+	}
+}
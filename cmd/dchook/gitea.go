@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+var (
+	giteaReceiver   = newGiteaReceiver("gitea", "X-Gitea-")
+	forgejoReceiver = newGiteaReceiver("forgejo", "X-Forgejo-")
+)
+
+// newGiteaReceiver creates a receiver for Gitea or Forgejo webhooks, which differ only
+// in their header prefix. The request body is authenticated with the hex HMAC-SHA256 in
+// the Signature header and replays are detected with the Delivery header and the body
+// hash. The event name is the Event header (push, package, release, ...).
+func newGiteaReceiver(name, headerPrefix string) *forgeReceiver {
+	return &forgeReceiver{
+		name:       name,
+		hmacSHA256: true,
+		authenticate: func(
+			r *http.Request,
+			body []byte,
+			secret string,
+			allowed map[string]bool,
+		) bool {
+			signature := r.Header.Get(headerPrefix + "Signature")
+			return signature != "" && dchook.VerifySignature(
+				body,
+				dchook.AlgorithmSHA256+":"+signature,
+				secret,
+				allowed,
+			)
+		},
+		delivery: func(r *http.Request) string {
+			return r.Header.Get(headerPrefix + "Delivery")
+		},
+		parse: func(r *http.Request, body []byte) (forgeEvent, error) {
+			var payload struct {
+				Action     string `json:"action"`
+				Ref        string `json:"ref"`
+				Repository *struct {
+					FullName string `json:"full_name"`
+				} `json:"repository"`
+				Package *struct {
+					Name  string `json:"name"`
+					Owner struct {
+						Login string `json:"login"`
+					} `json:"owner"`
+				} `json:"package"`
+			}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				return forgeEvent{}, err
+			}
+
+			event := forgeEvent{
+				event:  r.Header.Get(headerPrefix + "Event"),
+				action: payload.Action,
+				ref:    payload.Ref,
+			}
+
+			// Package events are not always linked to a repository
+			switch {
+			case payload.Repository != nil && payload.Repository.FullName != "":
+				event.project = payload.Repository.FullName
+			case payload.Package != nil:
+				event.project = payload.Package.Owner.Login + "/" + payload.Package.Name
+			}
+			return event, nil
+		},
+	}
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const githubSignaturePrefix = "sha256="
+
+// githubReceiver handles GitHub webhooks. The request body is authenticated with the
+// X-Hub-Signature-256 HMAC and replays are detected with X-GitHub-Delivery and the body
+// hash.
+var githubReceiver = &forgeReceiver{
+	name:       "github",
+	hmacSHA256: true,
+	authenticate: func(r *http.Request, body []byte, secret string, allowed map[string]bool) bool {
+		// X-Hub-Signature-256 is "sha256=<hex>"; the HMAC code expects "sha256:<hex>".
+		signature, found := strings.CutPrefix(
+			r.Header.Get("X-Hub-Signature-256"),
+			githubSignaturePrefix,
+		)
+		return found && dchook.VerifySignature(
+			body,
+			dchook.AlgorithmSHA256+":"+signature,
+			secret,
+			allowed,
+		)
+	},
+	delivery: func(r *http.Request) string {
+		return r.Header.Get("X-Github-Delivery")
+	},
+	parse: func(r *http.Request, body []byte) (forgeEvent, error) {
+		event := forgeEvent{event: r.Header.Get("X-Github-Event")}
+		if event.event == eventPing {
+			return event, nil
+		}
+
+		var payload struct {
+			Action     string `json:"action"`
+			Ref        string `json:"ref"`
+			Repository struct {
+				FullName string `json:"full_name"`
+			} `json:"repository"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return forgeEvent{}, err
+		}
+
+		event.action = payload.Action
+		event.ref = payload.Ref
+		event.project = payload.Repository.FullName
+		return event, nil
+	},
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestGitHubHandler(t *testing.T) {
+	t.Parallel()
+
+	secret := "github-secret"
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		allowedAlgorithms: map[string]bool{"sha256": true},
+		forges: map[string]*forgeConfig{
+			"github": {rules: []eventRule{{event: "push", qualifier: "main"}}, secret: secret},
+		},
+		adapter: &MockAdapter{},
+		history: NewDeploymentHistory(),
+	}
+	limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+	handler := createForgeHandler(NewConfigStore(cfg, nil), limiter, githubReceiver)
+
+	send := func(event, delivery, body, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(
+			http.MethodPost,
+			"/deploy/github",
+			bytes.NewReader([]byte(body)),
+		)
+		req.Header.Set("X-Github-Event", event)
+		req.Header.Set("X-Github-Delivery", delivery)
+		req.Header.Set("X-Hub-Signature-256", signature)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	sign := func(body string) string {
+		return "sha256=" + strings.TrimPrefix(
+			dchook.GenerateSignature([]byte(body), secret, "sha256"),
+			"sha256:",
+		)
+	}
+
+	pushMain := `{"ref":"refs/heads/main","repository":{"full_name":"org/app"}}`
+	pushFeature := `{"ref":"refs/heads/feature","repository":{"full_name":"org/app"}}`
+
+	if w := send("ping", "d-1", `{}`, sign(`{}`)); w.Code != http.StatusOK {
+		t.Errorf("ping status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if w := send("push", "d-2", pushFeature, sign(pushFeature)); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), "ignored") {
+		t.Errorf("unmatched push status = %d (%q), want ignored", w.Code, w.Body.String())
+	}
+
+	if w := send("push", "d-3", pushMain, sign(pushMain)); w.Code != dchook.DeployAcceptedStatus {
+		t.Errorf("matched push status = %d, want %d", w.Code, dchook.DeployAcceptedStatus)
+	}
+
+	if w := send("push", "d-3", pushMain, sign(pushMain)); w.Code != http.StatusBadRequest {
+		t.Errorf("replayed delivery status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// The delivery ID is not signed; a new one does not make a captured delivery new.
+	if w := send("push", "d-6", pushMain, sign(pushMain)); w.Code != http.StatusBadRequest {
+		t.Errorf("replayed body status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w := send("push", "d-4", pushMain, "sha256=00"); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := send("push", "d-5", pushMain, sign(pushFeature)); w.Code != http.StatusUnauthorized {
+		t.Errorf("mismatched signature status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	deployments := cfg.history.List()
+	if len(deployments) == 0 {
+		t.Fatal("no deployment recorded")
+	}
+	if !strings.Contains(string(deployments[0].Request), `"source":"github"`) {
+		t.Errorf("deployment request = %s, want GitHub source", deployments[0].Request)
+	}
+
+	disabled := createForgeHandler(NewConfigStore(&HandlerConfig{}, nil), limiter, githubReceiver)
+	req := httptest.NewRequest(http.MethodPost, "/deploy/github", nil)
+	w := httptest.NewRecorder()
+	disabled(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("disabled status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestGitHubHandlerRedelivery(t *testing.T) {
+	t.Parallel()
+
+	secret := "github-secret"
+	// One deployment per window and a ban on the first failure.
+	limiter := dchook.NewRateLimiter(1, 50*time.Millisecond, 1, time.Hour, time.Hour)
+	handler, _ := newForgeTestHandler(t, githubReceiver, &forgeConfig{
+		rules:  []eventRule{{event: "push", qualifier: "main"}},
+		secret: secret,
+	}, limiter)
+
+	send := func(delivery, after string) int {
+		body := `{"ref":"refs/heads/main","after":"` + after + `"}`
+		signature := "sha256=" + strings.TrimPrefix(
+			dchook.GenerateSignature([]byte(body), secret, "sha256"),
+			"sha256:",
+		)
+		return sendForgeRequest(handler, "/deploy/github", body, map[string]string{
+			"X-Github-Event":      "push",
+			"X-Github-Delivery":   delivery,
+			"X-Hub-Signature-256": signature,
+		}).Code
+	}
+
+	if code := send("d-1", "a1"); code != dchook.DeployAcceptedStatus {
+		t.Errorf("first push status = %d, want %d", code, dchook.DeployAcceptedStatus)
+	}
+
+	if code := send("d-2", "b2"); code != http.StatusTooManyRequests {
+		t.Errorf("rate limited push status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if limiter.SeenNonce("github:d-2") {
+		t.Error("rate limited delivery should not be recorded")
+	}
+
+	// A redelivery of the rejected event is neither a replay nor a failure.
+	time.Sleep(60 * time.Millisecond)
+	if code := send("d-2", "b2"); code != dchook.DeployAcceptedStatus {
+		t.Errorf("redelivered push status = %d, want %d", code, dchook.DeployAcceptedStatus)
+	}
+	if limiter.IsBanned("192.0.2.1") {
+		t.Error("redelivery of a rejected event should not ban the forge")
+	}
+
+	if code := send("d-2", "b2"); code != http.StatusBadRequest {
+		t.Errorf("replayed delivery status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+)
+
+// gitlabReceiver handles GitLab webhooks. GitLab does not sign payloads; the secret
+// token is sent as-is in X-Gitlab-Token and compared in constant time. Replays are
+// detected with X-Gitlab-Event-UUID.
+//
+// The event name is the payload object_kind (push, tag_push, pipeline, release, ...).
+// The action of a pipeline event is the pipeline status.
+var gitlabReceiver = &forgeReceiver{
+	name: "gitlab",
+	authenticate: func(r *http.Request, _ []byte, secret string, _ map[string]bool) bool {
+		token := r.Header.Get("X-Gitlab-Token")
+		return secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	},
+	delivery: func(r *http.Request) string {
+		return r.Header.Get("X-Gitlab-Event-Uuid")
+	},
+	parse: func(_ *http.Request, body []byte) (forgeEvent, error) {
+		var payload struct {
+			ObjectKind       string `json:"object_kind"`
+			Action           string `json:"action"`
+			Ref              string `json:"ref"`
+			ObjectAttributes struct {
+				Ref    string `json:"ref"`
+				Status string `json:"status"`
+				Action string `json:"action"`
+			} `json:"object_attributes"`
+			Project struct {
+				PathWithNamespace string `json:"path_with_namespace"`
+			} `json:"project"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return forgeEvent{}, err
+		}
+
+		event := forgeEvent{
+			event:   payload.ObjectKind,
+			action:  payload.Action,
+			ref:     payload.Ref,
+			project: payload.Project.PathWithNamespace,
+		}
+
+		switch {
+		case payload.ObjectKind == eventPipeline:
+			event.ref = payload.ObjectAttributes.Ref
+			event.action = payload.ObjectAttributes.Status
+		case event.action == "":
+			event.action = payload.ObjectAttributes.Action
+		}
+		return event, nil
+	},
+}
@@ -1,59 +1,150 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"net"
 	"net/http"
-	"net/netip"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/abczzz13/clientip"
 	"github.com/halostatue/dchook/internal/dchook"
 )
 
 // HandlerConfig contains shared configuration for HTTP handlers.
 type HandlerConfig struct {
 	dockerAvailable   bool
-	ipExtractor       *clientip.Extractor
+	ipExtractor       *dchook.IPExtractor
 	secret            string
+	secrets           map[string]string
+	publicKey         ed25519.PublicKey
 	allowedAlgorithms map[string]bool
-	adapter           ContainerAdapter
-	history           *DeploymentHistory
-	version           string
-	commit            string
+	// requireMessageSignatures rejects requests without an RFC 9421 message signature.
+	requireMessageSignatures bool
+	// clients are the registered client identities, by name.
+	clients map[string]*client
+	// oidc verifies OIDC bearer tokens, if configured.
+	oidc *oidcConfig
+	// tls is the TLS configuration, if TLS is enabled.
+	tls            *tlsConfig
+	forges         map[string]*forgeConfig
+	registrySecret string
+	adapter        ContainerAdapter
+	history        *DeploymentHistory
+	// deployments tracks running deployments for shutdown.
+	deployments *DeploymentTracker
+	// queue holds the deployments waiting for the schedule to open.
+	queue *DeploymentQueue
+	// metrics counts deploy requests and finished deployments for /metrics.
+	metrics *Metrics
+	// audit records authentication and deployment events, if enabled.
+	audit *AuditLog
+	// allowedServices are the services that deploy requests may select, or `*` for any
+	// deployed service. Service selection is disabled if empty.
+	allowedServices []string
+	// allowedProfiles are the compose profiles that deploy requests may enable, or `*`
+	// for any profile. Profile selection is disabled if empty.
+	allowedProfiles []string
+	// imageRepositories are the repositories that deploy requests may pin the image of
+	// each service to, by service. Image pinning is disabled if empty.
+	imageRepositories map[string][]string
+	// payloadEnv are the compose variables that deploy requests set from payload fields.
+	payloadEnv []payloadVariable
+	// git is the git source of the compose file directory of the adapter, if any.
+	git *gitSource
+	// schedule restricts when deployments run, if configured.
+	schedule *deploymentSchedule
+	// projects are the named projects managed alongside the default project, by name.
+	projects map[string]*project
+	// project is the name of the project that the configuration is for, or empty for
+	// the default project.
+	project string
+	version string
+	commit  string
 }
 
-func extractClientIP(extractor *clientip.Extractor, r *http.Request) string {
-	clientIP, err := extractor.ExtractAddr(r)
+// verifySignature checks the signature against the payload with the key material for
+// the signature algorithm: the public key for Ed25519, the shared secret for HMAC.
+// Signatures with a key ID are verified with the credential of the named client or the
+// named secret from the key set, and without one, with the default secret or public key.
+func (cfg *HandlerConfig) verifySignature(payload []byte, signature, keyID string) bool {
+	secret, publicKey, found := cfg.credentials(keyID)
+	if !found {
+		return false
+	}
+
+	if dchook.SignatureAlgorithm(signature) == dchook.AlgorithmEd25519 {
+		return dchook.VerifyEd25519Signature(
+			payload,
+			signature,
+			publicKey,
+			cfg.allowedAlgorithms,
+		)
+	}
+
+	// An empty HMAC key would accept signatures from anyone.
+	if secret == "" {
+		return false
+	}
+
+	return dchook.VerifySignature(payload, signature, secret, cfg.allowedAlgorithms)
+}
+
+// credentials returns the HMAC secret and Ed25519 public key for the key ID: the
+// credential of the named client, the named secret from the key set, or the default
+// secret and public key if there is no key ID. Returns false for unknown key IDs.
+func (cfg *HandlerConfig) credentials(keyID string) (string, ed25519.PublicKey, bool) {
+	if keyID == "" {
+		return cfg.secret, cfg.publicKey, true
+	}
+
+	if c, found := cfg.clients[keyID]; found {
+		return c.secret, c.publicKey, true
+	}
+
+	secret, found := cfg.secrets[keyID]
+	return secret, nil, found
+}
+
+// extractClientIP returns the client IP address of the request and its source (a header
+// from a trusted proxy, or the remote address). Returns an error if the remote address
+// or the selected header is invalid; the remote address of a trusted proxy is never
+// used in place of an invalid header, so that a client cannot get the proxy banned.
+func extractClientIP(extractor *dchook.IPExtractor, r *http.Request) (string, string, error) {
+	clientIP, source, err := extractor.ExtractAddr(r)
 	if err != nil {
 		//nolint:gosec // slog does not have log injection
 		slog.Warn(
-			"failed to extract client IP, using RemoteAddr",
+			"failed to extract client IP",
 			"error", err,
+			"ip_source", source,
 			"remote_addr", r.RemoteAddr,
 		)
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			ip = r.RemoteAddr
-		}
-		clientIP = netip.MustParseAddr(ip)
+		return "", source, err
 	}
-	return clientIP.String()
+	return clientIP.String(), source, nil
 }
 
 func createDeployHandler(
-	cfg *HandlerConfig,
+	store *ConfigStore,
 	limiter *dchook.RateLimiter,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Exact path match
-		if r.URL.Path != "/deploy" {
+		// Exact path match, or a project routed by createProjectHandler
+		projectName := r.PathValue("project")
+		if r.URL.Path != "/deploy" && projectName == "" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		cfg, found := store.Load().forProject(projectName)
+		if !found {
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
 		}
@@ -64,6 +155,7 @@ func createDeployHandler(
 		}
 
 		if !cfg.dockerAvailable {
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeUnavailable)
 			http.Error(
 				w,
 				"Service unavailable: Docker not accessible",
@@ -72,50 +164,156 @@ func createDeployHandler(
 			return
 		}
 
+		if cfg.deployments.Draining() {
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeUnavailable)
+			http.Error(w, "Service unavailable: shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
 		r.Body = http.MaxBytesReader(w, r.Body, dchook.MaxRequestBodySize)
 
-		ip := extractClientIP(cfg.ipExtractor, r)
+		ip, ipSource, err := extractClientIP(cfg.ipExtractor, r)
+		if err != nil {
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeBadRequest)
+			http.Error(w, "Bad request: invalid client address", http.StatusBadRequest)
+			return
+		}
+		audit := AuditRecord{Endpoint: endpointDeploy, IP: ip, IPSource: ipSource}
 
 		if limiter.IsBanned(ip) {
 			//nolint:gosec // slog does not have log injection
-			slog.Warn("banned IP attempted access", "ip", ip)
+			slog.Warn("banned IP attempted access", "ip", ip, "ip_source", ipSource)
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeBanned)
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 
+		tlsSubject, err := cfg.verifyClientCertificate(r)
+		if err != nil {
+			//nolint:gosec // slog does not have taint injection
+			slog.Warn("invalid client certificate", "ip", ip, "error", err)
+			cfg.recordFailure(limiter, audit.failed(auditAuthFailed, err.Error()))
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeUnauthorized)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
 		// Read payload
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			//nolint:gosec // slog does not have log injection
 			slog.Warn("failed to read request body", "ip", ip, "error", err)
-			limiter.RecordFailure(ip)
+			cfg.recordFailure(limiter, audit.failed(auditBadRequest, "unreadable body"))
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeBadRequest)
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
 
+		audit.TLSSubject = tlsSubject
+
 		// Verify signature
-		signature := r.Header.Get("Dchook-Signature")
-		if !dchook.VerifySignature(body, signature, cfg.secret, cfg.allowedAlgorithms) {
-			//nolint:gosec // slog does not have taint injection
-			slog.Warn("invalid signature", "ip", ip)
-			limiter.RecordFailure(ip)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		var algorithm, keyID, clientName string
+		var oidcToken *dchook.JWT
+		if cfg.usesOIDC(r) {
+			verifySpan := startVerifySpan(r, authSchemeOIDC)
+			token, ruleName, err := cfg.authenticateOIDC(r, actionDeploy)
+			if token != nil {
+				algorithm, keyID = token.Algorithm, token.KeyID
+			}
+
+			if err == nil {
+				err = checkOIDCReplay(token, limiter)
+			}
+
+			endVerifySpan(verifySpan, err)
+			if err != nil {
+				status, message := http.StatusUnauthorized, "Unauthorized"
+				if errors.Is(err, errOIDCNotPermitted) {
+					status, message = http.StatusForbidden, "Forbidden"
+				}
+
+				//nolint:gosec // slog does not have taint injection
+				slog.Warn("invalid token", "ip", ip, "key_id", keyID, "error", err)
+				audit.KeyID, audit.Algorithm = keyID, algorithm
+				cfg.recordFailure(limiter, audit.failed(signatureAuditEvent(err), err.Error()))
+				cfg.metrics.DeployRequest(endpointDeploy, signatureOutcome(err))
+				http.Error(w, message, status)
+				return
+			}
+			clientName, oidcToken = ruleName, token
+		} else if cfg.usesMessageSignature(r) {
+			verifySpan := startVerifySpan(r, authSchemeRFC9421)
+			messageSignature, err := cfg.verifyMessageSignature(r, body, limiter)
+			if messageSignature != nil {
+				algorithm, keyID = messageSignature.Algorithm, messageSignature.KeyID
+			}
+
+			endVerifySpan(verifySpan, err)
+			if err != nil {
+				//nolint:gosec // slog does not have taint injection
+				slog.Warn("invalid signature", "ip", ip, "key_id", keyID, "error", err)
+				audit.KeyID, audit.Algorithm = keyID, algorithm
+				cfg.recordFailure(limiter, audit.failed(signatureAuditEvent(err), err.Error()))
+				cfg.metrics.DeployRequest(endpointDeploy, signatureOutcome(err))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		} else {
+			var signature string
+			signature, keyID = dchook.SplitSignatureKeyID(r.Header.Get("Dchook-Signature"))
+			verifySpan := startVerifySpan(r, authSchemeDchook)
+			if !cfg.verifySignature(body, signature, keyID) {
+				endVerifySpan(verifySpan, errSignatureInvalid)
+				//nolint:gosec // slog does not have taint injection
+				slog.Warn("invalid signature", "ip", ip, "key_id", keyID)
+				audit.KeyID, audit.Algorithm = keyID, dchook.SignatureAlgorithm(signature)
+				cfg.recordFailure(limiter, audit.failed(auditAuthFailed, "invalid signature"))
+				cfg.metrics.DeployRequest(endpointDeploy, outcomeBadSignature)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			endVerifySpan(verifySpan, nil)
+			algorithm = dchook.SignatureAlgorithm(signature)
 		}
 
+		audit.KeyID, audit.Algorithm = keyID, algorithm
+
+		if clientName == "" {
+			var err error
+			clientName, err = cfg.authorize(keyID, actionDeploy, ip)
+			if err != nil {
+				//nolint:gosec // slog does not have taint injection
+				slog.Warn("client not authorized", "ip", ip, "client", clientName, "error", err)
+				audit.Identity = clientName
+				cfg.recordFailure(limiter, audit.failed(auditAccessDenied, err.Error()))
+				cfg.metrics.DeployRequest(endpointDeploy, outcomeUnauthorized)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		audit.Identity = clientName
+
 		// Parse envelope
 		var envelope struct {
 			Dchook struct {
-				Version   string `json:"version"`
-				Commit    string `json:"commit"`
-				Timestamp string `json:"timestamp"`
+				Version   string            `json:"version"`
+				Commit    string            `json:"commit"`
+				Timestamp string            `json:"timestamp"`
+				Project   string            `json:"project"`
+				Services  []string          `json:"services"`
+				Profiles  []string          `json:"profiles"`
+				Images    map[string]string `json:"images"`
+				Ref       string            `json:"ref"`
+				Override  string            `json:"override"`
 			} `json:"dchook"`
 			Payload any `json:"payload"`
 		}
 		if err := json.Unmarshal(body, &envelope); err != nil {
 			//nolint:gosec // slog does not have taint injection
 			slog.Warn("invalid JSON payload", "ip", ip, "error", err)
-			limiter.RecordFailure(ip)
+			cfg.recordFailure(limiter, audit.failed(auditBadRequest, "invalid JSON payload"))
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeBadRequest)
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
@@ -133,7 +331,26 @@ func createDeployHandler(
 				"error",
 				err,
 			)
-			limiter.RecordFailure(ip)
+			cfg.recordFailure(limiter, audit.failed(auditBadRequest, "invalid timestamp"))
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeBadRequest)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		// A signed request for one project may not deploy another.
+		if envelope.Dchook.Project != projectName {
+			//nolint:gosec // slog does not have taint injection
+			slog.Warn(
+				"project mismatch",
+				"ip",
+				ip,
+				"project",
+				projectName,
+				"envelope_project",
+				envelope.Dchook.Project,
+			)
+			cfg.recordFailure(limiter, audit.failed(auditBadRequest, errEnvelopeProject.Error()))
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeBadRequest)
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
@@ -142,7 +359,8 @@ func createDeployHandler(
 		if !limiter.CheckReplay(timestamp) {
 			//nolint:gosec // slog does not have taint injection
 			slog.Warn("replay attack detected", "ip", ip, "timestamp", envelope.Dchook.Timestamp)
-			limiter.RecordFailure(ip)
+			cfg.recordFailure(limiter, audit.failed(auditReplayDetected, "replayed timestamp"))
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeReplay)
 			http.Error(w, "Invalid or replayed timestamp", http.StatusBadRequest)
 			return
 		}
@@ -165,7 +383,11 @@ func createDeployHandler(
 				"server_commit",
 				cfg.commit,
 			)
-			limiter.RecordFailure(ip)
+			cfg.recordFailure(limiter, audit.failed(
+				auditVersionMismatch,
+				"client="+envelope.Dchook.Version+"/"+envelope.Dchook.Commit,
+			))
+			cfg.metrics.DeployRequest(endpointDeploy, outcomeVersionMismatch)
 			http.Error(
 				w,
 				fmt.Sprintf(
@@ -180,72 +402,407 @@ func createDeployHandler(
 			return
 		}
 
-		// Check success rate limit
-		if !limiter.RecordSuccess(ip) {
-			//nolint:gosec // slog does not have taint injection
-			slog.Warn("rate limit exceeded", "ip", ip)
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-			return
+		var override *DeploymentOverride
+		if envelope.Dchook.Override != "" {
+			reason := envelope.Dchook.Override
+			if err := validateOverrideReason(reason); err != nil {
+				//nolint:gosec // slog does not have taint injection
+				slog.Warn("invalid override", "ip", ip, "error", err)
+				cfg.recordFailure(limiter, audit.failed(auditBadRequest, err.Error()))
+				cfg.metrics.DeployRequest(endpointDeploy, outcomeBadRequest)
+				http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			err := cfg.authorizeOverride(cfg.usesOIDC(r), clientName, keyID, ip)
+			if err != nil {
+				//nolint:gosec // slog does not have taint injection
+				slog.Warn("override not authorized", "ip", ip, "client", clientName, "error", err)
+				cfg.recordFailure(limiter, audit.failed(auditAccessDenied, err.Error()))
+				cfg.metrics.DeployRequest(endpointDeploy, outcomeUnauthorized)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			override = &DeploymentOverride{Reason: reason}
 		}
 
-		//nolint:gosec // slog does not have taint injection
-		slog.Info(
-			"deployment triggered",
-			"client_version",
-			envelope.Dchook.Version,
-			"client_commit",
-			envelope.Dchook.Commit,
-			"ip",
-			ip,
-		)
+		cfg.acceptDeployment(w, r, limiter, &deployRequest{
+			endpoint:   endpointDeploy,
+			request:    json.RawMessage(body),
+			clientName: clientName,
+			audit:      audit,
+			options:    deployOptions{override: override},
+			prepare: func(options *deployOptions) bool {
+				selected, err := cfg.deployOptions(
+					r.Context(),
+					envelope.Dchook.Services,
+					envelope.Dchook.Profiles,
+					envelope.Dchook.Images,
+					envelope.Dchook.Ref,
+					envelope.Payload,
+				)
+				if err != nil {
+					//nolint:gosec // slog does not have taint injection
+					slog.Warn(
+						"invalid deploy options",
+						"ip",
+						ip,
+						"services",
+						envelope.Dchook.Services,
+						"profiles",
+						envelope.Dchook.Profiles,
+						"images",
+						envelope.Dchook.Images,
+						"ref",
+						envelope.Dchook.Ref,
+						"error",
+						err,
+					)
+					if errors.Is(err, errComposeServices) {
+						cfg.metrics.DeployRequest(endpointDeploy, outcomeUnavailable)
+						http.Error(
+							w,
+							"Service unavailable: failed to get services",
+							http.StatusServiceUnavailable,
+						)
+						return false
+					}
+
+					cfg.recordFailure(limiter, audit.failed(auditBadRequest, err.Error()))
+					cfg.metrics.DeployRequest(endpointDeploy, outcomeBadRequest)
+					http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+					return false
+				}
+
+				selected.override, selected.scheduledFor = options.override, options.scheduledFor
+				*options = selected
+				return true
+			},
+			claim: func() bool {
+				if oidcToken == nil {
+					return true
+				}
+
+				err := claimOIDCToken(oidcToken, limiter)
+				if err == nil {
+					return true
+				}
+
+				//nolint:gosec // slog does not have taint injection
+				slog.Warn("invalid token", "ip", ip, "key_id", keyID, "error", err)
+				cfg.recordFailure(limiter, audit.failed(signatureAuditEvent(err), err.Error()))
+				cfg.metrics.DeployRequest(endpointDeploy, signatureOutcome(err))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return false
+			},
+			logArgs: func(options deployOptions) []any {
+				return []any{
+					"client_version",
+					envelope.Dchook.Version,
+					"client_commit",
+					envelope.Dchook.Commit,
+					"algorithm",
+					algorithm,
+					"key_id",
+					keyID,
+					"client",
+					clientName,
+					"tls_subject",
+					tlsSubject,
+					"ip",
+					ip,
+					"ip_source",
+					ipSource,
+					"project",
+					projectName,
+					"services",
+					options.services,
+					"profiles",
+					options.profiles,
+					"images",
+					options.images,
+					"environment",
+					options.environment,
+					"ref",
+					options.ref,
+					"override",
+					envelope.Dchook.Override,
+				}
+			},
+		})
+	}
+}
+
+// deployOptions are the deployment options selected by a deploy request.
+type deployOptions struct {
+	// services are the services to pull and restart, or nil for all services.
+	services []string
+	// profiles are the compose profiles to enable after the default profiles.
+	profiles []string
+	// images are the images that services are pinned to, by service.
+	images map[string]string
+	// environment are the compose variables set from the payload.
+	environment map[string]string
+	// ref is the git ref to check out, or empty for the configured ref.
+	ref string
+	// override is the schedule override requested by the deploy request, if any.
+	override *DeploymentOverride
+	// scheduledFor is when a deployment queued by the schedule starts, or zero to start
+	// immediately.
+	scheduledFor time.Time
+}
 
-		// Generate deployment ID
-		deploymentID := generateDeploymentID()
-		deployment := Deployment{
-			ID:        deploymentID,
-			Timestamp: time.Now(),
-			Status:    statusPending,
-			Request:   json.RawMessage(body),
+// deployOptions validates the deployment options selected by a deploy request. ctx is
+// the request context, which stops the compose commands that list services.
+func (cfg *HandlerConfig) deployOptions(
+	ctx context.Context,
+	services []string,
+	profiles []string,
+	images map[string]string,
+	ref string,
+	payload any,
+) (deployOptions, error) {
+	var options deployOptions
+	var err error
+
+	if options.profiles, err = cfg.selectProfiles(profiles); err != nil {
+		return deployOptions{}, err
+	}
+
+	if options.services, err = cfg.selectServices(services); err != nil {
+		return deployOptions{}, err
+	}
+
+	if options.images, err = cfg.pinImages(images, options.services); err != nil {
+		return deployOptions{}, err
+	}
+
+	// The services are listed once, after the checks that do not need docker compose.
+	// With a git source, the deployment checks them against the compose files of the
+	// ref that it checks out instead.
+	if cfg.git == nil {
+		deployed, err := cfg.deployedServices(ctx, options)
+		if err != nil {
+			return deployOptions{}, err
+		}
+
+		if err := checkDeployed(deployed, options.services, options.images); err != nil {
+			return deployOptions{}, err
 		}
+	}
+
+	if options.environment, err = cfg.payloadEnvironment(payload); err != nil {
+		return deployOptions{}, err
+	}
+
+	if options.ref, err = cfg.selectRef(ref); err != nil {
+		return deployOptions{}, err
+	}
+	return options, nil
+}
+
+// deployRequest is an authenticated deploy request, from a client or a webhook, for
+// acceptDeployment.
+type deployRequest struct {
+	// endpoint labels the metrics of the request.
+	endpoint string
+	// request is recorded as the request of the deployment.
+	request json.RawMessage
+	// clientName is the client that sent the request, if any.
+	clientName string
+	audit      AuditRecord
+	// options are the deployment options known before the request is admitted, such as
+	// the schedule override.
+	options deployOptions
+	// prepare completes the options once the request is admitted, or responds to the
+	// request and returns false. Optional.
+	prepare func(options *deployOptions) bool
+	// claim records the delivery of the request, so that only accepted deliveries are
+	// replays, or responds to the request and returns false if a concurrent request
+	// claimed it first. Optional.
+	claim func() bool
+	// logArgs returns the attributes logged when the deployment is triggered.
+	logArgs func(options deployOptions) []any
+}
+
+// acceptDeployment starts a deployment for an authenticated deploy request and responds
+// with 202 Accepted, unless the schedule or the success rate limit rejects it. The
+// schedule is checked before the rate limit, so that a request rejected until the
+// schedule opens does not use up the success limit for its retry.
+func (cfg *HandlerConfig) acceptDeployment(
+	w http.ResponseWriter,
+	r *http.Request,
+	limiter *dchook.RateLimiter,
+	deploy *deployRequest,
+) {
+	options := deploy.options
+	if opens, err := cfg.scheduleDeployment(&options, time.Now()); err != nil {
+		cfg.rejectOutsideSchedule(w, deploy.endpoint, deploy.audit, opens, err)
+		return
+	}
+
+	// Check success rate limit
+	if !limiter.RecordSuccess(deploy.audit.IP) {
+		//nolint:gosec // slog does not have taint injection
+		slog.Warn("rate limit exceeded", "ip", deploy.audit.IP)
+		cfg.metrics.DeployRequest(deploy.endpoint, outcomeRateLimited)
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	if deploy.prepare != nil && !deploy.prepare(&options) {
+		return
+	}
+
+	if deploy.claim != nil && !deploy.claim() {
+		return
+	}
+
+	//nolint:gosec // slog does not have taint injection
+	slog.Info("deployment triggered", deploy.logArgs(options)...)
 
-		// Add to history immediately so it's queryable
-		cfg.history.Add(deployment)
+	deploymentID, err := startDeployment(
+		r.Context(),
+		cfg,
+		deploy.request,
+		deploy.clientName,
+		options,
+		deploy.audit,
+	)
+	if err != nil {
+		cfg.metrics.DeployRequest(deploy.endpoint, outcomeUnavailable)
+		http.Error(w, "Service unavailable: shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	cfg.metrics.DeployRequest(deploy.endpoint, outcomeAccepted)
+	writeDeployAccepted(w, r, deploymentID)
+}
+
+// startDeployment records a pending deployment for the request, the client that sent
+// it, if any, and the selected options in the history and the audit log, and starts it
+// asynchronously, or when the schedule opens if it is queued, continuing the trace of
+// the request span in ctx. Returns the deployment ID, or an error if the deployment
+// could not be started because of shutdown.
+func startDeployment(
+	ctx context.Context,
+	cfg *HandlerConfig,
+	request json.RawMessage,
+	clientName string,
+	options deployOptions,
+	audit AuditRecord,
+) (string, error) {
+	deploymentID := generateDeploymentID()
+	deployment := Deployment{
+		ID:          deploymentID,
+		Timestamp:   time.Now(),
+		Status:      statusPending,
+		Client:      clientName,
+		Request:     request,
+		Services:    options.services,
+		Profiles:    options.profiles,
+		Images:      options.images,
+		Environment: options.environment,
+		Ref:         options.ref,
+		Override:    options.override,
+	}
 
+	if sc := dchook.SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		deployment.TraceID = sc.TraceID.String()
+	}
+
+	if !options.scheduledFor.IsZero() {
+		deployment.Status = statusScheduled
+		deployment.ScheduledFor = &options.scheduledFor
+	}
+
+	// Add to history immediately so it's queryable
+	cfg.history.Add(deployment)
+
+	audit.Event = auditDeploymentTriggered
+	audit.DeploymentID = deploymentID
+	if options.override != nil {
+		audit.Reason = "override: " + options.override.Reason
+	}
+	cfg.audit.Record(audit)
+
+	var err error
+	if deployment.ScheduledFor != nil {
+		slog.Info(
+			"deployment queued",
+			"deployment_id",
+			deploymentID,
+			"scheduled_for",
+			options.scheduledFor,
+		)
+		err = cfg.queue.Go(cfg.project, deploymentID, func(queued *queuedDeployment) {
+			cfg.deployWhenOpen(context.WithoutCancel(ctx), queued, deployment)
+		})
+	} else {
 		// Deploy asynchronously
-		cfg.adapter.Deploy(&deployment, cfg.history)
+		err = cfg.adapter.Deploy(ctx, &deployment, cfg.history, cfg.deployments)
+	}
+
+	if err != nil {
+		cfg.history.Update(deploymentID, func(d *Deployment) {
+			d.Status = statusFailed
+		})
+		cfg.audit.Record(AuditRecord{
+			Event:        auditDeploymentFinished,
+			DeploymentID: deploymentID,
+			Status:       statusFailed,
+			Reason:       err.Error(),
+		})
+		return "", err
+	}
+
+	return deploymentID, nil
+}
 
-		// Check Accept header for JSON response
-		acceptJSON := slices.Contains(r.Header["Accept"], "application/json")
+// writeDeployAccepted writes the response for an accepted deployment, as JSON if the
+// request accepts it and as plain text otherwise.
+func writeDeployAccepted(w http.ResponseWriter, r *http.Request, deploymentID string) {
+	// Check Accept header for JSON response
+	acceptJSON := slices.Contains(r.Header["Accept"], "application/json")
 
+	if acceptJSON {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(dchook.DeployAcceptedStatus)
-		if acceptJSON {
-			w.Header().Set("Content-Type", "application/json")
-			response := map[string]string{
-				"deployment_id": deploymentID,
-				"message":       "Deployment triggered",
-			}
-			if err := json.NewEncoder(w).Encode(response); err != nil {
-				slog.Error("failed to encode JSON response", "error", err)
-			}
-		} else {
-			if _, err := fmt.Fprintf(w, "Deployment triggered\n"); err != nil {
-				slog.Error("failed to write response", "error", err)
-			}
+		response := map[string]string{
+			"deployment_id": deploymentID,
+			"message":       "Deployment triggered",
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("failed to encode JSON response", "error", err)
+		}
+	} else {
+		w.WriteHeader(dchook.DeployAcceptedStatus)
+		if _, err := fmt.Fprintf(w, "Deployment triggered\n"); err != nil {
+			slog.Error("failed to write response", "error", err)
 		}
 	}
 }
 
 func createStatusHandler(
-	cfg *HandlerConfig,
+	store *ConfigStore,
 	limiter *dchook.RateLimiter,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg, found := store.Load().forProject(r.PathValue("project"))
+		if !found {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		ip := extractClientIP(cfg.ipExtractor, r)
+		ip, _, err := extractClientIP(cfg.ipExtractor, r)
+		if err != nil {
+			http.Error(w, "Bad request: invalid client address", http.StatusBadRequest)
+			return
+		}
 
 		// Rate limiting
 		if !limiter.RecordSuccess(ip) {
@@ -255,8 +812,11 @@ func createStatusHandler(
 			return
 		}
 
-		// Extract deployment ID from path
+		// Extract deployment ID from path, or from createProjectHandler for a project
 		path := strings.TrimPrefix(r.URL.Path, "/deploy/status/")
+		if r.PathValue("project") != "" {
+			path = r.PathValue("id")
+		}
 
 		// Validate path - should be empty (list) or a single ID (no extra slashes)
 		if strings.Contains(path, "/") {
@@ -265,50 +825,31 @@ func createStatusHandler(
 		}
 
 		if path == "" {
-			handleListDeployments(w, r, cfg, limiter)
+			handleListDeployments(w, r, ip, cfg, limiter)
 			return
 		}
 
 		// Get specific deployment
-		handleGetDeployment(w, r, path, cfg, limiter)
+		handleGetDeployment(w, r, ip, path, cfg, limiter)
 	}
 }
 
 func handleListDeployments(
 	w http.ResponseWriter,
 	r *http.Request,
+	ip string,
 	cfg *HandlerConfig,
 	limiter *dchook.RateLimiter,
 ) {
-	timestamp := r.Header.Get("X-Dchook-Timestamp")
-	signature := r.Header.Get("X-Dchook-Signature")
-	nonce := r.Header.Get("X-Dchook-Nonce")
-
-	if timestamp == "" || signature == "" {
-		http.Error(w, "Missing authentication headers", http.StatusUnauthorized)
-		return
-	}
-
-	// Verify signature of timestamp:nonce
-	payload := timestamp
-	if nonce != "" {
-		payload = timestamp + ":" + nonce
-	}
-
-	if !dchook.VerifySignature(
-		[]byte(payload),
-		signature,
-		cfg.secret,
-		cfg.allowedAlgorithms,
+	if !authenticateStatusRequest(
+		w,
+		r,
+		ip,
+		cfg,
+		limiter,
+		actionList,
+		r.Header.Get("X-Dchook-Nonce"),
 	) {
-		http.Error(w, "Invalid signature", http.StatusUnauthorized)
-		return
-	}
-
-	// Validate timestamp
-	ts, err := strconv.ParseInt(timestamp, 10, 64)
-	if err != nil || !limiter.CheckReplay(ts) {
-		http.Error(w, "Invalid or expired timestamp", http.StatusUnauthorized)
 		return
 	}
 
@@ -325,32 +866,116 @@ func handleListDeployments(
 	}
 }
 
-func handleGetDeployment(
+// authenticateStatusRequest verifies an OIDC bearer token, an RFC 9421 message signature,
+// or, unless those are required, the X-Dchook-Signature of `timestamp:subject` (or
+// `timestamp` if the subject is empty), and checks that the client may perform the
+// action. The subject is the deployment ID or the list nonce. Writes the error response
+// and returns false if the request is not authenticated or not authorized.
+func authenticateStatusRequest(
 	w http.ResponseWriter,
 	r *http.Request,
-	deploymentID string,
+	ip string,
 	cfg *HandlerConfig,
 	limiter *dchook.RateLimiter,
-) {
+	action, subject string,
+) bool {
+	audit := AuditRecord{Endpoint: endpointStatus, IP: ip}
+	if cfg.usesOIDC(r) {
+		_, ruleName, err := cfg.authenticateOIDC(r, action)
+		if err != nil {
+			//nolint:gosec // slog does not have taint injection
+			slog.Warn("invalid token", "ip", ip, "error", err)
+			cfg.audit.Record(audit.failed(signatureAuditEvent(err), err.Error()))
+			if errors.Is(err, errOIDCNotPermitted) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			} else {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+			}
+			return false
+		}
+
+		//nolint:gosec // slog does not have taint injection
+		slog.Info("status requested", "action", action, "client", ruleName, "ip", ip)
+		return true
+	}
+
+	keyID, ok := verifyStatusSignature(w, r, cfg, limiter, subject)
+	if !ok {
+		cfg.audit.Record(audit.failed(auditAuthFailed, "invalid signature"))
+		return false
+	}
+
+	clientName, err := cfg.authorize(keyID, action, ip)
+	if err != nil {
+		//nolint:gosec // slog does not have taint injection
+		slog.Warn("client not authorized", "ip", ip, "client", clientName, "error", err)
+		audit.KeyID, audit.Identity = keyID, clientName
+		cfg.audit.Record(audit.failed(auditAccessDenied, err.Error()))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	if clientName != "" {
+		//nolint:gosec // slog does not have taint injection
+		slog.Info("status requested", "action", action, "client", clientName, "ip", ip)
+	}
+	return true
+}
+
+// verifyStatusSignature verifies the signature of a status request and returns its key
+// ID. Writes the error response and returns false if the signature is invalid.
+func verifyStatusSignature(
+	w http.ResponseWriter,
+	r *http.Request,
+	cfg *HandlerConfig,
+	limiter *dchook.RateLimiter,
+	subject string,
+) (string, bool) {
+	if cfg.usesMessageSignature(r) {
+		signature, err := cfg.verifyMessageSignature(r, nil, limiter)
+		if err != nil {
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return "", false
+		}
+		return signature.KeyID, true
+	}
+
 	timestamp := r.Header.Get("X-Dchook-Timestamp")
-	signature := r.Header.Get("X-Dchook-Signature")
+	signature, keyID := dchook.SplitSignatureKeyID(r.Header.Get("X-Dchook-Signature"))
 
 	if timestamp == "" || signature == "" {
 		http.Error(w, "Missing authentication headers", http.StatusUnauthorized)
-		return
+		return "", false
+	}
+
+	// Verify signature of timestamp:subject
+	payload := timestamp
+	if subject != "" {
+		payload = timestamp + ":" + subject
 	}
 
-	// Verify signature of timestamp:deploymentID
-	payload := timestamp + ":" + deploymentID
-	if !dchook.VerifySignature([]byte(payload), signature, cfg.secret, cfg.allowedAlgorithms) {
+	if !cfg.verifySignature([]byte(payload), signature, keyID) {
 		http.Error(w, "Invalid signature", http.StatusUnauthorized)
-		return
+		return "", false
 	}
 
 	// Validate timestamp
 	ts, err := strconv.ParseInt(timestamp, 10, 64)
 	if err != nil || !limiter.CheckReplay(ts) {
 		http.Error(w, "Invalid or expired timestamp", http.StatusUnauthorized)
+		return "", false
+	}
+	return keyID, true
+}
+
+func handleGetDeployment(
+	w http.ResponseWriter,
+	r *http.Request,
+	ip, deploymentID string,
+	cfg *HandlerConfig,
+	limiter *dchook.RateLimiter,
+) {
+	if !authenticateStatusRequest(w, r, ip, cfg, limiter, actionStatus, deploymentID) {
 		return
 	}
 
@@ -372,8 +997,10 @@ func handleGetDeployment(
 	}
 }
 
-func createHealthHandler(cfg *HandlerConfig) http.HandlerFunc {
+func createHealthHandler(store *ConfigStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := store.Load()
+
 		// Exact path match
 		if r.URL.Path != "/health" {
 			http.Error(w, "Not found", http.StatusNotFound)
@@ -393,20 +1020,36 @@ func createHealthHandler(cfg *HandlerConfig) http.HandlerFunc {
 			response["last_deployment"] = lastDeploy.Format(time.RFC3339)
 		}
 
-		if cfg.dockerAvailable {
-			response["status"] = "ok"
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			if err := json.NewEncoder(w).Encode(response); err != nil {
-				slog.Error("failed to encode JSON response", "error", err)
-			}
-		} else {
-			response["status"] = "degraded"
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusServiceUnavailable)
-			if err := json.NewEncoder(w).Encode(response); err != nil {
-				slog.Error("failed to encode JSON response", "error", err)
+		if len(cfg.projects) > 0 {
+			projects := make(map[string]any, len(cfg.projects))
+			for name, p := range cfg.projects {
+				success, failure := p.history.Stats()
+				projects[name] = map[string]int{
+					"deployments_success": success,
+					"deployments_failure": failure,
+				}
 			}
+			response["projects"] = projects
+		}
+
+		// Reload errors are logged, not reported, as they may include file paths.
+		if lastReload := store.LastReload(); lastReload != nil {
+			response["last_reload"] = lastReload
+		}
+
+		status, statusCode := "ok", http.StatusOK
+		switch {
+		case cfg.deployments.Draining():
+			status, statusCode = "stopping", http.StatusServiceUnavailable
+		case !cfg.dockerAvailable:
+			status, statusCode = "degraded", http.StatusServiceUnavailable
+		}
+		response["status"] = status
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("failed to encode JSON response", "error", err)
 		}
 	}
 }
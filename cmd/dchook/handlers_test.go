@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestHandlerConfigVerifySignature(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientPublicKey, clientPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &HandlerConfig{
+		secret:    "default-secret",
+		secrets:   map[string]string{"old": "old-secret", "new": "new-secret"},
+		publicKey: publicKey,
+		clients: map[string]*client{
+			"ci":      {name: "ci", secret: "ci-secret"},
+			"release": {name: "release", publicKey: clientPublicKey},
+		},
+		allowedAlgorithms: map[string]bool{
+			dchook.AlgorithmSHA256:  true,
+			dchook.AlgorithmEd25519: true,
+		},
+	}
+	payload := []byte(`{"test":"data"}`)
+	defaultSig := dchook.GenerateSignature(payload, "default-secret", "sha256")
+	oldSig := dchook.GenerateSignature(payload, "old-secret", "sha256")
+	newSig := dchook.GenerateSignature(payload, "new-secret", "sha256")
+	edSig := dchook.GenerateEd25519Signature(payload, privateKey)
+	ciSig := dchook.GenerateSignature(payload, "ci-secret", "sha256")
+	releaseSig := dchook.GenerateEd25519Signature(payload, clientPrivateKey)
+
+	tests := []struct {
+		name      string
+		signature string
+		keyID     string
+		want      bool
+	}{
+		{"default secret", defaultSig, "", true},
+		{"named secret", newSig, "new", true},
+		{"other named secret", oldSig, "old", true},
+		{"wrong key ID", oldSig, "new", false},
+		{"unknown key ID", newSig, "gone", false},
+		{"named secret without key ID", newSig, "", false},
+		{"ed25519", edSig, "", true},
+		{"ed25519 with key ID", edSig, "new", false},
+		{"HMAC client", ciSig, "ci", true},
+		{"HMAC client with Ed25519", releaseSig, "ci", false},
+		{"Ed25519 client", releaseSig, "release", true},
+		{"Ed25519 client with default key", edSig, "release", false},
+		{"client signature without key ID", releaseSig, "", false},
+		{
+			"algorithm not allowed",
+			dchook.GenerateSignature(payload, "default-secret", "sha512"),
+			"",
+			false,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			got := cfg.verifySignature(payload, testCase.signature, testCase.keyID)
+			if got != testCase.want {
+				t.Errorf("verifySignature() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+
+	t.Run("empty default secret", func(t *testing.T) {
+		t.Parallel()
+		noSecret := &HandlerConfig{allowedAlgorithms: cfg.allowedAlgorithms}
+		signature := dchook.GenerateSignature(payload, "", "sha256")
+		if noSecret.verifySignature(payload, signature, "") {
+			t.Error("verifySignature() accepted a signature made with an empty secret")
+		}
+	})
+}
+
+func TestDeployHandlerForwardedFor(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		secret:            "test-secret",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+	}
+	limiter := dchook.NewRateLimiter(100, time.Minute, deployMaxFailures, time.Hour, time.Hour)
+	handler := createDeployHandler(NewConfigStore(cfg, nil), limiter)
+
+	deploy := func(forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader([]byte("{}")))
+		req.Header.Set("Dchook-Signature", "sha256:bad")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.RemoteAddr = "127.0.0.1:12345"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	// Malformed entries added by the client are ignored, so the failures count against
+	// the client address.
+	for range deployMaxFailures {
+		if code := deploy("junk, 203.0.113.5"); code != http.StatusUnauthorized {
+			t.Fatalf("bad signature status = %d, want %d", code, http.StatusUnauthorized)
+		}
+	}
+	if !limiter.IsBanned("203.0.113.5") {
+		t.Error("client address should be banned")
+	}
+
+	// Without a valid untrusted address, the request is rejected rather than counted
+	// against the proxy.
+	for _, forwardedFor := range []string{"junk", "203.0.113.6, junk", "127.0.0.2"} {
+		if code := deploy(forwardedFor); code != http.StatusBadRequest {
+			t.Errorf("X-Forwarded-For %q status = %d, want %d", forwardedFor, code, 400)
+		}
+	}
+	if limiter.IsBanned("127.0.0.1") {
+		t.Error("proxy address should not be banned")
+	}
+}
+
+func TestDeployHandlerChecksBeforeOptions(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(dchook.DefaultTrustedProxies, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Selecting services queries docker compose, which fails here, so requests that
+	// reach option validation are answered with 503.
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		version:           "v1.0.0",
+		commit:            "abc",
+		secret:            "test-secret",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{ServicesErr: errComposeServices},
+		history:           NewDeploymentHistory(),
+		allowedServices:   []string{"web"},
+	}
+	handler := createDeployHandler(
+		NewConfigStore(cfg, nil),
+		dchook.NewRateLimiter(1, time.Minute, 100, time.Hour, time.Hour),
+	)
+
+	deploy := func(timestamp int64) int {
+		body := []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+			strconv.FormatInt(timestamp, 10) + `","services":["web"]},"payload":{}}`)
+		req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+		req.Header.Set(
+			"Dchook-Signature",
+			dchook.GenerateSignature(body, "test-secret", dchook.AlgorithmSHA256),
+		)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	timestamp := time.Now().UnixMicro()
+	tests := []struct {
+		name      string
+		timestamp int64
+		want      int
+	}{
+		{"accepted", timestamp, http.StatusServiceUnavailable},
+		{"replayed", timestamp, http.StatusBadRequest},
+		{"rate limited", timestamp + 1, http.StatusTooManyRequests},
+	}
+
+	for _, testCase := range tests {
+		if code := deploy(testCase.timestamp); code != testCase.want {
+			t.Errorf("%s status = %d, want %d", testCase.name, code, testCase.want)
+		}
+	}
+}
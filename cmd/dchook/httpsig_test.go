@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func newMessageSignatureTestConfig(t *testing.T, required bool) *ConfigStore {
+	t.Helper()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewConfigStore(&HandlerConfig{
+		dockerAvailable:          true,
+		ipExtractor:              ipExtractor,
+		version:                  "v1.0.0",
+		commit:                   "abc",
+		secret:                   "default-secret",
+		secrets:                  map[string]string{"new": "new-secret"},
+		allowedAlgorithms:        map[string]bool{dchook.AlgorithmSHA256: true},
+		requireMessageSignatures: required,
+		adapter:                  &MockAdapter{},
+		history:                  NewDeploymentHistory(),
+	}, nil)
+}
+
+func TestDeployHandlerMessageSignature(t *testing.T) {
+	t.Parallel()
+
+	newBody := func() []byte {
+		return []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+			strconv.FormatInt(time.Now().UnixMicro(), 10) + `"},"payload":{}}`)
+	}
+
+	send := func(
+		handler http.HandlerFunc,
+		signer *dchook.MessageSigner,
+		nonce string,
+		body []byte,
+		modify func(*http.Request),
+	) int {
+		req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+		if err := signer.Sign(req, body, nonce, time.Now()); err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		if modify != nil {
+			modify(req)
+		}
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	signer := &dchook.MessageSigner{
+		Algorithm: dchook.MessageAlgorithmHMACSHA256,
+		Secret:    "default-secret",
+	}
+	keyedSigner := &dchook.MessageSigner{
+		Algorithm: dchook.MessageAlgorithmHMACSHA256,
+		KeyID:     "new",
+		Secret:    "new-secret",
+	}
+
+	tests := []struct {
+		name   string
+		signer *dchook.MessageSigner
+		modify func(*http.Request)
+		want   int
+	}{
+		{"default secret", signer, nil, dchook.DeployAcceptedStatus},
+		{"named secret", keyedSigner, nil, dchook.DeployAcceptedStatus},
+		{
+			"unknown key ID",
+			&dchook.MessageSigner{
+				Algorithm: dchook.MessageAlgorithmHMACSHA256,
+				KeyID:     "gone",
+				Secret:    "new-secret",
+			},
+			nil,
+			http.StatusUnauthorized,
+		},
+		{
+			"other authority",
+			signer,
+			func(req *http.Request) { req.Host = "other.example" },
+			http.StatusUnauthorized,
+		},
+		{
+			"content digest removed",
+			signer,
+			func(req *http.Request) { req.Header.Del("Content-Digest") },
+			http.StatusUnauthorized,
+		},
+		{
+			"content digest not covered",
+			signer,
+			func(req *http.Request) {
+				req.Header.Set(
+					"Signature-Input",
+					`dchook=("@method" "@path" "@authority");created=`+
+						strconv.FormatInt(time.Now().Unix(), 10)+`;nonce="x";alg="hmac-sha256"`,
+				)
+			},
+			http.StatusUnauthorized,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := newMessageSignatureTestConfig(t, false)
+			limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+			handler := createDeployHandler(store, limiter)
+
+			code := send(handler, testCase.signer, "nonce-1", newBody(), testCase.modify)
+			if code != testCase.want {
+				t.Errorf("status = %d, want %d", code, testCase.want)
+			}
+		})
+	}
+
+	t.Run("replayed nonce", func(t *testing.T) {
+		t.Parallel()
+
+		store := newMessageSignatureTestConfig(t, false)
+		limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+		handler := createDeployHandler(store, limiter)
+
+		code := send(handler, signer, "nonce-1", newBody(), nil)
+		if code != dchook.DeployAcceptedStatus {
+			t.Fatalf("first status = %d, want %d", code, dchook.DeployAcceptedStatus)
+		}
+
+		code = send(handler, signer, "nonce-1", newBody(), nil)
+		if code != http.StatusUnauthorized {
+			t.Errorf("replayed status = %d, want %d", code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		t.Parallel()
+
+		store := newMessageSignatureTestConfig(t, true)
+		limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+		handler := createDeployHandler(store, limiter)
+
+		body := newBody()
+		req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+		req.Header.Set(
+			"Dchook-Signature",
+			dchook.GenerateSignature(body, "default-secret", dchook.AlgorithmSHA256),
+		)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("dchook signature status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+
+		code := send(handler, signer, "nonce-2", newBody(), nil)
+		if code != dchook.DeployAcceptedStatus {
+			t.Errorf("message signature status = %d, want %d", code, dchook.DeployAcceptedStatus)
+		}
+	})
+}
+
+func TestStatusHandlerMessageSignature(t *testing.T) {
+	t.Parallel()
+
+	store := newMessageSignatureTestConfig(t, true)
+	store.Load().history.Add(Deployment{ID: "deploy-1", Status: statusComplete})
+	limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+	handler := createStatusHandler(store, limiter)
+
+	signer := &dchook.MessageSigner{
+		Algorithm: dchook.MessageAlgorithmHMACSHA256,
+		Secret:    "default-secret",
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		nonce  string
+		sign   bool
+		want   int
+	}{
+		{"list", "/deploy/status/", "nonce-1", true, http.StatusOK},
+		{"get", "/deploy/status/deploy-1", "nonce-2", true, http.StatusOK},
+		{"replayed nonce", "/deploy/status/deploy-1", "nonce-2", true, http.StatusUnauthorized},
+		{"unsigned", "/deploy/status/deploy-1", "", false, http.StatusUnauthorized},
+	}
+
+	// Cases share the limiter, so they run in order.
+	for _, testCase := range tests {
+		req := httptest.NewRequest(http.MethodGet, testCase.target, nil)
+		if testCase.sign {
+			if err := signer.Sign(req, nil, testCase.nonce, time.Now()); err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+		}
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != testCase.want {
+			t.Errorf("%s status = %d, want %d", testCase.name, w.Code, testCase.want)
+		}
+	}
+}
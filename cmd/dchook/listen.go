@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	unixAddressPrefix = "unix:"
+
+	// defaultSocketMode allows the socket owner and group to connect.
+	defaultSocketMode = 0o660
+
+	// systemdListenFDsStart is the first file descriptor passed by systemd socket
+	// activation.
+	systemdListenFDsStart = 3
+
+	// unixPeerAddress is the remote address of requests received on a Unix socket.
+	unixPeerAddress = "127.0.0.1:0"
+)
+
+var (
+	errSocketNotAbsolute = errors.New("socket path must be an absolute path")
+	errSocketExists      = errors.New("socket path exists and is not a socket")
+	errSocketMode        = errors.New("invalid socket mode")
+	errSocketOwner       = errors.New("invalid socket owner")
+	errListenFDs         = errors.New("invalid systemd LISTEN_FDS")
+)
+
+// listen returns the listener for the server: the first socket passed by systemd
+// socket activation, a Unix socket for `unix:/path` bind addresses, or a TCP socket.
+func listen(address, port string) (net.Listener, error) {
+	listener, err := systemdListener()
+	if err != nil || listener != nil {
+		return listener, err
+	}
+
+	if path, found := strings.CutPrefix(address, unixAddressPrefix); found {
+		//nolint:errcheck // Optional
+		modeValue, _ := dchook.FlagValue(*socketMode, "DCHOOK_SOCKET_MODE", "--socket-mode")
+		mode, err := parseSocketMode(modeValue)
+		if err != nil {
+			return nil, err
+		}
+
+		//nolint:errcheck // Optional
+		ownerValue, _ := dchook.FlagValue(*socketOwner, "DCHOOK_SOCKET_OWNER", "--socket-owner")
+		uid, gid, err := parseSocketOwner(ownerValue)
+		if err != nil {
+			return nil, err
+		}
+		return listenUnix(path, mode, uid, gid)
+	}
+
+	listener, err = net.Listen("tcp", net.JoinHostPort(address, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	return listener, nil
+}
+
+// systemdListener returns the first listener passed by systemd socket activation, or
+// nil if the process was not socket activated.
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil //nolint:nilnil // Not socket activated
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("%w: %q", errListenFDs, os.Getenv("LISTEN_FDS"))
+	}
+
+	// Child processes (docker compose) must not inherit the activation variables.
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		//nolint:errcheck // Unsetting an environment variable cannot fail on Unix
+		_ = os.Unsetenv(name)
+	}
+
+	if count > 1 {
+		slog.Warn("systemd passed more than one socket, using the first", "count", count)
+	}
+
+	file := os.NewFile(uintptr(systemdListenFDsStart), "systemd-socket")
+	defer file.Close() //nolint:errcheck // The listener holds its own descriptor
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use systemd socket: %w", err)
+	}
+	return listener, nil
+}
+
+// listenUnix listens on a Unix socket at the path, replacing a stale socket, and sets
+// the socket mode and owner. User and group IDs of -1 are not changed.
+func listenUnix(path string, mode fs.FileMode, uid, gid int) (net.Listener, error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("%w: %q", errSocketNotAbsolute, path)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%w: %q", errSocketExists, path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close() //nolint:errcheck,gosec // Already failing
+		return nil, fmt.Errorf("failed to set socket mode: %w", err)
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			listener.Close() //nolint:errcheck,gosec // Already failing
+			return nil, fmt.Errorf("failed to set socket owner: %w", err)
+		}
+	}
+	return listener, nil
+}
+
+// parseSocketMode parses an octal socket mode, defaulting to 0660.
+func parseSocketMode(value string) (fs.FileMode, error) {
+	if value == "" {
+		return defaultSocketMode, nil
+	}
+
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || fs.FileMode(mode)&^fs.ModePerm != 0 {
+		return 0, fmt.Errorf("%w: %q", errSocketMode, value)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// parseSocketOwner returns the user and group IDs for a `user[:group]` socket owner,
+// where each may be a name or a numeric ID. Returns -1 for IDs that are not given.
+func parseSocketOwner(value string) (int, int, error) {
+	if value == "" {
+		return -1, -1, nil
+	}
+
+	userName, groupName, _ := strings.Cut(value, ":")
+
+	uid := -1
+	if userName != "" {
+		account, err := user.Lookup(userName)
+		if err != nil {
+			account, err = user.LookupId(userName)
+		}
+		if err != nil {
+			return -1, -1, fmt.Errorf("%w: %q: %w", errSocketOwner, value, err)
+		}
+
+		if uid, err = strconv.Atoi(account.Uid); err != nil {
+			return -1, -1, fmt.Errorf("%w: %q: %w", errSocketOwner, value, err)
+		}
+	}
+
+	gid := -1
+	if groupName != "" {
+		group, err := user.LookupGroup(groupName)
+		if err != nil {
+			group, err = user.LookupGroupId(groupName)
+		}
+		if err != nil {
+			return -1, -1, fmt.Errorf("%w: %q: %w", errSocketOwner, value, err)
+		}
+
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return -1, -1, fmt.Errorf("%w: %q: %w", errSocketOwner, value, err)
+		}
+	}
+	return uid, gid, nil
+}
+
+// unixPeerHandler sets the remote address of requests received on a Unix socket, which
+// have no peer address, to loopback. The peer is a local process such as a reverse
+// proxy, so its X-Forwarded-For header is trusted as for a local TCP connection.
+func unixPeerHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+			r.RemoteAddr = unixPeerAddress
+		}
+		next.ServeHTTP(w, r)
+	})
+}
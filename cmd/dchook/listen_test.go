@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListenUnix(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dchook.sock")
+
+	// A stale socket from an earlier run is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false) //nolint:forcetypeassert
+	stale.Close()                                     //nolint:errcheck,gosec // Test cleanup
+
+	listener, err := listenUnix(path, 0o600, os.Getuid(), -1)
+	if err != nil {
+		t.Fatalf("listenUnix() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() }) //nolint:errcheck,gosec // Test cleanup
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Type() != fs.ModeSocket || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, want socket with 0600", info.Mode())
+	}
+
+	regular := filepath.Join(dir, "regular")
+	if err := os.WriteFile(regular, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(regular, 0o600, -1, -1); !errors.Is(err, errSocketExists) {
+		t.Errorf("listenUnix() on a regular file error = %v, want %v", err, errSocketExists)
+	}
+
+	if _, err := listenUnix("dchook.sock", 0o600, -1, -1); !errors.Is(err, errSocketNotAbsolute) {
+		t.Errorf("listenUnix() relative error = %v, want %v", err, errSocketNotAbsolute)
+	}
+}
+
+func TestParseSocketSettings(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		want    fs.FileMode
+		wantErr bool
+	}{
+		{"", defaultSocketMode, false},
+		{"0600", 0o600, false},
+		{"660", 0o660, false},
+		{"0999", 0, true},
+		{"1777", 0, true},
+		{"rw", 0, true},
+	}
+
+	for _, testCase := range tests {
+		mode, err := parseSocketMode(testCase.value)
+		if mode != testCase.want || (err != nil) != testCase.wantErr {
+			t.Errorf("parseSocketMode(%q) = %o, %v", testCase.value, mode, err)
+		}
+	}
+
+	uid, gid, err := parseSocketOwner(strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid()))
+	if err != nil || uid != os.Getuid() || gid != os.Getgid() {
+		t.Errorf("parseSocketOwner() = %d, %d, %v", uid, gid, err)
+	}
+
+	if uid, gid, err := parseSocketOwner(""); uid != -1 || gid != -1 || err != nil {
+		t.Errorf("parseSocketOwner(\"\") = %d, %d, %v", uid, gid, err)
+	}
+
+	if _, _, err := parseSocketOwner("no-such-user-dchook"); !errors.Is(err, errSocketOwner) {
+		t.Errorf("parseSocketOwner() error = %v, want %v", err, errSocketOwner)
+	}
+}
+
+func TestUnixPeerHandler(t *testing.T) {
+	t.Parallel()
+
+	var remoteAddr string
+	handler := unixPeerHandler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.RemoteAddr = "@"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if remoteAddr != unixPeerAddress {
+		t.Errorf("unix peer RemoteAddr = %q, want %q", remoteAddr, unixPeerAddress)
+	}
+
+	req.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if remoteAddr != "192.0.2.1:1234" {
+		t.Errorf("TCP RemoteAddr = %q, want unchanged", remoteAddr)
+	}
+}
+
+//nolint:paralleltest // Sets environment variables
+func TestSDNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck,gosec // Test cleanup
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	sdNotify(sdNotifyReady)
+
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 64)
+	n, err := conn.Read(buffer)
+	if err != nil || string(buffer[:n]) != sdNotifyReady {
+		t.Errorf("notification = %q, %v, want %q", buffer[:n], err, sdNotifyReady)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if interval := sdWatchdogInterval(); interval != 15*time.Second {
+		t.Errorf("sdWatchdogInterval() = %v, want 15s", interval)
+	}
+
+	t.Setenv("WATCHDOG_PID", "1")
+	if interval := sdWatchdogInterval(); interval != 0 {
+		t.Errorf("sdWatchdogInterval() for another process = %v, want 0", interval)
+	}
+}
@@ -2,6 +2,8 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,11 +11,12 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/abczzz13/clientip"
 	"github.com/halostatue/dchook/internal/dchook"
 )
 
@@ -23,12 +26,19 @@ var (
 	errComposeNotRegular   = errors.New("compose file must be a regular file")
 	errProjectInvalidStart = errors.New("project name must start with lowercase letter or digit")
 	errProjectInvalidChar  = errors.New("project name contains invalid character")
+	errInvalidAlgorithm    = errors.New("invalid algorithm")
+	errSecretEmpty         = errors.New("secret file is empty")
+	errWatchTooShort       = errors.New("watch interval is too short")
 )
 
 const (
 	httpReadTimeout  = 10 * time.Second
 	httpWriteTimeout = 10 * time.Second
 	httpIdleTimeout  = 60 * time.Second
+
+	// httpShutdownTimeout is how long shutdown waits for requests in progress after
+	// running deployments have finished.
+	httpShutdownTimeout = 10 * time.Second
 )
 
 var (
@@ -39,15 +49,115 @@ var (
 	// `-ldflags="-X main.rateLimitWindow=5s"`.
 	rateLimitWindow = "60s"
 
+	configPath     = flag.String("config", "", "Path to TOML or YAML configuration file")
 	secretFile     = flag.String("s", "", "Path to webhook secret file")
-	composeFile    = flag.String("c", "", "Path to docker-compose.yml")
+	keySetFile     = flag.String("keyset", "", "Path to webhook key set file")
+	publicKeyFile  = flag.String("k", "", "Path to Ed25519 public key file")
+	clientsFile    = flag.String("clients", "", "Path to client registry file")
+	oidcJWKSFile   = flag.String("oidc-jwks", "", "Path to OIDC issuer JWKS file")
+	oidcIssuer     = flag.String("oidc-issuer", "", "Required OIDC token issuer")
+	oidcAudience   = flag.String("oidc-audience", "", "Required OIDC token audience")
+	oidcRulesFile  = flag.String("oidc-rules", "", "Path to OIDC claim rules file")
+	composeFile    = flag.String("c", "", "Comma-separated paths to compose files")
+	envFiles       = flag.String("env-files", "", "Comma-separated paths to compose env files")
 	composeProject = flag.String("project", "", "Docker Compose project name")
+	projectsFile   = flag.String("projects", "", "Path to projects file")
+	payloadEnvFile = flag.String("payload-env", "", "Path to payload environment file")
+	scheduleFile   = flag.String("schedule", "", "Path to deployment schedule file")
 	bindAddress    = flag.String("b", "", "Bind address")
 	port           = flag.String("p", "", "HTTP port to listen on")
-	algorithms     = flag.String(
+	socketOwner    = flag.String("socket-owner", "", "Unix socket owner (user[:group])")
+	socketMode     = flag.String("socket-mode", "", "Unix socket mode (octal, default 0660)")
+	tlsCertFile    = flag.String("tls-cert", "", "Path to TLS certificate chain file (PEM)")
+	tlsKeyFile     = flag.String("tls-key", "", "Path to TLS private key file (PEM)")
+	tlsClientCA    = flag.String(
+		"tls-client-ca",
+		"",
+		"Path to CA bundle for verifying TLS client certificates (PEM)",
+	)
+	tlsClientSubjects = flag.String(
+		"tls-client-subjects",
+		"",
+		"Comma-separated client certificate subject common names required on /deploy",
+	)
+	trustedProxies = flag.String(
+		"trusted-proxies",
+		"",
+		"Comma-separated trusted proxy CIDRs or addresses (default loopback)",
+	)
+	ipHeaders = flag.String(
+		"ip-headers",
+		"",
+		"Comma-separated client IP headers from trusted proxies (default x-forwarded-for)",
+	)
+	proxyProtocol = flag.Bool(
+		"proxy-protocol",
+		false,
+		"Accept PROXY protocol headers from trusted proxies",
+	)
+	algorithms = flag.String(
 		"algorithms",
-		"sha256,sha384,sha512",
-		"Comma-separated list of allowed HMAC algorithms",
+		"",
+		"Comma-separated list of allowed signature algorithms",
+	)
+	githubEvents = flag.String(
+		"github-events",
+		"",
+		"Comma-separated GitHub events that trigger deployments (e.g. push:main)",
+	)
+	githubSecretFile = flag.String("github-secret", "", "Path to GitHub webhook secret file")
+	gitlabEvents     = flag.String(
+		"gitlab-events",
+		"",
+		"Comma-separated GitLab events that trigger deployments (e.g. pipeline:main)",
+	)
+	gitlabSecretFile = flag.String("gitlab-secret", "", "Path to GitLab webhook token file")
+	giteaEvents      = flag.String(
+		"gitea-events",
+		"",
+		"Comma-separated Gitea events that trigger deployments (e.g. push:main)",
+	)
+	giteaSecretFile = flag.String("gitea-secret", "", "Path to Gitea webhook secret file")
+	forgejoEvents   = flag.String(
+		"forgejo-events",
+		"",
+		"Comma-separated Forgejo events that trigger deployments (e.g. push:main)",
+	)
+	forgejoSecretFile  = flag.String("forgejo-secret", "", "Path to Forgejo webhook secret file")
+	registrySecretFile = flag.String(
+		"registry-secret",
+		"",
+		"Path to container registry notification secret file",
+	)
+	signatureScheme = flag.String(
+		"signature-scheme",
+		"",
+		"Accepted signature scheme: any or rfc9421",
+	)
+	watchInterval = flag.String(
+		"watch",
+		"",
+		"Interval for checking secret and key files for changes (e.g. 30s)",
+	)
+	shutdownTimeout = flag.String(
+		"shutdown-timeout",
+		"",
+		"Time to wait for running deployments on shutdown (default 1m)",
+	)
+	metricsAddress = flag.String(
+		"metrics-address",
+		"",
+		"Separate address (host:port) to serve /metrics on",
+	)
+	otlpEndpoint = flag.String(
+		"otlp-endpoint",
+		"",
+		"OTLP/HTTP endpoint to export traces to (e.g. http://localhost:4318)",
+	)
+	auditLogFile = flag.String(
+		"audit-log",
+		"",
+		"Path to the hash-chained audit log file",
 	)
 	showVersion = flag.Bool("version", false, "Show version information")
 	showHelp    = flag.Bool("help", false, "Show help message")
@@ -60,33 +170,199 @@ const (
 	statusMaxRequests    = 15
 	statusRateWindow2    = time.Minute
 	replayTrackingWindow = 10 * time.Minute
+	minWatchInterval     = time.Second
 )
 
 func printUsage(w io.Writer) {
 	progName := filepath.Base(os.Args[0])
 	//nolint:errcheck,gosec // Writing to stderr/stdout
 	fmt.Fprintf(w, `Usage: %s [OPTIONS]
+       %s [OPTIONS] check-config
+       %s [OPTIONS] doctor [--json] [--clock-reference URL]
+       %s audit verify [FILE]
 
 Secure webhook receiver for Docker Compose deployments.
 
 Options:
-`, progName)
+`, progName, progName, progName, progName)
 	flag.CommandLine.SetOutput(w)
 	flag.PrintDefaults()
 	//nolint:errcheck,gosec // Writing to stderr/stdout
 	fmt.Fprintf(w, `
 Environment Variables:
-  DCHOOK_SECRET_FILE         *    Path to webhook secret file
-  DCHOOK_COMPOSE_FILE        *    Path to docker-compose.yml to manage
+  DCHOOK_CONFIG                   Path to a TOML (.toml) or YAML (.yaml, .yml)
+                                  configuration file; flags and environment
+                                  variables take precedence over its settings
+  DCHOOK_SECRET_FILE         +    Path to webhook secret file
+  DCHOOK_KEYSET_FILE         +    Path to webhook key set file ("key-id secret"
+                                  per line) for secret rotation
+  DCHOOK_PUBLIC_KEY_FILE     +    Path to Ed25519 public key file
+  DCHOOK_CLIENTS_FILE        +    Path to client registry file ("name
+                                  credential actions [cidrs]" per line) for
+                                  per-client credentials and permissions
+  DCHOOK_OIDC_JWKS_FILE      +    Path to the JWKS file of an OIDC issuer;
+                                  enables bearer token authentication
+  DCHOOK_OIDC_ISSUER              Required OIDC token issuer (iss), e.g.
+                                  https://token.actions.githubusercontent.com
+                                  (required with DCHOOK_OIDC_JWKS_FILE)
+  DCHOOK_OIDC_AUDIENCE            Required OIDC token audience (aud)
+                                  (required with DCHOOK_OIDC_JWKS_FILE)
+  DCHOOK_OIDC_RULES_FILE          Path to OIDC claim rules file ("name actions
+                                  claim=pattern..." per line) (required with
+                                  DCHOOK_OIDC_JWKS_FILE)
+  DCHOOK_COMPOSE_FILE        *    Path to docker-compose.yml to manage, or
+                                  comma-separated paths of a base compose file
+                                  and overlays applied in order
   DCHOOK_COMPOSE_PROJECT          Docker Compose project name
+  DCHOOK_ENV_FILES                Comma-separated paths of env files passed to
+                                  docker compose with --env-file (default:
+                                  .env in the compose file directory)
+  DCHOOK_COMPOSE_PROFILES         Comma-separated compose profiles enabled for
+                                  every deployment
+  DCHOOK_ALLOWED_PROFILES         Comma-separated list of compose profiles that
+                                  deploy requests may enable, or * for any
+                                  profile (default: selection disabled)
   DCHOOK_EXCEPT_SERVICES          (Experimental) Comma-separated list of
                                   services to exclude from updates
-  DCHOOK_BIND_ADDRESS             Bind address (default: 127.0.0.1)
+  DCHOOK_ALLOWED_SERVICES         Comma-separated list of services that deploy
+                                  requests may select, or * for any service
+                                  (default: selection disabled)
+  DCHOOK_IMAGE_REPOSITORIES       Comma-separated service=repository pairs
+                                  that deploy requests may pin service images
+                                  to by digest (default: pinning disabled)
+  DCHOOK_PAYLOAD_ENV_FILE         Path to payload environment file ("variable
+                                  field pattern" per line) mapping payload
+                                  fields to compose variables
+  DCHOOK_GIT_REF                  Git branch or tag to fetch and check out in
+                                  the compose file directory before each
+                                  deployment (default: git updates disabled)
+  DCHOOK_GIT_REFS                 Comma-separated patterns of git refs that
+                                  deploy requests may select (e.g., v*)
+  DCHOOK_GIT_REMOTE               Git remote to fetch from (default: origin)
+  DCHOOK_GIT_VERIFY_COMMITS       Require a valid signature on checked out
+                                  commits (true or false)
+  DCHOOK_SCHEDULE_FILE            Path to deployment schedule file ("window
+                                  days hours [time-zone]" or "freeze name start
+                                  end [time-zone]" per line)
+  DCHOOK_SCHEDULE_MODE            Handling of deploy requests outside the
+                                  schedule: reject or queue (default: reject)
+  DCHOOK_PROJECTS_FILE            Path to projects file ("name compose-file
+                                  [option=value...]" per line) for additional
+                                  projects deployed with /deploy/{project}
+  DCHOOK_BIND_ADDRESS             Bind address, or unix:/path for a Unix socket
+                                  (default: 127.0.0.1)
   DCHOOK_PORT                     HTTP port to listen on (default: 7999)
-  DCHOOK_ALLOWED_ALGORITHMS       Comma-separated list of allowed HMAC
-                                  algorithms (default: sha256,sha384,sha512)
+  DCHOOK_SOCKET_OWNER             Unix socket owner as user[:group]
+  DCHOOK_SOCKET_MODE              Unix socket mode in octal (default: 0660)
+  DCHOOK_TRUSTED_PROXIES          Comma-separated CIDRs or addresses of reverse
+                                  proxies whose client IP headers are trusted,
+                                  or none (default: 127.0.0.0/8,::1)
+  DCHOOK_IP_HEADERS               Comma-separated client IP headers accepted
+                                  from trusted proxies, in order of preference:
+                                  x-forwarded-for, x-real-ip, forwarded, or
+                                  none (default: x-forwarded-for)
+  DCHOOK_PROXY_PROTOCOL           Accept HAProxy PROXY protocol v1/v2 headers
+                                  from trusted proxies (true or false)
+  DCHOOK_TLS_CERT                 Path to TLS certificate chain file (PEM);
+                                  enables HTTPS
+  DCHOOK_TLS_KEY                  Path to TLS private key file (PEM) (required
+                                  with DCHOOK_TLS_CERT)
+  DCHOOK_TLS_CLIENT_CA            Path to CA bundle for verifying TLS client
+                                  certificates (PEM)
+  DCHOOK_TLS_CLIENT_SUBJECTS      Comma-separated client certificate subject
+                                  common names; /deploy requires a verified
+                                  client certificate with one of them
+  DCHOOK_ALLOWED_ALGORITHMS       Comma-separated list of allowed signature
+                                  algorithms: sha256, sha384, sha512, ed25519
+                                  (default: sha256,sha384,sha512 with a secret
+                                  or key set file, ed25519 with a public key
+                                  file)
+  DCHOOK_GITHUB_EVENTS            Comma-separated GitHub webhook events that
+                                  trigger deployments via /deploy/github, as
+                                  event[:qualifier][@owner/repo] (e.g.
+                                  push:main, registry_package:published)
+  DCHOOK_GITHUB_SECRET_FILE       Path to GitHub webhook secret file
+                                  (default: DCHOOK_SECRET_FILE)
+  DCHOOK_GITLAB_EVENTS            Comma-separated GitLab webhook events that
+                                  trigger deployments via /deploy/gitlab (e.g.
+                                  pipeline:main@group/app, tag_push)
+  DCHOOK_GITLAB_SECRET_FILE       Path to GitLab webhook secret token file
+                                  (required for GitLab webhooks)
+  DCHOOK_GITEA_EVENTS             Comma-separated Gitea webhook events that
+                                  trigger deployments via /deploy/gitea (e.g.
+                                  push:main, package:created)
+  DCHOOK_GITEA_SECRET_FILE        Path to Gitea webhook secret file
+                                  (default: DCHOOK_SECRET_FILE)
+  DCHOOK_FORGEJO_EVENTS           Comma-separated Forgejo webhook events that
+                                  trigger deployments via /deploy/forgejo
+  DCHOOK_FORGEJO_SECRET_FILE      Path to Forgejo webhook secret file
+                                  (default: DCHOOK_SECRET_FILE)
+  DCHOOK_REGISTRY_SECRET_FILE     Path to container registry notification
+                                  secret file; enables /deploy/registry
+  DCHOOK_SIGNATURE_SCHEME         Accepted signature schemes for /deploy and
+                                  /deploy/status: any (RFC 9421 message
+                                  signatures or dchook signatures) or rfc9421
+                                  (default: any)
+  DCHOOK_WATCH_INTERVAL           Interval for checking the secret, key set,
+                                  public key, clients, OIDC, and TLS files for
+                                  changes and reloading (default: disabled, or
+                                  1m for the TLS files with TLS enabled)
+  DCHOOK_SHUTDOWN_TIMEOUT         Time to wait for running deployments when
+                                  stopping before interrupting them
+                                  (default: 1m)
+  DCHOOK_METRICS_ADDRESS          Separate address (host:port) to serve
+                                  /metrics on instead of the main listener
+  DCHOOK_OTLP_ENDPOINT            OTLP/HTTP endpoint to export deployment
+                                  traces to (e.g. http://localhost:4318);
+                                  spans are sent to /v1/traces unless the
+                                  endpoint has a path
+  DCHOOK_AUDIT_LOG                Path to the audit log of authentication and
+                                  deployment events (JSON Lines, hash-chained;
+                                  created with mode 0600)
+  DCHOOK_RATE_LIMIT_WINDOW        Minimum time between accepted deployments
+                                  per client IP (1s-24h, default: 60s)
+  DCHOOK_DEPLOY_MAX_FAILURES      Failed deploy requests before a client IP is
+                                  banned (1-100, default: 2)
+  DCHOOK_DEPLOY_BAN_DURATION      Ban duration for deploy and webhook clients
+                                  (1m-720h, default: 1h)
+  DCHOOK_STATUS_MAX_REQUESTS      Status requests per minute per client IP
+                                  (1-1000, default: 15)
+  DCHOOK_HTTP_READ_TIMEOUT        HTTP request read timeout (1s-10m,
+                                  default: 10s)
+  DCHOOK_HTTP_WRITE_TIMEOUT       HTTP response write timeout (1s-10m,
+                                  default: 10s)
+  DCHOOK_HTTP_IDLE_TIMEOUT        HTTP keep-alive idle timeout (1s-1h,
+                                  default: 60s)
+  DCHOOK_HISTORY_SIZE             Number of deployments kept for the status
+                                  endpoints (1-1000, default: 10)
+
+Variables marked with * are required. At least one of the variables marked
+with + is required; each must be present if its algorithms are allowed.
+
+Signals:
+  SIGHUP    Re-read and validate the configuration, secrets, and keys. The
+            current configuration is kept if the new one is invalid.
+  SIGTERM   Stop accepting deployments, wait for running deployments until
+            the shutdown timeout, and stop the server (also SIGINT). A second
+            signal stops waiting.
 
-Variables marked with * are required.
+Commands:
+  check-config          Load and validate the configuration, secrets, keys,
+                        and compose file without starting the server.
+  doctor                Check that this host can run dchook: the startup
+                        checks, Docker access, the compose configuration
+                        and images, registry credentials, and the user.
+                        Prints pass, warn, and fail results with hints.
+    --json              Print the report as JSON.
+    --clock-reference URL
+                        Check clock skew against the Date header of URL.
+  audit verify [FILE]   Verify the hash chain of the audit log (default:
+                        DCHOOK_AUDIT_LOG) and print its head hash.
+
+systemd:
+  A socket passed by systemd socket activation (LISTEN_FDS) is used instead of
+  the bind address and port. With Type=notify, dchook reports READY=1 and
+  STOPPING=1, and pings the watchdog when WatchdogSec= is set.
 
 Examples:
   # Using environment variables
@@ -97,10 +373,12 @@ Examples:
 
   # Using flags
   %s -s /etc/dchook/secret -c /opt/app/docker-compose.yml --project myapp -p 8080
-`, progName, progName)
+
+  # Accepting only Ed25519 signatures
+  %s -k /etc/dchook/deploy.pub --algorithms ed25519 -c /opt/app/docker-compose.yml
+`, progName, progName, progName)
 }
 
-//nolint:gocognit // Will be refactored when switching to Docker API approach
 func main() {
 	flag.Usage = func() {
 		printUsage(os.Stderr)
@@ -117,6 +395,15 @@ func main() {
 		os.Exit(0)
 	}
 
+	switch flag.Arg(0) {
+	case "audit":
+		os.Exit(auditCommand(flag.Args()[1:], os.Stdout, os.Stderr))
+	case "check-config":
+		os.Exit(checkConfigCommand(os.Stdout, os.Stderr))
+	case "doctor":
+		os.Exit(doctorCommand(flag.Args()[1:], os.Stdout, os.Stderr))
+	}
+
 	// Initialize structured logger
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
@@ -125,81 +412,27 @@ func main() {
 
 	slog.Info("starting dchook", "version", version, "commit", commit)
 
-	secret, err := readSecretFile()
+	configFilePath, err := loadConfigFile()
 	if err != nil {
-		slog.Error("failed to read secret file", "error", err)
+		slog.Error("invalid configuration file", "error", err)
 		os.Exit(1)
 	}
-
-	allowedAlgos, err := dchook.FlagValue(*algorithms, "DCHOOK_ALLOWED_ALGORITHMS", "-a")
-	if err != nil {
-		allowedAlgos = "sha256,sha384,sha512"
-	}
-
-	allowedAlgorithms := make(map[string]bool)
-	for algo := range strings.SplitSeq(allowedAlgos, ",") {
-		algo = strings.TrimSpace(algo)
-		if algo == "sha256" || algo == "sha384" || algo == "sha512" {
-			allowedAlgorithms[algo] = true
-		} else {
-			slog.Error("invalid algorithm", "algorithm", algo)
-			os.Exit(1)
-		}
+	if configFilePath != "" {
+		slog.Info("configuration file loaded", "path", configFilePath)
 	}
 
-	composeFilePath, err := dchook.FlagValue(*composeFile, "DCHOOK_COMPOSE_FILE", "-c")
+	limits, err := loadServerLimits()
 	if err != nil {
-		slog.Error("missing compose file", "error", err)
+		slog.Error("invalid limits", "error", err)
 		os.Exit(1)
 	}
 
-	composeFilePath, err = validateComposeFile(composeFilePath)
+	cfg, err := loadHandlerConfig()
 	if err != nil {
-		slog.Error("invalid compose file", "error", err)
+		slog.Error("invalid configuration", "error", err)
 		os.Exit(1)
 	}
 
-	//nolint:errcheck // Optional
-	projectName, _ := dchook.FlagValue(
-		*composeProject,
-		"DCHOOK_COMPOSE_PROJECT",
-		"--project",
-	)
-
-	if projectName != "" {
-		if err := validateProjectName(projectName); err != nil {
-			slog.Error("invalid project name", "error", err)
-			os.Exit(1)
-		}
-	}
-
-	//nolint:errcheck // Optional
-	exceptServices, _ := dchook.FlagValue(
-		"",
-		"DCHOOK_EXCEPT_SERVICES",
-		"",
-	)
-
-	var exceptServicesList []string
-	if exceptServices != "" {
-		for svc := range strings.SplitSeq(exceptServices, ",") {
-			if svc = strings.TrimSpace(svc); svc != "" {
-				exceptServicesList = append(exceptServicesList, svc)
-			}
-		}
-	}
-
-	controller := &DockerComposeAdapter{
-		ComposeFile:    composeFilePath,
-		ProjectName:    projectName,
-		ExceptServices: exceptServicesList,
-	}
-	dockerAvailable := true
-	if err := controller.Available(); err != nil {
-		slog.Warn("docker unavailable, deployments will fail with 503", "error", err)
-		dockerAvailable = false
-	}
-
 	listenAddr, err := dchook.FlagValue(*bindAddress, "DCHOOK_BIND_ADDRESS", "-b")
 	if err != nil {
 		listenAddr = "127.0.0.1"
@@ -211,51 +444,151 @@ func main() {
 		listenPort = "7999"
 	}
 
-	history := NewDeploymentHistory()
+	proxy, err := loadProxyConfig()
+	if err != nil {
+		slog.Error("invalid proxy configuration", "error", err)
+		os.Exit(1)
+	}
 
-	ipExtractor, err := clientip.New(clientip.PresetVMReverseProxy())
+	ipExtractor, err := dchook.NewIPExtractor(proxy.trustedProxies, proxy.ipSources)
 	if err != nil {
 		slog.Error("failed to create IP extractor", "error", err)
 		os.Exit(1)
 	}
 
-	rateLimitDuration, err := time.ParseDuration(rateLimitWindow)
+	tracer, otlpURL, err := loadTracer()
+	if err != nil {
+		slog.Error("invalid tracing configuration", "error", err)
+		os.Exit(1)
+	}
+
+	auditLog, err := loadAuditLog()
 	if err != nil {
-		slog.Error("invalid rate limit window", "window", rateLimitWindow, "error", err)
+		slog.Error("invalid audit log", "error", err)
 		os.Exit(1)
 	}
 
 	deployLimiter := dchook.NewRateLimiter(
 		1,
-		rateLimitDuration,
-		deployMaxFailures,
-		deployBanDuration,
+		limits.rateLimitWindow,
+		limits.deployMaxFailures,
+		limits.deployBanDuration,
 		replayTrackingWindow,
 	)
+	webhookLimiter := dchook.NewRateLimiter(
+		1,
+		limits.rateLimitWindow,
+		limits.deployMaxFailures,
+		limits.deployBanDuration,
+		deliveryTrackingWindow,
+	)
 	statusLimiter := dchook.NewRateLimiter(
 		1,
 		statusRateWindow,
-		statusMaxRequests,
+		limits.statusMaxRequests,
 		statusRateWindow2,
 		replayTrackingWindow,
 	)
 
-	// Create handler configuration
-	cfg := &HandlerConfig{
-		dockerAvailable:   dockerAvailable,
-		ipExtractor:       ipExtractor,
-		secret:            secret,
-		allowedAlgorithms: allowedAlgorithms,
-		adapter:           controller,
-		history:           history,
-		version:           version,
-		commit:            commit,
+	cfg.ipExtractor = ipExtractor
+	cfg.history = NewDeploymentHistoryWithSize(limits.historySize)
+	cfg.deployments = NewDeploymentTracker()
+	cfg.metrics = newServerMetrics()
+	cfg.audit = auditLog
+	for _, p := range cfg.projects {
+		p.history = NewDeploymentHistoryWithSize(limits.historySize)
+	}
+	store := NewConfigStore(cfg, reloadHandlerConfig)
+	cfg.queue = NewDeploymentQueue(store.Load)
+	cfg.deployments.OnFinish(func(id string) {
+		if deployment, found := store.Load().findDeployment(id); found {
+			cfg.metrics.DeploymentFinished(deployment)
+			cfg.audit.Record(AuditRecord{
+				Event:        auditDeploymentFinished,
+				DeploymentID: id,
+				Identity:     deployment.Client,
+				Status:       deployment.Status,
+			})
+		}
+	})
+	store.HandleSignals()
+
+	watchInterval, err := parseWatchInterval()
+	if err != nil {
+		slog.Error("invalid watch interval", "error", err)
+		os.Exit(1)
+	}
+	if watchInterval > 0 {
+		go store.WatchFiles(watchedFiles(), watchInterval)
+	} else if cfg.tls != nil {
+		// Renewed certificates are picked up without a restart.
+		certPath, keyPath, clientCAPath := tlsFilePaths()
+		go store.WatchFiles(
+			[]string{certPath, keyPath, clientCAPath},
+			defaultTLSWatchInterval,
+		)
 	}
 
 	// Register handlers (most specific first)
-	http.HandleFunc("/deploy/status/", createStatusHandler(cfg, statusLimiter))
-	http.HandleFunc("/deploy", createDeployHandler(cfg, deployLimiter))
-	http.HandleFunc("/health", createHealthHandler(cfg))
+	statusHandler := createStatusHandler(store, statusLimiter)
+	deployHandler := createDeployHandler(store, deployLimiter)
+	http.HandleFunc("/deploy/status/", statusHandler)
+	for _, forge := range webhookForges() {
+		route := "/deploy/" + forge.receiver.name
+		http.HandleFunc(
+			route,
+			traced(tracer, route, createForgeHandler(store, webhookLimiter, forge.receiver)),
+		)
+	}
+	http.HandleFunc(
+		"/deploy/registry",
+		traced(tracer, "/deploy/registry", createRegistryHandler(store, webhookLimiter)),
+	)
+	http.HandleFunc("/deploy", traced(tracer, "/deploy", deployHandler))
+	http.HandleFunc("/deploy/", createProjectHandler(
+		traced(tracer, "/deploy/{project}", deployHandler),
+		statusHandler,
+	))
+	http.HandleFunc("/health", createHealthHandler(store))
+
+	metricsHandler := createMetricsHandler(store, cfg.metrics, map[string]*dchook.RateLimiter{
+		"deploy":  deployLimiter,
+		"webhook": webhookLimiter,
+		"status":  statusLimiter,
+	})
+
+	//nolint:errcheck // Optional
+	metricsAddr, _ := dchook.FlagValue(
+		*metricsAddress,
+		"DCHOOK_METRICS_ADDRESS",
+		"--metrics-address",
+	)
+	var metricsServer *http.Server
+	if metricsAddr == "" {
+		http.HandleFunc("/metrics", metricsHandler)
+	} else {
+		metricsServer, err = serveMetrics(metricsAddr, metricsHandler, limits)
+		if err != nil {
+			slog.Error("failed to listen for metrics", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	drainTimeout, err := parseShutdownTimeout()
+	if err != nil {
+		slog.Error("invalid shutdown timeout", "error", err)
+		os.Exit(1)
+	}
+
+	listener, err := listen(listenAddr, listenPort)
+	if err != nil {
+		slog.Error("failed to listen", "error", err)
+		os.Exit(1)
+	}
+
+	if proxy.proxyProtocol {
+		listener = dchook.NewProxyProtocolListener(listener, proxy.trustedProxies)
+	}
 
 	slog.Info(
 		"server starting",
@@ -264,22 +597,88 @@ func main() {
 		"commit",
 		commit,
 		"address",
-		listenAddr,
-		"port",
-		listenPort,
+		listener.Addr().String(),
+		"tls",
+		cfg.tls != nil,
+		"trusted_proxies",
+		proxy.trustedProxies,
+		"ip_headers",
+		proxy.ipSources,
+		"proxy_protocol",
+		proxy.proxyProtocol,
+		"metrics_address",
+		metricsAddr,
+		"otlp_endpoint",
+		otlpURL,
 	)
 
 	server := &http.Server{
-		Addr:         listenAddr + ":" + listenPort,
-		ReadTimeout:  httpReadTimeout,
-		WriteTimeout: httpWriteTimeout,
-		IdleTimeout:  httpIdleTimeout,
+		Handler:      unixPeerHandler(http.DefaultServeMux),
+		ReadTimeout:  limits.httpReadTimeout,
+		WriteTimeout: limits.httpWriteTimeout,
+		IdleTimeout:  limits.httpIdleTimeout,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
+	// A second signal stops waiting for running deployments.
+	stopSignals := make(chan os.Signal, 2)
+	signal.Notify(stopSignals, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		received := <-stopSignals
+		slog.Info(
+			"server stopping",
+			"signal",
+			received.String(),
+			"shutdown_timeout",
+			drainTimeout.String(),
+		)
+		sdNotify(sdNotifyStopping)
+
+		shutdown(store.Load(), drainTimeout, stopSignals)
+
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Warn("server shutdown incomplete", "error", err)
+		}
+
+		if metricsServer != nil {
+			if err := metricsServer.Shutdown(ctx); err != nil {
+				slog.Warn("metrics server shutdown incomplete", "error", err)
+			}
+		}
+
+		if err := tracer.Shutdown(ctx); err != nil {
+			slog.Warn("failed to export spans", "error", err)
+		}
+
+		if err := auditLog.Close(); err != nil {
+			slog.Warn("failed to close audit log", "error", err)
+		}
+	}()
+
+	sdNotify(sdNotifyReady)
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go sdWatchdog(interval)
+	}
+
+	if cfg.tls != nil {
+		server.TLSConfig = newServerTLSConfig(store)
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("server failed", "error", err)
 		os.Exit(1)
 	}
+
+	<-stopped
+	slog.Info("server stopped")
 }
 
 func readSecretFile() (string, error) {
@@ -292,32 +691,466 @@ func readSecretFile() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to read secret: %w", err)
 	}
+
+	// An empty secret would accept signatures from anyone. This also catches a named
+	// pipe that has already been read, such as process substitution on reload.
+	if secret == "" {
+		return "", fmt.Errorf("%w: %q", errSecretEmpty, secretFilePath)
+	}
 	return secret, nil
 }
 
+// loadHandlerConfig reads and validates the reloadable configuration: the allowed
+// algorithms, secrets and keys, and the compose adapter. The returned configuration has
+// no IP extractor or deployment history; those are set once and kept across reloads.
+func loadHandlerConfig() (*HandlerConfig, error) {
+	allowedAlgorithms, err := parseAllowedAlgorithms()
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed algorithms: %w", err)
+	}
+
+	clients, err := readClients()
+	if err != nil {
+		return nil, err
+	}
+
+	oidc, err := loadOIDCConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	tls, err := loadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// With a client registry or OIDC, the secret and public key files are optional.
+	optional := clients != nil || oidc != nil
+
+	var secret string
+	var secrets map[string]string
+	if allowsHMAC(allowedAlgorithms) {
+		secret, secrets, err = readSecrets(optional)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkClientNames(clients, secrets); err != nil {
+		return nil, err
+	}
+
+	var publicKey ed25519.PublicKey
+	if allowedAlgorithms[dchook.AlgorithmEd25519] {
+		publicKey, err = readPublicKeyFile(optional)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	requireMessageSignatures, err := parseSignatureScheme()
+	if err != nil {
+		return nil, err
+	}
+
+	forges := make(map[string]*forgeConfig)
+	for _, forge := range webhookForges() {
+		forgeConfig, err := loadForgeConfig(
+			forge.receiver,
+			forge.events,
+			forge.secretFile,
+			secret,
+			allowedAlgorithms,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if forgeConfig != nil {
+			forges[forge.receiver.name] = forgeConfig
+		}
+	}
+
+	registrySecret, err := readRegistrySecret()
+	if err != nil {
+		return nil, err
+	}
+
+	projects, err := readProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	composeFilePaths, err := dchook.FlagValue(*composeFile, "DCHOOK_COMPOSE_FILE", "-c")
+	if err != nil {
+		return nil, fmt.Errorf("missing compose file: %w", err)
+	}
+
+	//nolint:errcheck // Optional
+	envFilePaths, _ := dchook.FlagValue(*envFiles, "DCHOOK_ENV_FILES", "--env-files")
+
+	controller, err := newComposeAdapter(
+		composeFilePaths,
+		envFilePaths,
+		dchook.EnvValue("DCHOOK_COMPOSE_PROFILES"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedProfiles, err := parseProfiles(dchook.EnvValue("DCHOOK_ALLOWED_PROFILES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed profiles: %w", err)
+	}
+
+	//nolint:errcheck // Optional
+	projectName, _ := dchook.FlagValue(
+		*composeProject,
+		"DCHOOK_COMPOSE_PROJECT",
+		"--project",
+	)
+
+	if err := validateProjectName(projectName); err != nil {
+		return nil, fmt.Errorf("invalid project name: %w", err)
+	}
+
+	//nolint:errcheck // Optional
+	exceptServices, _ := dchook.FlagValue(
+		"",
+		"DCHOOK_EXCEPT_SERVICES",
+		"",
+	)
+
+	//nolint:errcheck // Optional
+	allowedServices, _ := dchook.FlagValue(
+		"",
+		"DCHOOK_ALLOWED_SERVICES",
+		"",
+	)
+
+	//nolint:errcheck // Optional
+	imageRepositoriesValue, _ := dchook.FlagValue(
+		"",
+		"DCHOOK_IMAGE_REPOSITORIES",
+		"",
+	)
+
+	imageRepositories, err := parseImageRepositories(imageRepositoriesValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image repositories: %w", err)
+	}
+
+	//nolint:errcheck // Optional
+	payloadEnvFilePath, _ := dchook.FlagValue(
+		*payloadEnvFile,
+		"DCHOOK_PAYLOAD_ENV_FILE",
+		"--payload-env",
+	)
+
+	payloadEnv, err := readPayloadEnv(payloadEnvFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck // Optional
+	scheduleFilePath, _ := dchook.FlagValue(*scheduleFile, "DCHOOK_SCHEDULE_FILE", "--schedule")
+
+	schedule, err := readSchedule(scheduleFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	git, err := loadGitSource(
+		controller.ComposeFile,
+		dchook.EnvValue("DCHOOK_GIT_REMOTE"),
+		dchook.EnvValue("DCHOOK_GIT_REF"),
+		dchook.EnvValue("DCHOOK_GIT_REFS"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid git source: %w", err)
+	}
+
+	controller.ProjectName = projectName
+	controller.ExceptServices = splitList(exceptServices)
+	controller.Git = git
+	dockerAvailable := true
+	if err := controller.Available(); err != nil {
+		slog.Warn("docker unavailable, deployments will fail with 503", "error", err)
+		dockerAvailable = false
+	}
+
+	return &HandlerConfig{
+		dockerAvailable:          dockerAvailable,
+		secret:                   secret,
+		secrets:                  secrets,
+		publicKey:                publicKey,
+		allowedAlgorithms:        allowedAlgorithms,
+		requireMessageSignatures: requireMessageSignatures,
+		clients:                  clients,
+		oidc:                     oidc,
+		tls:                      tls,
+		forges:                   forges,
+		registrySecret:           registrySecret,
+		adapter:                  controller,
+		allowedServices:          splitList(allowedServices),
+		allowedProfiles:          allowedProfiles,
+		imageRepositories:        imageRepositories,
+		payloadEnv:               payloadEnv,
+		git:                      git,
+		schedule:                 schedule,
+		projects:                 projects,
+		version:                  version,
+		commit:                   commit,
+	}, nil
+}
+
+func parseWatchInterval() (time.Duration, error) {
+	//nolint:errcheck // Optional
+	interval, _ := dchook.FlagValue(*watchInterval, "DCHOOK_WATCH_INTERVAL", "--watch")
+	if interval == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", interval, err)
+	}
+
+	if duration < minWatchInterval {
+		return 0, fmt.Errorf("%w: %v (minimum %v)", errWatchTooShort, duration, minWatchInterval)
+	}
+	return duration, nil
+}
+
+// forgeFlags holds the configured events and secret file flags for a forge receiver.
+type forgeFlags struct {
+	receiver   *forgeReceiver
+	events     string
+	secretFile string
+}
+
+// webhookForges returns the forge webhook receivers with their flag values.
+func webhookForges() []forgeFlags {
+	return []forgeFlags{
+		{githubReceiver, *githubEvents, *githubSecretFile},
+		{gitlabReceiver, *gitlabEvents, *gitlabSecretFile},
+		{giteaReceiver, *giteaEvents, *giteaSecretFile},
+		{forgejoReceiver, *forgejoEvents, *forgejoSecretFile},
+	}
+}
+
+// fileSetting is a file path set by flag, environment variable, or configuration file.
+type fileSetting struct {
+	flagVal *string
+	envVar  string
+}
+
+// secretFileSettings are the secret, key set, public key, clients, projects, payload
+// environment, schedule, OIDC JWKS and rules, forge secret, registry secret, and TLS key
+// file settings. All of them are read with the checks of dchook.ReadSecretFileStrict.
+var secretFileSettings = []fileSetting{
+	{secretFile, "DCHOOK_SECRET_FILE"},
+	{keySetFile, "DCHOOK_KEYSET_FILE"},
+	{publicKeyFile, "DCHOOK_PUBLIC_KEY_FILE"},
+	{clientsFile, "DCHOOK_CLIENTS_FILE"},
+	{projectsFile, "DCHOOK_PROJECTS_FILE"},
+	{payloadEnvFile, "DCHOOK_PAYLOAD_ENV_FILE"},
+	{scheduleFile, "DCHOOK_SCHEDULE_FILE"},
+	{oidcJWKSFile, "DCHOOK_OIDC_JWKS_FILE"},
+	{oidcRulesFile, "DCHOOK_OIDC_RULES_FILE"},
+	{githubSecretFile, "DCHOOK_GITHUB_SECRET_FILE"},
+	{gitlabSecretFile, "DCHOOK_GITLAB_SECRET_FILE"},
+	{giteaSecretFile, "DCHOOK_GITEA_SECRET_FILE"},
+	{forgejoSecretFile, "DCHOOK_FORGEJO_SECRET_FILE"},
+	{registrySecretFile, "DCHOOK_REGISTRY_SECRET_FILE"},
+	{tlsKeyFile, "DCHOOK_TLS_KEY"},
+}
+
+// certFileSettings are the TLS certificate and client CA file settings. Certificates are
+// public, so they are read with the checks of dchook.ReadCertFileStrict, which allow
+// symlinks and any mode.
+var certFileSettings = []fileSetting{
+	{tlsCertFile, "DCHOOK_TLS_CERT"},
+	{tlsClientCA, "DCHOOK_TLS_CLIENT_CA"},
+}
+
+// value returns the configured path, or an empty string if the file is not configured.
+func (setting fileSetting) value() string {
+	//nolint:errcheck // Optional
+	value, _ := dchook.FlagValue(*setting.flagVal, setting.envVar, "")
+	return value
+}
+
+// watchedFiles returns the configuration file and the configured secret, key set, public
+// key, clients, OIDC JWKS and rules, forge secret, registry secret, and TLS file paths.
+func watchedFiles() []string {
+	settings := append([]fileSetting{{configPath, "DCHOOK_CONFIG"}}, secretFileSettings...)
+	settings = append(settings, certFileSettings...)
+
+	var paths []string
+	for _, setting := range settings {
+		if value := setting.value(); value != "" {
+			paths = append(paths, value)
+		}
+	}
+	return paths
+}
+
+// readSecrets reads the default secret and the key set of named secrets. The secret file
+// is only required if no key set file is configured and the secrets are not optional.
+func readSecrets(optional bool) (string, map[string]string, error) {
+	//nolint:errcheck // Optional
+	keySetFilePath, _ := dchook.FlagValue(*keySetFile, "DCHOOK_KEYSET_FILE", "--keyset")
+	//nolint:errcheck // Optional with a key set
+	secretFilePath, _ := dchook.FlagValue(*secretFile, "DCHOOK_SECRET_FILE", "-s")
+
+	if keySetFilePath == "" {
+		if optional && secretFilePath == "" {
+			return "", nil, nil
+		}
+
+		secret, err := readSecretFile()
+		return secret, nil, err
+	}
+
+	secrets, err := dchook.ReadKeySetFileStrict(keySetFilePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read key set: %w", err)
+	}
+
+	if secretFilePath == "" {
+		return "", secrets, nil
+	}
+
+	secret, err := readSecretFile()
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, secrets, nil
+}
+
+// readPublicKeyFile reads the default Ed25519 public key. Returns nil if the public key
+// is optional and no public key file is configured.
+func readPublicKeyFile(optional bool) (ed25519.PublicKey, error) {
+	publicKeyFilePath, err := dchook.FlagValue(*publicKeyFile, "DCHOOK_PUBLIC_KEY_FILE", "-k")
+	if err != nil {
+		if optional {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("public key file configuration: %w", err)
+	}
+
+	publicKey, err := dchook.ReadPublicKeyFileStrict(publicKeyFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	return publicKey, nil
+}
+
+// parseAllowedAlgorithms returns the set of allowed signature algorithms. When not
+// configured, HMAC algorithms are allowed if a secret, key set, or clients file is
+// configured (or if no public key file is configured) and Ed25519 is allowed if a public
+// key or clients file is configured.
+func parseAllowedAlgorithms() (map[string]bool, error) {
+	allowedAlgos, err := dchook.FlagValue(
+		*algorithms,
+		"DCHOOK_ALLOWED_ALGORITHMS",
+		"--algorithms",
+	)
+	if err != nil {
+		//nolint:errcheck // Optional
+		secretFilePath, _ := dchook.FlagValue(*secretFile, "DCHOOK_SECRET_FILE", "-s")
+		//nolint:errcheck // Optional
+		keySetFilePath, _ := dchook.FlagValue(*keySetFile, "DCHOOK_KEYSET_FILE", "--keyset")
+		//nolint:errcheck // Optional
+		publicKeyFilePath, _ := dchook.FlagValue(
+			*publicKeyFile,
+			"DCHOOK_PUBLIC_KEY_FILE",
+			"-k",
+		)
+
+		//nolint:errcheck // Optional
+		clientsFilePath, _ := dchook.FlagValue(*clientsFile, "DCHOOK_CLIENTS_FILE", "--clients")
+
+		var defaults []string
+		if secretFilePath != "" || keySetFilePath != "" || clientsFilePath != "" ||
+			publicKeyFilePath == "" {
+			defaults = append(
+				defaults,
+				dchook.AlgorithmSHA256,
+				dchook.AlgorithmSHA384,
+				dchook.AlgorithmSHA512,
+			)
+		}
+		if publicKeyFilePath != "" || clientsFilePath != "" {
+			defaults = append(defaults, dchook.AlgorithmEd25519)
+		}
+		allowedAlgos = strings.Join(defaults, ",")
+	}
+
+	allowedAlgorithms := make(map[string]bool)
+	for algo := range strings.SplitSeq(allowedAlgos, ",") {
+		algo = strings.TrimSpace(algo)
+		if !dchook.IsHMACAlgorithm(algo) && algo != dchook.AlgorithmEd25519 {
+			return nil, fmt.Errorf("%w: %q", errInvalidAlgorithm, algo)
+		}
+		allowedAlgorithms[algo] = true
+	}
+	return allowedAlgorithms, nil
+}
+
+func allowsHMAC(allowedAlgorithms map[string]bool) bool {
+	for algo := range allowedAlgorithms {
+		if dchook.IsHMACAlgorithm(algo) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileKindErrors are the errors that validateRegularFile reports for a kind of file.
+type fileKindErrors struct {
+	name        string
+	symlink     error
+	notAbsolute error
+	notRegular  error
+}
+
+var composeFileErrors = fileKindErrors{
+	name:        "compose file",
+	symlink:     errComposeSymlink,
+	notAbsolute: errComposeNotAbsolute,
+	notRegular:  errComposeNotRegular,
+}
+
 func validateComposeFile(path string) (string, error) {
+	return validateRegularFile(path, composeFileErrors)
+}
+
+// validateRegularFile checks that path is an absolute path to a regular file that is not
+// a symlink, reporting failures with the errors for its kind. Returns the resolved path.
+func validateRegularFile(path string, kind fileKindErrors) (string, error) {
 	// Check if the path is a symlink
 	info, err := os.Lstat(path)
 	if err != nil {
-		return "", fmt.Errorf("failed to stat compose file %q: %w", path, err)
+		return "", fmt.Errorf("failed to stat %s %q: %w", kind.name, path, err)
 	}
 	if info.Mode()&os.ModeSymlink != 0 {
-		return "", fmt.Errorf("%w: %q", errComposeSymlink, path)
+		return "", fmt.Errorf("%w: %q", kind.symlink, path)
 	}
 
 	// Resolve and validate path
 	resolvedPath, err := filepath.EvalSymlinks(path)
 	if err != nil {
-		return "", fmt.Errorf("invalid compose file path %q: %w", path, err)
+		return "", fmt.Errorf("invalid %s path %q: %w", kind.name, path, err)
 	}
 
 	if !filepath.IsAbs(resolvedPath) {
-		return "", fmt.Errorf("%w: %q", errComposeNotAbsolute, path)
+		return "", fmt.Errorf("%w: %q", kind.notAbsolute, path)
 	}
 
 	// Verify it's a regular file
 	if !info.Mode().IsRegular() {
-		return "", fmt.Errorf("%w: %q", errComposeNotRegular, path)
+		return "", fmt.Errorf("%w: %q", kind.notRegular, path)
 	}
 
 	return resolvedPath, nil
@@ -7,8 +7,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/abczzz13/clientip"
-
 	"github.com/halostatue/dchook/internal/dchook"
 )
 
@@ -19,7 +17,10 @@ func FuzzDeployHandler(f *testing.F) {
 	limiter := dchook.NewRateLimiter(10, time.Minute, 5, time.Hour, 10*time.Minute)
 	history := NewDeploymentHistory()
 	adapter := &MockAdapter{}
-	ipExtractor, err := clientip.New(clientip.PresetVMReverseProxy())
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
 	if err != nil {
 		f.Fatal(err)
 	}
@@ -35,7 +36,7 @@ func FuzzDeployHandler(f *testing.F) {
 		commit:            "abc",
 	}
 
-	handler := createDeployHandler(cfg, limiter)
+	handler := createDeployHandler(NewConfigStore(cfg, nil), limiter)
 
 	// Seed with valid and invalid inputs
 	validPayload := []byte(
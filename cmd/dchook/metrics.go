@@ -0,0 +1,348 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+// Deploy request outcomes, recorded for /deploy and the webhook endpoints.
+const (
+	outcomeAccepted        = "accepted"
+	outcomeIgnored         = "ignored"
+	outcomeBadRequest      = "bad_request"
+	outcomeBadSignature    = "bad_signature"
+	outcomeUnauthorized    = "unauthorized"
+	outcomeReplay          = "replay"
+	outcomeVersionMismatch = "version_mismatch"
+	outcomeRateLimited     = "rate_limited"
+	outcomeBanned          = "banned"
+	outcomeUnavailable     = "unavailable"
+	outcomeClosed          = "closed"
+	outcomeError           = "error"
+
+	endpointDeploy   = "deploy"
+	endpointRegistry = "registry"
+
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	deployOutcomes = []string{
+		outcomeAccepted,
+		outcomeIgnored,
+		outcomeBadRequest,
+		outcomeBadSignature,
+		outcomeUnauthorized,
+		outcomeReplay,
+		outcomeVersionMismatch,
+		outcomeRateLimited,
+		outcomeBanned,
+		outcomeUnavailable,
+		outcomeClosed,
+		outcomeError,
+	}
+
+	// durationBuckets are the histogram buckets, in seconds, for docker compose pull and
+	// up durations.
+	durationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+)
+
+// Metrics holds the counters and histograms exposed in the Prometheus text format on
+// /metrics. Gauges are read from their sources when scraped.
+type Metrics struct {
+	mutex        sync.Mutex
+	requests     map[string]map[string]uint64 // by endpoint and outcome
+	deployments  map[string]uint64            // by final status
+	pullDuration histogram
+	upDuration   histogram
+}
+
+// histogram is a cumulative Prometheus histogram over durationBuckets.
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewMetrics creates metrics with zeroed request counters for the endpoints, so that
+// every outcome is exported before it first occurs.
+func NewMetrics(endpoints ...string) *Metrics {
+	metrics := &Metrics{
+		requests: make(map[string]map[string]uint64),
+		deployments: map[string]uint64{
+			statusComplete: 0,
+			statusFailed:   0,
+		},
+		pullDuration: histogram{buckets: make([]uint64, len(durationBuckets))},
+		upDuration:   histogram{buckets: make([]uint64, len(durationBuckets))},
+	}
+
+	for _, endpoint := range endpoints {
+		metrics.requests[endpoint] = make(map[string]uint64)
+		for _, outcome := range deployOutcomes {
+			metrics.requests[endpoint][outcome] = 0
+		}
+	}
+	return metrics
+}
+
+// newServerMetrics creates metrics for /deploy, the registry endpoint, and each webhook
+// forge.
+func newServerMetrics() *Metrics {
+	endpoints := []string{endpointDeploy, endpointRegistry}
+	for _, forge := range webhookForges() {
+		endpoints = append(endpoints, forge.receiver.name)
+	}
+	return NewMetrics(endpoints...)
+}
+
+// DeployRequest counts a deploy request to the endpoint with the outcome.
+func (m *Metrics) DeployRequest(endpoint, outcome string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.requests[endpoint] == nil {
+		m.requests[endpoint] = make(map[string]uint64)
+	}
+	m.requests[endpoint][outcome]++
+}
+
+// DeploymentFinished counts a finished deployment by its final status and observes the
+// pull and up durations.
+func (m *Metrics) DeploymentFinished(deployment Deployment) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.deployments[deployment.Status]++
+	if deployment.Pull != nil {
+		m.pullDuration.observe(float64(deployment.Pull.DurationMs) / 1000)
+	}
+	if deployment.Restart != nil {
+		m.upDuration.observe(float64(deployment.Restart.DurationMs) / 1000)
+	}
+}
+
+func (h *histogram) observe(seconds float64) {
+	h.count++
+	h.sum += seconds
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+}
+
+// createMetricsHandler serves the metrics, the active bans of each rate limiter, the
+// number of running deployments, and Docker availability in the Prometheus text format.
+func createMetricsHandler(
+	store *ConfigStore,
+	metrics *Metrics,
+	limiters map[string]*dchook.RateLimiter,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cfg := store.Load()
+		w.Header().Set("Content-Type", metricsContentType)
+
+		out := bufio.NewWriter(w)
+		metrics.write(out)
+
+		writeMetricHeader(out, "dchook_active_bans", "gauge", "Currently banned IP addresses.")
+		for _, name := range slices.Sorted(maps.Keys(limiters)) {
+			writeMetric(out, "dchook_active_bans", limiters[name].ActiveBans(), "limiter", name)
+		}
+
+		writeMetricHeader(
+			out,
+			"dchook_deployments_running",
+			"gauge",
+			"Deployments currently running.",
+		)
+		writeMetric(out, "dchook_deployments_running", cfg.deployments.Running())
+
+		writeMetricHeader(
+			out,
+			"dchook_docker_available",
+			"gauge",
+			"Whether Docker was available at the last configuration load.",
+		)
+		writeMetric(out, "dchook_docker_available", boolValue(cfg.dockerAvailable))
+
+		writeMetricHeader(out, "dchook_build_info", "gauge", "Build information.")
+		writeMetric(out, "dchook_build_info", 1, "version", cfg.version, "commit", cfg.commit)
+
+		if err := out.Flush(); err != nil {
+			slog.Error("failed to write metrics", "error", err)
+		}
+	}
+}
+
+// serveMetrics serves the metrics handler on a separate listener at addr, so that
+// /metrics can be kept off the public listener.
+func serveMetrics(
+	addr string,
+	handler http.HandlerFunc,
+	limits serverLimits,
+) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %q: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handler)
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  limits.httpReadTimeout,
+		WriteTimeout: limits.httpWriteTimeout,
+		IdleTimeout:  limits.httpIdleTimeout,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server failed", "error", err)
+		}
+	}()
+	return server, nil
+}
+
+func (m *Metrics) write(out io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	writeMetricHeader(
+		out,
+		"dchook_deploy_requests_total",
+		"counter",
+		"Deploy requests by endpoint and outcome.",
+	)
+	for _, endpoint := range slices.Sorted(maps.Keys(m.requests)) {
+		outcomes := m.requests[endpoint]
+		for _, outcome := range slices.Sorted(maps.Keys(outcomes)) {
+			writeMetric(
+				out,
+				"dchook_deploy_requests_total",
+				outcomes[outcome],
+				"endpoint",
+				endpoint,
+				"outcome",
+				outcome,
+			)
+		}
+	}
+
+	writeMetricHeader(
+		out,
+		"dchook_deployments_total",
+		"counter",
+		"Finished deployments by final status.",
+	)
+	for _, status := range slices.Sorted(maps.Keys(m.deployments)) {
+		writeMetric(out, "dchook_deployments_total", m.deployments[status], "status", status)
+	}
+
+	m.pullDuration.write(
+		out,
+		"dchook_deployment_pull_duration_seconds",
+		"Duration of docker compose pull.",
+	)
+	m.upDuration.write(
+		out,
+		"dchook_deployment_up_duration_seconds",
+		"Duration of docker compose up.",
+	)
+}
+
+func (h *histogram) write(out io.Writer, name, help string) {
+	writeMetricHeader(out, name, "histogram", help)
+	for i, bound := range durationBuckets {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		writeMetric(out, name+"_bucket", h.buckets[i], "le", le)
+	}
+	writeMetric(out, name+"_bucket", h.count, "le", "+Inf")
+	writeMetric(out, name+"_sum", h.sum)
+	writeMetric(out, name+"_count", h.count)
+}
+
+func writeMetricHeader(out io.Writer, name, metricType, help string) {
+	//nolint:errcheck // Write errors are reported by the caller's flush
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeMetric writes a sample with label name and value pairs.
+func writeMetric[V uint64 | int | float64](out io.Writer, name string, value V, labels ...string) {
+	var builder strings.Builder
+	builder.WriteString(name)
+
+	if len(labels) > 0 {
+		builder.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				builder.WriteByte(',')
+			}
+			builder.WriteString(labels[i])
+			builder.WriteString(`="`)
+			builder.WriteString(escapeLabelValue(labels[i+1]))
+			builder.WriteByte('"')
+		}
+		builder.WriteByte('}')
+	}
+
+	//nolint:errcheck // Write errors are reported by the caller's flush
+	fmt.Fprintf(out, "%s %v\n", builder.String(), value)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// signatureOutcome classifies an OIDC token or message signature verification error.
+func signatureOutcome(err error) string {
+	switch {
+	case errors.Is(err, errOIDCReplay), errors.Is(err, errMessageSignatureReplay):
+		return outcomeReplay
+	case errors.Is(err, errOIDCNotPermitted):
+		return outcomeUnauthorized
+	default:
+		return outcomeBadSignature
+	}
+}
+
+func boolValue(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
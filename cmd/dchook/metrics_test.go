@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	t.Parallel()
+
+	metrics := NewMetrics(endpointDeploy, endpointRegistry)
+	metrics.DeployRequest(endpointDeploy, outcomeAccepted)
+	metrics.DeployRequest(endpointDeploy, outcomeAccepted)
+	metrics.DeployRequest(endpointRegistry, outcomeBadSignature)
+	metrics.DeploymentFinished(Deployment{
+		Status:  statusComplete,
+		Pull:    &DeploymentResult{DurationMs: 4500},
+		Restart: &DeploymentResult{DurationMs: 45000},
+	})
+
+	cfg := &HandlerConfig{
+		dockerAvailable: true,
+		history:         NewDeploymentHistory(),
+		deployments:     NewDeploymentTracker(),
+		metrics:         metrics,
+		version:         "1.2.3",
+		commit:          `ab"c`,
+	}
+	limiter := dchook.NewRateLimiter(1, time.Minute, 1, time.Hour, time.Hour)
+	limiter.RecordFailure("192.0.2.1")
+	handler := createMetricsHandler(
+		NewConfigStore(cfg, nil),
+		metrics,
+		map[string]*dchook.RateLimiter{"deploy": limiter},
+	)
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != metricsContentType {
+		t.Errorf("Content-Type = %q, want %q", contentType, metricsContentType)
+	}
+
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`dchook_deploy_requests_total{endpoint="deploy",outcome="accepted"} 2`,
+		`dchook_deploy_requests_total{endpoint="deploy",outcome="banned"} 0`,
+		`dchook_deploy_requests_total{endpoint="registry",outcome="bad_signature"} 1`,
+		`dchook_deployments_total{status="complete"} 1`,
+		`dchook_deployments_total{status="failed"} 0`,
+		`dchook_deployment_pull_duration_seconds_bucket{le="1"} 0`,
+		`dchook_deployment_pull_duration_seconds_bucket{le="5"} 1`,
+		`dchook_deployment_pull_duration_seconds_sum 4.5`,
+		`dchook_deployment_up_duration_seconds_bucket{le="30"} 0`,
+		`dchook_deployment_up_duration_seconds_bucket{le="60"} 1`,
+		`dchook_deployment_up_duration_seconds_bucket{le="+Inf"} 1`,
+		`dchook_deployment_up_duration_seconds_count 1`,
+		`dchook_active_bans{limiter="deploy"} 1`,
+		`dchook_deployments_running 0`,
+		`dchook_docker_available 1`,
+		`dchook_build_info{version="1.2.3",commit="ab\"c"} 1`,
+		"# TYPE dchook_deploy_requests_total counter",
+		"# TYPE dchook_deployment_up_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsHandlerMethod(t *testing.T) {
+	t.Parallel()
+
+	store := NewConfigStore(&HandlerConfig{}, nil)
+	recorder := httptest.NewRecorder()
+	createMetricsHandler(store, NewMetrics(), nil)(
+		recorder,
+		httptest.NewRequest(http.MethodPost, "/metrics", nil),
+	)
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestDeployHandlerMetrics(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := NewMetrics(endpointDeploy)
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		secret:            "test-secret",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+		deployments:       NewDeploymentTracker(),
+		metrics:           metrics,
+	}
+	store := NewConfigStore(cfg, nil)
+	limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+
+	request := httptest.NewRequest(http.MethodPost, "/deploy", strings.NewReader("{}"))
+	request.Header.Set("Dchook-Signature", "sha256=00")
+	createDeployHandler(store, limiter)(httptest.NewRecorder(), request)
+
+	recorder := httptest.NewRecorder()
+	createMetricsHandler(store, metrics, nil)(
+		recorder,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	)
+	want := `dchook_deploy_requests_total{endpoint="deploy",outcome="bad_signature"} 1`
+	if !strings.Contains(recorder.Body.String(), want) {
+		t.Errorf("metrics missing %q in:\n%s", want, recorder.Body.String())
+	}
+}
+
+func TestSignatureOutcome(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"oidc replay", errOIDCReplay, outcomeReplay},
+		{"message signature replay", errMessageSignatureReplay, outcomeReplay},
+		{"oidc not permitted", errOIDCNotPermitted, outcomeUnauthorized},
+		{"other", errors.New("bad"), outcomeBadSignature},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := signatureOutcome(testCase.err); got != testCase.want {
+				t.Errorf("signatureOutcome() = %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	t.Parallel()
+
+	if got := escapeLabelValue("a\\b\"c\nd"); got != `a\\b\"c\nd` {
+		t.Errorf("escapeLabelValue() = %q", got)
+	}
+}
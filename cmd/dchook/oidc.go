@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	bearerPrefix = "Bearer "
+
+	minOIDCRuleFields = 3
+)
+
+var (
+	errOIDCRuleLine     = errors.New("OIDC rule line must be \"name actions claim=value...\"")
+	errOIDCRuleClaim    = errors.New("OIDC rule claim must be claim=pattern")
+	errOIDCRuleDup      = errors.New("duplicate OIDC rule name")
+	errOIDCRulesEmpty   = errors.New("OIDC rules file contains no rules")
+	errOIDCIssuer       = errors.New("OIDC issuer is required with a JWKS file")
+	errOIDCAudience     = errors.New("OIDC audience is required with a JWKS file")
+	errOIDCRulesMissing = errors.New("OIDC rules file is required with a JWKS file")
+	errOIDCNotPermitted = errors.New("no OIDC rule permits the token")
+	errOIDCReplay       = errors.New("OIDC token already used to deploy")
+	errOIDCTokenID      = errors.New("OIDC token has no jti claim to deploy")
+)
+
+// oidcConfig verifies bearer JWTs, such as GitHub Actions OIDC tokens, against a local
+// JWKS. Tokens must be issued by the issuer for the audience, and their claims must
+// match a rule that allows the action.
+type oidcConfig struct {
+	jwks     *dchook.JWKS
+	issuer   string
+	audience string
+	rules    []oidcRule
+}
+
+// oidcRule allows actions for tokens whose claims match every pattern. Patterns use
+// path.Match syntax, so `refs/tags/v*` matches release tags.
+type oidcRule struct {
+	name    string
+	actions map[string]bool
+	claims  map[string]string
+}
+
+// matches checks if every claim in the rule is a string claim in the token matching the
+// pattern.
+func (rule *oidcRule) matches(token *dchook.JWT) bool {
+	for claim, pattern := range rule.claims {
+		value, ok := token.Claims[claim].(string)
+		if !ok {
+			return false
+		}
+
+		if matched, err := path.Match(pattern, value); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// usesOIDC checks if the request should be authenticated with an OIDC bearer token.
+func (cfg *HandlerConfig) usesOIDC(r *http.Request) bool {
+	return cfg.oidc != nil && strings.HasPrefix(r.Header.Get("Authorization"), bearerPrefix)
+}
+
+// authenticateOIDC verifies the bearer token and returns it with the name of the first
+// rule that allows the action. Returns errOIDCNotPermitted if the token is valid but no
+// rule allows the action.
+func (cfg *HandlerConfig) authenticateOIDC(
+	r *http.Request,
+	action string,
+) (*dchook.JWT, string, error) {
+	token, err := cfg.oidc.jwks.VerifyJWT(
+		strings.TrimPrefix(r.Header.Get("Authorization"), bearerPrefix),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := token.ValidateClaims(cfg.oidc.issuer, cfg.oidc.audience, time.Now()); err != nil {
+		return token, "", err
+	}
+
+	for _, rule := range cfg.oidc.rules {
+		if rule.actions[action] && rule.matches(token) {
+			return token, rule.name, nil
+		}
+	}
+
+	return token, "", fmt.Errorf(
+		"%w: %s for sub %q",
+		errOIDCNotPermitted,
+		action,
+		token.StringClaim("sub"),
+	)
+}
+
+// checkOIDCReplay checks that a token used to deploy has a jti claim that has not been
+// used to deploy before. Tokens without a jti could be replayed until they expire. The
+// jti is recorded by claimOIDCToken once the deployment is accepted, so that a request
+// rejected after authentication does not use up the token for its retry.
+func checkOIDCReplay(token *dchook.JWT, limiter *dchook.RateLimiter) error {
+	id := token.ID()
+	if id == "" {
+		return errOIDCTokenID
+	}
+
+	if limiter.SeenNonce(oidcTokenNonce(id)) {
+		return errOIDCReplay
+	}
+	return nil
+}
+
+// claimOIDCToken records the jti of a token used to deploy until the token expires,
+// however long it lives. Returns errOIDCReplay if a concurrent request recorded it first.
+func claimOIDCToken(token *dchook.JWT, limiter *dchook.RateLimiter) error {
+	if !limiter.CheckNonceUntil(oidcTokenNonce(token.ID()), token.ValidUntil()) {
+		return errOIDCReplay
+	}
+	return nil
+}
+
+func oidcTokenNonce(id string) string {
+	return "oidc:" + id
+}
+
+// parseOIDCRules parses OIDC rules data into a list of rules, in order.
+//
+// Each non-blank line that does not start with `#` holds a name, a comma-separated list
+// of actions (deploy, status, list, override), and one or more `claim=pattern` conditions,
+// separated by whitespace. Names must be valid key IDs and unique.
+func parseOIDCRules(data string) ([]oidcRule, error) {
+	var rules []oidcRule
+	names := make(map[string]bool)
+
+	for number, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < minOIDCRuleFields {
+			return nil, fmt.Errorf("line %d: %w", number+1, errOIDCRuleLine)
+		}
+
+		rule, err := parseOIDCRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+
+		if names[rule.name] {
+			return nil, fmt.Errorf("line %d: %w: %q", number+1, errOIDCRuleDup, rule.name)
+		}
+		names[rule.name] = true
+		rules = append(rules, rule)
+	}
+
+	if len(rules) == 0 {
+		return nil, errOIDCRulesEmpty
+	}
+	return rules, nil
+}
+
+func parseOIDCRule(fields []string) (oidcRule, error) {
+	if err := dchook.ValidateKeyID(fields[0]); err != nil {
+		return oidcRule{}, err
+	}
+
+	rule := oidcRule{
+		name:    fields[0],
+		actions: make(map[string]bool),
+		claims:  make(map[string]string),
+	}
+
+	for action := range strings.SplitSeq(fields[1], ",") {
+		switch action {
+		case actionDeploy, actionStatus, actionList, actionOverride:
+			rule.actions[action] = true
+		default:
+			return oidcRule{}, fmt.Errorf("%w: %q", errUnknownAction, action)
+		}
+	}
+
+	for _, condition := range fields[2:] {
+		claim, pattern, found := strings.Cut(condition, "=")
+		if !found || claim == "" || pattern == "" {
+			return oidcRule{}, fmt.Errorf("%w: %q", errOIDCRuleClaim, condition)
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return oidcRule{}, fmt.Errorf("%w: %q: %w", errOIDCRuleClaim, condition, err)
+		}
+		rule.claims[claim] = pattern
+	}
+	return rule, nil
+}
+
+// loadOIDCConfig reads the OIDC configuration. OIDC is disabled unless a JWKS file is
+// configured; the issuer, audience, and rules file are then required.
+func loadOIDCConfig() (*oidcConfig, error) {
+	//nolint:errcheck // Optional
+	jwksFilePath, _ := dchook.FlagValue(*oidcJWKSFile, "DCHOOK_OIDC_JWKS_FILE", "--oidc-jwks")
+	if jwksFilePath == "" {
+		return nil, nil //nolint:nilnil // Not configured
+	}
+
+	jwks, err := dchook.ReadJWKSFileStrict(jwksFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+
+	//nolint:errcheck // Checked below
+	issuer, _ := dchook.FlagValue(*oidcIssuer, "DCHOOK_OIDC_ISSUER", "--oidc-issuer")
+	if issuer == "" {
+		return nil, errOIDCIssuer
+	}
+
+	//nolint:errcheck // Checked below
+	audience, _ := dchook.FlagValue(*oidcAudience, "DCHOOK_OIDC_AUDIENCE", "--oidc-audience")
+	if audience == "" {
+		return nil, errOIDCAudience
+	}
+
+	//nolint:errcheck // Checked below
+	rulesFilePath, _ := dchook.FlagValue(
+		*oidcRulesFile,
+		"DCHOOK_OIDC_RULES_FILE",
+		"--oidc-rules",
+	)
+	if rulesFilePath == "" {
+		return nil, errOIDCRulesMissing
+	}
+
+	data, err := dchook.ReadSecretFileStrict(rulesFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OIDC rules: %w", err)
+	}
+
+	rules, err := parseOIDCRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC rules file %q: %w", rulesFilePath, err)
+	}
+
+	return &oidcConfig{jwks: jwks, issuer: issuer, audience: audience, rules: rules}, nil
+}
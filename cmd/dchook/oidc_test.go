@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const testOIDCIssuer = "https://token.actions.githubusercontent.com"
+
+func TestParseOIDCRules(t *testing.T) {
+	t.Parallel()
+
+	rules, err := parseOIDCRules(`
+# name actions claim=pattern...
+release deploy,status repository=example/app ref=refs/tags/v*
+staging deploy        repository=example/app environment=staging
+`)
+	if err != nil {
+		t.Fatalf("parseOIDCRules() error = %v", err)
+	}
+
+	if len(rules) != 2 || rules[0].name != "release" || !rules[0].actions[actionStatus] ||
+		rules[0].claims["ref"] != "refs/tags/v*" || rules[1].actions[actionList] {
+		t.Errorf("parseOIDCRules() = %+v", rules)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{"empty", "# nothing\n", errOIDCRulesEmpty},
+		{"missing claims", "release deploy", errOIDCRuleLine},
+		{"duplicate", "a deploy sub=x\na status sub=y", errOIDCRuleDup},
+		{"invalid name", "a/b deploy sub=x", dchook.ErrInvalidKeyID},
+		{"unknown action", "a deploy,restart sub=x", errUnknownAction},
+		{"not a claim", "a deploy repository", errOIDCRuleClaim},
+		{"empty pattern", "a deploy repository=", errOIDCRuleClaim},
+		{"invalid pattern", "a deploy ref=refs/[", errOIDCRuleClaim},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := parseOIDCRules(testCase.data); !errors.Is(err, testCase.wantErr) {
+				t.Errorf("parseOIDCRules() error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+// newOIDCTestConfig returns an OIDC configuration for the rules and a function that
+// issues tokens for it with the claims and default issuer, audience, and expiry.
+func newOIDCTestConfig(t *testing.T, rules string) (*oidcConfig, func(map[string]any) string) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoding := base64.RawURLEncoding
+	jwks, err := dchook.ParseJWKS([]byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1",` +
+		`"x":"` + encoding.EncodeToString(publicKey) + `"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsedRules, err := parseOIDCRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(claims map[string]any) string {
+		token := map[string]any{
+			"iss": testOIDCIssuer,
+			"aud": "dchook",
+			"exp": time.Now().Add(5 * time.Minute).Unix(),
+		}
+		for name, value := range claims {
+			token[name] = value
+		}
+
+		payload, err := json.Marshal(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed := encoding.EncodeToString([]byte(`{"alg":"EdDSA","kid":"k1"}`)) + "." +
+			encoding.EncodeToString(payload)
+		return signed + "." +
+			encoding.EncodeToString(ed25519.Sign(privateKey, []byte(signed)))
+	}
+
+	return &oidcConfig{
+		jwks:     jwks,
+		issuer:   testOIDCIssuer,
+		audience: "dchook",
+		rules:    parsedRules,
+	}, issue
+}
+
+func TestHandlerOIDC(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oidc, issue := newOIDCTestConfig(t, `
+release deploy,status,list repository=example/app ref=refs/tags/v*
+preview status,list        repository=example/app
+`)
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		version:           "v1.0.0",
+		commit:            "abc",
+		secret:            "secret",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		oidc:              oidc,
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+	}
+	store := NewConfigStore(cfg, nil)
+	limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+	deployHandler := createDeployHandler(store, limiter)
+	statusHandler := createStatusHandler(store, limiter)
+
+	deploy := func(token string) int {
+		body := []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+			strconv.FormatInt(time.Now().UnixMicro(), 10) + `"},"payload":{}}`)
+		req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		deployHandler(w, req)
+		return w.Code
+	}
+
+	list := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/deploy/status/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		statusHandler(w, req)
+		return w.Code
+	}
+
+	release := issue(map[string]any{
+		"repository": "example/app",
+		"ref":        "refs/tags/v1.2.3",
+		"jti":        "release-1",
+	})
+	preview := issue(map[string]any{"repository": "example/app", "ref": "refs/heads/main"})
+	anonymous := issue(map[string]any{"repository": "example/app", "ref": "refs/tags/v1.2.4"})
+	other := issue(map[string]any{"repository": "example/other", "ref": "refs/tags/v1.2.3"})
+	expired := issue(map[string]any{
+		"repository": "example/app",
+		"ref":        "refs/tags/v1.2.3",
+		"exp":        time.Now().Add(-time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name    string
+		request func(string) int
+		token   string
+		want    int
+	}{
+		{"deploy", deploy, release, dchook.DeployAcceptedStatus},
+		{"replayed deploy", deploy, release, http.StatusUnauthorized},
+		{"deploy without jti", deploy, anonymous, http.StatusUnauthorized},
+		{"status-only deploy", deploy, preview, http.StatusForbidden},
+		{"unmatched deploy", deploy, other, http.StatusForbidden},
+		{"expired deploy", deploy, expired, http.StatusUnauthorized},
+		{"invalid token", deploy, "not-a-token", http.StatusUnauthorized},
+		{"status-only list", list, preview, http.StatusOK},
+		{"unmatched list", list, other, http.StatusForbidden},
+	}
+
+	// Not parallel: the replayed deploy depends on the first deploy.
+	for _, testCase := range tests {
+		if code := testCase.request(testCase.token); code != testCase.want {
+			t.Errorf("%s status = %d, want %d", testCase.name, code, testCase.want)
+		}
+	}
+
+	deployments := cfg.history.List()
+	if len(deployments) == 0 || deployments[0].Client != "release" {
+		t.Errorf("deployments = %+v, want client release", deployments)
+	}
+}
+
+func TestHandlerOIDCRetry(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oidc, issue := newOIDCTestConfig(t, "release deploy repository=example/app")
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		version:           "v1.0.0",
+		commit:            "abc",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		oidc:              oidc,
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+	}
+	// One deployment per window.
+	limiter := dchook.NewRateLimiter(1, 50*time.Millisecond, 10, time.Hour, time.Hour)
+	handler := createDeployHandler(NewConfigStore(cfg, nil), limiter)
+
+	deploy := func(token string) int {
+		body := []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+			strconv.FormatInt(time.Now().UnixMicro(), 10) + `"},"payload":{}}`)
+		req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	first := issue(map[string]any{"repository": "example/app", "jti": "first"})
+	retried := issue(map[string]any{"repository": "example/app", "jti": "retried"})
+
+	if code := deploy(first); code != dchook.DeployAcceptedStatus {
+		t.Fatalf("first deploy status = %d, want %d", code, dchook.DeployAcceptedStatus)
+	}
+	if code := deploy(retried); code != http.StatusTooManyRequests {
+		t.Fatalf("rate limited deploy status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// The rate limited request did not use up its token.
+	time.Sleep(60 * time.Millisecond)
+	if code := deploy(retried); code != dchook.DeployAcceptedStatus {
+		t.Errorf("retried deploy status = %d, want %d", code, dchook.DeployAcceptedStatus)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if code := deploy(retried); code != http.StatusUnauthorized {
+		t.Errorf("replayed deploy status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
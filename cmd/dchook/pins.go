@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	errImagePinning       = errors.New("image pinning is not enabled")
+	errImageNotAllowed    = errors.New("image repository is not allowed for service")
+	errImageDigest        = errors.New("image must be repository@sha256:<digest>")
+	errImageNotSelected   = errors.New("pinned service is not selected")
+	errImageRepositories  = errors.New("image repositories must be service=repository")
+	errImageRepositoryRef = errors.New("invalid image repository")
+
+	// imageDigestPattern matches a SHA-256 image digest.
+	imageDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+	// imageRepositoryPattern matches an image repository with an optional registry
+	// host and port, without a tag or digest.
+	imageRepositoryPattern = regexp.MustCompile(
+		`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-]+[a-z0-9]+)*)*$`,
+	)
+	// composeServicePattern matches a Docker Compose service name.
+	composeServicePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+)
+
+// parseImageRepositories parses comma-separated `service=repository` pairs into a map
+// of service to the repositories that its image may be pinned to. A service may be
+// listed more than once.
+func parseImageRepositories(value string) (map[string][]string, error) {
+	repositories := make(map[string][]string)
+
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		svc, repository, found := strings.Cut(entry, "=")
+		if !found || !composeServicePattern.MatchString(svc) {
+			return nil, fmt.Errorf("%w: %q", errImageRepositories, entry)
+		}
+
+		if !imageRepositoryPattern.MatchString(repository) {
+			return nil, fmt.Errorf("%w: %q", errImageRepositoryRef, repository)
+		}
+
+		repositories[svc] = append(repositories[svc], repository)
+	}
+
+	if len(repositories) == 0 {
+		return nil, nil
+	}
+	return repositories, nil
+}
+
+// pinImages validates the images that a deploy request pins services to: each image
+// must be `repository@sha256:<digest>` with a repository allowed for the service, and,
+// if services are selected, each service must be selected. checkDeployed checks that the
+// services are deployed. Returns a copy of the pins, or nil if no images are pinned.
+func (cfg *HandlerConfig) pinImages(
+	images map[string]string,
+	services []string,
+) (map[string]string, error) {
+	if len(images) == 0 {
+		return nil, nil
+	}
+
+	if len(cfg.imageRepositories) == 0 {
+		return nil, errImagePinning
+	}
+
+	for _, svc := range slices.Sorted(maps.Keys(images)) {
+		repository, digest, found := strings.Cut(images[svc], "@")
+		if !found || !imageDigestPattern.MatchString(digest) {
+			return nil, fmt.Errorf("%w: %q", errImageDigest, images[svc])
+		}
+
+		if !slices.Contains(cfg.imageRepositories[svc], repository) {
+			return nil, fmt.Errorf("%w: %q: %q", errImageNotAllowed, svc, repository)
+		}
+
+		if len(services) > 0 && !slices.Contains(services, svc) {
+			return nil, fmt.Errorf("%w: %q", errImageNotSelected, svc)
+		}
+	}
+
+	return maps.Clone(images), nil
+}
+
+// writeImageOverride writes a compose override file that sets the image of each pinned
+// service. Returns the path of the file, which the caller must remove.
+func writeImageOverride(deploymentID string, images map[string]string) (string, error) {
+	var override strings.Builder
+	override.WriteString("# Generated by dchook for deployment " + deploymentID + "\n")
+	override.WriteString("services:\n")
+	for _, svc := range slices.Sorted(maps.Keys(images)) {
+		override.WriteString("  " + strconv.Quote(svc) + ":\n")
+		override.WriteString("    image: " + strconv.Quote(images[svc]) + "\n")
+	}
+
+	return writeDeploymentFile("dchook-override-*.yml", override.String())
+}
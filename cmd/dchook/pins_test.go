@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseImageRepositories(t *testing.T) {
+	t.Parallel()
+
+	repositories, err := parseImageRepositories(
+		"web=ghcr.io/org/web, web=registry.example.com:5000/web,worker=org/worker",
+	)
+	if err != nil {
+		t.Fatalf("parseImageRepositories() error = %v", err)
+	}
+
+	want := map[string][]string{
+		"web":    {"ghcr.io/org/web", "registry.example.com:5000/web"},
+		"worker": {"org/worker"},
+	}
+	if !maps.EqualFunc(repositories, want, slices.Equal) {
+		t.Errorf("parseImageRepositories() = %v, want %v", repositories, want)
+	}
+
+	if repositories, err := parseImageRepositories(" , "); repositories != nil || err != nil {
+		t.Errorf("parseImageRepositories(empty) = %v, %v, want nil", repositories, err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  error
+	}{
+		{"missing repository", "web", errImageRepositories},
+		{"invalid service", "we b=org/web", errImageRepositories},
+		{"tag", "web=org/web:latest", errImageRepositoryRef},
+		{"digest", "web=org/web@" + testDigest, errImageRepositoryRef},
+		{"uppercase", "web=Org/Web", errImageRepositoryRef},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := parseImageRepositories(testCase.value); !errors.Is(err, testCase.want) {
+				t.Errorf("parseImageRepositories() error = %v, want %v", err, testCase.want)
+			}
+		})
+	}
+}
+
+func TestPinImages(t *testing.T) {
+	t.Parallel()
+
+	repositories := map[string][]string{
+		"web":  {"ghcr.io/org/web"},
+		"cron": {"ghcr.io/org/cron"},
+	}
+
+	tests := []struct {
+		name         string
+		repositories map[string][]string
+		images       map[string]string
+		services     []string
+		wantErr      error
+	}{
+		{"no pins", nil, nil, nil, nil},
+		{
+			"pinning disabled",
+			nil,
+			map[string]string{"web": "ghcr.io/org/web@" + testDigest},
+			nil,
+			errImagePinning,
+		},
+		{
+			"pinned",
+			repositories,
+			map[string]string{"web": "ghcr.io/org/web@" + testDigest},
+			[]string{"web"},
+			nil,
+		},
+		{
+			"tag",
+			repositories,
+			map[string]string{"web": "ghcr.io/org/web:latest"},
+			nil,
+			errImageDigest,
+		},
+		{
+			"short digest",
+			repositories,
+			map[string]string{"web": "ghcr.io/org/web@sha256:0123"},
+			nil,
+			errImageDigest,
+		},
+		{
+			"other repository",
+			repositories,
+			map[string]string{"web": "ghcr.io/evil/web@" + testDigest},
+			nil,
+			errImageNotAllowed,
+		},
+		{
+			"service without repositories",
+			repositories,
+			map[string]string{"db": "ghcr.io/org/web@" + testDigest},
+			nil,
+			errImageNotAllowed,
+		},
+		{
+			"not selected",
+			repositories,
+			map[string]string{"web": "ghcr.io/org/web@" + testDigest},
+			[]string{"worker"},
+			errImageNotSelected,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &HandlerConfig{imageRepositories: testCase.repositories}
+
+			images, err := cfg.pinImages(testCase.images, testCase.services)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("pinImages() error = %v, want %v", err, testCase.wantErr)
+			}
+			if err == nil && !maps.Equal(images, testCase.images) {
+				t.Errorf("pinImages() = %v, want %v", images, testCase.images)
+			}
+		})
+	}
+}
+
+func TestWriteImageOverride(t *testing.T) {
+	t.Parallel()
+
+	path, err := writeImageOverride("abc123", map[string]string{
+		"worker": "ghcr.io/org/worker@" + testDigest,
+		"web":    "ghcr.io/org/web@" + testDigest,
+	})
+	if err != nil {
+		t.Fatalf("writeImageOverride() error = %v", err)
+	}
+	defer os.Remove(path) //nolint:errcheck // Test cleanup
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# Generated by dchook for deployment abc123
+services:
+  "web":
+    image: "ghcr.io/org/web@` + testDigest + `"
+  "worker":
+    image: "ghcr.io/org/worker@` + testDigest + `"
+`
+	if string(data) != want {
+		t.Errorf("override =\n%s\nwant\n%s", data, want)
+	}
+
+	adapter := &DockerComposeAdapter{
+		ComposeFile: "/opt/app/compose.yml",
+		ProjectName: "app",
+	}
+	pinned, cleanup, err := adapter.forDeployment(&Deployment{
+		ID:     "abc123",
+		Images: map[string]string{"web": "ghcr.io/org/web@" + testDigest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	command := pinned.formatCommand("pull", "web")
+	wantCommand := "docker compose -f /opt/app/compose.yml -f " + pinned.OverrideFile +
+		" -p app pull web"
+	if command != wantCommand {
+		t.Errorf("formatCommand() = %q, want %q", command, wantCommand)
+	}
+	if adapter.OverrideFile != "" {
+		t.Error("forDeployment() should not change the adapter")
+	}
+
+	cleanup()
+	if _, err := os.Stat(pinned.OverrideFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("cleanup() should remove the override file: %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	projectOptionProject    = "project"
+	projectOptionExcept     = "except"
+	projectOptionSecret     = "secret"
+	projectOptionPublicKey  = "public-key"
+	projectOptionServices   = "services"
+	projectOptionEnvFiles   = "env-files"
+	projectOptionProfiles   = "compose-profiles"
+	projectOptionAllowed    = "profiles"
+	projectOptionImages     = "images"
+	projectOptionPayloadEnv = "payload-env"
+	projectOptionGitRef     = "git-ref"
+	projectOptionGitRefs    = "git-refs"
+	projectOptionGitRemote  = "git-remote"
+	projectOptionSchedule   = "schedule"
+
+	minProjectFields = 2
+
+	// projectStatusRoute separates the project name from the deployment ID in
+	// `/deploy/{project}/status/{id}`.
+	projectStatusRoute = "status/"
+)
+
+var (
+	errProjectLine      = errors.New("project line must be \"name compose-file [option=value...]\"")
+	errProjectDuplicate = errors.New("duplicate project name")
+	errProjectReserved  = errors.New("project name is a reserved route")
+	errProjectOption    = errors.New("unknown project option")
+	errProjectsEmpty    = errors.New("projects file contains no projects")
+	errEnvelopeProject  = errors.New("envelope project does not match the request path")
+)
+
+// project is a named compose project managed alongside the default project, with its
+// own compose adapter and deployment history. Requests for the project are signed with
+// its secret or public key, if set, instead of the default secret or public key.
+type project struct {
+	name string
+	// composeFiles are the comma-separated compose file and overlay files.
+	composeFiles   string
+	composeProject string
+	exceptServices []string
+	// envFiles are the comma-separated env files.
+	envFiles string
+	// composeProfiles are the comma-separated profiles enabled for every deployment.
+	composeProfiles string
+	// allowedServices are the services that deploy requests may select.
+	allowedServices []string
+	// allowedProfiles are the profiles that deploy requests may enable.
+	allowedProfiles []string
+	// imageRepositories are the repositories that deploy requests may pin images to.
+	imageRepositories map[string][]string
+	payloadEnvFile    string
+	gitRef            string
+	gitRefs           string
+	gitRemote         string
+	scheduleFile      string
+	secretFile        string
+	publicKeyFile     string
+	// adapter is created by load from the compose settings.
+	adapter    ContainerAdapter
+	secret     string
+	publicKey  ed25519.PublicKey
+	payloadEnv []payloadVariable
+	git        *gitSource
+	schedule   *deploymentSchedule
+	history    *DeploymentHistory
+}
+
+// forProject returns the configuration for requests to the named project: a copy with
+// the adapter, git source, history, allowed services and profiles, image repositories,
+// payload environment, and default credentials and schedule of the project. A project
+// with its own secret or public key accepts only those credentials: the key set,
+// clients, and OIDC rules of the listener are not used for it. The default project (an
+// empty name) uses the configuration itself. Returns false for unknown projects.
+func (cfg *HandlerConfig) forProject(name string) (*HandlerConfig, bool) {
+	if name == "" {
+		return cfg, true
+	}
+
+	p, found := cfg.projects[name]
+	if !found {
+		return nil, false
+	}
+
+	projectCfg := *cfg
+	projectCfg.project = name
+	projectCfg.adapter = p.adapter
+	projectCfg.history = p.history
+	projectCfg.allowedServices = p.allowedServices
+	projectCfg.allowedProfiles = p.allowedProfiles
+	projectCfg.imageRepositories = p.imageRepositories
+	projectCfg.payloadEnv = p.payloadEnv
+	projectCfg.git = p.git
+	if p.secret != "" || p.publicKey != nil {
+		projectCfg.secret, projectCfg.publicKey = p.secret, p.publicKey
+		projectCfg.secrets, projectCfg.clients, projectCfg.oidc = nil, nil, nil
+	}
+	if p.schedule != nil {
+		projectCfg.schedule = p.schedule
+	}
+	return &projectCfg, true
+}
+
+// findDeployment looks for a deployment in the history of the default project and of
+// each project.
+func (cfg *HandlerConfig) findDeployment(id string) (Deployment, bool) {
+	if deployment, found := cfg.history.Get(id); found {
+		return deployment, true
+	}
+
+	for _, p := range cfg.projects {
+		if deployment, found := p.history.Get(id); found {
+			return deployment, true
+		}
+	}
+	return Deployment{}, false
+}
+
+// createProjectHandler routes `/deploy/{project}` to the deploy handler and
+// `/deploy/{project}/status/` and `/deploy/{project}/status/{id}` to the status handler,
+// with the project name and deployment ID as the `project` and `id` path values.
+func createProjectHandler(deploy, status http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, rest, nested := strings.Cut(strings.TrimPrefix(r.URL.Path, "/deploy/"), "/")
+		if name == "" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		r.SetPathValue("project", name)
+		if !nested {
+			deploy(w, r)
+			return
+		}
+
+		id, found := strings.CutPrefix(rest, projectStatusRoute)
+		if !found {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		r.SetPathValue("id", id)
+		status(w, r)
+	}
+}
+
+// parseProjects parses projects file data into a map of project name to project.
+//
+// Each non-blank line that does not start with `#` holds a name, the compose file (or
+// comma-separated compose file and overlay files), and optional `option=value` settings,
+// separated by whitespace: `project` (the compose project name, default: the name),
+// `except` (comma-separated services to exclude from updates), `env-files`
+// (comma-separated env files), `compose-profiles` (comma-separated profiles enabled for
+// every deployment), `services` and `profiles` (comma-separated services and profiles
+// that deploy requests may select, or `*` for any), `images` (comma-separated
+// `service=repository` pairs that deploy requests may pin images to), `payload-env` (the
+// payload environment file), `git-ref`, `git-refs`, and `git-remote` (the git source of
+// the compose file directory), `schedule` (the deployment schedule file), `secret` (the
+// secret file), and `public-key` (the Ed25519 public key file).
+// Names must be valid compose project names, unique, and not a reserved route under
+// `/deploy/`.
+func parseProjects(data string) (map[string]*project, error) {
+	projects := make(map[string]*project)
+
+	for number, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < minProjectFields {
+			return nil, fmt.Errorf("line %d: %w", number+1, errProjectLine)
+		}
+
+		p, err := parseProject(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+
+		if _, exists := projects[p.name]; exists {
+			return nil, fmt.Errorf("line %d: %w: %q", number+1, errProjectDuplicate, p.name)
+		}
+		projects[p.name] = p
+	}
+
+	if len(projects) == 0 {
+		return nil, errProjectsEmpty
+	}
+	return projects, nil
+}
+
+func parseProject(fields []string) (*project, error) {
+	name := fields[0]
+	if err := validateProjectName(name); err != nil {
+		return nil, err
+	}
+
+	if isReservedRoute(name) {
+		return nil, fmt.Errorf("%w: %q", errProjectReserved, name)
+	}
+
+	p := &project{
+		name:           name,
+		composeFiles:   fields[1],
+		composeProject: name,
+	}
+
+	for _, field := range fields[minProjectFields:] {
+		option, value, found := strings.Cut(field, "=")
+		if !found || value == "" {
+			return nil, fmt.Errorf("%w: %q", errProjectLine, field)
+		}
+
+		switch option {
+		case projectOptionProject:
+			if err := validateProjectName(value); err != nil {
+				return nil, err
+			}
+			p.composeProject = value
+		case projectOptionExcept:
+			p.exceptServices = splitList(value)
+		case projectOptionEnvFiles:
+			p.envFiles = value
+		case projectOptionProfiles:
+			p.composeProfiles = value
+		case projectOptionServices:
+			p.allowedServices = splitList(value)
+		case projectOptionAllowed:
+			profiles, err := parseProfiles(value)
+			if err != nil {
+				return nil, err
+			}
+			p.allowedProfiles = profiles
+		case projectOptionImages:
+			repositories, err := parseImageRepositories(value)
+			if err != nil {
+				return nil, err
+			}
+			p.imageRepositories = repositories
+		case projectOptionPayloadEnv:
+			p.payloadEnvFile = value
+		case projectOptionGitRef:
+			p.gitRef = value
+		case projectOptionGitRefs:
+			p.gitRefs = value
+		case projectOptionGitRemote:
+			p.gitRemote = value
+		case projectOptionSchedule:
+			p.scheduleFile = value
+		case projectOptionSecret:
+			p.secretFile = value
+		case projectOptionPublicKey:
+			p.publicKeyFile = value
+		default:
+			return nil, fmt.Errorf("%w: %q", errProjectOption, option)
+		}
+	}
+	return p, nil
+}
+
+// isReservedRoute checks if the name is a route under `/deploy/` other than a project:
+// the status endpoints, the registry receiver, or a forge receiver.
+func isReservedRoute(name string) bool {
+	if name == "status" || name == "registry" {
+		return true
+	}
+
+	return slices.ContainsFunc(webhookForges(), func(forge forgeFlags) bool {
+		return forge.receiver.name == name
+	})
+}
+
+// readProjects reads the projects file, validating each compose file and reading each
+// secret and public key file. Returns nil if no projects file is configured.
+func readProjects() (map[string]*project, error) {
+	//nolint:errcheck // Optional
+	projectsFilePath, _ := dchook.FlagValue(*projectsFile, "DCHOOK_PROJECTS_FILE", "--projects")
+	if projectsFilePath == "" {
+		return nil, nil //nolint:nilnil // Not configured
+	}
+
+	data, err := dchook.ReadSecretFileStrict(projectsFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read projects: %w", err)
+	}
+
+	projects, err := parseProjects(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid projects file %q: %w", projectsFilePath, err)
+	}
+
+	for _, p := range projects {
+		if err := p.load(); err != nil {
+			return nil, fmt.Errorf("project %q: %w", p.name, err)
+		}
+	}
+	return projects, nil
+}
+
+// load validates the compose and env files, profiles, and git source, reads the secret,
+// public key, payload environment, and schedule files, and creates the compose adapter
+// of the project.
+func (p *project) load() error {
+	adapter, err := newComposeAdapter(p.composeFiles, p.envFiles, p.composeProfiles)
+	if err != nil {
+		return err
+	}
+
+	if p.secretFile != "" {
+		p.secret, err = dchook.ReadSecretFileStrict(p.secretFile)
+		if err != nil {
+			return fmt.Errorf("failed to read secret: %w", err)
+		}
+
+		if p.secret == "" {
+			return fmt.Errorf("%w: %q", errSecretEmpty, p.secretFile)
+		}
+	}
+
+	if p.publicKeyFile != "" {
+		p.publicKey, err = dchook.ReadPublicKeyFileStrict(p.publicKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read public key: %w", err)
+		}
+	}
+
+	if p.payloadEnv, err = readPayloadEnv(p.payloadEnvFile); err != nil {
+		return err
+	}
+
+	if p.schedule, err = readSchedule(p.scheduleFile); err != nil {
+		return err
+	}
+
+	p.git, err = loadGitSource(adapter.ComposeFile, p.gitRemote, p.gitRef, p.gitRefs)
+	if err != nil {
+		return fmt.Errorf("invalid git source: %w", err)
+	}
+
+	adapter.ProjectName = p.composeProject
+	adapter.ExceptServices = p.exceptServices
+	adapter.Git = p.git
+	p.adapter = adapter
+	return nil
+}
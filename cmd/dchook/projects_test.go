@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestParseProjects(t *testing.T) {
+	t.Parallel()
+
+	projects, err := parseProjects(`# name  compose-file  [option=value...]
+shop  /opt/shop/compose.yml  project=shop-prod except=db,cache secret=/etc/dchook/shop
+blog  /opt/blog/compose.yml  public-key=/etc/dchook/blog.pub services=web,worker
+wiki  /opt/wiki/compose.yml,/opt/wiki/prod.yml  env-files=/opt/wiki/.env profiles=worker,debug
+`)
+	if err != nil {
+		t.Fatalf("parseProjects() error = %v", err)
+	}
+
+	shop, blog, wiki := projects["shop"], projects["blog"], projects["wiki"]
+	if shop == nil || blog == nil || wiki == nil || len(projects) != 3 {
+		t.Fatalf("projects = %v, want shop, blog, and wiki", projects)
+	}
+	if shop.composeFiles != "/opt/shop/compose.yml" || shop.composeProject != "shop-prod" ||
+		!slices.Equal(shop.exceptServices, []string{"db", "cache"}) ||
+		shop.secretFile != "/etc/dchook/shop" {
+		t.Errorf("shop = %+v", shop)
+	}
+	if blog.composeProject != "blog" || blog.publicKeyFile != "/etc/dchook/blog.pub" ||
+		!slices.Equal(blog.allowedServices, []string{"web", "worker"}) {
+		t.Errorf("blog = %+v", blog)
+	}
+	if wiki.composeFiles != "/opt/wiki/compose.yml,/opt/wiki/prod.yml" ||
+		wiki.envFiles != "/opt/wiki/.env" ||
+		!slices.Equal(wiki.allowedProfiles, []string{"worker", "debug"}) {
+		t.Errorf("wiki = %+v", wiki)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"empty", "# no projects\n", errProjectsEmpty},
+		{"missing compose file", "shop\n", errProjectLine},
+		{"duplicate", "shop /a.yml\nshop /b.yml\n", errProjectDuplicate},
+		{"status route", "status /a.yml\n", errProjectReserved},
+		{"forge route", "github /a.yml\n", errProjectReserved},
+		{"registry route", "registry /a.yml\n", errProjectReserved},
+		{"invalid name", "Shop /a.yml\n", errProjectInvalidStart},
+		{"invalid compose project", "shop /a.yml project=a.b\n", errProjectInvalidChar},
+		{"unknown option", "shop /a.yml profile=web\n", errProjectOption},
+		{"invalid profile", "shop /a.yml profiles=-web\n", errProfileName},
+		{"option without value", "shop /a.yml secret=\n", errProjectLine},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := parseProjects(testCase.data); !errors.Is(err, testCase.want) {
+				t.Errorf("parseProjects() error = %v, want %v", err, testCase.want)
+			}
+		})
+	}
+}
+
+func TestProjectRoutes(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shop := &project{
+		name:    "shop",
+		secret:  "shop-secret",
+		adapter: &MockAdapter{},
+		history: NewDeploymentHistory(),
+	}
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		version:           "v1.0.0",
+		commit:            "abc",
+		secret:            "default-secret",
+		secrets:           map[string]string{"2026-10": "rotated-secret"},
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+		projects:          map[string]*project{"shop": shop},
+	}
+	store := NewConfigStore(cfg, nil)
+	limiter := dchook.NewRateLimiter(100, time.Minute, 100, time.Hour, time.Hour)
+
+	statusHandler := createStatusHandler(store, limiter)
+	deployHandler := createDeployHandler(store, limiter)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/deploy/status/", statusHandler)
+	mux.HandleFunc("/deploy", deployHandler)
+	mux.HandleFunc("/deploy/", createProjectHandler(deployHandler, statusHandler))
+
+	timestamp := time.Now().UnixMicro()
+	deploy := func(path, envelopeProject, secret, keyID string) *httptest.ResponseRecorder {
+		timestamp++
+		body := []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+			strconv.FormatInt(timestamp, 10) + `","project":"` + envelopeProject +
+			`"},"payload":{}}`)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Dchook-Signature", dchook.FormatSignatureKeyID(
+			dchook.GenerateSignature(body, secret, dchook.AlgorithmSHA256),
+			keyID,
+		))
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	status := func(path, deploymentID, secret string) int {
+		timestamp++
+		ts := strconv.FormatInt(timestamp, 10)
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Dchook-Timestamp", ts)
+		req.Header.Set("X-Dchook-Signature", dchook.GenerateSignature(
+			[]byte(ts+":"+deploymentID),
+			secret,
+			dchook.AlgorithmSHA256,
+		))
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	accepted := deploy("/deploy/shop", "shop", "shop-secret", "")
+	if accepted.Code != dchook.DeployAcceptedStatus {
+		t.Fatalf("project deploy status = %d, want %d", accepted.Code, dchook.DeployAcceptedStatus)
+	}
+
+	var response map[string]string
+	if err := json.Unmarshal(accepted.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	deploymentID := response["deployment_id"]
+
+	if _, found := shop.history.Get(deploymentID); !found {
+		t.Error("project deployment should be in the project history")
+	}
+	if _, found := cfg.history.Get(deploymentID); found {
+		t.Error("project deployment should not be in the default history")
+	}
+	if _, found := cfg.findDeployment(deploymentID); !found {
+		t.Error("findDeployment() should find the project deployment")
+	}
+
+	for _, testCase := range []struct {
+		name            string
+		path            string
+		envelopeProject string
+		secret          string
+		keyID           string
+		want            int
+	}{
+		{"default secret for project", "/deploy/shop", "shop", "default-secret", "", 401},
+		{"key set for project", "/deploy/shop", "shop", "rotated-secret", "2026-10", 401},
+		{"project envelope mismatch", "/deploy/shop", "", "shop-secret", "", 400},
+		{"project envelope on default", "/deploy", "shop", "default-secret", "", 400},
+		{"unknown project", "/deploy/blog", "blog", "default-secret", "", 404},
+		{"default project", "/deploy", "", "default-secret", "", dchook.DeployAcceptedStatus},
+		{"key set for default", "/deploy", "", "rotated-secret", "2026-10", 202},
+	} {
+		if code := deploy(
+			testCase.path,
+			testCase.envelopeProject,
+			testCase.secret,
+			testCase.keyID,
+		).Code; code != testCase.want {
+			t.Errorf("%s status = %d, want %d", testCase.name, code, testCase.want)
+		}
+	}
+
+	for _, testCase := range []struct {
+		name   string
+		path   string
+		secret string
+		want   int
+	}{
+		{"project status", "/deploy/shop/status/" + deploymentID, "shop-secret", 200},
+		{"default status", "/deploy/status/" + deploymentID, "default-secret", 404},
+		{"project route", "/deploy/shop/other/" + deploymentID, "shop-secret", 404},
+	} {
+		if code := status(testCase.path, deploymentID, testCase.secret); code != testCase.want {
+			t.Errorf("%s status = %d, want %d", testCase.name, code, testCase.want)
+		}
+	}
+}
+
+func TestReloadKeepsProjectHistory(t *testing.T) {
+	t.Parallel()
+
+	history := NewDeploymentHistoryWithSize(3)
+	current := &HandlerConfig{
+		history: history,
+		projects: map[string]*project{
+			"shop": {name: "shop", history: NewDeploymentHistory()},
+		},
+	}
+	shopHistory := current.projects["shop"].history
+
+	store := NewConfigStore(current, func() (*HandlerConfig, error) {
+		return &HandlerConfig{projects: map[string]*project{
+			"shop": {name: "shop"},
+			"blog": {name: "blog"},
+		}}, nil
+	})
+	if err := store.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	next := store.Load()
+	if next.projects["shop"].history != shopHistory {
+		t.Error("reload should keep the history of an existing project")
+	}
+	if blog := next.projects["blog"].history; blog == nil || blog.Size() != 3 {
+		t.Errorf("new project history = %v, want size 3", blog)
+	}
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+var errProxyProtocol = errors.New("invalid DCHOOK_PROXY_PROTOCOL")
+
+// proxyConfig configures how the client IP address is determined behind reverse proxies.
+// Client IP addresses are used for rate limiting, bans, and client CIDR restrictions.
+type proxyConfig struct {
+	trustedProxies []netip.Prefix
+	ipSources      []string
+	proxyProtocol  bool
+}
+
+// loadProxyConfig reads the trusted proxies, the client IP header sources, and whether
+// the listener accepts PROXY protocol headers.
+func loadProxyConfig() (*proxyConfig, error) {
+	//nolint:errcheck // Optional
+	proxies, _ := dchook.FlagValue(*trustedProxies, "DCHOOK_TRUSTED_PROXIES", "--trusted-proxies")
+	trusted, err := dchook.ParseTrustedProxies(proxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+
+	//nolint:errcheck // Optional
+	headers, _ := dchook.FlagValue(*ipHeaders, "DCHOOK_IP_HEADERS", "--ip-headers")
+	sources, err := dchook.ParseIPSources(headers)
+	if err != nil {
+		return nil, fmt.Errorf("client IP headers: %w", err)
+	}
+
+	enabled, err := parseProxyProtocol(*proxyProtocol, dchook.EnvValue("DCHOOK_PROXY_PROTOCOL"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyConfig{trustedProxies: trusted, ipSources: sources, proxyProtocol: enabled}, nil
+}
+
+// parseProxyProtocol returns whether PROXY protocol is enabled by the flag or the
+// environment variable value.
+func parseProxyProtocol(flagValue bool, envValue string) (bool, error) {
+	if flagValue || envValue == "" {
+		return flagValue, nil
+	}
+
+	enabled, err := strconv.ParseBool(envValue)
+	if err != nil {
+		return false, fmt.Errorf("%w: %q", errProxyProtocol, envValue)
+	}
+	return enabled, nil
+}
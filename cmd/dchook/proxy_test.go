@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseProxyProtocol(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		flagValue bool
+		envValue  string
+		want      bool
+		wantErr   error
+	}{
+		{false, "", false, nil},
+		{true, "", true, nil},
+		{true, "false", true, nil},
+		{false, "true", true, nil},
+		{false, "0", false, nil},
+		{false, "haproxy", false, errProxyProtocol},
+	}
+
+	for _, testCase := range tests {
+		got, err := parseProxyProtocol(testCase.flagValue, testCase.envValue)
+		if got != testCase.want || !errors.Is(err, testCase.wantErr) {
+			t.Errorf(
+				"parseProxyProtocol(%v, %q) = %v, %v, want %v, %v",
+				testCase.flagValue,
+				testCase.envValue,
+				got,
+				err,
+				testCase.want,
+				testCase.wantErr,
+			)
+		}
+	}
+}
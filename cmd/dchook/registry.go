@@ -0,0 +1,505 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	registrySourceDistribution = "distribution"
+	registrySourceHarbor       = "harbor"
+	registrySourceQuay         = "quay"
+
+	distributionActionPush = "push"
+	harborEventPush        = "PUSH_ARTIFACT"
+
+	dockerHubHost    = "docker.io"
+	dockerHubLibrary = "library/"
+	defaultImageTag  = "latest"
+)
+
+var (
+	errRegistryPayloadUnknown = errors.New("unrecognized registry notification")
+	errRegistrySecretEmpty    = errors.New("registry secret file is empty")
+)
+
+// imageReference is a parsed container image reference. Docker Hub references are
+// normalized to the docker.io host and library namespace.
+type imageReference struct {
+	host       string
+	repository string
+	tag        string
+	digest     string
+}
+
+// registryPush is an image push reported by a registry notification.
+type registryPush struct {
+	id    string
+	image imageReference
+}
+
+// parseImageReference parses an image reference such as `nginx`,
+// `ghcr.io/org/app:1.2`, or `localhost:5000/app@sha256:...`. References without a tag or
+// digest use the `latest` tag.
+func parseImageReference(value string) imageReference {
+	var image imageReference
+	value, image.digest, _ = strings.Cut(value, "@")
+
+	// A tag follows the last colon after the last slash; earlier colons are ports.
+	if i := strings.LastIndex(value, ":"); i > strings.LastIndex(value, "/") {
+		value, image.tag = value[:i], value[i+1:]
+	}
+
+	host, repository, found := strings.Cut(value, "/")
+	if found && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		image.host, image.repository = normalizeRegistryHost(host), repository
+	} else {
+		image.host, image.repository = dockerHubHost, value
+	}
+
+	if image.host == dockerHubHost && !strings.Contains(image.repository, "/") {
+		image.repository = dockerHubLibrary + image.repository
+	}
+
+	if image.tag == "" && image.digest == "" {
+		image.tag = defaultImageTag
+	}
+	return image
+}
+
+func normalizeRegistryHost(host string) string {
+	host = strings.ToLower(host)
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubHost
+	}
+	return host
+}
+
+// String formats the reference as host/repository[:tag][@digest].
+func (image imageReference) String() string {
+	value := image.host + "/" + image.repository
+	if image.tag != "" {
+		value += ":" + image.tag
+	}
+	if image.digest != "" {
+		value += "@" + image.digest
+	}
+	return value
+}
+
+// matches checks if the pushed image is this image. The registry host is only compared
+// if the notification reports it, and references pinned to a digest only match that
+// digest.
+func (image imageReference) matches(pushed imageReference) bool {
+	if pushed.host != "" && pushed.host != image.host {
+		return false
+	}
+
+	if pushed.repository != image.repository {
+		return false
+	}
+
+	if image.digest != "" {
+		return pushed.digest == image.digest
+	}
+	return pushed.tag == image.tag
+}
+
+// parseRegistryNotification detects the notification format and returns the format and
+// the image pushes it reports. CNCF distribution (registry:2) envelopes may contain
+// several events; only manifest pushes are returned.
+func parseRegistryNotification(body []byte) (string, []registryPush, error) {
+	var payload struct {
+		// CNCF distribution
+		Events []struct {
+			ID     string `json:"id"`
+			Action string `json:"action"`
+			Target struct {
+				Repository string `json:"repository"`
+				Tag        string `json:"tag"`
+				Digest     string `json:"digest"`
+			} `json:"target"`
+			Request struct {
+				Host string `json:"host"`
+			} `json:"request"`
+		} `json:"events"`
+
+		// Harbor
+		Type      string `json:"type"`
+		EventData *struct {
+			Resources []struct {
+				Tag         string `json:"tag"`
+				Digest      string `json:"digest"`
+				ResourceURL string `json:"resource_url"`
+			} `json:"resources"`
+		} `json:"event_data"`
+
+		// Quay
+		DockerURL   string   `json:"docker_url"`
+		UpdatedTags []string `json:"updated_tags"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", nil, err
+	}
+
+	var pushes []registryPush
+	switch {
+	case payload.Events != nil:
+		for _, event := range payload.Events {
+			if event.Action != distributionActionPush ||
+				(event.Target.Tag == "" && event.Target.Digest == "") {
+				continue
+			}
+
+			image := imageReference{
+				repository: event.Target.Repository,
+				tag:        event.Target.Tag,
+				digest:     event.Target.Digest,
+			}
+			if event.Request.Host != "" {
+				image.host = normalizeRegistryHost(event.Request.Host)
+			}
+			pushes = append(pushes, registryPush{id: event.ID, image: image})
+		}
+		return registrySourceDistribution, pushes, nil
+
+	case payload.EventData != nil:
+		if payload.Type != harborEventPush {
+			return registrySourceHarbor, nil, nil
+		}
+
+		for _, resource := range payload.EventData.Resources {
+			image := parseImageReference(resource.ResourceURL)
+			image.tag = resource.Tag
+			image.digest = resource.Digest
+			pushes = append(pushes, registryPush{image: image})
+		}
+		return registrySourceHarbor, pushes, nil
+
+	case payload.UpdatedTags != nil:
+		repository := parseImageReference(payload.DockerURL)
+		for _, tag := range payload.UpdatedTags {
+			image := repository
+			image.tag = tag
+			pushes = append(pushes, registryPush{image: image})
+		}
+		return registrySourceQuay, pushes, nil
+	}
+
+	return "", nil, errRegistryPayloadUnknown
+}
+
+// authenticateRegistry checks the Dchook-Signature HMAC of `timestamp:body` with the
+// timestamp from X-Dchook-Timestamp if present, and otherwise a bearer token or the
+// password of basic authentication (for registries that only support credentials in the
+// notification URL). Returns the signed timestamp, which the caller must check for
+// replays, or zero for credentials.
+func authenticateRegistry(
+	r *http.Request,
+	body []byte,
+	secret string,
+	allowedAlgorithms map[string]bool,
+) (int64, bool) {
+	if signature := r.Header.Get("Dchook-Signature"); signature != "" {
+		timestamp := r.Header.Get("X-Dchook-Timestamp")
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || ts <= 0 {
+			return 0, false
+		}
+
+		payload := append([]byte(timestamp+":"), body...)
+		return ts, dchook.VerifySignature(payload, signature, secret, allowedAlgorithms)
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		_, token, found = r.BasicAuth()
+	}
+	return 0, found && token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// registryAlgorithm returns the algorithm recorded in the audit log for a registry
+// notification: the algorithm of the Dchook-Signature HMAC, or a token for credentials.
+func registryAlgorithm(r *http.Request) string {
+	if signature := r.Header.Get("Dchook-Signature"); signature != "" {
+		return dchook.SignatureAlgorithm(signature)
+	}
+	return auditAlgorithmToken
+}
+
+// matchPushes returns the pushed images that are used in the compose file.
+func matchPushes(pushes []registryPush, composeImages []string) []registryPush {
+	var matched []registryPush
+	for _, push := range pushes {
+		for _, composeImage := range composeImages {
+			if parseImageReference(composeImage).matches(push.image) {
+				matched = append(matched, push)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// readRegistrySecret reads the registry notification secret. Registry notifications are
+// disabled unless a registry secret file is configured. The webhook secret is not used
+// because registries send the secret as a plain token.
+func readRegistrySecret() (string, error) {
+	//nolint:errcheck // Optional
+	secretFilePath, _ := dchook.FlagValue(
+		*registrySecretFile,
+		"DCHOOK_REGISTRY_SECRET_FILE",
+		"--registry-secret",
+	)
+	if secretFilePath == "" {
+		return "", nil
+	}
+
+	secret, err := dchook.ReadSecretFileStrict(secretFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read registry secret: %w", err)
+	}
+
+	if secret == "" {
+		return "", fmt.Errorf("%w: %q", errRegistrySecretEmpty, secretFilePath)
+	}
+	return secret, nil
+}
+
+// createRegistryHandler handles push notifications from a container registry. A
+// deployment is started when a pushed repository and tag matches an image used in the
+// compose file.
+func createRegistryHandler(
+	store *ConfigStore,
+	limiter *dchook.RateLimiter,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := store.Load()
+
+		// Exact path match; not found unless enabled
+		if r.URL.Path != "/deploy/registry" || cfg.registrySecret == "" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		audit, body, ok := cfg.readWebhook(w, r, limiter, endpointRegistry)
+		if !ok {
+			return
+		}
+
+		timestamp, ok := cfg.authenticateNotification(w, r, limiter, &audit, body)
+		if !ok {
+			return
+		}
+
+		source, matched, ok := cfg.matchNotification(w, r, limiter, &audit, body)
+		if !ok {
+			return
+		}
+
+		request, err := json.Marshal(webhookEvent{
+			Source:   source,
+			Event:    eventPush,
+			Delivery: matched[0].id,
+			Payload:  json.RawMessage(body),
+		})
+		if err != nil {
+			slog.Error("failed to encode deployment request", "error", err)
+			cfg.metrics.DeployRequest(endpointRegistry, outcomeError)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		cfg.acceptDeployment(w, r, limiter, &deployRequest{
+			endpoint: endpointRegistry,
+			request:  request,
+			audit:    audit,
+			claim: func() bool {
+				return cfg.claimPushes(w, limiter, audit, timestamp, matched)
+			},
+			logArgs: func(deployOptions) []any {
+				return []any{
+					"source",
+					source,
+					"image",
+					matched[0].image.String(),
+					"ip",
+					audit.IP,
+					"ip_source",
+					audit.IPSource,
+				}
+			},
+		})
+	}
+}
+
+// authenticateNotification checks a registry notification against the registry secret
+// and records the algorithm in the audit record. Returns the signed timestamp, or zero
+// for credentials, or responds to the request and returns false.
+func (cfg *HandlerConfig) authenticateNotification(
+	w http.ResponseWriter,
+	r *http.Request,
+	limiter *dchook.RateLimiter,
+	audit *AuditRecord,
+	body []byte,
+) (int64, bool) {
+	audit.Algorithm = registryAlgorithm(r)
+	verifySpan := startVerifySpan(r, authSchemeRegistry)
+	timestamp, ok := authenticateRegistry(r, body, cfg.registrySecret, cfg.allowedAlgorithms)
+	if !ok {
+		endVerifySpan(verifySpan, errSignatureInvalid)
+		//nolint:gosec // slog does not have taint injection
+		slog.Warn("invalid registry credentials", "ip", audit.IP)
+		cfg.recordFailure(limiter, audit.failed(auditAuthFailed, "invalid credentials"))
+		cfg.metrics.DeployRequest(endpointRegistry, outcomeBadSignature)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+	endVerifySpan(verifySpan, nil)
+
+	// A signed timestamp is recorded only once the deployment is accepted, like event
+	// IDs, but a timestamp that is stale or already recorded is a replay.
+	if timestamp != 0 && !limiter.FreshTimestamp(timestamp) {
+		cfg.rejectRegistryReplay(w, limiter, *audit, timestamp)
+		return 0, false
+	}
+	return timestamp, true
+}
+
+// matchNotification parses a registry notification, records its format as the identity
+// in the audit record, and returns the format and the pushes of images used in the
+// compose file that have not been deployed, or responds to the request and returns false.
+func (cfg *HandlerConfig) matchNotification(
+	w http.ResponseWriter,
+	r *http.Request,
+	limiter *dchook.RateLimiter,
+	audit *AuditRecord,
+	body []byte,
+) (string, []registryPush, bool) {
+	source, pushes, err := parseRegistryNotification(body)
+	if err != nil {
+		//nolint:gosec // slog does not have taint injection
+		slog.Warn("invalid registry notification", "ip", audit.IP, "error", err)
+		cfg.recordFailure(limiter, audit.failed(auditBadRequest, "invalid notification"))
+		cfg.metrics.DeployRequest(endpointRegistry, outcomeBadRequest)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return "", nil, false
+	}
+	audit.Identity = source
+
+	// Registries retry notifications that fail; skip events already deployed.
+	pushes = unseenPushes(pushes, limiter)
+
+	images, err := cfg.adapter.Images(r.Context())
+	if err != nil {
+		slog.Error("failed to list compose images", "error", err)
+		cfg.metrics.DeployRequest(endpointRegistry, outcomeError)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", nil, false
+	}
+
+	matched := matchPushes(pushes, images)
+	if len(matched) == 0 {
+		pushed := make([]string, 0, len(pushes))
+		for _, push := range pushes {
+			pushed = append(pushed, push.image.String())
+		}
+
+		//nolint:gosec // slog does not have taint injection
+		slog.Info("registry push ignored", "source", source, "images", pushed)
+		cfg.metrics.DeployRequest(endpointRegistry, outcomeIgnored)
+		if _, err := fmt.Fprintf(w, "Event ignored\n"); err != nil {
+			slog.Error("failed to write response", "error", err)
+		}
+		return "", nil, false
+	}
+	return source, matched, true
+}
+
+// claimPushes records the signed timestamp and the event IDs of a notification whose
+// deployment is accepted. Event IDs are recorded only once the deployment is accepted,
+// so that retries of rejected notifications can still deploy. Returns false, rejecting
+// or ignoring the notification, if a concurrent notification recorded them first.
+func (cfg *HandlerConfig) claimPushes(
+	w http.ResponseWriter,
+	limiter *dchook.RateLimiter,
+	audit AuditRecord,
+	timestamp int64,
+	matched []registryPush,
+) bool {
+	if timestamp != 0 && !limiter.CheckReplay(timestamp) {
+		cfg.rejectRegistryReplay(w, limiter, audit, timestamp)
+		return false
+	}
+
+	if recordPushes(matched, limiter) {
+		return true
+	}
+
+	//nolint:gosec // slog does not have taint injection
+	slog.Info("registry push already deployed", "source", audit.Identity, "ip", audit.IP)
+	cfg.metrics.DeployRequest(endpointRegistry, outcomeIgnored)
+	if _, err := fmt.Fprintf(w, "Event ignored\n"); err != nil {
+		slog.Error("failed to write response", "error", err)
+	}
+	return false
+}
+
+// rejectRegistryReplay rejects a signed notification whose timestamp is stale or has
+// already been used.
+func (cfg *HandlerConfig) rejectRegistryReplay(
+	w http.ResponseWriter,
+	limiter *dchook.RateLimiter,
+	audit AuditRecord,
+	timestamp int64,
+) {
+	//nolint:gosec // slog does not have taint injection
+	slog.Warn("replay attack detected", "ip", audit.IP, "timestamp", timestamp)
+	cfg.recordFailure(limiter, audit.failed(auditReplayDetected, "replayed timestamp"))
+	cfg.metrics.DeployRequest(endpointRegistry, outcomeReplay)
+	http.Error(w, "Invalid or replayed timestamp", http.StatusBadRequest)
+}
+
+// unseenPushes removes pushes with an event ID that has already been recorded by
+// recordPushes. Pushes without an event ID are kept.
+func unseenPushes(pushes []registryPush, limiter *dchook.RateLimiter) []registryPush {
+	unseen := pushes[:0]
+	for _, push := range pushes {
+		if push.id == "" || !limiter.SeenNonce(registryPushNonce(push.id)) {
+			unseen = append(unseen, push)
+		}
+	}
+	return unseen
+}
+
+// recordPushes records the event IDs of the pushes of an accepted deployment. Returns
+// false if every push has an event ID that a concurrent notification already recorded.
+func recordPushes(pushes []registryPush, limiter *dchook.RateLimiter) bool {
+	recorded := false
+	for _, push := range pushes {
+		if push.id == "" || limiter.CheckNonce(registryPushNonce(push.id)) {
+			recorded = true
+		}
+	}
+	return recorded
+}
+
+func registryPushNonce(id string) string {
+	return registrySourceDistribution + ":" + id
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestParseImageReference(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value string
+		want  imageReference
+	}{
+		{"nginx", imageReference{"docker.io", "library/nginx", "latest", ""}},
+		{"nginx:1.27", imageReference{"docker.io", "library/nginx", "1.27", ""}},
+		{"org/app:v1", imageReference{"docker.io", "org/app", "v1", ""}},
+		{"index.docker.io/org/app", imageReference{"docker.io", "org/app", "latest", ""}},
+		{"ghcr.io/org/app:1.2", imageReference{"ghcr.io", "org/app", "1.2", ""}},
+		{"localhost:5000/app", imageReference{"localhost:5000", "app", "latest", ""}},
+		{"localhost/app:dev", imageReference{"localhost", "app", "dev", ""}},
+		{
+			"registry.example.com:5000/team/app@sha256:abc",
+			imageReference{"registry.example.com:5000", "team/app", "", "sha256:abc"},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.value, func(t *testing.T) {
+			t.Parallel()
+
+			if got := parseImageReference(testCase.value); got != testCase.want {
+				t.Errorf(
+					"parseImageReference(%q) = %+v, want %+v",
+					testCase.value,
+					got,
+					testCase.want,
+				)
+			}
+		})
+	}
+}
+
+func TestParseRegistryNotification(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantSource string
+		wantImages []string
+	}{
+		{
+			name: "distribution",
+			body: `{"events":[` +
+				`{"id":"e-1","action":"push","target":{"repository":"app","tag":"latest",` +
+				`"digest":"sha256:abc"},"request":{"host":"localhost:5000"}},` +
+				`{"id":"e-2","action":"push","target":{"repository":"app","digest":""}},` +
+				`{"id":"e-3","action":"pull","target":{"repository":"app","tag":"latest"}}]}`,
+			wantSource: registrySourceDistribution,
+			wantImages: []string{"localhost:5000/app:latest@sha256:abc"},
+		},
+		{
+			name: "harbor",
+			body: `{"type":"PUSH_ARTIFACT","event_data":{"resources":[{"tag":"v1",` +
+				`"digest":"sha256:def","resource_url":"harbor.example.com/library/app:v1"}]}}`,
+			wantSource: registrySourceHarbor,
+			wantImages: []string{"harbor.example.com/library/app:v1@sha256:def"},
+		},
+		{
+			name:       "harbor delete",
+			body:       `{"type":"DELETE_ARTIFACT","event_data":{"resources":[]}}`,
+			wantSource: registrySourceHarbor,
+		},
+		{
+			name:       "quay",
+			body:       `{"docker_url":"quay.io/org/app","updated_tags":["latest","v2"]}`,
+			wantSource: registrySourceQuay,
+			wantImages: []string{"quay.io/org/app:latest", "quay.io/org/app:v2"},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			source, pushes, err := parseRegistryNotification([]byte(testCase.body))
+			if err != nil {
+				t.Fatalf("parseRegistryNotification() error = %v", err)
+			}
+
+			if source != testCase.wantSource {
+				t.Errorf("source = %q, want %q", source, testCase.wantSource)
+			}
+
+			if len(pushes) != len(testCase.wantImages) {
+				t.Fatalf("pushes = %v, want %v", pushes, testCase.wantImages)
+			}
+			for i, push := range pushes {
+				if push.image.String() != testCase.wantImages[i] {
+					t.Errorf("pushes[%d] = %q, want %q", i, push.image, testCase.wantImages[i])
+				}
+			}
+		})
+	}
+
+	if _, _, err := parseRegistryNotification([]byte(`{"hello":"world"}`)); err == nil {
+		t.Error("parseRegistryNotification() should reject unknown formats")
+	}
+}
+
+func TestMatchPushes(t *testing.T) {
+	t.Parallel()
+
+	composeImages := []string{
+		"localhost:5000/app:latest",
+		"nginx",
+		"ghcr.io/org/api@sha256:abc",
+	}
+
+	tests := []struct {
+		name  string
+		image imageReference
+		want  bool
+	}{
+		{"same tag", imageReference{"localhost:5000", "app", "latest", ""}, true},
+		{"other tag", imageReference{"localhost:5000", "app", "dev", ""}, false},
+		{"other host", imageReference{"registry.example.com", "app", "latest", ""}, false},
+		{"unknown host", imageReference{"", "app", "latest", ""}, true},
+		{"docker hub", imageReference{"docker.io", "library/nginx", "latest", ""}, true},
+		{"pinned digest", imageReference{"ghcr.io", "org/api", "v1", "sha256:abc"}, true},
+		{"other digest", imageReference{"ghcr.io", "org/api", "v1", "sha256:def"}, false},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			matched := matchPushes([]registryPush{{image: testCase.image}}, composeImages)
+			if got := len(matched) == 1; got != testCase.want {
+				t.Errorf("matchPushes(%v) = %v, want %v", testCase.image, got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	t.Parallel()
+
+	secret := "registry-token"
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		allowedAlgorithms: map[string]bool{"sha256": true},
+		registrySecret:    secret,
+		adapter:           &MockAdapter{ImageList: []string{"localhost:5000/app:latest"}},
+		history:           NewDeploymentHistory(),
+	}
+	limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+	handler := createRegistryHandler(NewConfigStore(cfg, nil), limiter)
+
+	notification := func(id, tag string) string {
+		return `{"events":[{"id":"` + id + `","action":"push","target":{"repository":"app",` +
+			`"tag":"` + tag + `"},"request":{"host":"localhost:5000"}}]}`
+	}
+
+	bearer := map[string]string{"Authorization": "Bearer " + secret}
+
+	if w := sendForgeRequest(
+		handler,
+		"/deploy/registry",
+		notification("e-1", "dev"),
+		bearer,
+	); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "ignored") {
+		t.Errorf("unmatched tag status = %d (%q), want ignored", w.Code, w.Body.String())
+	}
+
+	if w := sendForgeRequest(
+		handler,
+		"/deploy/registry",
+		notification("e-2", "latest"),
+		bearer,
+	); w.Code != dchook.DeployAcceptedStatus {
+		t.Errorf("bearer status = %d, want %d", w.Code, dchook.DeployAcceptedStatus)
+	}
+
+	// A retried notification is not deployed again
+	if w := sendForgeRequest(
+		handler,
+		"/deploy/registry",
+		notification("e-2", "latest"),
+		bearer,
+	); w.Code != http.StatusOK {
+		t.Errorf("retried notification status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// Signed notifications are replayed without event IDs, as for Harbor and Quay.
+	body := `{"type":"PUSH_ARTIFACT","event_data":{"resources":[{"tag":"latest",` +
+		`"resource_url":"localhost:5000/app:latest"}]}}`
+	timestamp := strconv.FormatInt(time.Now().UnixMicro(), 10)
+	signed := map[string]string{
+		"X-Dchook-Timestamp": timestamp,
+		"Dchook-Signature": dchook.GenerateSignature(
+			[]byte(timestamp+":"+body),
+			secret,
+			"sha256",
+		),
+	}
+	if w := sendForgeRequest(handler, "/deploy/registry", body, signed); w.Code != 202 {
+		t.Errorf("HMAC status = %d, want %d", w.Code, dchook.DeployAcceptedStatus)
+	}
+	if w := sendForgeRequest(handler, "/deploy/registry", body, signed); w.Code != 400 {
+		t.Errorf("replayed HMAC status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).UnixMicro(), 10)
+	if w := sendForgeRequest(handler, "/deploy/registry", body, map[string]string{
+		"X-Dchook-Timestamp": stale,
+		"Dchook-Signature": dchook.GenerateSignature(
+			[]byte(stale+":"+body),
+			secret,
+			"sha256",
+		),
+	}); w.Code != http.StatusBadRequest {
+		t.Errorf("stale HMAC status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	for name, headers := range map[string]map[string]string{
+		"missing":   {},
+		"wrong":     {"Authorization": "Bearer wrong"},
+		"signature": {"Dchook-Signature": "sha256:00", "X-Dchook-Timestamp": timestamp},
+		"unsigned timestamp": {
+			"Dchook-Signature": dchook.GenerateSignature([]byte(body), secret, "sha256"),
+		},
+	} {
+		if w := sendForgeRequest(
+			handler,
+			"/deploy/registry",
+			notification("e-4", "latest"),
+			headers,
+		); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s credentials status = %d, want %d", name, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	sources := map[string]bool{}
+	for _, deployment := range cfg.history.List() {
+		var request webhookEvent
+		if err := json.Unmarshal(deployment.Request, &request); err != nil {
+			t.Fatal(err)
+		}
+		sources[request.Source] = true
+	}
+	if !sources[registrySourceDistribution] || !sources[registrySourceHarbor] {
+		t.Errorf("deployment sources = %v, want distribution and harbor", sources)
+	}
+}
+
+func TestRegistryHandlerRetry(t *testing.T) {
+	t.Parallel()
+
+	secret := "registry-token"
+	ipExtractor, err := dchook.NewIPExtractor(dchook.DefaultTrustedProxies, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	cfg := &HandlerConfig{
+		dockerAvailable: true,
+		ipExtractor:     ipExtractor,
+		registrySecret:  secret,
+		adapter:         &MockAdapter{ImageList: []string{"localhost:5000/app:latest"}},
+		history:         NewDeploymentHistory(),
+		schedule: &deploymentSchedule{freezes: []scheduleFreezeRange{{
+			name:  "incident",
+			start: now.Add(-time.Hour),
+			end:   now.Add(time.Hour),
+		}}},
+	}
+	// One deployment per window; the rejected notification does not use it up.
+	limiter := dchook.NewRateLimiter(1, time.Minute, 10, time.Hour, time.Hour)
+	handler := createRegistryHandler(NewConfigStore(cfg, nil), limiter)
+
+	notify := func(id string) int {
+		body := `{"events":[{"id":"` + id + `","action":"push","target":{"repository":"app",` +
+			`"tag":"latest"},"request":{"host":"localhost:5000"}}]}`
+		headers := map[string]string{"Authorization": "Bearer " + secret}
+		return sendForgeRequest(handler, "/deploy/registry", body, headers).Code
+	}
+
+	// A notification rejected by the schedule deploys when the registry retries it.
+	if code := notify("e-1"); code != http.StatusConflict {
+		t.Errorf("frozen notification status = %d, want %d", code, http.StatusConflict)
+	}
+	cfg.schedule = nil
+	if code := notify("e-1"); code != dchook.DeployAcceptedStatus {
+		t.Errorf("retried notification status = %d, want %d", code, 202)
+	}
+
+	// A rate limited notification is not recorded either.
+	if code := notify("e-2"); code != http.StatusTooManyRequests {
+		t.Errorf("rate limited status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if limiter.SeenNonce(registryPushNonce("e-2")) {
+		t.Error("rate limited notification should not be recorded")
+	}
+
+	if code := notify("e-1"); code != http.StatusOK {
+		t.Errorf("deployed notification status = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ReloadStatus describes the result of the most recent configuration reload.
+type ReloadStatus struct {
+	Timestamp time.Time `json:"timestamp"`
+	Success   bool      `json:"success"`
+	Trigger   string    `json:"trigger"`
+}
+
+// ConfigStore holds the current HandlerConfig and replaces it atomically on reload.
+// Handlers load the configuration once per request, so a request in progress finishes
+// with the configuration it started with, and deployments already started keep their
+// adapter.
+type ConfigStore struct {
+	current    atomic.Pointer[HandlerConfig]
+	lastReload atomic.Pointer[ReloadStatus]
+	mutex      sync.Mutex // serializes reloads
+	load       func() (*HandlerConfig, error)
+}
+
+// NewConfigStore creates a store holding cfg. The load function produces a new
+// configuration on reload.
+func NewConfigStore(cfg *HandlerConfig, load func() (*HandlerConfig, error)) *ConfigStore {
+	store := &ConfigStore{load: load}
+	store.current.Store(cfg)
+	return store
+}
+
+// Load returns the current configuration.
+func (s *ConfigStore) Load() *HandlerConfig {
+	return s.current.Load()
+}
+
+// LastReload returns the status of the most recent reload, or nil if there has not been
+// one.
+func (s *ConfigStore) LastReload() *ReloadStatus {
+	return s.lastReload.Load()
+}
+
+// Reload loads and validates a new configuration and swaps it in. If loading fails, the
+// current configuration is kept. The IP extractor, deployment history, deployment
+// tracker, deployment queue, metrics, and audit log are carried over from the current
+// configuration, as are the histories of projects that are still configured. New
+// projects start with an empty history.
+func (s *ConfigStore) Reload(trigger string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	next, err := s.load()
+	s.lastReload.Store(&ReloadStatus{
+		Timestamp: time.Now(),
+		Success:   err == nil,
+		Trigger:   trigger,
+	})
+
+	if err != nil {
+		slog.Error(
+			"configuration reload failed, keeping current configuration",
+			"trigger",
+			trigger,
+			"error",
+			err,
+		)
+		return err
+	}
+
+	current := s.current.Load()
+	next.ipExtractor = current.ipExtractor
+	next.history = current.history
+	next.deployments = current.deployments
+	next.queue = current.queue
+	next.metrics = current.metrics
+	next.audit = current.audit
+	for name, p := range next.projects {
+		if previous, found := current.projects[name]; found {
+			p.history = previous.history
+		} else {
+			p.history = NewDeploymentHistoryWithSize(current.history.Size())
+		}
+	}
+	s.current.Store(next)
+
+	slog.Info(
+		"configuration reloaded",
+		"trigger",
+		trigger,
+		"docker_available",
+		next.dockerAvailable,
+		"allowed_algorithms",
+		slices.Sorted(maps.Keys(next.allowedAlgorithms)),
+		"key_ids",
+		slices.Sorted(maps.Keys(next.secrets)),
+		"projects",
+		slices.Sorted(maps.Keys(next.projects)),
+	)
+	return nil
+}
+
+// HandleSignals reloads the configuration whenever the process receives SIGHUP.
+func (s *ConfigStore) HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			//nolint:errcheck // Failures are logged and the current configuration is kept
+			_ = s.Reload("SIGHUP")
+		}
+	}()
+}
+
+// WatchFiles polls the files every interval and reloads the configuration when the
+// modification time or size of any regular file changes. Named pipes are not watched.
+func (s *ConfigStore) WatchFiles(paths []string, interval time.Duration) {
+	snapshot := statFiles(paths)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		next := statFiles(paths)
+		if fileStatsEqual(snapshot, next) {
+			continue
+		}
+
+		snapshot = next
+		//nolint:errcheck // Failures are logged and the current configuration is kept
+		_ = s.Reload("file change")
+	}
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFiles follows symlinks, so that renewed certificates behind a symlink are seen as
+// changes. Secret files that are symlinks are rejected when they are read.
+func statFiles(paths []string) map[string]fileStat {
+	stats := make(map[string]fileStat, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		stats[path] = fileStat{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stats
+}
+
+func fileStatsEqual(a, b map[string]fileStat) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for path, stat := range a {
+		other, ok := b[path]
+		if !ok || !stat.modTime.Equal(other.modTime) || stat.size != other.size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var errTestLoad = errors.New("load failed")
+
+func TestConfigStoreReload(t *testing.T) {
+	t.Parallel()
+
+	history := NewDeploymentHistory()
+	history.Add(Deployment{ID: "before-reload", Timestamp: time.Now()})
+
+	initial := &HandlerConfig{secret: "old-secret", history: history}
+
+	var loadErr error
+	store := NewConfigStore(initial, func() (*HandlerConfig, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return &HandlerConfig{secret: "new-secret"}, nil
+	})
+
+	if store.LastReload() != nil {
+		t.Error("LastReload() should be nil before any reload")
+	}
+
+	if err := store.Reload("test"); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	reloaded := store.Load()
+	if reloaded.secret != "new-secret" {
+		t.Errorf("Load().secret = %q, want %q", reloaded.secret, "new-secret")
+	}
+	if reloaded.history != history {
+		t.Error("Reload() did not carry over the deployment history")
+	}
+	if _, found := reloaded.history.Get("before-reload"); !found {
+		t.Error("deployment recorded before reload is missing")
+	}
+	if status := store.LastReload(); status == nil || !status.Success {
+		t.Errorf("LastReload() = %+v, want success", status)
+	}
+
+	// The configuration loaded before the reload is unchanged.
+	if initial.secret != "old-secret" {
+		t.Errorf("initial configuration was modified: secret = %q", initial.secret)
+	}
+
+	loadErr = errTestLoad
+	if err := store.Reload("test"); !errors.Is(err, errTestLoad) {
+		t.Fatalf("Reload() error = %v, want %v", err, errTestLoad)
+	}
+	if store.Load() != reloaded {
+		t.Error("failed Reload() replaced the configuration")
+	}
+	if status := store.LastReload(); status == nil || status.Success {
+		t.Errorf("LastReload() = %+v, want failure", status)
+	}
+}
+
+func TestStatFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret")
+	if err := os.WriteFile(path, []byte("one"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	before := statFiles([]string{path, filepath.Join(dir, "missing")})
+	if len(before) != 1 {
+		t.Fatalf("statFiles() returned %d entries, want 1", len(before))
+	}
+
+	if !fileStatsEqual(before, statFiles([]string{path})) {
+		t.Error("fileStatsEqual() = false for an unchanged file")
+	}
+
+	if err := os.WriteFile(path, []byte("longer"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if fileStatsEqual(before, statFiles([]string{path})) {
+		t.Error("fileStatsEqual() = true for a changed file")
+	}
+}
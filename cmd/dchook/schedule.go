@@ -0,0 +1,687 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	// Time zones are resolved without the system database in minimal images.
+	_ "time/tzdata"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const (
+	scheduleModeReject = "reject"
+	scheduleModeQueue  = "queue"
+
+	scheduleWindow = "window"
+	scheduleFreeze = "freeze"
+
+	minWindowFields = 3
+	maxWindowFields = 4
+	minFreezeFields = 4
+	maxFreezeFields = 5
+
+	minutesPerDay = 24 * 60
+	daysPerWeek   = 7
+
+	scheduleDateLayout     = "2006-01-02"
+	scheduleDateTimeLayout = "2006-01-02T15:04"
+
+	// scheduleHorizon bounds the search for the time that deployments open.
+	scheduleHorizon = 400 * 24 * time.Hour
+	// maxScheduleSteps bounds the number of window starts and freeze ends that the
+	// search steps through.
+	maxScheduleSteps = 1000
+
+	// maxOverrideReasonLength bounds the length of an override reason.
+	maxOverrideReasonLength = 200
+)
+
+var (
+	errScheduleLine     = errors.New("schedule line must be a window or freeze")
+	errScheduleWindow   = errors.New("window line must be \"window days hours [time-zone]\"")
+	errScheduleFreezeLn = errors.New("freeze line must be \"freeze name start end [time-zone]\"")
+	errScheduleDays     = errors.New("invalid window days")
+	errScheduleHours    = errors.New("invalid window hours")
+	errScheduleName     = errors.New("invalid freeze name")
+	errScheduleTime     = errors.New("invalid freeze time")
+	errScheduleRange    = errors.New("freeze end must be after its start")
+	errScheduleEmpty    = errors.New("schedule file contains no windows or freezes")
+	errScheduleMode     = errors.New("invalid DCHOOK_SCHEDULE_MODE")
+	errScheduleClosed   = errors.New("outside deployment windows")
+	errScheduleFrozen   = errors.New("deployment freeze")
+	errQueueReplaced    = errors.New("replaced by a newer queued deployment")
+	errQueueProject     = errors.New("project is no longer configured")
+	errOverrideReason   = errors.New("override reason must be printable and at most 200 bytes")
+
+	weekdayNames = map[string]time.Weekday{
+		"sun": time.Sunday,
+		"mon": time.Monday,
+		"tue": time.Tuesday,
+		"wed": time.Wednesday,
+		"thu": time.Thursday,
+		"fri": time.Friday,
+		"sat": time.Saturday,
+	}
+)
+
+// deploymentSchedule restricts deployments to windows, except during freezes. Without
+// windows, deployments are allowed at any time outside freezes. Requests outside the
+// schedule are rejected, or queued until the schedule opens if queue is set.
+type deploymentSchedule struct {
+	windows []scheduleWindowRange
+	freezes []scheduleFreezeRange
+	queue   bool
+}
+
+// scheduleWindowRange allows deployments on its days between start and end, in minutes
+// after midnight in its time zone. A window that ends at or before its start ends on the
+// next day.
+type scheduleWindowRange struct {
+	days     [daysPerWeek]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+// scheduleFreezeRange forbids deployments from start until end.
+type scheduleFreezeRange struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+// DeploymentOverride records a deploy request that overrode the deployment schedule.
+type DeploymentOverride struct {
+	Reason string `json:"reason"`
+	// Bypassed is why the schedule was closed when the request was made, if it was.
+	Bypassed string `json:"bypassed,omitempty"`
+}
+
+// parseSchedule parses schedule file data into a schedule.
+//
+// Each non-blank line that does not start with `#` holds a window or a freeze, with
+// fields separated by whitespace:
+//
+//   - `window days hours [time-zone]`: days are comma-separated weekdays or weekday
+//     ranges (`Mon-Fri,Sun`) or `*`, and hours are `HH:MM-HH:MM` (`24:00` ends at
+//     midnight).
+//   - `freeze name start end [time-zone]`: start and end are `YYYY-MM-DD` or
+//     `YYYY-MM-DDTHH:MM`. An end date without a time includes the whole day.
+//
+// Time zones are IANA names (`Europe/Berlin`) and default to UTC.
+func parseSchedule(data string) (*deploymentSchedule, error) {
+	schedule := &deploymentSchedule{}
+
+	for number, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		var err error
+		switch fields[0] {
+		case scheduleWindow:
+			var window scheduleWindowRange
+			window, err = parseScheduleWindow(fields)
+			schedule.windows = append(schedule.windows, window)
+		case scheduleFreeze:
+			var freeze scheduleFreezeRange
+			freeze, err = parseScheduleFreeze(fields)
+			schedule.freezes = append(schedule.freezes, freeze)
+		default:
+			err = fmt.Errorf("%w: %q", errScheduleLine, fields[0])
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+	}
+
+	if len(schedule.windows) == 0 && len(schedule.freezes) == 0 {
+		return nil, errScheduleEmpty
+	}
+	return schedule, nil
+}
+
+func parseScheduleWindow(fields []string) (scheduleWindowRange, error) {
+	if len(fields) < minWindowFields || len(fields) > maxWindowFields {
+		return scheduleWindowRange{}, errScheduleWindow
+	}
+
+	window := scheduleWindowRange{location: time.UTC}
+
+	if err := window.parseDays(fields[1]); err != nil {
+		return scheduleWindowRange{}, err
+	}
+
+	start, end, found := strings.Cut(fields[2], "-")
+	if !found {
+		return scheduleWindowRange{}, fmt.Errorf("%w: %q", errScheduleHours, fields[2])
+	}
+
+	var startErr, endErr error
+	window.start, startErr = parseScheduleMinutes(start)
+	window.end, endErr = parseScheduleMinutes(end)
+	if startErr != nil || endErr != nil || window.start == minutesPerDay ||
+		window.start == window.end {
+		return scheduleWindowRange{}, fmt.Errorf("%w: %q", errScheduleHours, fields[2])
+	}
+
+	if len(fields) == maxWindowFields {
+		location, err := time.LoadLocation(fields[3])
+		if err != nil {
+			return scheduleWindowRange{}, fmt.Errorf("invalid time zone %q: %w", fields[3], err)
+		}
+		window.location = location
+	}
+	return window, nil
+}
+
+// parseDays parses comma-separated weekdays, weekday ranges, or `*` for every day.
+func (window *scheduleWindowRange) parseDays(value string) error {
+	if value == "*" {
+		window.days = [daysPerWeek]bool{true, true, true, true, true, true, true}
+		return nil
+	}
+
+	for days := range strings.SplitSeq(value, ",") {
+		first, last, isRange := strings.Cut(days, "-")
+		if !isRange {
+			last = first
+		}
+
+		from, fromFound := weekdayNames[strings.ToLower(first)]
+		to, toFound := weekdayNames[strings.ToLower(last)]
+		if !fromFound || !toFound {
+			return fmt.Errorf("%w: %q", errScheduleDays, value)
+		}
+
+		for day := from; ; day = (day + 1) % daysPerWeek {
+			window.days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseScheduleMinutes parses `HH:MM` (up to `24:00`) into minutes after midnight.
+func parseScheduleMinutes(value string) (int, error) {
+	hours, minutes, found := strings.Cut(value, ":")
+	if !found || len(hours) != 2 || len(minutes) != 2 {
+		return 0, errScheduleHours
+	}
+
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return 0, errScheduleHours
+	}
+
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, errScheduleHours
+	}
+
+	total := h*60 + m
+	if h < 0 || m < 0 || m > 59 || total > minutesPerDay {
+		return 0, errScheduleHours
+	}
+	return total, nil
+}
+
+func parseScheduleFreeze(fields []string) (scheduleFreezeRange, error) {
+	if len(fields) < minFreezeFields || len(fields) > maxFreezeFields {
+		return scheduleFreezeRange{}, errScheduleFreezeLn
+	}
+
+	// Freeze names follow the key ID rules.
+	name := fields[1]
+	if dchook.ValidateKeyID(name) != nil {
+		return scheduleFreezeRange{}, fmt.Errorf("%w: %q", errScheduleName, name)
+	}
+
+	location := time.UTC
+	if len(fields) == maxFreezeFields {
+		var err error
+		if location, err = time.LoadLocation(fields[4]); err != nil {
+			return scheduleFreezeRange{}, fmt.Errorf("invalid time zone %q: %w", fields[4], err)
+		}
+	}
+
+	start, _, err := parseScheduleTime(fields[2], location)
+	if err != nil {
+		return scheduleFreezeRange{}, err
+	}
+
+	end, dateOnly, err := parseScheduleTime(fields[3], location)
+	if err != nil {
+		return scheduleFreezeRange{}, err
+	}
+	if dateOnly {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	if !end.After(start) {
+		return scheduleFreezeRange{}, fmt.Errorf("%w: %q", errScheduleRange, name)
+	}
+	return scheduleFreezeRange{name: name, start: start, end: end}, nil
+}
+
+// parseScheduleTime parses a date or a date and time in the location. Returns true if
+// the value is a date without a time.
+func parseScheduleTime(value string, location *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(scheduleDateLayout, value, location); err == nil {
+		return t, true, nil
+	}
+
+	t, err := time.ParseInLocation(scheduleDateTimeLayout, value, location)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: %q", errScheduleTime, value)
+	}
+	return t, false, nil
+}
+
+// readSchedule reads the schedule file at path, with the mode from DCHOOK_SCHEDULE_MODE.
+// Returns nil if path is empty.
+func readSchedule(path string) (*deploymentSchedule, error) {
+	if path == "" {
+		return nil, nil //nolint:nilnil // Not configured
+	}
+
+	data, err := dchook.ReadSecretFileStrict(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule: %w", err)
+	}
+
+	schedule, err := parseSchedule(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule file %q: %w", path, err)
+	}
+
+	switch mode := dchook.EnvValue("DCHOOK_SCHEDULE_MODE"); mode {
+	case "", scheduleModeReject:
+	case scheduleModeQueue:
+		schedule.queue = true
+	default:
+		return nil, fmt.Errorf("%w: %q", errScheduleMode, mode)
+	}
+	return schedule, nil
+}
+
+// contains checks if the window includes the time.
+func (window *scheduleWindowRange) contains(t time.Time) bool {
+	local := t.In(window.location)
+	minutes := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+
+	if window.start < window.end {
+		return window.days[day] && minutes >= window.start && minutes < window.end
+	}
+
+	previous := (day + daysPerWeek - 1) % daysPerWeek
+	return (window.days[day] && minutes >= window.start) ||
+		(window.days[previous] && minutes < window.end)
+}
+
+// nextStart returns the first start of the window after t, or the zero time if the
+// window has no days.
+func (window *scheduleWindowRange) nextStart(t time.Time) time.Time {
+	local := t.In(window.location)
+	for offset := range daysPerWeek + 1 {
+		date := local.AddDate(0, 0, offset)
+		if !window.days[date.Weekday()] {
+			continue
+		}
+
+		start := time.Date(
+			date.Year(),
+			date.Month(),
+			date.Day(),
+			0,
+			window.start,
+			0,
+			0,
+			window.location,
+		)
+		if start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// frozen returns the freeze that includes the time, if any.
+func (s *deploymentSchedule) frozen(t time.Time) *scheduleFreezeRange {
+	for i := range s.freezes {
+		if freeze := &s.freezes[i]; !t.Before(freeze.start) && t.Before(freeze.end) {
+			return freeze
+		}
+	}
+	return nil
+}
+
+// inWindow checks if the time is in a window, or if there are no windows.
+func (s *deploymentSchedule) inWindow(t time.Time) bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+
+	for i := range s.windows {
+		if s.windows[i].contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// check checks if deployments are allowed at now. If they are not, returns the reason
+// and the time that deployments open, or the zero time if they do not open within the
+// search horizon. A nil schedule allows deployments at any time.
+func (s *deploymentSchedule) check(now time.Time) (time.Time, error) {
+	if s == nil {
+		return time.Time{}, nil
+	}
+
+	var reason error
+	if freeze := s.frozen(now); freeze != nil {
+		reason = fmt.Errorf(
+			"%w %q until %s",
+			errScheduleFrozen,
+			freeze.name,
+			freeze.end.Format(time.RFC3339),
+		)
+	} else if !s.inWindow(now) {
+		reason = errScheduleClosed
+	} else {
+		return time.Time{}, nil
+	}
+
+	opens := s.nextOpen(now)
+	if opens.IsZero() {
+		return opens, reason
+	}
+	return opens, fmt.Errorf("%w; deployments open at %s", reason, opens.Format(time.RFC3339))
+}
+
+// nextOpen returns the first time after now that is in a window and not in a freeze, or
+// the zero time if there is none within the search horizon.
+func (s *deploymentSchedule) nextOpen(now time.Time) time.Time {
+	t := now
+	for range maxScheduleSteps {
+		if t.Sub(now) > scheduleHorizon {
+			break
+		}
+
+		if freeze := s.frozen(t); freeze != nil {
+			t = freeze.end
+			continue
+		}
+
+		if s.inWindow(t) {
+			return t
+		}
+
+		var next time.Time
+		for i := range s.windows {
+			start := s.windows[i].nextStart(t)
+			if !start.IsZero() && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		if next.IsZero() {
+			break
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// validateOverrideReason checks that the override reason of a deploy request can be
+// recorded.
+func validateOverrideReason(reason string) error {
+	if strings.TrimSpace(reason) == "" || len(reason) > maxOverrideReasonLength ||
+		strings.ContainsAny(reason, "\r\n") || !dchook.IsPrintableUTF8([]byte(reason)) {
+		return errOverrideReason
+	}
+	return nil
+}
+
+// authorizeOverride checks that the identity of a deploy request may override the
+// schedule: the OIDC rule that authenticated the request, or the client named by the key
+// ID.
+func (cfg *HandlerConfig) authorizeOverride(viaOIDC bool, identity, keyID, ip string) error {
+	if !viaOIDC {
+		_, err := cfg.authorize(keyID, actionOverride, ip)
+		return err
+	}
+
+	for _, rule := range cfg.oidc.rules {
+		if rule.name == identity && rule.actions[actionOverride] {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s for rule %q", errOIDCNotPermitted, actionOverride, identity)
+}
+
+// scheduleDeployment applies the schedule to the deployment options at now: a closed
+// schedule is bypassed by an override, which records the reason, or queues the
+// deployment until the schedule opens in queue mode. Otherwise, returns the reason that
+// the schedule is closed and the time that it opens, if known.
+func (cfg *HandlerConfig) scheduleDeployment(
+	options *deployOptions,
+	now time.Time,
+) (time.Time, error) {
+	opens, err := cfg.schedule.check(now)
+	switch {
+	case err == nil:
+		return time.Time{}, nil
+	case options.override != nil:
+		options.override.Bypassed = err.Error()
+		return time.Time{}, nil
+	case cfg.schedule.queue && !opens.IsZero():
+		options.scheduledFor = opens
+		return time.Time{}, nil
+	default:
+		return opens, err
+	}
+}
+
+// rejectOutsideSchedule responds to a deploy request that the schedule does not allow
+// with 409 Conflict, the reason, and the seconds until the schedule opens in
+// Retry-After, if known. Rejections are audited, but are not failures for the rate
+// limiter.
+func (cfg *HandlerConfig) rejectOutsideSchedule(
+	w http.ResponseWriter,
+	endpoint string,
+	audit AuditRecord,
+	opens time.Time,
+	err error,
+) {
+	//nolint:gosec // slog does not have taint injection
+	slog.Warn("deployment outside schedule", "ip", audit.IP, "reason", err)
+	cfg.audit.Record(audit.failed(auditScheduleClosed, err.Error()))
+	cfg.metrics.DeployRequest(endpoint, outcomeClosed)
+
+	if !opens.IsZero() {
+		retryAfter := int64(time.Until(opens).Round(time.Second) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(max(retryAfter, 1), 10))
+	}
+	http.Error(w, "Deployment not allowed: "+err.Error(), http.StatusConflict)
+}
+
+// DeploymentQueue holds the deployments that wait for the schedule to open, at most one
+// per project: a newer deployment replaces the queued deployment of its project. It is
+// shared by every configuration, so queued deployments start with the configuration
+// that is current when the schedule opens.
+type DeploymentQueue struct {
+	mutex   sync.Mutex
+	pending map[string]*queuedDeployment // by project name
+	stopped bool
+	running sync.WaitGroup
+	// load returns the current configuration.
+	load func() *HandlerConfig
+}
+
+// queuedDeployment is a deployment waiting in the queue.
+type queuedDeployment struct {
+	id string
+	// cancelled is closed when the deployment is replaced or the queue stops.
+	cancelled chan struct{}
+	// reason is the reason that the deployment was cancelled.
+	reason error
+}
+
+// NewDeploymentQueue creates an empty queue that starts deployments with the
+// configuration returned by load.
+func NewDeploymentQueue(load func() *HandlerConfig) *DeploymentQueue {
+	return &DeploymentQueue{pending: make(map[string]*queuedDeployment), load: load}
+}
+
+// Go queues the deployment of the project, cancelling the queued deployment of the
+// project, and runs wait in a new goroutine. wait must call Remove before starting the
+// deployment. Returns an error without running wait if the queue is stopped.
+func (q *DeploymentQueue) Go(project, id string, wait func(queued *queuedDeployment)) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.stopped {
+		return errShuttingDown
+	}
+
+	if previous, found := q.pending[project]; found {
+		q.cancel(previous, fmt.Errorf("%w %s", errQueueReplaced, id))
+	}
+
+	queued := &queuedDeployment{id: id, cancelled: make(chan struct{})}
+	q.pending[project] = queued
+	q.running.Add(1)
+	go func() {
+		defer q.running.Done()
+		wait(queued)
+	}()
+	return nil
+}
+
+// Remove takes the deployment out of the queue so that it can start. Returns the reason
+// that it was cancelled if it is no longer queued.
+func (q *DeploymentQueue) Remove(project string, queued *queuedDeployment) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.pending[project] != queued {
+		return queued.reason
+	}
+	delete(q.pending, project)
+	return nil
+}
+
+// Stop cancels the queued deployments and waits for them to be recorded as failed. No
+// deployments are queued afterwards.
+func (q *DeploymentQueue) Stop() {
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	q.stopped = true
+	for project, queued := range q.pending {
+		q.cancel(queued, errShuttingDown)
+		delete(q.pending, project)
+	}
+	q.mutex.Unlock()
+
+	q.running.Wait()
+}
+
+// cancel cancels the queued deployment with the reason. The caller must hold the mutex.
+func (q *DeploymentQueue) cancel(queued *queuedDeployment, reason error) {
+	queued.reason = reason
+	close(queued.cancelled)
+}
+
+// deployWhenOpen waits for the schedule to open and starts a queued deployment with the
+// current configuration of its project, checking the schedule of that configuration
+// again. The deployment fails if it is cancelled first, the project is no longer
+// configured, or the schedule does not open again.
+func (cfg *HandlerConfig) deployWhenOpen(
+	ctx context.Context,
+	queued *queuedDeployment,
+	deployment Deployment,
+) {
+	for {
+		current, found := cfg.queue.load().forProject(cfg.project)
+		if !found {
+			cfg.cancelQueuedDeployment(queued, errQueueProject)
+			return
+		}
+
+		opens, err := current.schedule.check(time.Now())
+		if err == nil {
+			cfg = current
+			break
+		}
+
+		if opens.IsZero() {
+			cfg.cancelQueuedDeployment(queued, err)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(opens))
+		select {
+		case <-timer.C:
+		case <-queued.cancelled:
+			timer.Stop()
+			cfg.failQueuedDeployment(deployment.ID, queued.reason)
+			return
+		}
+	}
+
+	if err := cfg.queue.Remove(cfg.project, queued); err != nil {
+		cfg.failQueuedDeployment(deployment.ID, err)
+		return
+	}
+
+	slog.Info("queued deployment starting", "deployment_id", deployment.ID)
+	deployment.Status = statusPending
+	cfg.history.Update(deployment.ID, func(d *Deployment) {
+		d.Status = statusPending
+	})
+
+	if err := cfg.adapter.Deploy(ctx, &deployment, cfg.history, cfg.deployments); err != nil {
+		cfg.failQueuedDeployment(deployment.ID, err)
+	}
+}
+
+// cancelQueuedDeployment takes a queued deployment that will not start out of the queue
+// and marks it as failed, with the reason it was cancelled if it is no longer queued.
+func (cfg *HandlerConfig) cancelQueuedDeployment(queued *queuedDeployment, err error) {
+	if removeErr := cfg.queue.Remove(cfg.project, queued); removeErr != nil {
+		err = removeErr
+	}
+	cfg.failQueuedDeployment(queued.id, err)
+}
+
+// failQueuedDeployment marks a queued deployment that did not start as failed.
+func (cfg *HandlerConfig) failQueuedDeployment(id string, err error) {
+	slog.Warn("queued deployment failed", "deployment_id", id, "error", err)
+	cfg.history.Update(id, func(d *Deployment) {
+		d.Status = statusFailed
+	})
+	cfg.audit.Record(AuditRecord{
+		Event:        auditDeploymentFinished,
+		DeploymentID: id,
+		Status:       statusFailed,
+		Reason:       err.Error(),
+	})
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const testSchedule = `
+# window days hours [time-zone]
+window Mon-Fri 09:00-17:00 Europe/Berlin
+window sat     22:00-02:00
+
+# freeze name start end [time-zone]
+freeze december 2026-12-18 2027-01-04 Europe/Berlin
+`
+
+func TestParseSchedule(t *testing.T) {
+	t.Parallel()
+
+	schedule, err := parseSchedule(testSchedule)
+	if err != nil {
+		t.Fatalf("parseSchedule() error = %v", err)
+	}
+
+	if len(schedule.windows) != 2 || len(schedule.freezes) != 1 {
+		t.Fatalf("schedule = %+v, want 2 windows and 1 freeze", schedule)
+	}
+
+	weekdays := schedule.windows[0]
+	if !weekdays.days[time.Monday] || !weekdays.days[time.Friday] ||
+		weekdays.days[time.Saturday] || weekdays.start != 9*60 || weekdays.end != 17*60 ||
+		weekdays.location.String() != "Europe/Berlin" {
+		t.Errorf("weekday window = %+v", weekdays)
+	}
+
+	if night := schedule.windows[1]; !night.days[time.Saturday] ||
+		night.location != time.UTC {
+		t.Errorf("night window = %+v", night)
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	freeze := schedule.freezes[0]
+	if freeze.name != "december" ||
+		!freeze.start.Equal(time.Date(2026, 12, 18, 0, 0, 0, 0, berlin)) ||
+		!freeze.end.Equal(time.Date(2027, 1, 5, 0, 0, 0, 0, berlin)) {
+		t.Errorf("freeze = %+v, want the end date included", freeze)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"empty", "# nothing\n", errScheduleEmpty},
+		{"unknown line", "deploy Mon 09:00-17:00", errScheduleLine},
+		{"window fields", "window Mon-Fri", errScheduleWindow},
+		{"unknown day", "window Mon-Fry 09:00-17:00", errScheduleDays},
+		{"hours without range", "window * 09:00", errScheduleHours},
+		{"invalid hour", "window * 09:00-25:00", errScheduleHours},
+		{"invalid minute", "window * 09:60-17:00", errScheduleHours},
+		{"empty window", "window * 09:00-09:00", errScheduleHours},
+		{"freeze fields", "freeze december 2026-12-18", errScheduleFreezeLn},
+		{"freeze name", "freeze dec/2026 2026-12-18 2027-01-04", errScheduleName},
+		{"freeze time", "freeze december 2026-12-18T09 2027-01-04", errScheduleTime},
+		{"freeze range", "freeze december 2026-12-18T12:00 2026-12-18T09:00", errScheduleRange},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := parseSchedule(testCase.data); !errors.Is(err, testCase.want) {
+				t.Errorf("parseSchedule() error = %v, want %v", err, testCase.want)
+			}
+		})
+	}
+
+	if _, err := parseSchedule("window * 09:00-17:00 Mars/Olympus"); err == nil {
+		t.Error("parseSchedule() should fail for an unknown time zone")
+	}
+}
+
+func TestDeploymentScheduleCheck(t *testing.T) {
+	t.Parallel()
+
+	schedule, err := parseSchedule(testSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		opens time.Time
+		want  error
+	}{
+		{"weekday window", time.Date(2026, 10, 16, 12, 0, 0, 0, berlin), time.Time{}, nil},
+		{
+			"friday evening",
+			time.Date(2026, 10, 16, 18, 0, 0, 0, berlin),
+			time.Date(2026, 10, 17, 22, 0, 0, 0, time.UTC),
+			errScheduleClosed,
+		},
+		{"overnight window", time.Date(2026, 10, 18, 1, 0, 0, 0, time.UTC), time.Time{}, nil},
+		{
+			"after overnight window",
+			time.Date(2026, 10, 18, 2, 0, 0, 0, time.UTC),
+			time.Date(2026, 10, 19, 9, 0, 0, 0, berlin),
+			errScheduleClosed,
+		},
+		{
+			"freeze",
+			time.Date(2026, 12, 21, 10, 0, 0, 0, berlin),
+			time.Date(2027, 1, 5, 9, 0, 0, 0, berlin),
+			errScheduleFrozen,
+		},
+		{
+			"last day of freeze",
+			time.Date(2027, 1, 4, 23, 0, 0, 0, berlin),
+			time.Date(2027, 1, 5, 9, 0, 0, 0, berlin),
+			errScheduleFrozen,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			opens, err := schedule.check(testCase.now)
+			if !errors.Is(err, testCase.want) {
+				t.Fatalf("check() error = %v, want %v", err, testCase.want)
+			}
+			if !opens.Equal(testCase.opens) {
+				t.Errorf("check() opens = %v, want %v", opens, testCase.opens)
+			}
+			if err != nil && !strings.Contains(err.Error(), testCase.opens.Format(time.RFC3339)) {
+				t.Errorf("check() error = %v, want the opening time", err)
+			}
+		})
+	}
+
+	var unset *deploymentSchedule
+	if _, err := unset.check(time.Now()); err != nil {
+		t.Errorf("nil schedule check() error = %v", err)
+	}
+
+	frozen := &deploymentSchedule{freezes: []scheduleFreezeRange{{
+		name:  "forever",
+		start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		end:   time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+	}}}
+	if opens, err := frozen.check(time.Now()); !errors.Is(err, errScheduleFrozen) ||
+		!opens.IsZero() {
+		t.Errorf("check() = %v, %v, want frozen without an opening time", opens, err)
+	}
+}
+
+func TestScheduleDeployment(t *testing.T) {
+	t.Parallel()
+
+	schedule, err := parseSchedule(testSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := *schedule
+	queued.queue = true
+
+	closed := time.Date(2026, 10, 16, 20, 0, 0, 0, time.UTC)
+	opens := time.Date(2026, 10, 17, 22, 0, 0, 0, time.UTC)
+
+	cfg := &HandlerConfig{schedule: schedule}
+	options := deployOptions{}
+	if when, err := cfg.scheduleDeployment(&options, closed); !errors.Is(err, errScheduleClosed) ||
+		!when.Equal(opens) {
+		t.Errorf("scheduleDeployment() = %v, %v, want rejected until %v", when, err, opens)
+	}
+
+	options = deployOptions{override: &DeploymentOverride{Reason: "outage"}}
+	if _, err := cfg.scheduleDeployment(&options, closed); err != nil {
+		t.Fatalf("scheduleDeployment() override error = %v", err)
+	}
+	if !strings.Contains(options.override.Bypassed, errScheduleClosed.Error()) {
+		t.Errorf("override = %+v, want the bypassed reason", options.override)
+	}
+
+	options = deployOptions{}
+	cfg = &HandlerConfig{schedule: &queued}
+	if _, err := cfg.scheduleDeployment(&options, closed); err != nil {
+		t.Fatalf("scheduleDeployment() queue error = %v", err)
+	}
+	if !options.scheduledFor.Equal(opens) {
+		t.Errorf("scheduledFor = %v, want %v", options.scheduledFor, opens)
+	}
+}
+
+func TestAuthorizeOverride(t *testing.T) {
+	t.Parallel()
+
+	clients, err := parseClients(`
+ci      hmac:ci-secret       deploy,status,list
+oncall  hmac:oncall-secret   deploy,override
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := parseOIDCRules(`
+release deploy          repository=example/app
+hotfix  deploy,override repository=example/app ref=refs/heads/hotfix/*
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &HandlerConfig{clients: clients, oidc: &oidcConfig{rules: rules}}
+
+	tests := []struct {
+		name     string
+		viaOIDC  bool
+		identity string
+		keyID    string
+		wantErr  bool
+	}{
+		{"client without override", false, "ci", "ci", true},
+		{"client with override", false, "oncall", "oncall", false},
+		{"rule without override", true, "release", "", true},
+		{"rule with override", true, "hotfix", "", false},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := cfg.authorizeOverride(
+				testCase.viaOIDC,
+				testCase.identity,
+				testCase.keyID,
+				"192.0.2.1",
+			)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("authorizeOverride() error = %v, want error %v", err, testCase.wantErr)
+			}
+		})
+	}
+
+	for _, reason := range []string{"", " ", "line\nbreak", strings.Repeat("x", 201)} {
+		if err := validateOverrideReason(reason); !errors.Is(err, errOverrideReason) {
+			t.Errorf("validateOverrideReason(%q) error = %v, want an error", reason, err)
+		}
+	}
+}
+
+func TestDeployHandlerSchedule(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	schedule := &deploymentSchedule{freezes: []scheduleFreezeRange{{
+		name:  "incident",
+		start: now.Add(-time.Hour),
+		end:   now.Add(time.Hour),
+	}}}
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		version:           "v1.0.0",
+		commit:            "abc",
+		secret:            "test-secret",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+		deployments:       NewDeploymentTracker(),
+		schedule:          schedule,
+	}
+	store := NewConfigStore(cfg, nil)
+	cfg.queue = NewDeploymentQueue(store.Load)
+	handler := createDeployHandler(
+		store,
+		dchook.NewRateLimiter(100, time.Minute, 100, time.Hour, time.Hour),
+	)
+
+	timestamp := now.UnixMicro()
+	deploy := func(options string) *httptest.ResponseRecorder {
+		timestamp++
+		body := []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+			strconv.FormatInt(timestamp, 10) + `"` + options + `},"payload":{}}`)
+		req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set(
+			"Dchook-Signature",
+			dchook.GenerateSignature(body, "test-secret", dchook.AlgorithmSHA256),
+		)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	deploymentFor := func(w *httptest.ResponseRecorder) Deployment {
+		t.Helper()
+
+		if w.Code != dchook.DeployAcceptedStatus {
+			t.Fatalf("deploy status = %d, want %d", w.Code, dchook.DeployAcceptedStatus)
+		}
+
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+
+		deployment, found := cfg.history.Get(response["deployment_id"])
+		if !found {
+			t.Fatal("deployment should be in the history")
+		}
+		return deployment
+	}
+
+	rejected := deploy("")
+	if rejected.Code != http.StatusConflict {
+		t.Fatalf("frozen deploy status = %d, want %d", rejected.Code, http.StatusConflict)
+	}
+	if !strings.Contains(rejected.Body.String(), `deployment freeze "incident"`) {
+		t.Errorf("frozen deploy body = %q, want the freeze", rejected.Body.String())
+	}
+	retryAfter, err := strconv.Atoi(rejected.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 3500 || retryAfter > 3600 {
+		t.Errorf("Retry-After = %q, want about an hour", rejected.Header().Get("Retry-After"))
+	}
+
+	if code := deploy(`,"override":"line\nbreak"`).Code; code != http.StatusBadRequest {
+		t.Errorf("invalid override status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	overridden := deploymentFor(deploy(`,"override":"checkout outage"`))
+	if overridden.Override == nil || overridden.Override.Reason != "checkout outage" ||
+		!strings.Contains(overridden.Override.Bypassed, "incident") {
+		t.Errorf("deployment override = %+v, want the reason recorded", overridden.Override)
+	}
+
+	queued := *schedule
+	queued.queue = true
+	cfg.schedule = &queued
+
+	scheduled := deploymentFor(deploy(""))
+	if scheduled.Status != statusScheduled || scheduled.ScheduledFor == nil ||
+		!scheduled.ScheduledFor.Equal(schedule.freezes[0].end) {
+		t.Errorf("deployment = %+v, want scheduled for the end of the freeze", scheduled)
+	}
+
+	// Queued deployments fail when the listener stops.
+	shutdown(cfg, time.Second, nil)
+	if deployment, _ := cfg.history.Get(scheduled.ID); deployment.Status != statusFailed {
+		t.Errorf("queued deployment status = %q, want %q", deployment.Status, statusFailed)
+	}
+
+	if code := deploy("").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("draining deploy status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestScheduleBeforeRateLimit(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	freezeFor := func(duration time.Duration) *deploymentSchedule {
+		now := time.Now()
+		return &deploymentSchedule{freezes: []scheduleFreezeRange{{
+			name:  "incident",
+			start: now.Add(-time.Hour),
+			end:   now.Add(duration),
+		}}}
+	}
+
+	t.Run("deploy", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &HandlerConfig{
+			dockerAvailable:   true,
+			ipExtractor:       ipExtractor,
+			version:           "v1.0.0",
+			commit:            "abc",
+			secret:            "test-secret",
+			allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+			adapter:           &MockAdapter{},
+			history:           NewDeploymentHistory(),
+			deployments:       NewDeploymentTracker(),
+			schedule:          freezeFor(100 * time.Millisecond),
+		}
+		// One deployment per window.
+		handler := createDeployHandler(
+			NewConfigStore(cfg, nil),
+			dchook.NewRateLimiter(1, time.Minute, 10, time.Hour, time.Hour),
+		)
+
+		deploy := func() int {
+			body := []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+				strconv.FormatInt(time.Now().UnixMicro(), 10) + `"},"payload":{}}`)
+			req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+			req.Header.Set(
+				"Dchook-Signature",
+				dchook.GenerateSignature(body, "test-secret", dchook.AlgorithmSHA256),
+			)
+			req.RemoteAddr = "192.0.2.1:12345"
+			w := httptest.NewRecorder()
+			handler(w, req)
+			return w.Code
+		}
+
+		if code := deploy(); code != http.StatusConflict {
+			t.Fatalf("frozen deploy status = %d, want %d", code, http.StatusConflict)
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		if code := deploy(); code != dchook.DeployAcceptedStatus {
+			t.Errorf("retried deploy status = %d, want %d", code, dchook.DeployAcceptedStatus)
+		}
+	})
+
+	t.Run("github", func(t *testing.T) {
+		t.Parallel()
+
+		secret := "github-secret"
+		handler, cfg := newForgeTestHandler(t, githubReceiver, &forgeConfig{
+			rules:  []eventRule{{event: "push", qualifier: "main"}},
+			secret: secret,
+		}, dchook.NewRateLimiter(1, time.Minute, 10, time.Hour, time.Hour))
+		cfg.schedule = freezeFor(100 * time.Millisecond)
+
+		body := `{"ref":"refs/heads/main"}`
+		push := func() int {
+			signature := "sha256=" + strings.TrimPrefix(
+				dchook.GenerateSignature([]byte(body), secret, dchook.AlgorithmSHA256),
+				"sha256:",
+			)
+			return sendForgeRequest(handler, "/deploy/github", body, map[string]string{
+				"X-Github-Event":      "push",
+				"X-Github-Delivery":   "d-1",
+				"X-Hub-Signature-256": signature,
+			}).Code
+		}
+
+		if code := push(); code != http.StatusConflict {
+			t.Fatalf("frozen push status = %d, want %d", code, http.StatusConflict)
+		}
+
+		time.Sleep(150 * time.Millisecond)
+		if code := push(); code != dchook.DeployAcceptedStatus {
+			t.Errorf("redelivered push status = %d, want %d", code, dchook.DeployAcceptedStatus)
+		}
+	})
+}
+
+func TestDeploymentQueue(t *testing.T) {
+	t.Parallel()
+
+	waitForStatus := func(history *DeploymentHistory, id, want string) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			deployment, _ := history.Get(id)
+			if deployment.Status == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("deployment %s status = %q, want %q", id, deployment.Status, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	frozenFor := func(duration time.Duration) *deploymentSchedule {
+		now := time.Now()
+		return &deploymentSchedule{queue: true, freezes: []scheduleFreezeRange{{
+			name:  "incident",
+			start: now.Add(-time.Hour),
+			end:   now.Add(duration),
+		}}}
+	}
+
+	app := &project{name: "app", adapter: &MockAdapter{}, history: NewDeploymentHistory()}
+	cfg := &HandlerConfig{
+		adapter:     &MockAdapter{},
+		history:     NewDeploymentHistory(),
+		deployments: NewDeploymentTracker(),
+		schedule:    frozenFor(100 * time.Millisecond),
+		projects:    map[string]*project{"app": app},
+	}
+	store := NewConfigStore(cfg, nil)
+	cfg.queue = NewDeploymentQueue(store.Load)
+
+	queue := func(cfg *HandlerConfig) string {
+		t.Helper()
+
+		options := deployOptions{}
+		if _, err := cfg.scheduleDeployment(&options, time.Now()); err != nil {
+			t.Fatalf("scheduleDeployment() error = %v", err)
+		}
+		id, err := startDeployment(context.Background(), cfg, nil, "", options, AuditRecord{})
+		if err != nil {
+			t.Fatalf("startDeployment() error = %v", err)
+		}
+		return id
+	}
+
+	appCfg, _ := cfg.forProject("app")
+	replaced := queue(cfg)
+	pending := queue(cfg)
+	other := queue(appCfg)
+
+	// A newer deployment replaces the queued deployment of the same project only.
+	waitForStatus(cfg.history, replaced, statusFailed)
+
+	// The schedule of the current configuration is checked when the freeze ends.
+	current := *cfg
+	current.schedule = frozenFor(time.Hour)
+	store.current.Store(&current)
+	time.Sleep(300 * time.Millisecond)
+	for _, id := range []string{pending, other} {
+		if deployment, _ := store.Load().findDeployment(id); deployment.Status != statusScheduled {
+			t.Errorf("deployment %s status = %q, want %q", id, deployment.Status, statusScheduled)
+		}
+	}
+
+	// Stopping the queue cancels the queued deployments.
+	cfg.queue.Stop()
+	for _, id := range []string{pending, other} {
+		if deployment, _ := store.Load().findDeployment(id); deployment.Status != statusFailed {
+			t.Errorf("deployment %s status = %q, want %q", id, deployment.Status, statusFailed)
+		}
+	}
+
+	if _, err := startDeployment(
+		context.Background(),
+		cfg,
+		nil,
+		"",
+		deployOptions{scheduledFor: time.Now().Add(time.Hour)},
+		AuditRecord{},
+	); !errors.Is(err, errShuttingDown) {
+		t.Errorf("startDeployment() error = %v, want %v", err, errShuttingDown)
+	}
+}
+
+func TestDeployWhenOpen(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	cfg := &HandlerConfig{
+		adapter:     &MockAdapter{},
+		history:     NewDeploymentHistory(),
+		deployments: NewDeploymentTracker(),
+		schedule: &deploymentSchedule{queue: true, freezes: []scheduleFreezeRange{{
+			name:  "incident",
+			start: now.Add(-time.Hour),
+			end:   now.Add(time.Hour),
+		}}},
+	}
+	store := NewConfigStore(cfg, nil)
+	cfg.queue = NewDeploymentQueue(store.Load)
+
+	options := deployOptions{}
+	if _, err := cfg.scheduleDeployment(&options, now); err != nil {
+		t.Fatalf("scheduleDeployment() error = %v", err)
+	}
+
+	// The schedule was removed by a reload before the deployment was queued.
+	current := *cfg
+	current.schedule = nil
+	store.current.Store(&current)
+
+	id, err := startDeployment(context.Background(), cfg, nil, "", options, AuditRecord{})
+	if err != nil {
+		t.Fatalf("startDeployment() error = %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deployment, _ := cfg.history.Get(id)
+		if deployment.Status == statusPending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deployment = %+v, want started", deployment)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
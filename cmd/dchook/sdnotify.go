@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sdNotifyReady    = "READY=1"
+	sdNotifyStopping = "STOPPING=1"
+	sdNotifyWatchdog = "WATCHDOG=1"
+)
+
+// sdNotify sends a state notification to the systemd service manager. It does nothing
+// unless NOTIFY_SOCKET is set (with `Type=notify`).
+func sdNotify(state string) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return
+	}
+
+	// Abstract namespace sockets start with @.
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix(
+		"unixgram",
+		nil,
+		&net.UnixAddr{Name: socketPath, Net: "unixgram"},
+	)
+	if err != nil {
+		slog.Warn("failed to notify systemd", "state", state, "error", err)
+		return
+	}
+	defer conn.Close() //nolint:errcheck // Best effort close in defer
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("failed to notify systemd", "state", state, "error", err)
+	}
+}
+
+// sdWatchdogInterval returns half of the systemd watchdog timeout (`WatchdogSec=`), or
+// zero if the watchdog is not enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdWatchdog pings the systemd watchdog every interval.
+func sdWatchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sdNotify(sdNotifyWatchdog)
+	}
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// allServices in the allowed services permits the selection of any deployed service.
+const allServices = "*"
+
+var (
+	errComposeServices   = errors.New("failed to get compose services")
+	errServiceSelection  = errors.New("service selection is not enabled")
+	errServiceNotAllowed = errors.New("service is not allowed")
+	errServiceUnknown    = errors.New("service is not deployed")
+)
+
+// selectServices validates services selected by a deploy request against the allowed
+// services. Returns the sorted services without duplicates, or nil if no services are
+// selected, which deploys all services. checkDeployed checks that they are deployed.
+func (cfg *HandlerConfig) selectServices(selected []string) ([]string, error) {
+	if len(selected) == 0 {
+		return nil, nil
+	}
+
+	if len(cfg.allowedServices) == 0 {
+		return nil, errServiceSelection
+	}
+
+	anyService := slices.Contains(cfg.allowedServices, allServices)
+	for _, svc := range selected {
+		if !anyService && !slices.Contains(cfg.allowedServices, svc) {
+			return nil, fmt.Errorf("%w: %q", errServiceNotAllowed, svc)
+		}
+	}
+
+	services := slices.Clone(selected)
+	slices.Sort(services)
+	return slices.Compact(services), nil
+}
+
+// deployedServices returns the services deployed by the adapter with the selected
+// profiles (the services in the compose files, without excepted services), if the deploy
+// request selects services or pins images. Returns nil otherwise, without running
+// docker compose.
+func (cfg *HandlerConfig) deployedServices(
+	ctx context.Context,
+	options deployOptions,
+) ([]string, error) {
+	if len(options.services) == 0 && len(options.images) == 0 {
+		return nil, nil
+	}
+
+	deployed, err := cfg.adapter.Services(ctx, options.profiles)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errComposeServices, err)
+	}
+	return deployed, nil
+}
+
+// checkDeployed checks that the services selected by a deploy request and the services
+// that it pins images for are deployed.
+func checkDeployed(deployed []string, services []string, images map[string]string) error {
+	for _, svc := range services {
+		if !slices.Contains(deployed, svc) {
+			return fmt.Errorf("%w: %q", errServiceUnknown, svc)
+		}
+	}
+
+	for _, svc := range slices.Sorted(maps.Keys(images)) {
+		if !slices.Contains(deployed, svc) {
+			return fmt.Errorf("%w: %q", errServiceUnknown, svc)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, such as a list of services, ignoring blank
+// entries.
+func splitList(value string) []string {
+	var entries []string
+	for entry := range strings.SplitSeq(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestSelectServices(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		allowed  []string
+		selected []string
+		want     []string
+		wantErr  error
+	}{
+		{"no selection", nil, nil, nil, nil},
+		{"no selection with allowlist", []string{"web"}, nil, nil, nil},
+		{"selection disabled", nil, []string{"web"}, nil, errServiceSelection},
+		{
+			"allowed",
+			[]string{"web", "worker"},
+			[]string{"worker", "web", "worker"},
+			[]string{"web", "worker"},
+			nil,
+		},
+		{"any service", []string{allServices}, []string{"db"}, []string{"db"}, nil},
+		{"not allowed", []string{"web"}, []string{"db"}, nil, errServiceNotAllowed},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &HandlerConfig{allowedServices: testCase.allowed}
+
+			services, err := cfg.selectServices(testCase.selected)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("selectServices() error = %v, want %v", err, testCase.wantErr)
+			}
+			if !slices.Equal(services, testCase.want) {
+				t.Errorf("selectServices() = %v, want %v", services, testCase.want)
+			}
+		})
+	}
+}
+
+func TestDeployOptionsServices(t *testing.T) {
+	t.Parallel()
+
+	errCompose := errors.New("compose failed")
+	pin := map[string]string{"web": "ghcr.io/org/web@" + testDigest}
+
+	tests := []struct {
+		name        string
+		services    []string
+		images      map[string]string
+		servicesErr error
+		git         *gitSource
+		wantCalls   int32
+		wantErr     error
+	}{
+		{"no selection", nil, nil, nil, nil, 0, nil},
+		{"services and pins", []string{"web", "worker"}, pin, nil, nil, 1, nil},
+		{"pins only", nil, pin, nil, nil, 1, nil},
+		{"not allowed", []string{"db"}, nil, nil, nil, 0, errServiceNotAllowed},
+		{"not deployed", []string{"cron"}, nil, nil, nil, 1, errServiceUnknown},
+		{"compose error", []string{"web"}, nil, errCompose, nil, 1, errComposeServices},
+		// The deployment checks the services of the ref that it checks out.
+		{"git source", []string{"cron"}, nil, nil, &gitSource{}, 0, nil},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			adapter := &MockAdapter{
+				ServiceList: []string{"web", "worker"},
+				ServicesErr: testCase.servicesErr,
+			}
+			cfg := &HandlerConfig{
+				allowedServices:   []string{"web", "worker", "cron"},
+				imageRepositories: map[string][]string{"web": {"ghcr.io/org/web"}},
+				adapter:           adapter,
+				git:               testCase.git,
+			}
+
+			_, err := cfg.deployOptions(
+				t.Context(),
+				testCase.services,
+				nil,
+				testCase.images,
+				"",
+				nil,
+			)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("deployOptions() error = %v, want %v", err, testCase.wantErr)
+			}
+			if calls := adapter.ServicesCalls.Load(); calls != testCase.wantCalls {
+				t.Errorf("Services() calls = %d, want %d", calls, testCase.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDeployHandlerServices(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		version:           "v1.0.0",
+		commit:            "abc",
+		secret:            "test-secret",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{ServiceList: []string{"web", "worker", "db"}},
+		history:           NewDeploymentHistory(),
+		allowedServices:   []string{"web", "worker"},
+		imageRepositories: map[string][]string{"web": {"ghcr.io/org/web"}},
+	}
+	handler := createDeployHandler(
+		NewConfigStore(cfg, nil),
+		dchook.NewRateLimiter(100, time.Minute, 100, time.Hour, time.Hour),
+	)
+
+	timestamp := time.Now().UnixMicro()
+	deploy := func(options string) *httptest.ResponseRecorder {
+		timestamp++
+		body := []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+			strconv.FormatInt(timestamp, 10) + `",` + options + `},"payload":{}}`)
+		req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set(
+			"Dchook-Signature",
+			dchook.GenerateSignature(body, "test-secret", dchook.AlgorithmSHA256),
+		)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	accepted := deploy(`"services":["worker","web"],"images":{"web":"ghcr.io/org/web@` +
+		testDigest + `"}`)
+	if accepted.Code != dchook.DeployAcceptedStatus {
+		t.Fatalf("deploy status = %d, want %d", accepted.Code, dchook.DeployAcceptedStatus)
+	}
+
+	var response map[string]string
+	if err := json.Unmarshal(accepted.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	deployment, found := cfg.history.Get(response["deployment_id"])
+	if !found {
+		t.Fatal("deployment should be in the history")
+	}
+	if !slices.Equal(deployment.Services, []string{"web", "worker"}) {
+		t.Errorf("deployment services = %v, want [web worker]", deployment.Services)
+	}
+	if deployment.Images["web"] != "ghcr.io/org/web@"+testDigest {
+		t.Errorf("deployment images = %v, want web pinned", deployment.Images)
+	}
+
+	for _, testCase := range []struct {
+		name    string
+		options string
+		want    int
+	}{
+		{"not allowed", `"services":["db"]`, http.StatusBadRequest},
+		{"pin not allowed", `"images":{"web":"ghcr.io/evil/web@` + testDigest + `"}`, 400},
+		{"all services", `"services":null`, dchook.DeployAcceptedStatus},
+	} {
+		if code := deploy(testCase.options).Code; code != testCase.want {
+			t.Errorf("%s status = %d, want %d", testCase.name, code, testCase.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+// defaultShutdownTimeout is how long shutdown waits for running deployments when no
+// shutdown timeout is configured.
+const defaultShutdownTimeout = time.Minute
+
+var (
+	errShutdownDeadline = errors.New("shutdown timeout exceeded")
+	errShutdownSignal   = errors.New("second stop signal received")
+	errShutdownTimeout  = errors.New("invalid shutdown timeout")
+	errShuttingDown     = errors.New("shutting down")
+)
+
+// DeploymentTracker tracks running deployments so that shutdown can wait for them to
+// finish. Once draining, no new deployments are accepted.
+type DeploymentTracker struct {
+	mutex    sync.Mutex
+	running  map[string]bool
+	idle     chan struct{} // closed when the last deployment finishes while draining
+	draining atomic.Bool
+	onFinish func(id string)
+	ctx      context.Context //nolint:containedctx // Cancels deployments on shutdown
+	cancel   context.CancelFunc
+}
+
+// NewDeploymentTracker creates a tracker with no running deployments.
+func NewDeploymentTracker() *DeploymentTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DeploymentTracker{running: make(map[string]bool), ctx: ctx, cancel: cancel}
+}
+
+// OnFinish sets a function called with the ID of each deployment when it finishes. It
+// must be set before any deployment is started.
+func (t *DeploymentTracker) OnFinish(fn func(id string)) {
+	t.onFinish = fn
+}
+
+// Running returns the number of running deployments.
+func (t *DeploymentTracker) Running() int {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.running)
+}
+
+// Draining reports whether shutdown has started. New deployments must be rejected.
+func (t *DeploymentTracker) Draining() bool {
+	return t != nil && t.draining.Load()
+}
+
+// Go runs the deployment in a new goroutine. The context is cancelled if the deployment
+// is still running when the shutdown timeout expires. Returns an error without running
+// the deployment if draining.
+func (t *DeploymentTracker) Go(id string, run func(ctx context.Context)) error {
+	t.mutex.Lock()
+	if t.draining.Load() {
+		t.mutex.Unlock()
+		return errShuttingDown
+	}
+	t.running[id] = true
+	t.mutex.Unlock()
+
+	go func() {
+		defer t.finish(id)
+		run(t.ctx)
+
+		if t.onFinish != nil {
+			t.onFinish(id)
+		}
+	}()
+	return nil
+}
+
+// Drain stops accepting deployments and waits for running deployments to finish or for
+// ctx to be done. Returns the IDs of the deployments that were still running, whose
+// contexts are cancelled, and the cause of ctx if it ended the wait.
+func (t *DeploymentTracker) Drain(ctx context.Context) ([]string, error) {
+	t.mutex.Lock()
+	t.draining.Store(true)
+	if len(t.running) == 0 {
+		t.mutex.Unlock()
+		return nil, nil
+	}
+	idle := make(chan struct{})
+	t.idle = idle
+	t.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil, nil
+	case <-ctx.Done():
+	}
+
+	t.mutex.Lock()
+	interrupted := slices.Sorted(maps.Keys(t.running))
+	t.mutex.Unlock()
+
+	t.cancel()
+	return interrupted, context.Cause(ctx)
+}
+
+func (t *DeploymentTracker) finish(id string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.running, id)
+	if len(t.running) == 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// shutdown cancels the queued deployments of cfg and drains the running deployments for
+// up to timeout, or until stop receives a second signal, and logs the deployments that
+// were interrupted with their status in the default or project history and the reason.
+func shutdown(cfg *HandlerConfig, timeout time.Duration, stop <-chan os.Signal) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), timeout, errShutdownDeadline)
+	defer cancel()
+
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	defer cancelCause(nil)
+
+	go func() {
+		select {
+		case <-stop:
+			cancelCause(errShutdownSignal)
+		case <-ctx.Done():
+		}
+	}()
+
+	cfg.queue.Stop()
+	interrupted, reason := cfg.deployments.Drain(ctx)
+	for _, id := range interrupted {
+		status := statusPending
+		if deployment, found := cfg.findDeployment(id); found {
+			status = deployment.Status
+		}
+
+		slog.Warn(
+			"deployment interrupted",
+			"deployment_id",
+			id,
+			"status",
+			status,
+			"reason",
+			reason.Error(),
+			"timeout",
+			timeout.String(),
+		)
+	}
+}
+
+func parseShutdownTimeout() (time.Duration, error) {
+	//nolint:errcheck // Optional
+	value, _ := dchook.FlagValue(*shutdownTimeout, "DCHOOK_SHUTDOWN_TIMEOUT", "--shutdown-timeout")
+	if value == "" {
+		return defaultShutdownTimeout, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("%w: %q", errShutdownTimeout, value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestDeploymentTrackerDrain(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewDeploymentTracker()
+	release := make(chan struct{})
+	if err := tracker.Go("finishes", func(context.Context) { <-release }); err != nil {
+		t.Fatalf("Go() error = %v", err)
+	}
+
+	type drainResult struct {
+		interrupted []string
+		err         error
+	}
+	done := make(chan drainResult)
+	go func() {
+		interrupted, err := tracker.Drain(context.Background())
+		done <- drainResult{interrupted, err}
+	}()
+
+	for !tracker.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := tracker.Go("late", func(context.Context) {}); !errors.Is(err, errShuttingDown) {
+		t.Errorf("Go() while draining error = %v, want %v", err, errShuttingDown)
+	}
+
+	close(release)
+	if result := <-done; result.interrupted != nil || result.err != nil {
+		t.Errorf("Drain() = %v, %v, want none interrupted", result.interrupted, result.err)
+	}
+}
+
+func TestDeploymentTrackerDrainDeadline(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewDeploymentTracker()
+	cancelled := make(chan struct{})
+	if err := tracker.Go("stuck", func(ctx context.Context) {
+		<-ctx.Done()
+		close(cancelled)
+	}); err != nil {
+		t.Fatalf("Go() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(
+		context.Background(),
+		10*time.Millisecond,
+		errShutdownDeadline,
+	)
+	defer cancel()
+
+	interrupted, err := tracker.Drain(ctx)
+	if !slices.Equal(interrupted, []string{"stuck"}) || !errors.Is(err, errShutdownDeadline) {
+		t.Errorf("Drain() = %v, %v, want [stuck], %v", interrupted, err, errShutdownDeadline)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("interrupted deployment context was not cancelled")
+	}
+}
+
+func TestShutdownSecondSignal(t *testing.T) {
+	t.Parallel()
+
+	app := &project{name: "app", history: NewDeploymentHistory()}
+	app.history.Add(Deployment{ID: "stuck", Status: statusRestarting})
+	cfg := &HandlerConfig{
+		deployments: NewDeploymentTracker(),
+		history:     NewDeploymentHistory(),
+		projects:    map[string]*project{"app": app},
+	}
+	if err := cfg.deployments.Go("stuck", func(ctx context.Context) { <-ctx.Done() }); err != nil {
+		t.Fatalf("Go() error = %v", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+
+	start := time.Now()
+	shutdown(cfg, time.Hour, stop)
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("shutdown() waited %v after a second signal", elapsed)
+	}
+}
+
+func TestHandlersWhileDraining(t *testing.T) {
+	t.Parallel()
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		secret:            "test-secret",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+		deployments:       NewDeploymentTracker(),
+	}
+	//nolint:errcheck,gosec // No deployments are running
+	cfg.deployments.Drain(context.Background())
+	store := NewConfigStore(cfg, nil)
+
+	limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+	deploy := httptest.NewRecorder()
+	createDeployHandler(store, limiter)(
+		deploy,
+		httptest.NewRequest(http.MethodPost, "/deploy", strings.NewReader("{}")),
+	)
+	if deploy.Code != http.StatusServiceUnavailable {
+		t.Errorf("deploy status = %d, want %d", deploy.Code, http.StatusServiceUnavailable)
+	}
+
+	health := httptest.NewRecorder()
+	createHealthHandler(store)(health, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if health.Code != http.StatusServiceUnavailable ||
+		!strings.Contains(health.Body.String(), `"status":"stopping"`) {
+		t.Errorf("health = %d %s, want stopping", health.Code, health.Body.String())
+	}
+}
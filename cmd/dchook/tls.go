@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+// defaultTLSWatchInterval is the interval for checking the TLS files for changes when
+// TLS is enabled and no watch interval is configured.
+const defaultTLSWatchInterval = time.Minute
+
+var (
+	errTLSKeyMissing       = errors.New("TLS key is required with a TLS certificate")
+	errTLSCertMissing      = errors.New("TLS certificate is required with a TLS key")
+	errTLSClientCA         = errors.New("TLS client CA requires a TLS certificate")
+	errTLSClientSubjects   = errors.New("TLS client subjects require a TLS client CA")
+	errTLSDisabled         = errors.New("TLS is no longer configured")
+	errClientCertMissing   = errors.New("verified client certificate is required")
+	errClientCertNotPermit = errors.New("client certificate subject is not permitted")
+)
+
+// tlsConfig is the TLS configuration of the listener. With client CAs, clients may
+// present certificates, which are verified against the CAs. With client subjects,
+// /deploy requires a verified client certificate with one of the subject common names.
+type tlsConfig struct {
+	certificate    *tls.Certificate
+	clientCAs      *x509.CertPool
+	clientSubjects map[string]bool
+}
+
+// newServerTLSConfig returns a server TLS configuration that uses the current TLS
+// configuration in the store for each connection, so reloaded certificates and client
+// CAs take effect for new connections without a restart.
+func newServerTLSConfig(store *ConfigStore) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			current := store.Load().tls
+			if current == nil {
+				return nil, errTLSDisabled
+			}
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*current.certificate},
+			}
+			if current.clientCAs != nil {
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+				config.ClientCAs = current.clientCAs
+			}
+			return config, nil
+		},
+	}
+}
+
+// verifyClientCertificate returns the subject common name of the verified client
+// certificate, if any. Returns an error if client subjects are configured and the
+// request does not have a verified client certificate with one of them.
+func (cfg *HandlerConfig) verifyClientCertificate(r *http.Request) (string, error) {
+	var subject string
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		subject = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+
+	if cfg.tls == nil || len(cfg.tls.clientSubjects) == 0 {
+		return subject, nil
+	}
+
+	if subject == "" {
+		return "", errClientCertMissing
+	}
+
+	if !cfg.tls.clientSubjects[subject] {
+		return subject, fmt.Errorf("%w: %q", errClientCertNotPermit, subject)
+	}
+	return subject, nil
+}
+
+// loadTLSConfig reads the TLS certificate, key, and client CAs. Returns nil if no TLS
+// certificate or key is configured.
+func loadTLSConfig() (*tlsConfig, error) {
+	certPath, keyPath, clientCAPath := tlsFilePaths()
+
+	//nolint:errcheck // Optional
+	clientSubjects, _ := dchook.FlagValue(
+		*tlsClientSubjects,
+		"DCHOOK_TLS_CLIENT_SUBJECTS",
+		"--tls-client-subjects",
+	)
+
+	switch {
+	case certPath == "" && keyPath == "":
+		if clientCAPath != "" {
+			return nil, errTLSClientCA
+		}
+		if clientSubjects != "" {
+			return nil, errTLSClientSubjects
+		}
+		return nil, nil //nolint:nilnil // Not configured
+	case keyPath == "":
+		return nil, errTLSKeyMissing
+	case certPath == "":
+		return nil, errTLSCertMissing
+	case clientCAPath == "" && clientSubjects != "":
+		return nil, errTLSClientSubjects
+	}
+
+	certificate, err := dchook.ReadCertificateStrict(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+
+	config := &tlsConfig{certificate: certificate}
+
+	if clientCAPath != "" {
+		config.clientCAs, err = dchook.ReadCertPoolStrict(clientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+	}
+
+	if clientSubjects != "" {
+		config.clientSubjects = make(map[string]bool)
+		for subject := range strings.SplitSeq(clientSubjects, ",") {
+			if subject = strings.TrimSpace(subject); subject != "" {
+				config.clientSubjects[subject] = true
+			}
+		}
+	}
+	return config, nil
+}
+
+// tlsFilePaths returns the configured TLS certificate, key, and client CA file paths.
+func tlsFilePaths() (string, string, string) {
+	//nolint:errcheck // Optional
+	certPath, _ := dchook.FlagValue(*tlsCertFile, "DCHOOK_TLS_CERT", "--tls-cert")
+	//nolint:errcheck // Optional
+	keyPath, _ := dchook.FlagValue(*tlsKeyFile, "DCHOOK_TLS_KEY", "--tls-key")
+	//nolint:errcheck // Optional
+	clientCAPath, _ := dchook.FlagValue(*tlsClientCA, "DCHOOK_TLS_CLIENT_CA", "--tls-client-ca")
+	return certPath, keyPath, clientCAPath
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// issueCertificate issues a certificate for the common name signed by the parent, or a
+// self-signed CA certificate if parent is nil.
+func issueCertificate(
+	t *testing.T,
+	commonName string,
+	parent *tls.Certificate,
+) *tls.Certificate {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+
+	signer, signerKey := template, any(privateKey)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, publicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: privateKey, Leaf: leaf}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	ca := issueCertificate(t, "dchook test CA", nil)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+
+	cfg := &HandlerConfig{
+		tls: &tlsConfig{
+			certificate:    issueCertificate(t, "server", ca),
+			clientCAs:      clientCAs,
+			clientSubjects: map[string]bool{"ci": true},
+		},
+	}
+	store := NewConfigStore(cfg, nil)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			subject, err := store.Load().verifyClientCertificate(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			_, _ = io.WriteString(w, subject) //nolint:errcheck // Test response
+		},
+	))
+	server.TLS = newServerTLSConfig(store)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+
+	request := func(clientCert *tls.Certificate) (int, string, error) {
+		transport := &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+			DisableKeepAlives: true,
+		}
+		if clientCert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*clientCert}
+		}
+
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close() //nolint:errcheck // Best effort close in defer
+
+		body, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), err
+	}
+
+	untrusted := issueCertificate(t, "ci", issueCertificate(t, "other CA", nil))
+
+	tests := []struct {
+		name       string
+		clientCert *tls.Certificate
+		wantStatus int
+	}{
+		{"permitted subject", issueCertificate(t, "ci", ca), http.StatusOK},
+		{"other subject", issueCertificate(t, "dashboard", ca), http.StatusUnauthorized},
+		{"no certificate", nil, http.StatusUnauthorized},
+		// The client only sends certificates issued by the acceptable CAs.
+		{"untrusted CA", untrusted, http.StatusUnauthorized},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			status, body, err := request(testCase.clientCert)
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+
+			if status != testCase.wantStatus {
+				t.Errorf("request = %d %q, want %d", status, body, testCase.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServerTLSConfigReload(t *testing.T) {
+	t.Parallel()
+
+	ca := issueCertificate(t, "dchook test CA", nil)
+	store := NewConfigStore(&HandlerConfig{
+		tls: &tlsConfig{certificate: issueCertificate(t, "old", ca)},
+	}, nil)
+	config := newServerTLSConfig(store)
+
+	serverName := func() string {
+		connConfig, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("GetConfigForClient() error = %v", err)
+		}
+		return connConfig.Certificates[0].Leaf.Subject.CommonName
+	}
+
+	if name := serverName(); name != "old" {
+		t.Errorf("certificate = %q, want old", name)
+	}
+
+	store.current.Store(&HandlerConfig{
+		tls: &tlsConfig{certificate: issueCertificate(t, "new", ca)},
+	})
+	if name := serverName(); name != "new" {
+		t.Errorf("reloaded certificate = %q, want new", name)
+	}
+
+	store.current.Store(&HandlerConfig{})
+	if _, err := config.GetConfigForClient(&tls.ClientHelloInfo{}); !errors.Is(
+		err,
+		errTLSDisabled,
+	) {
+		t.Errorf("GetConfigForClient() error = %v, want %v", err, errTLSDisabled)
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+// Authentication schemes recorded on signature verification spans.
+const (
+	authSchemeDchook   = "dchook"
+	authSchemeRFC9421  = "rfc9421"
+	authSchemeOIDC     = "oidc"
+	authSchemeRegistry = "registry"
+)
+
+var (
+	errServerStatus     = errors.New("server error status")
+	errSignatureInvalid = errors.New("invalid signature")
+)
+
+// loadTracer creates a tracer that exports spans to the OTLP/HTTP endpoint from
+// `--otlp-endpoint` or DCHOOK_OTLP_ENDPOINT, and the URL that spans are sent to. Returns
+// nil if tracing is not configured.
+func loadTracer() (*dchook.Tracer, string, error) {
+	//nolint:errcheck // Optional
+	endpoint, _ := dchook.FlagValue(*otlpEndpoint, "DCHOOK_OTLP_ENDPOINT", "--otlp-endpoint")
+	if endpoint == "" {
+		return nil, "", nil
+	}
+
+	exporter, err := dchook.NewOTLPExporter(endpoint, "dchook", version)
+	if err != nil {
+		return nil, "", fmt.Errorf("tracing configuration: %w", err)
+	}
+
+	tracer := dchook.NewTracer(exporter, func(err error) {
+		slog.Warn("failed to export spans", "error", err)
+	})
+	return tracer, exporter.URL(), nil
+}
+
+// traced wraps a deploy handler in a server span that continues the trace from the W3C
+// traceparent header of the request, if any. The handler is returned unchanged if tracer
+// is nil.
+func traced(tracer *dchook.Tracer, route string, handler http.HandlerFunc) http.HandlerFunc {
+	if tracer == nil {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		parent, err := dchook.ParseTraceparent(r.Header.Get(dchook.TraceparentHeader))
+		if err == nil {
+			ctx = dchook.ContextWithRemoteSpanContext(ctx, parent)
+		}
+
+		ctx, span := tracer.Start(ctx, r.Method+" "+route, dchook.SpanKindServer)
+		defer span.End()
+
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("network.peer.address", r.RemoteAddr)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r.WithContext(ctx))
+
+		span.SetAttribute("http.response.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("%w: %d", errServerStatus, recorder.status))
+		}
+	}
+}
+
+// statusRecorder records the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// startVerifySpan starts a signature verification span for the authentication scheme,
+// if the request is traced.
+func startVerifySpan(r *http.Request, scheme string) *dchook.Span {
+	_, span := dchook.StartSpan(r.Context(), "verify signature")
+	span.SetAttribute("dchook.auth.scheme", scheme)
+	return span
+}
+
+// endVerifySpan ends a signature verification span, marking it failed with err if set.
+func endVerifySpan(span *dchook.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestTracedDeployHandler(t *testing.T) {
+	t.Parallel()
+
+	ipExtractor, err := dchook.NewIPExtractor(
+		dchook.DefaultTrustedProxies,
+		[]string{dchook.IPSourceXForwardedFor},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &HandlerConfig{
+		dockerAvailable:   true,
+		ipExtractor:       ipExtractor,
+		version:           "v1.0.0",
+		commit:            "abc",
+		secret:            "test-secret",
+		allowedAlgorithms: map[string]bool{dchook.AlgorithmSHA256: true},
+		adapter:           &MockAdapter{},
+		history:           NewDeploymentHistory(),
+	}
+	limiter := dchook.NewRateLimiter(10, time.Minute, 10, time.Hour, time.Hour)
+
+	exporter := &dchook.InMemoryExporter{}
+	tracer := dchook.NewTracer(exporter, nil)
+	handler := traced(tracer, "/deploy", createDeployHandler(NewConfigStore(cfg, nil), limiter))
+
+	body := []byte(`{"dchook":{"version":"v1.0.0","commit":"abc","timestamp":"` +
+		strconv.FormatInt(time.Now().UnixMicro(), 10) + `"},"payload":{}}`)
+	req := httptest.NewRequest(http.MethodPost, "/deploy", bytes.NewReader(body))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(
+		"Dchook-Signature",
+		dchook.GenerateSignature(body, "test-secret", dchook.AlgorithmSHA256),
+	)
+	req.Header.Set("Traceparent", testTraceparent)
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+
+	if recorder.Code != dchook.DeployAcceptedStatus {
+		t.Fatalf("status = %d, want %d", recorder.Code, dchook.DeployAcceptedStatus)
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+
+	parent, err := dchook.ParseTraceparent(testTraceparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify, request := spans[0], spans[1]
+	if request.Name != "POST /deploy" || request.ParentSpanID != parent.SpanID ||
+		request.SpanContext.TraceID != parent.TraceID {
+		t.Errorf("request span = %+v", request)
+	}
+	if status := request.Attribute("http.response.status_code"); status != http.StatusAccepted {
+		t.Errorf("request span status code = %v", status)
+	}
+	if verify.Name != "verify signature" || verify.ParentSpanID != request.SpanContext.SpanID ||
+		verify.Error != "" || verify.Attribute("dchook.auth.scheme") != authSchemeDchook {
+		t.Errorf("verify span = %+v", verify)
+	}
+
+	var response map[string]string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	deployment, found := cfg.history.Get(response["deployment_id"])
+	if !found || deployment.TraceID != parent.TraceID.String() {
+		t.Errorf("deployment trace ID = %q, want %s", deployment.TraceID, parent.TraceID)
+	}
+}
+
+func TestDockerComposeAdapterSpans(t *testing.T) {
+	t.Parallel()
+
+	exporter := &dchook.InMemoryExporter{}
+	tracer := dchook.NewTracer(exporter, nil)
+	ctx, request := tracer.Start(context.Background(), "POST /deploy", dchook.SpanKindServer)
+
+	adapter := &DockerComposeAdapter{ComposeFile: "/nonexistent/docker-compose.yml"}
+	history := NewDeploymentHistory()
+	tracker := NewDeploymentTracker()
+	deployment := Deployment{ID: "traced", Status: statusPending}
+	history.Add(deployment)
+
+	if err := adapter.Deploy(ctx, &deployment, history, tracker); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	request.End()
+
+	if _, err := tracker.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	spans := map[string]dchook.SpanData{}
+	for _, span := range exporter.Spans() {
+		spans[span.Name] = span
+	}
+
+	pull, deploy := spans["docker compose pull"], spans["deployment"]
+	if deploy.ParentSpanID != request.SpanContext().SpanID ||
+		deploy.Attribute("dchook.deployment.status") != statusFailed || deploy.Error == "" {
+		t.Errorf("deployment span = %+v", deploy)
+	}
+	if pull.ParentSpanID != deploy.SpanContext.SpanID || pull.Error == "" {
+		t.Errorf("pull span = %+v", pull)
+	}
+
+	wantCommand := "docker compose -f /nonexistent/docker-compose.yml pull"
+	if command := pull.Attribute("process.command_line"); command != wantCommand {
+		t.Errorf("pull command = %v, want %q", command, wantCommand)
+	}
+	if exitCode, ok := pull.Attribute("process.exit.code").(int); !ok || exitCode == 0 {
+		t.Errorf("pull exit code = %v, want non-zero", pull.Attribute("process.exit.code"))
+	}
+	if _, found := spans["docker compose up"]; found {
+		t.Error("up span exported after a failed pull")
+	}
+}
@@ -2,5 +2,3 @@
 module github.com/halostatue/dchook
 
 go 1.24.9
-
-require github.com/abczzz13/clientip v0.0.7
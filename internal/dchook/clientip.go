@@ -0,0 +1,296 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Client IP sources. The direct peer address (RemoteAddr) is used unless the peer is a
+// trusted proxy and one of the configured header sources is present.
+const (
+	IPSourceRemoteAddr    = "remote-addr"
+	IPSourceXForwardedFor = "x-forwarded-for"
+	IPSourceXRealIP       = "x-real-ip"
+	IPSourceForwarded     = "forwarded"
+)
+
+var (
+	ErrIPSource         = errors.New("invalid client IP source")
+	ErrTrustedProxy     = errors.New("invalid trusted proxy")
+	ErrRemoteAddr       = errors.New("invalid remote address")
+	ErrForwardedAddress = errors.New("invalid forwarded address")
+)
+
+// DefaultTrustedProxies are the loopback ranges, trusted when no trusted proxies are
+// configured.
+var DefaultTrustedProxies = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+}
+
+// IPExtractor determines the client IP address of a request. Forwarding headers are only
+// honoured when the direct peer is a trusted proxy.
+type IPExtractor struct {
+	trustedProxies []netip.Prefix
+	sources        []string
+}
+
+// NewIPExtractor creates an extractor that trusts the forwarding header sources, in
+// order of preference, from the trusted proxies.
+func NewIPExtractor(trustedProxies []netip.Prefix, sources []string) (*IPExtractor, error) {
+	for _, source := range sources {
+		switch source {
+		case IPSourceXForwardedFor, IPSourceXRealIP, IPSourceForwarded:
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrIPSource, source)
+		}
+	}
+
+	return &IPExtractor{
+		trustedProxies: slices.Clone(trustedProxies),
+		sources:        slices.Clone(sources),
+	}, nil
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDR ranges and IP addresses.
+// Returns DefaultTrustedProxies for an empty value and no proxies for "none".
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	switch value {
+	case "":
+		return slices.Clone(DefaultTrustedProxies), nil
+	case "none":
+		return nil, nil
+	}
+
+	var prefixes []netip.Prefix
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q: %w", ErrTrustedProxy, entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrTrustedProxy, entry, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// ParseIPSources parses a comma-separated list of client IP header sources. Returns
+// X-Forwarded-For for an empty value and no header sources for "none".
+func ParseIPSources(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	switch value {
+	case "":
+		return []string{IPSourceXForwardedFor}, nil
+	case "none":
+		return nil, nil
+	}
+
+	var sources []string
+	for source := range strings.SplitSeq(value, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+		switch source {
+		case "":
+			continue
+		case IPSourceXForwardedFor, IPSourceXRealIP, IPSourceForwarded:
+			sources = append(sources, source)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrIPSource, source)
+		}
+	}
+	return sources, nil
+}
+
+// Trusted reports whether the address is a trusted proxy.
+func (e *IPExtractor) Trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range e.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractAddr returns the client IP address of the request and the source it was taken
+// from. Headers are used only when the peer is a trusted proxy; the first configured
+// header source present on the request is used. Returns an error if the remote address
+// is invalid, or if the selected header has no valid address that is not a trusted
+// proxy.
+func (e *IPExtractor) ExtractAddr(r *http.Request) (netip.Addr, string, error) {
+	peer, err := parseRemoteAddr(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, "", err
+	}
+
+	if !e.Trusted(peer) {
+		return peer, IPSourceRemoteAddr, nil
+	}
+
+	for _, source := range e.sources {
+		var addrs []netip.Addr
+		switch source {
+		case IPSourceXForwardedFor:
+			values := r.Header.Values("X-Forwarded-For")
+			if len(values) == 0 {
+				continue
+			}
+			addrs, err = parseForwardedFor(values)
+		case IPSourceXRealIP:
+			values := r.Header.Values("X-Real-Ip")
+			if len(values) == 0 {
+				continue
+			}
+			addrs, err = parseRealIP(values)
+		case IPSourceForwarded:
+			values := r.Header.Values("Forwarded")
+			if len(values) == 0 {
+				continue
+			}
+			addrs, err = parseForwarded(values)
+		}
+
+		if err != nil {
+			return netip.Addr{}, source, err
+		}
+
+		addr, err := e.rightmostUntrusted(addrs)
+		return addr, source, err
+	}
+	return peer, IPSourceRemoteAddr, nil
+}
+
+// rightmostUntrusted returns the address added by the nearest proxy that is not itself a
+// trusted proxy. Addresses to its left could have been spoofed by the client, so
+// malformed (invalid) addresses there are ignored. Returns an error if a malformed
+// address is found first, or if every address is trusted.
+func (e *IPExtractor) rightmostUntrusted(addrs []netip.Addr) (netip.Addr, error) {
+	for i := len(addrs) - 1; i >= 0; i-- {
+		switch {
+		case !addrs[i].IsValid():
+			return netip.Addr{}, fmt.Errorf(
+				"%w: malformed address from a trusted proxy",
+				ErrForwardedAddress,
+			)
+		case !e.Trusted(addrs[i]):
+			return addrs[i], nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("%w: no untrusted address", ErrForwardedAddress)
+}
+
+func parseRemoteAddr(remoteAddr string) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: %q", ErrRemoteAddr, remoteAddr)
+	}
+	return addr.Unmap().WithZone(""), nil
+}
+
+// parseForwardedFor parses X-Forwarded-For headers, which may be repeated and contain
+// comma-separated addresses. Malformed addresses are returned as invalid addresses.
+func parseForwardedFor(values []string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, value := range values {
+		for entry := range strings.SplitSeq(value, ",") {
+			//nolint:errcheck // Malformed addresses are invalid
+			addr, _ := parseForwardedAddr(strings.TrimSpace(entry))
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// parseRealIP parses an X-Real-IP header, which must contain exactly one address.
+func parseRealIP(values []string) ([]netip.Addr, error) {
+	if len(values) != 1 {
+		return nil, fmt.Errorf("%w: multiple X-Real-IP headers", ErrForwardedAddress)
+	}
+
+	addr, err := parseForwardedAddr(strings.TrimSpace(values[0]))
+	if err != nil {
+		return nil, err
+	}
+	return []netip.Addr{addr}, nil
+}
+
+// parseForwarded parses the `for` parameters of RFC 7239 Forwarded headers. Obfuscated
+// and unknown identifiers, which cannot be rate limited, and malformed addresses are
+// returned as invalid addresses.
+func parseForwarded(values []string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, value := range values {
+		for element := range strings.SplitSeq(value, ",") {
+			for pair := range strings.SplitSeq(element, ";") {
+				name, node, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(name), "for") {
+					continue
+				}
+
+				node = strings.TrimSpace(node)
+				if unquoted, ok := strings.CutPrefix(node, `"`); ok {
+					if node, ok = strings.CutSuffix(unquoted, `"`); !ok {
+						addrs = append(addrs, netip.Addr{})
+						continue
+					}
+				}
+
+				//nolint:errcheck // Malformed addresses are invalid
+				addr, _ := parseForwardedAddr(node)
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: no for parameter in Forwarded", ErrForwardedAddress)
+	}
+	return addrs, nil
+}
+
+// parseForwardedAddr parses an address with an optional port, where IPv6 addresses with
+// a port are enclosed in brackets.
+func parseForwardedAddr(value string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap().WithZone(""), nil
+	}
+
+	host := value
+	if unbracketed, ok := strings.CutPrefix(value, "["); ok {
+		host, ok = strings.CutSuffix(unbracketed, "]")
+		if !ok {
+			return netip.Addr{}, fmt.Errorf("%w: %q", ErrForwardedAddress, value)
+		}
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: %q", ErrForwardedAddress, value)
+	}
+	return addr.Unmap().WithZone(""), nil
+}
@@ -0,0 +1,209 @@
+package dchook_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestIPExtractorExtractAddr(t *testing.T) {
+	t.Parallel()
+
+	trusted, err := dchook.ParseTrustedProxies("10.0.0.0/8, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		sources    []string
+		remoteAddr string
+		headers    map[string]string
+		wantIP     string
+		wantSource string
+		wantErr    bool
+	}{
+		{
+			name:       "untrusted peer ignores headers",
+			sources:    []string{dchook.IPSourceXForwardedFor},
+			remoteAddr: "172.17.0.3:40000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.5"},
+			wantIP:     "172.17.0.3",
+			wantSource: dchook.IPSourceRemoteAddr,
+		},
+		{
+			name:       "trusted peer without header",
+			sources:    []string{dchook.IPSourceXForwardedFor},
+			remoteAddr: "10.0.0.1:40000",
+			wantIP:     "10.0.0.1",
+			wantSource: dchook.IPSourceRemoteAddr,
+		},
+		{
+			name:       "rightmost untrusted forwarded address",
+			sources:    []string{dchook.IPSourceXForwardedFor},
+			remoteAddr: "10.0.0.1:40000",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.99, 203.0.113.5, 10.0.0.2"},
+			wantIP:     "203.0.113.5",
+			wantSource: dchook.IPSourceXForwardedFor,
+		},
+		{
+			name:       "all forwarded addresses trusted",
+			sources:    []string{dchook.IPSourceXForwardedFor},
+			remoteAddr: "[::1]:40000",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			wantSource: dchook.IPSourceXForwardedFor,
+			wantErr:    true,
+		},
+		{
+			name:       "malformed address left of the client",
+			sources:    []string{dchook.IPSourceXForwardedFor},
+			remoteAddr: "10.0.0.1:40000",
+			headers:    map[string]string{"X-Forwarded-For": "junk, 203.0.113.5, 10.0.0.2"},
+			wantIP:     "203.0.113.5",
+			wantSource: dchook.IPSourceXForwardedFor,
+		},
+		{
+			name:       "malformed address from a trusted proxy",
+			sources:    []string{dchook.IPSourceXForwardedFor},
+			remoteAddr: "10.0.0.1:40000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.5, junk, 10.0.0.2"},
+			wantSource: dchook.IPSourceXForwardedFor,
+			wantErr:    true,
+		},
+		{
+			name:       "invalid forwarded address",
+			sources:    []string{dchook.IPSourceXForwardedFor},
+			remoteAddr: "10.0.0.1:40000",
+			headers:    map[string]string{"X-Forwarded-For": "not-an-ip"},
+			wantSource: dchook.IPSourceXForwardedFor,
+			wantErr:    true,
+		},
+		{
+			name:       "X-Real-IP",
+			sources:    []string{dchook.IPSourceXRealIP},
+			remoteAddr: "10.0.0.1:40000",
+			headers:    map[string]string{"X-Real-Ip": "203.0.113.7"},
+			wantIP:     "203.0.113.7",
+			wantSource: dchook.IPSourceXRealIP,
+		},
+		{
+			name:       "Forwarded with IPv6 and port",
+			sources:    []string{dchook.IPSourceForwarded},
+			remoteAddr: "10.0.0.1:40000",
+			headers: map[string]string{
+				"Forwarded": `for=192.0.2.60;proto=http, For="[2001:db8::1]:4711"`,
+			},
+			wantIP:     "2001:db8::1",
+			wantSource: dchook.IPSourceForwarded,
+		},
+		{
+			name:       "Forwarded with malformed address left of the client",
+			sources:    []string{dchook.IPSourceForwarded},
+			remoteAddr: "10.0.0.1:40000",
+			headers: map[string]string{
+				"Forwarded": `for="[2001:db8::1, for=192.0.2.60`,
+			},
+			wantIP:     "192.0.2.60",
+			wantSource: dchook.IPSourceForwarded,
+		},
+		{
+			name:       "Forwarded with obfuscated identifier",
+			sources:    []string{dchook.IPSourceForwarded},
+			remoteAddr: "10.0.0.1:40000",
+			headers:    map[string]string{"Forwarded": "for=_hidden"},
+			wantSource: dchook.IPSourceForwarded,
+			wantErr:    true,
+		},
+		{
+			name:       "first present source is used",
+			sources:    []string{dchook.IPSourceForwarded, dchook.IPSourceXForwardedFor},
+			remoteAddr: "10.0.0.1:40000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.5"},
+			wantIP:     "203.0.113.5",
+			wantSource: dchook.IPSourceXForwardedFor,
+		},
+		{
+			name:       "unconfigured source is ignored",
+			sources:    []string{dchook.IPSourceXForwardedFor},
+			remoteAddr: "10.0.0.1:40000",
+			headers:    map[string]string{"X-Real-Ip": "203.0.113.7"},
+			wantIP:     "10.0.0.1",
+			wantSource: dchook.IPSourceRemoteAddr,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			extractor, err := dchook.NewIPExtractor(trusted, testCase.sources)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = testCase.remoteAddr
+			for name, value := range testCase.headers {
+				req.Header.Set(name, value)
+			}
+
+			addr, source, err := extractor.ExtractAddr(req)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("ExtractAddr() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if source != testCase.wantSource {
+				t.Errorf("ExtractAddr() source = %q, want %q", source, testCase.wantSource)
+			}
+			if !testCase.wantErr && addr.String() != testCase.wantIP {
+				t.Errorf("ExtractAddr() = %v, want %v", addr, testCase.wantIP)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{"", dchook.DefaultTrustedProxies, false},
+		{"none", nil, false},
+		{
+			"10.1.2.3/8, 192.0.2.1",
+			[]netip.Prefix{
+				netip.MustParsePrefix("10.0.0.0/8"),
+				netip.MustParsePrefix("192.0.2.1/32"),
+			},
+			false,
+		},
+		{"10.0.0.0/33", nil, true},
+		{"proxy.example.com", nil, true},
+	}
+
+	for _, testCase := range tests {
+		got, err := dchook.ParseTrustedProxies(testCase.value)
+		if (err != nil) != testCase.wantErr {
+			t.Errorf("ParseTrustedProxies(%q) error = %v", testCase.value, err)
+			continue
+		}
+		if testCase.wantErr && !errors.Is(err, dchook.ErrTrustedProxy) {
+			t.Errorf("ParseTrustedProxies(%q) error = %v, want %v", testCase.value, err,
+				dchook.ErrTrustedProxy)
+		}
+		if !slices.Equal(got, testCase.want) {
+			t.Errorf("ParseTrustedProxies(%q) = %v, want %v", testCase.value, got, testCase.want)
+		}
+	}
+
+	if _, err := dchook.ParseIPSources("x-forwarded-for,via"); !errors.Is(err, dchook.ErrIPSource) {
+		t.Errorf("ParseIPSources() error = %v, want %v", err, dchook.ErrIPSource)
+	}
+}
@@ -0,0 +1,425 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// maxConfigFileSize is the maximum size of a configuration file.
+const maxConfigFileSize = 1 << 20
+
+var (
+	// ErrConfigFile is returned when a configuration file cannot be parsed.
+	ErrConfigFile = errors.New("invalid configuration file")
+
+	// ErrConfigFormat is returned when a configuration file is not TOML or YAML.
+	ErrConfigFormat = errors.New("configuration file must be .toml, .yaml, or .yml")
+)
+
+// configFile is the configuration file that FlagValue falls back to.
+var configFile atomic.Pointer[ConfigFile]
+
+// ConfigFile is a TOML or YAML configuration file. Settings are named after their
+// environment variables: keys are lowercased environment variable names without the
+// prefix, and nested keys are joined with underscores, so `oidc_issuer = "…"` and
+// `issuer = "…"` in an `[oidc]` table are both DCHOOK_OIDC_ISSUER with the DCHOOK_
+// prefix. Arrays are joined with commas.
+type ConfigFile struct {
+	path   string
+	prefix string
+	values map[string]string
+}
+
+// ReadConfigFile reads a TOML (.toml) or YAML (.yaml or .yml) configuration file with
+// the environment variable prefix.
+//
+// Only the subset of each format used for settings is supported: tables (mappings) of
+// strings, integers, booleans, and arrays (sequences) of those.
+func ReadConfigFile(path, prefix string) (*ConfigFile, error) {
+	var parse func(string) (map[string]string, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		parse = parseTOML
+	case ".yaml", ".yml":
+		parse = parseYAML
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrConfigFormat, path)
+	}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("read configuration file: %w", err)
+	}
+
+	if len(data) > maxConfigFileSize || !IsPrintableUTF8(data) {
+		return nil, fmt.Errorf("%w: %q is too large or not UTF-8 text", ErrConfigFile, path)
+	}
+
+	values, err := parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", path, err)
+	}
+
+	return &ConfigFile{path: path, prefix: prefix, values: values}, nil
+}
+
+// Path returns the path of the configuration file.
+func (f *ConfigFile) Path() string {
+	return f.path
+}
+
+// Value returns the value of the setting for the environment variable and whether it is
+// set in the file.
+func (f *ConfigFile) Value(envVar string) (string, bool) {
+	if f == nil || !strings.HasPrefix(envVar, f.prefix) {
+		return "", false
+	}
+
+	value, found := f.values[strings.ToLower(strings.TrimPrefix(envVar, f.prefix))]
+	return value, found
+}
+
+// EnvVars returns the environment variable names of the settings in the file, sorted.
+func (f *ConfigFile) EnvVars() []string {
+	envVars := make([]string, 0, len(f.values))
+	for key := range f.values {
+		envVars = append(envVars, f.prefix+strings.ToUpper(key))
+	}
+	slices.Sort(envVars)
+	return envVars
+}
+
+// SetConfigFile sets the configuration file that FlagValue and EnvValue fall back to
+// when a setting is not in the environment. A nil file removes the fallback.
+func SetConfigFile(file *ConfigFile) {
+	configFile.Store(file)
+}
+
+// EnvValue returns the value of the environment variable or, if it is not set, of the
+// setting in the configuration file.
+func EnvValue(envVar string) string {
+	if envVal := os.Getenv(envVar); envVal != "" {
+		return envVal
+	}
+
+	value, _ := configFile.Load().Value(envVar) //nolint:errcheck // Not an error
+	return value
+}
+
+func configError(line int, format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrConfigFile, line, fmt.Sprintf(format, args...))
+}
+
+func setConfigValue(values map[string]string, line int, path []string, value string) error {
+	key := strings.ToLower(strings.Join(path, "_"))
+	if _, found := values[key]; found {
+		return configError(line, "duplicate key %q", strings.Join(path, "."))
+	}
+	values[key] = value
+	return nil
+}
+
+// stripComment removes a `#` comment that is outside of quotes from the line.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitList splits the items of a flow sequence or array, without the brackets, at
+// commas outside of quotes. A trailing comma is allowed.
+func splitList(list string) []string {
+	var items []string
+	var quote rune
+	escaped := false
+	start := 0
+	for i, r := range list {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			items = append(items, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+
+	if last := strings.TrimSpace(list[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+func isBareKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, r := range key {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') &&
+			r != '_' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTOMLKey parses a bare or dotted TOML key.
+func parseTOMLKey(line int, key string) ([]string, error) {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+		if !isBareKey(parts[i]) {
+			return nil, configError(line, "unsupported key %q", strings.TrimSpace(key))
+		}
+	}
+	return parts, nil
+}
+
+func parseTOML(data string) (map[string]string, error) {
+	values := make(map[string]string)
+	lines := strings.Split(data, "\n")
+
+	var table []string
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") || !strings.HasSuffix(line, "]") {
+				return nil, configError(lineNumber, "unsupported table %q", line)
+			}
+
+			var err error
+			table, err = parseTOMLKey(lineNumber, line[1:len(line)-1])
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, configError(lineNumber, "expected key = value")
+		}
+
+		path, err := parseTOMLKey(lineNumber, key)
+		if err != nil {
+			return nil, err
+		}
+
+		// Arrays may continue over several lines.
+		value = strings.TrimSpace(value)
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") &&
+			i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+
+		parsed, err := parseTOMLValue(lineNumber, value, true)
+		if err != nil {
+			return nil, err
+		}
+
+		err = setConfigValue(values, lineNumber, slices.Concat(table, path), parsed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+func parseTOMLValue(line int, value string, allowArray bool) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''"):
+		return "", configError(line, "multi-line strings are not supported")
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", configError(line, "invalid string %s", value)
+		}
+		return unquoted, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") ||
+			strings.Contains(value[1:len(value)-1], "'") {
+			return "", configError(line, "invalid string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") && allowArray:
+		items := splitList(value[1 : len(value)-1])
+		for i, item := range items {
+			parsed, err := parseTOMLValue(line, item, false)
+			if err != nil {
+				return "", err
+			}
+			items[i] = parsed
+		}
+		return strings.Join(items, ","), nil
+	case value == "true" || value == "false":
+		return value, nil
+	}
+
+	number, err := strconv.ParseInt(value, 0, 64)
+	if err != nil {
+		return "", configError(line, "unsupported value %q", value)
+	}
+	return strconv.FormatInt(number, 10), nil
+}
+
+type yamlKey struct {
+	indent int
+	name   string
+	// pending is set until the key has a value or children.
+	pending bool
+}
+
+func parseYAML(data string) (map[string]string, error) {
+	values := make(map[string]string)
+	var parents []yamlKey
+
+	path := func() []string {
+		names := make([]string, 0, len(parents))
+		for _, parent := range parents {
+			names = append(names, parent.name)
+		}
+		return names
+	}
+
+	for i, rawLine := range strings.Split(data, "\n") {
+		lineNumber := i + 1
+		line := strings.TrimRight(stripComment(rawLine), " \t\r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || content == "---" || content == "..." {
+			continue
+		}
+
+		indent := len(line) - len(content)
+		if strings.HasPrefix(content, "\t") {
+			return nil, configError(lineNumber, "tabs are not allowed for indentation")
+		}
+
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			for len(parents) > 0 && parents[len(parents)-1].indent > indent {
+				parents = parents[:len(parents)-1]
+			}
+			if len(parents) == 0 || parents[len(parents)-1].indent > indent {
+				return nil, configError(lineNumber, "sequence item without a key")
+			}
+
+			item, err := parseYAMLScalar(lineNumber, strings.TrimSpace(content[1:]))
+			if err != nil {
+				return nil, err
+			}
+
+			key := strings.ToLower(strings.Join(path(), "_"))
+			parent := &parents[len(parents)-1]
+			if current, found := values[key]; found && !parent.pending {
+				values[key] = current + "," + item
+			} else if parent.pending {
+				values[key] = item
+				parent.pending = false
+			} else {
+				return nil, configError(lineNumber, "sequence item after a value")
+			}
+			continue
+		}
+
+		name, value, found := strings.Cut(content, ":")
+		if !found || (value != "" && value[0] != ' ') {
+			return nil, configError(lineNumber, "expected key: value")
+		}
+
+		name = strings.TrimSpace(name)
+		if !isBareKey(name) {
+			return nil, configError(lineNumber, "unsupported key %q", name)
+		}
+
+		for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+			parents = parents[:len(parents)-1]
+		}
+		if len(parents) > 0 {
+			parents[len(parents)-1].pending = false
+		}
+
+		value = strings.TrimSpace(value)
+		if value == "" {
+			parents = append(parents, yamlKey{indent: indent, name: name, pending: true})
+			continue
+		}
+
+		var parsed string
+		var err error
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			items := splitList(value[1 : len(value)-1])
+			for i, item := range items {
+				if items[i], err = parseYAMLScalar(lineNumber, item); err != nil {
+					return nil, err
+				}
+			}
+			parsed = strings.Join(items, ",")
+		} else if parsed, err = parseYAMLScalar(lineNumber, value); err != nil {
+			return nil, err
+		}
+
+		if err := setConfigValue(values, lineNumber, append(path(), name), parsed); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+func parseYAMLScalar(line int, value string) (string, error) {
+	switch {
+	case value == "":
+		return "", configError(line, "empty value")
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return "", configError(line, "invalid string %s", value)
+		}
+		return unquoted, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", configError(line, "invalid string %s", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	case strings.ContainsAny(value[:1], "[]{}&*!|>%@`") || strings.Contains(value, ": "):
+		return "", configError(line, "unsupported value %q", value)
+	}
+	return value, nil
+}
@@ -0,0 +1,197 @@
+package dchook_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	t.Parallel()
+
+	want := map[string]string{
+		"TEST_COMPOSE_FILE":        "/opt/app/compose.yml",
+		"TEST_COMPOSE_PROJECT":     "my-app",
+		"TEST_ALLOWED_ALGORITHMS":  "sha256,ed25519",
+		"TEST_OIDC_ISSUER":         "https://token.actions.githubusercontent.com",
+		"TEST_PROXY_PROTOCOL":      "true",
+		"TEST_DEPLOY_MAX_FAILURES": "3",
+		"TEST_EXCEPT_SERVICES":     "db,cache # not a comment",
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "toml",
+			file: "dchook.toml",
+			content: `# dchook configuration
+compose_file = "/opt/app/compose.yml"
+compose_project = 'my-app' # trailing comment
+allowed_algorithms = ["sha256", "ed25519"]
+proxy_protocol = true
+except_services = "db,cache # not a comment"
+
+[oidc]
+issuer = "https://token.actions.githubusercontent.com"
+
+[deploy]
+max_failures = 3
+`,
+		},
+		{
+			name: "toml multi-line array and dotted key",
+			file: "dchook.toml",
+			content: `compose.file = "/opt/app/compose.yml"
+compose.project = "my-app"
+allowed_algorithms = [
+  "sha256", # HMAC
+  "ed25519",
+]
+oidc_issuer = "https://token.actions.githubusercontent.com"
+proxy_protocol = true
+deploy.max_failures = 3
+except_services = "db,cache # not a comment"
+`,
+		},
+		{
+			name: "yaml",
+			file: "dchook.yaml",
+			content: `---
+# dchook configuration
+compose:
+  file: /opt/app/compose.yml
+  project: 'my-app'  # trailing comment
+allowed_algorithms:
+  - sha256
+  - "ed25519"
+oidc:
+  issuer: https://token.actions.githubusercontent.com
+proxy_protocol: true
+deploy:
+  max_failures: 3
+except_services: "db,cache # not a comment"
+`,
+		},
+		{
+			name: "yaml flow sequence",
+			file: "dchook.yml",
+			content: `compose_file: /opt/app/compose.yml
+compose_project: my-app
+allowed_algorithms: [sha256, ed25519]
+oidc_issuer: "https://token.actions.githubusercontent.com"
+proxy_protocol: true
+deploy_max_failures: 3
+except_services: 'db,cache # not a comment'
+`,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeConfigFile(t, testCase.file, testCase.content)
+			file, err := dchook.ReadConfigFile(path, "TEST_")
+			if err != nil {
+				t.Fatalf("ReadConfigFile() error = %v", err)
+			}
+
+			if got := len(file.EnvVars()); got != len(want) {
+				t.Errorf("EnvVars() = %v, want %d settings", file.EnvVars(), len(want))
+			}
+
+			for envVar, value := range want {
+				got, found := file.Value(envVar)
+				if !found || got != value {
+					t.Errorf("Value(%q) = %q, %v, want %q", envVar, got, found, value)
+				}
+			}
+
+			if _, found := file.Value("OTHER_COMPOSE_FILE"); found {
+				t.Error("Value() found a setting without the prefix")
+			}
+		})
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    error
+	}{
+		{"unknown format", "dchook.json", `{}`, dchook.ErrConfigFormat},
+		{"toml duplicate key", "a.toml", "port = 1\nport = 2\n", dchook.ErrConfigFile},
+		{
+			"toml nested duplicate",
+			"a.toml",
+			"oidc_issuer = \"a\"\n[oidc]\nissuer = \"b\"\n",
+			dchook.ErrConfigFile,
+		},
+		{"toml missing value", "a.toml", "port\n", dchook.ErrConfigFile},
+		{"toml array table", "a.toml", "[[clients]]\n", dchook.ErrConfigFile},
+		{"toml float", "a.toml", "port = 1.5\n", dchook.ErrConfigFile},
+		{"toml unterminated string", "a.toml", "port = \"1\n", dchook.ErrConfigFile},
+		{"toml multi-line string", "a.toml", "port = \"\"\"1\"\"\"\n", dchook.ErrConfigFile},
+		{"yaml tab indent", "a.yaml", "oidc:\n\tissuer: a\n", dchook.ErrConfigFile},
+		{"yaml block scalar", "a.yaml", "port: |\n  1\n", dchook.ErrConfigFile},
+		{"yaml anchor", "a.yaml", "port: &port 1\n", dchook.ErrConfigFile},
+		{"yaml mapping item", "a.yaml", "clients:\n  - name: ci\n", dchook.ErrConfigFile},
+		{"yaml orphan item", "a.yaml", "- a\n", dchook.ErrConfigFile},
+		{"yaml missing colon", "a.yaml", "port 1\n", dchook.ErrConfigFile},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := writeConfigFile(t, testCase.file, testCase.content)
+			if _, err := dchook.ReadConfigFile(path, "TEST_"); !errors.Is(err, testCase.want) {
+				t.Errorf("ReadConfigFile() error = %v, want %v", err, testCase.want)
+			}
+		})
+	}
+}
+
+func TestFlagValueConfigFile(t *testing.T) {
+	path := writeConfigFile(t, "dchook.toml", "setting = \"from-file\"\n")
+	file, err := dchook.ReadConfigFile(path, "DCHOOK_TEST_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dchook.SetConfigFile(file)
+	t.Cleanup(func() { dchook.SetConfigFile(nil) })
+
+	got, err := dchook.FlagValue("", "DCHOOK_TEST_SETTING", "-f")
+	if err != nil || got != "from-file" {
+		t.Errorf("FlagValue() = %q, %v, want file value", got, err)
+	}
+
+	t.Setenv("DCHOOK_TEST_SETTING", "from-env")
+	if got := dchook.EnvValue("DCHOOK_TEST_SETTING"); got != "from-env" {
+		t.Errorf("EnvValue() = %q, want environment value", got)
+	}
+	if got, _ = dchook.FlagValue("from-flag", "DCHOOK_TEST_SETTING", "-f"); got != "from-flag" {
+		t.Errorf("FlagValue() = %q, want flag value", got)
+	}
+}
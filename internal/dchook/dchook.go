@@ -1,5 +1,6 @@
 // Package dchook provides core functionality for secure webhook handling,
-// including HMAC signature verification, rate limiting, and version compatibility checks.
+// including HMAC and Ed25519 signature verification, rate limiting, and version
+// compatibility checks.
 package dchook
 
 import (
@@ -11,7 +12,6 @@ import (
 	"fmt"
 	"hash"
 	"net/http"
-	"os"
 	"strings"
 )
 
@@ -32,13 +32,27 @@ const (
 	AlgorithmSHA384 = "sha384"
 	// AlgorithmSHA512 is used for HMAC-SHA512.
 	AlgorithmSHA512 = "sha512"
+	// AlgorithmEd25519 is used for Ed25519 asymmetric signatures.
+	AlgorithmEd25519 = "ed25519"
 
 	// signatureParts is the expected number of parts in algorithm:hash format.
 	signatureParts = 2
+
+	// keyIDParam separates an optional key ID from the signature.
+	keyIDParam = ";keyid="
+
+	// maxKeyIDLength is the maximum length of a key ID.
+	maxKeyIDLength = 64
 )
 
-// ErrFlagRequired is returned when both flag and environment variable are empty.
-var ErrFlagRequired = errors.New("flag or environment variable required")
+var (
+	// ErrFlagRequired is returned when both flag and environment variable are empty.
+	ErrFlagRequired = errors.New("flag or environment variable required")
+
+	// ErrInvalidKeyID is returned when a key ID is empty, too long, or contains
+	// characters other than ASCII letters, digits, '.', '_', and '-'.
+	ErrInvalidKeyID = errors.New("invalid key ID")
+)
 
 // parseSignature splits a signature into algorithm and hash parts.
 // Returns empty strings if the signature format is invalid.
@@ -50,6 +64,53 @@ func parseSignature(signature string) (string, string) {
 	return parts[0], parts[1]
 }
 
+// FormatSignatureKeyID appends a key ID to a signature as "algorithm:hash;keyid=id".
+// The signature is returned unchanged if keyID is empty.
+func FormatSignatureKeyID(signature, keyID string) string {
+	if keyID == "" || signature == "" {
+		return signature
+	}
+	return signature + keyIDParam + keyID
+}
+
+// SplitSignatureKeyID splits a signature header value into the signature and the
+// optional key ID.
+func SplitSignatureKeyID(value string) (string, string) {
+	signature, keyID, found := strings.Cut(value, keyIDParam)
+	if !found {
+		return value, ""
+	}
+	return signature, keyID
+}
+
+// ValidateKeyID checks that a key ID is 1–64 ASCII letters, digits, '.', '_', or '-'.
+func ValidateKeyID(keyID string) error {
+	if keyID == "" || len(keyID) > maxKeyIDLength {
+		return fmt.Errorf("%w: %q", ErrInvalidKeyID, keyID)
+	}
+
+	for _, r := range keyID {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') &&
+			r != '.' && r != '_' && r != '-' {
+			return fmt.Errorf("%w: %q", ErrInvalidKeyID, keyID)
+		}
+	}
+	return nil
+}
+
+// SignatureAlgorithm returns the algorithm part of a signature in "algorithm:hash"
+// format, or an empty string if the signature format is invalid.
+func SignatureAlgorithm(signature string) string {
+	algorithm, _ := parseSignature(signature)
+	return algorithm
+}
+
+// IsHMACAlgorithm checks if algorithm is one of the supported HMAC algorithms.
+func IsHMACAlgorithm(algorithm string) bool {
+	return algorithm == AlgorithmSHA256 || algorithm == AlgorithmSHA384 ||
+		algorithm == AlgorithmSHA512
+}
+
 // IsVersionCompatible checks if client and server versions are compatible.
 func IsVersionCompatible(clientVer, serverVer, clientCommit, serverCommit string) bool {
 	client, err := ParseVersion(clientVer, clientCommit)
@@ -76,15 +137,16 @@ func IsPrintableUTF8(data []byte) bool {
 	return true
 }
 
-// FlagValue returns the value from flag if non-empty, otherwise from environment variable.
-// Returns an error if neither is set.
+// FlagValue returns the value from flag if non-empty, otherwise from environment variable,
+// otherwise from the configuration file set with SetConfigFile. Returns an error if none
+// is set.
 func FlagValue(flagVal, envVar, flagName string) (string, error) {
 	if flagVal != "" {
 		return flagVal, nil
 	}
 
-	if envVal := os.Getenv(envVar); envVal != "" {
-		return envVal, nil
+	if value := EnvValue(envVar); value != "" {
+		return value, nil
 	}
 
 	return "", fmt.Errorf(
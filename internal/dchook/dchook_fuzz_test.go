@@ -1,6 +1,7 @@
 package dchook_test
 
 import (
+	"net/http"
 	"testing"
 
 	"github.com/halostatue/dchook/internal/dchook"
@@ -20,6 +21,19 @@ func FuzzVerifySignature(f *testing.F) {
 	})
 }
 
+func FuzzVerifyEd25519Signature(f *testing.F) {
+	allowedAlgos := map[string]bool{"ed25519": true}
+	key := make([]byte, 32)
+
+	f.Add([]byte("payload"), "ed25519:abc123")
+	f.Add([]byte(""), "ed25519:")
+	f.Add([]byte("test"), "ed25519:zz")
+
+	f.Fuzz(func(_ *testing.T, payload []byte, sig string) {
+		_ = dchook.VerifyEd25519Signature(payload, sig, key, allowedAlgos)
+	})
+}
+
 func FuzzGenerateSignature(f *testing.F) {
 	f.Add([]byte("payload"), "secret", "sha256")
 	f.Add([]byte(""), "", "sha256")
@@ -52,3 +66,59 @@ func FuzzIsPrintableUTF8(f *testing.F) {
 		_ = dchook.IsPrintableUTF8(data)
 	})
 }
+
+func FuzzParseMessageSignature(f *testing.F) {
+	f.Add(
+		`dchook=("@method" "@path");created=1700000000;nonce="abc";alg="hmac-sha256"`,
+		`dchook=:YWJj:`,
+	)
+	f.Add(`sig=();alg=ed25519`, `sig=::`)
+	f.Add(`sig=("a\"b");created=-1`, `sig=:@@:`)
+	f.Add(``, ``)
+
+	f.Fuzz(func(_ *testing.T, input, signature string) {
+		header := http.Header{}
+		header.Set("Signature-Input", input)
+		header.Set("Signature", signature)
+		_, _ = dchook.ParseMessageSignature(header)
+	})
+}
+
+func FuzzVerifyContentDigest(f *testing.F) {
+	f.Add("sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:", []byte(""))
+	f.Add("sha-512=:YWJj:, sha-256=:YWJj:", []byte("abc"))
+	f.Add("unknown=:YWJj:", []byte("abc"))
+
+	f.Fuzz(func(_ *testing.T, header string, body []byte) {
+		_ = dchook.VerifyContentDigest(header, body)
+	})
+}
+
+func FuzzParseJWKS(f *testing.F) {
+	f.Add([]byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"` +
+		`11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`))
+	f.Add([]byte(`{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`))
+	f.Add([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"","y":""}]}`))
+	f.Add([]byte(`{}`))
+
+	f.Fuzz(func(_ *testing.T, data []byte) {
+		_, _ = dchook.ParseJWKS(data)
+	})
+}
+
+func FuzzVerifyJWT(f *testing.F) {
+	jwks, err := dchook.ParseJWKS([]byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"` +
+		`11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add("eyJhbGciOiJFZERTQSJ9.eyJzdWIiOiJ4In0.YWJj")
+	f.Add("eyJhbGciOiJub25lIn0.e30.")
+	f.Add("a.b.c")
+	f.Add("")
+
+	f.Fuzz(func(_ *testing.T, token string) {
+		_, _ = jwks.VerifyJWT(token)
+	})
+}
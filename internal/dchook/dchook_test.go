@@ -316,3 +316,74 @@ func TestIsPrintableUTF8(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitSignatureKeyID(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		value         string
+		wantSignature string
+		wantKeyID     string
+	}{
+		{"sha256:abc", "sha256:abc", ""},
+		{"sha256:abc;keyid=2026-10", "sha256:abc", "2026-10"},
+		{dchook.FormatSignatureKeyID("sha512:def", "old"), "sha512:def", "old"},
+		{dchook.FormatSignatureKeyID("sha512:def", ""), "sha512:def", ""},
+		{"", "", ""},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.value, func(t *testing.T) {
+			t.Parallel()
+			signature, keyID := dchook.SplitSignatureKeyID(testCase.value)
+			if signature != testCase.wantSignature || keyID != testCase.wantKeyID {
+				t.Errorf(
+					"SplitSignatureKeyID(%q) = %q, %q, want %q, %q",
+					testCase.value,
+					signature,
+					keyID,
+					testCase.wantSignature,
+					testCase.wantKeyID,
+				)
+			}
+		})
+	}
+}
+
+func TestParseKeySet(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "two keys with comments",
+			data: "# rotated 2026-10\n2026-09 old-secret\n\n2026-10\tnew-secret\n",
+			want: map[string]string{"2026-09": "old-secret", "2026-10": "new-secret"},
+		},
+		{name: "missing secret", data: "2026-10\n", wantErr: true},
+		{name: "extra field", data: "2026-10 new secret\n", wantErr: true},
+		{name: "invalid key ID", data: "key/1 secret\n", wantErr: true},
+		{name: "duplicate key ID", data: "a one\na two\n", wantErr: true},
+		{name: "empty", data: "# nothing\n", wantErr: true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := dchook.ParseKeySet(testCase.data)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("ParseKeySet() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if len(got) != len(testCase.want) {
+				t.Fatalf("ParseKeySet() = %v, want %v", got, testCase.want)
+			}
+			for keyID, secret := range testCase.want {
+				if got[keyID] != secret {
+					t.Errorf("ParseKeySet()[%q] = %q, want %q", keyID, got[keyID], secret)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RFC 9421 HTTP Message Signatures and RFC 9530 Content-Digest support. Only the parts
+// of RFC 8941 Structured Fields needed for the Signature-Input, Signature, and
+// Content-Digest headers are implemented.
+const (
+	// MessageSignatureLabel is the label used for signatures created by dchook-notify.
+	MessageSignatureLabel = "dchook"
+
+	// MessageAlgorithmHMACSHA256 is the RFC 9421 name for HMAC-SHA256.
+	MessageAlgorithmHMACSHA256 = "hmac-sha256"
+	// MessageAlgorithmEd25519 is the RFC 9421 name for Ed25519.
+	MessageAlgorithmEd25519 = "ed25519"
+
+	// ComponentMethod, ComponentPath, ComponentAuthority, and ComponentContentDigest are
+	// the covered components that bind a signature to a request.
+	ComponentMethod        = "@method"
+	ComponentPath          = "@path"
+	ComponentAuthority     = "@authority"
+	ComponentContentDigest = "content-digest"
+
+	componentQuery           = "@query"
+	componentSignatureParams = "@signature-params"
+
+	digestSHA256 = "sha-256"
+	digestSHA512 = "sha-512"
+)
+
+var (
+	// ErrMessageSignatureMissing is returned when a request has no Signature-Input or
+	// no Signature for its label.
+	ErrMessageSignatureMissing = errors.New("message signature missing")
+	// ErrMessageSignatureMalformed is returned when the signature headers cannot be
+	// parsed.
+	ErrMessageSignatureMalformed = errors.New("malformed message signature")
+	// ErrMessageSignatureAlgorithm is returned for missing or unsupported algorithms.
+	ErrMessageSignatureAlgorithm = errors.New("unsupported message signature algorithm")
+	// ErrMessageSignatureComponent is returned when a covered component is missing
+	// from the request or not supported.
+	ErrMessageSignatureComponent = errors.New("invalid covered component")
+	// ErrMessageSignatureInvalid is returned when the signature does not verify.
+	ErrMessageSignatureInvalid = errors.New("message signature does not verify")
+	// ErrContentDigest is returned when Content-Digest is missing or does not match the
+	// body.
+	ErrContentDigest = errors.New("content digest does not match body")
+)
+
+// MessageSignature is a parsed RFC 9421 signature.
+type MessageSignature struct {
+	Label      string
+	Components []string
+	Created    time.Time
+	Expires    time.Time
+	Nonce      string
+	KeyID      string
+	Algorithm  string
+	Signature  []byte
+
+	// params is the serialized signature parameters for @signature-params.
+	params string
+}
+
+// MessageSigner creates RFC 9421 signatures with an HMAC-SHA256 secret or an Ed25519
+// private key.
+type MessageSigner struct {
+	Algorithm  string
+	KeyID      string
+	Secret     string
+	PrivateKey ed25519.PrivateKey
+}
+
+// MessageAlgorithm returns the RFC 9421 algorithm name for a dchook algorithm, or "" if
+// RFC 9421 has no equivalent.
+func MessageAlgorithm(algorithm string) string {
+	switch algorithm {
+	case AlgorithmSHA256:
+		return MessageAlgorithmHMACSHA256
+	case AlgorithmEd25519:
+		return MessageAlgorithmEd25519
+	default:
+		return ""
+	}
+}
+
+// ContentDigest returns the RFC 9530 Content-Digest header value for the body.
+func ContentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return digestSHA256 + "=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// VerifyContentDigest checks the Content-Digest header value against the body. Every
+// supported digest (sha-256, sha-512) must match, and at least one must be present.
+func VerifyContentDigest(header string, body []byte) bool {
+	members, err := parseDictionary(header)
+	if err != nil {
+		return false
+	}
+
+	verified := false
+	for _, member := range members {
+		var sum []byte
+		switch member.key {
+		case digestSHA256:
+			digest := sha256.Sum256(body)
+			sum = digest[:]
+		case digestSHA512:
+			digest := sha512.Sum512(body)
+			sum = digest[:]
+		default:
+			continue
+		}
+
+		if member.bytes == nil || !hmac.Equal(member.bytes, sum) {
+			return false
+		}
+		verified = true
+	}
+	return verified
+}
+
+// Sign adds Signature-Input and Signature headers to the request, covering the method,
+// path, and authority, and the Content-Digest of the body, which is also added, if the
+// body is not nil.
+func (signer *MessageSigner) Sign(
+	r *http.Request,
+	body []byte,
+	nonce string,
+	created time.Time,
+) error {
+	components := []string{ComponentMethod, ComponentPath, ComponentAuthority}
+	if body != nil {
+		r.Header.Set("Content-Digest", ContentDigest(body))
+		components = append(components, ComponentContentDigest)
+	}
+
+	signature := &MessageSignature{
+		Label:      MessageSignatureLabel,
+		Components: components,
+		Created:    created,
+		Nonce:      nonce,
+		KeyID:      signer.KeyID,
+		Algorithm:  signer.Algorithm,
+	}
+	signature.params = signature.serializeParams()
+
+	base, err := signature.base(r)
+	if err != nil {
+		return err
+	}
+
+	switch signer.Algorithm {
+	case MessageAlgorithmHMACSHA256:
+		mac := hmac.New(sha256.New, []byte(signer.Secret))
+		mac.Write(base)
+		signature.Signature = mac.Sum(nil)
+	case MessageAlgorithmEd25519:
+		signature.Signature = ed25519.Sign(signer.PrivateKey, base)
+	default:
+		return fmt.Errorf("%w: %q", ErrMessageSignatureAlgorithm, signer.Algorithm)
+	}
+
+	r.Header.Set("Signature-Input", signature.Label+"="+signature.params)
+	r.Header.Set(
+		"Signature",
+		signature.Label+"=:"+base64.StdEncoding.EncodeToString(signature.Signature)+":",
+	)
+	return nil
+}
+
+// ParseMessageSignature parses the first signature in the Signature-Input header and
+// the matching entry in the Signature header. The alg parameter is required.
+func ParseMessageSignature(header http.Header) (*MessageSignature, error) {
+	inputs, err := parseDictionary(strings.Join(header.Values("Signature-Input"), ", "))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(inputs) == 0 {
+		return nil, ErrMessageSignatureMissing
+	}
+
+	input := inputs[0]
+	if input.items == nil {
+		return nil, fmt.Errorf(
+			"%w: signature input is not an inner list",
+			ErrMessageSignatureMalformed,
+		)
+	}
+
+	signatures, err := parseDictionary(strings.Join(header.Values("Signature"), ", "))
+	if err != nil {
+		return nil, err
+	}
+
+	signature := &MessageSignature{Label: input.key, Components: input.items}
+	for _, member := range signatures {
+		if member.key == input.key {
+			signature.Signature = member.bytes
+		}
+	}
+
+	if signature.Signature == nil {
+		return nil, fmt.Errorf("%w: no signature for %q", ErrMessageSignatureMissing, input.key)
+	}
+
+	for _, param := range input.params {
+		switch param.name {
+		case "created", "expires":
+			seconds, ok := param.value.(int64)
+			if !ok {
+				return nil, fmt.Errorf(
+					"%w: %s is not an integer",
+					ErrMessageSignatureMalformed,
+					param.name,
+				)
+			}
+
+			if param.name == "created" {
+				signature.Created = time.Unix(seconds, 0)
+			} else {
+				signature.Expires = time.Unix(seconds, 0)
+			}
+		case "nonce", "keyid", "alg":
+			value, ok := param.value.(string)
+			if !ok {
+				return nil, fmt.Errorf(
+					"%w: %s is not a string",
+					ErrMessageSignatureMalformed,
+					param.name,
+				)
+			}
+
+			switch param.name {
+			case "nonce":
+				signature.Nonce = value
+			case "keyid":
+				signature.KeyID = value
+			default:
+				signature.Algorithm = value
+			}
+		}
+	}
+
+	if signature.Algorithm != MessageAlgorithmHMACSHA256 &&
+		signature.Algorithm != MessageAlgorithmEd25519 {
+		return nil, fmt.Errorf("%w: %q", ErrMessageSignatureAlgorithm, signature.Algorithm)
+	}
+
+	signature.params = serializeInnerList(input.items, input.params)
+	return signature, nil
+}
+
+// Covers checks if the signature covers all the components.
+func (signature *MessageSignature) Covers(components ...string) bool {
+	for _, component := range components {
+		if !slices.Contains(signature.Components, component) {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks the signature over the request with the HMAC secret or Ed25519 public
+// key for the signature algorithm. If Content-Digest is covered, it is checked against
+// the body.
+func (signature *MessageSignature) Verify(
+	r *http.Request,
+	body []byte,
+	secret string,
+	publicKey ed25519.PublicKey,
+) error {
+	base, err := signature.base(r)
+	if err != nil {
+		return err
+	}
+
+	var valid bool
+	switch signature.Algorithm {
+	case MessageAlgorithmHMACSHA256:
+		// An empty HMAC key would accept signatures from anyone.
+		if secret != "" {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(base)
+			valid = hmac.Equal(mac.Sum(nil), signature.Signature)
+		}
+	case MessageAlgorithmEd25519:
+		valid = len(publicKey) == ed25519.PublicKeySize &&
+			ed25519.Verify(publicKey, base, signature.Signature)
+	}
+
+	if !valid {
+		return ErrMessageSignatureInvalid
+	}
+
+	if signature.Covers(ComponentContentDigest) &&
+		!VerifyContentDigest(r.Header.Get("Content-Digest"), body) {
+		return ErrContentDigest
+	}
+	return nil
+}
+
+// base builds the RFC 9421 signature base for the request.
+func (signature *MessageSignature) base(r *http.Request) ([]byte, error) {
+	var base strings.Builder
+	for _, component := range signature.Components {
+		var value string
+		switch component {
+		case ComponentMethod:
+			value = r.Method
+		case ComponentPath:
+			value = r.URL.EscapedPath()
+			if value == "" {
+				value = "/"
+			}
+		case componentQuery:
+			value = "?" + r.URL.RawQuery
+		case ComponentAuthority:
+			value = strings.ToLower(r.Host)
+		default:
+			if strings.HasPrefix(component, "@") || component != strings.ToLower(component) {
+				return nil, fmt.Errorf("%w: %q", ErrMessageSignatureComponent, component)
+			}
+
+			values := r.Header.Values(component)
+			if len(values) == 0 {
+				return nil, fmt.Errorf(
+					"%w: %q not present",
+					ErrMessageSignatureComponent,
+					component,
+				)
+			}
+
+			trimmed := make([]string, len(values))
+			for i, headerValue := range values {
+				trimmed[i] = strings.TrimSpace(headerValue)
+			}
+			value = strings.Join(trimmed, ", ")
+		}
+
+		base.WriteString(serializeString(component) + ": " + value + "\n")
+	}
+
+	base.WriteString(serializeString(componentSignatureParams) + ": " + signature.params)
+	return []byte(base.String()), nil
+}
+
+func (signature *MessageSignature) serializeParams() string {
+	params := []sfParam{{name: "created", value: signature.Created.Unix()}}
+	if !signature.Expires.IsZero() {
+		params = append(params, sfParam{name: "expires", value: signature.Expires.Unix()})
+	}
+	if signature.Nonce != "" {
+		params = append(params, sfParam{name: "nonce", value: signature.Nonce})
+	}
+	if signature.KeyID != "" {
+		params = append(params, sfParam{name: "keyid", value: signature.KeyID})
+	}
+	params = append(params, sfParam{name: "alg", value: signature.Algorithm})
+	return serializeInnerList(signature.Components, params)
+}
@@ -0,0 +1,301 @@
+package dchook_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func newSignedRequest(
+	t *testing.T,
+	signer *dchook.MessageSigner,
+	method, target string,
+	body []byte,
+) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	if err := signer.Sign(req, body, "nonce-1", time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	return req
+}
+
+func TestMessageSignatureRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey := generateEd25519Key(t)
+	body := []byte(`{"version":"1.0.0"}`)
+
+	tests := []struct {
+		name   string
+		signer *dchook.MessageSigner
+	}{
+		{
+			name: "hmac-sha256",
+			signer: &dchook.MessageSigner{
+				Algorithm: dchook.MessageAlgorithmHMACSHA256,
+				KeyID:     "2026-10",
+				Secret:    "secret",
+			},
+		},
+		{
+			name: "ed25519",
+			signer: &dchook.MessageSigner{
+				Algorithm:  dchook.MessageAlgorithmEd25519,
+				PrivateKey: privateKey,
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := newSignedRequest(t, testCase.signer, http.MethodPost, "/deploy", body)
+
+			signature, err := dchook.ParseMessageSignature(req.Header)
+			if err != nil {
+				t.Fatalf("ParseMessageSignature() error = %v", err)
+			}
+
+			if signature.Label != dchook.MessageSignatureLabel ||
+				signature.Algorithm != testCase.signer.Algorithm ||
+				signature.KeyID != testCase.signer.KeyID ||
+				signature.Nonce != "nonce-1" ||
+				signature.Created.Unix() != 1700000000 {
+				t.Errorf("ParseMessageSignature() = %+v", signature)
+			}
+
+			if !signature.Covers(
+				dchook.ComponentMethod,
+				dchook.ComponentPath,
+				dchook.ComponentAuthority,
+				dchook.ComponentContentDigest,
+			) {
+				t.Errorf("signature covers %v", signature.Components)
+			}
+
+			if err := signature.Verify(req, body, "secret", publicKey); err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestMessageSignatureVerifyRejectsChanges(t *testing.T) {
+	t.Parallel()
+
+	signer := &dchook.MessageSigner{
+		Algorithm: dchook.MessageAlgorithmHMACSHA256,
+		Secret:    "secret",
+	}
+	body := []byte(`{"version":"1.0.0"}`)
+
+	tests := []struct {
+		name    string
+		modify  func(req *http.Request) []byte
+		secret  string
+		wantErr error
+	}{
+		{
+			name: "method",
+			modify: func(req *http.Request) []byte {
+				req.Method = http.MethodPut
+				return body
+			},
+			secret:  "secret",
+			wantErr: dchook.ErrMessageSignatureInvalid,
+		},
+		{
+			name: "path",
+			modify: func(req *http.Request) []byte {
+				req.URL.Path = "/deploy/status"
+				return body
+			},
+			secret:  "secret",
+			wantErr: dchook.ErrMessageSignatureInvalid,
+		},
+		{
+			name: "authority",
+			modify: func(req *http.Request) []byte {
+				req.Host = "attacker.example"
+				return body
+			},
+			secret:  "secret",
+			wantErr: dchook.ErrMessageSignatureInvalid,
+		},
+		{
+			name:    "body",
+			modify:  func(*http.Request) []byte { return []byte(`{"version":"2.0.0"}`) },
+			secret:  "secret",
+			wantErr: dchook.ErrContentDigest,
+		},
+		{
+			name:    "wrong secret",
+			modify:  func(*http.Request) []byte { return body },
+			secret:  "other",
+			wantErr: dchook.ErrMessageSignatureInvalid,
+		},
+		{
+			name:    "empty secret",
+			modify:  func(*http.Request) []byte { return body },
+			secret:  "",
+			wantErr: dchook.ErrMessageSignatureInvalid,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := newSignedRequest(t, signer, http.MethodPost, "/deploy", body)
+			signature, err := dchook.ParseMessageSignature(req.Header)
+			if err != nil {
+				t.Fatalf("ParseMessageSignature() error = %v", err)
+			}
+
+			modified := testCase.modify(req)
+			err = signature.Verify(req, modified, testCase.secret, nil)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseMessageSignature(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		input     string
+		signature string
+		wantErr   error
+	}{
+		{
+			name:      "valid",
+			input:     `sig1=("@method" "@path");created=1700000000;keyid="k";alg="hmac-sha256"`,
+			signature: `sig1=:YWJj:`,
+		},
+		{
+			name:      "missing input",
+			signature: `sig1=:YWJj:`,
+			wantErr:   dchook.ErrMessageSignatureMissing,
+		},
+		{
+			name:      "missing signature",
+			input:     `sig1=("@method");alg="hmac-sha256"`,
+			signature: `sig2=:YWJj:`,
+			wantErr:   dchook.ErrMessageSignatureMissing,
+		},
+		{
+			name:      "missing algorithm",
+			input:     `sig1=("@method");created=1700000000`,
+			signature: `sig1=:YWJj:`,
+			wantErr:   dchook.ErrMessageSignatureAlgorithm,
+		},
+		{
+			name:      "unsupported algorithm",
+			input:     `sig1=("@method");alg="rsa-pss-sha512"`,
+			signature: `sig1=:YWJj:`,
+			wantErr:   dchook.ErrMessageSignatureAlgorithm,
+		},
+		{
+			name:      "not an inner list",
+			input:     `sig1=:YWJj:`,
+			signature: `sig1=:YWJj:`,
+			wantErr:   dchook.ErrMessageSignatureMalformed,
+		},
+		{
+			name:      "created not an integer",
+			input:     `sig1=("@method");created="now";alg="hmac-sha256"`,
+			signature: `sig1=:YWJj:`,
+			wantErr:   dchook.ErrMessageSignatureMalformed,
+		},
+		{
+			name:      "invalid base64",
+			input:     `sig1=("@method");alg="hmac-sha256"`,
+			signature: `sig1=:@@@:`,
+			wantErr:   dchook.ErrMessageSignatureMalformed,
+		},
+		{
+			name:      "component parameters",
+			input:     `sig1=("content-digest";sf);alg="hmac-sha256"`,
+			signature: `sig1=:YWJj:`,
+			wantErr:   dchook.ErrMessageSignatureMalformed,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			header := http.Header{}
+			if testCase.input != "" {
+				header.Set("Signature-Input", testCase.input)
+			}
+			header.Set("Signature", testCase.signature)
+
+			_, err := dchook.ParseMessageSignature(header)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("ParseMessageSignature() error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyContentDigest(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`{"hello": "world"}`)
+
+	// RFC 9530 Appendix B.1
+	if !dchook.VerifyContentDigest(
+		"sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:",
+		body,
+	) {
+		t.Error("RFC 9530 sha-256 example should verify")
+	}
+
+	if !dchook.VerifyContentDigest(dchook.ContentDigest(body), body) {
+		t.Error("ContentDigest() should verify")
+	}
+
+	if dchook.VerifyContentDigest(dchook.ContentDigest(body), []byte("other")) {
+		t.Error("digest of a different body should not verify")
+	}
+
+	if dchook.VerifyContentDigest("md5=:YWJj:", body) {
+		t.Error("unsupported digests only should not verify")
+	}
+
+	if dchook.VerifyContentDigest(
+		dchook.ContentDigest(body)+", sha-512=:YWJj:",
+		body,
+	) {
+		t.Error("any mismatched supported digest should not verify")
+	}
+}
+
+func TestMessageSignatureUnknownComponent(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodPost, "/deploy", strings.NewReader(""))
+	req.Header.Set("Signature-Input", `sig1=("@target-uri");alg="hmac-sha256"`)
+	req.Header.Set("Signature", `sig1=:YWJj:`)
+
+	signature, err := dchook.ParseMessageSignature(req.Header)
+	if err != nil {
+		t.Fatalf("ParseMessageSignature() error = %v", err)
+	}
+
+	err = signature.Verify(req, nil, "secret", nil)
+	if !errors.Is(err, dchook.ErrMessageSignatureComponent) {
+		t.Errorf("Verify() error = %v, want %v", err, dchook.ErrMessageSignatureComponent)
+	}
+}
@@ -0,0 +1,399 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JSON Web Token (RFC 7519) verification against a JSON Web Key Set (RFC 7517). Only
+// compact JWS tokens signed with RS256, ES256, or EdDSA (Ed25519) are supported.
+const (
+	// JWTAlgorithmRS256 is RSASSA-PKCS1-v1_5 with SHA-256.
+	JWTAlgorithmRS256 = "RS256"
+	// JWTAlgorithmES256 is ECDSA with P-256 and SHA-256.
+	JWTAlgorithmES256 = "ES256"
+	// JWTAlgorithmEdDSA is EdDSA with Ed25519.
+	JWTAlgorithmEdDSA = "EdDSA"
+
+	keyTypeRSA = "RSA"
+	keyTypeEC  = "EC"
+	keyTypeOKP = "OKP"
+
+	curveP256    = "P-256"
+	curveEd25519 = "Ed25519"
+
+	minRSAKeyBits      = 2048
+	maxRSAExponentSize = 4
+	p256CoordinateSize = 32
+	es256SignatureSize = 64
+	jwtParts           = 3
+
+	// maxClockSkew is the allowed clock difference for exp, nbf, and iat.
+	maxClockSkew = time.Minute
+)
+
+var (
+	// ErrJWKSInvalid is returned when a JWKS cannot be parsed or has no usable keys.
+	ErrJWKSInvalid = errors.New("invalid JWKS")
+	// ErrJWTMalformed is returned when a token is not a compact JWS.
+	ErrJWTMalformed = errors.New("malformed JWT")
+	// ErrJWTAlgorithm is returned for unsupported algorithms.
+	ErrJWTAlgorithm = errors.New("unsupported JWT algorithm")
+	// ErrJWTKeyNotFound is returned when no key in the set matches the token.
+	ErrJWTKeyNotFound = errors.New("no matching JWKS key")
+	// ErrJWTSignature is returned when the token signature does not verify.
+	ErrJWTSignature = errors.New("JWT signature does not verify")
+	// ErrJWTClaims is returned when the issuer, audience, or validity period is wrong.
+	ErrJWTClaims = errors.New("invalid JWT claims")
+
+	errJWKRSAKey     = errors.New("weak RSA key or exponent")
+	errJWKP256Key    = errors.New("invalid P-256 key")
+	errJWKEd25519Key = errors.New("invalid Ed25519 key")
+)
+
+// JWKS is a set of public keys for verifying JWTs.
+type JWKS struct {
+	keys []jsonWebKey
+}
+
+type jsonWebKey struct {
+	keyID     string
+	algorithm string
+	key       crypto.PublicKey
+}
+
+// JWT is a verified JSON Web Token.
+type JWT struct {
+	Algorithm string
+	KeyID     string
+	Claims    map[string]any
+}
+
+// ReadJWKSFileStrict reads a JWKS file, applying the same checks as
+// ReadSecretFileStrict.
+func ReadJWKSFileStrict(path string) (*JWKS, error) {
+	data, err := readSecretFile(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks, err := ParseJWKS([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS file %q: %w", path, err)
+	}
+	return jwks, nil
+}
+
+// ParseJWKS parses a JSON Web Key Set. RSA (at least 2048 bits), EC P-256, and OKP
+// Ed25519 signing keys are used; other keys are ignored. At least one key must be
+// usable.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []struct {
+			KeyType   string `json:"kty"`
+			KeyID     string `json:"kid"`
+			Algorithm string `json:"alg"`
+			Use       string `json:"use"`
+			Curve     string `json:"crv"`
+			N         string `json:"n"`
+			E         string `json:"e"`
+			X         string `json:"x"`
+			Y         string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJWKSInvalid, err)
+	}
+
+	jwks := &JWKS{}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		webKey := jsonWebKey{keyID: key.KeyID, algorithm: key.Algorithm}
+		var err error
+		switch {
+		case key.KeyType == keyTypeRSA:
+			webKey.key, err = parseRSAKey(key.N, key.E)
+		case key.KeyType == keyTypeEC && key.Curve == curveP256:
+			webKey.key, err = parseP256Key(key.X, key.Y)
+		case key.KeyType == keyTypeOKP && key.Curve == curveEd25519:
+			webKey.key, err = parseEd25519Key(key.X)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: key %q: %w", ErrJWKSInvalid, key.KeyID, err)
+		}
+		jwks.keys = append(jwks.keys, webKey)
+	}
+
+	if len(jwks.keys) == 0 {
+		return nil, fmt.Errorf("%w: no supported signing keys", ErrJWKSInvalid)
+	}
+	return jwks, nil
+}
+
+func parseRSAKey(n, e string) (*rsa.PublicKey, error) {
+	modulus, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(exponent) > maxRSAExponentSize {
+		return nil, errJWKRSAKey
+	}
+
+	key := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(modulus),
+		E: int(new(big.Int).SetBytes(exponent).Int64()),
+	}
+	if key.N.BitLen() < minRSAKeyBits || key.E < 3 || key.E%2 == 0 {
+		return nil, fmt.Errorf("%w (minimum %d bits)", errJWKRSAKey, minRSAKeyBits)
+	}
+	return key, nil
+}
+
+func parseP256Key(x, y string) (*ecdsa.PublicKey, error) {
+	xBytes, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+
+	yBytes, err := base64.RawURLEncoding.DecodeString(y)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(xBytes) != p256CoordinateSize || len(yBytes) != p256CoordinateSize {
+		return nil, errJWKP256Key
+	}
+
+	// crypto/ecdh rejects points that are not on the curve.
+	point := slices.Concat([]byte{4}, xBytes, yBytes)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("%w: %w", errJWKP256Key, err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(xBytes),
+		Y:     new(big.Int).SetBytes(yBytes),
+	}, nil
+}
+
+func parseEd25519Key(x string) (ed25519.PublicKey, error) {
+	key, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errJWKEd25519Key
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// VerifyJWT verifies the signature of a compact JWS token with a key from the set and
+// returns the token. If the token has a key ID, only the key with that ID is used;
+// otherwise every key for the algorithm is tried. The claims are not validated; see
+// ValidateClaims.
+func (jwks *JWKS) VerifyJWT(token string) (*JWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != jwtParts {
+		return nil, ErrJWTMalformed
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJWTMalformed, err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	found := false
+	verified := false
+	for _, webKey := range jwks.keys {
+		if header.KeyID != "" && webKey.keyID != header.KeyID {
+			continue
+		}
+
+		if webKey.algorithm != "" && webKey.algorithm != header.Algorithm {
+			continue
+		}
+
+		ok, supported := verifyJWS(header.Algorithm, webKey.key, signed, signature)
+		if !supported {
+			continue
+		}
+		found = true
+
+		if ok {
+			verified = true
+			break
+		}
+	}
+
+	switch {
+	case !slices.Contains(
+		[]string{JWTAlgorithmRS256, JWTAlgorithmES256, JWTAlgorithmEdDSA},
+		header.Algorithm,
+	):
+		return nil, fmt.Errorf("%w: %q", ErrJWTAlgorithm, header.Algorithm)
+	case !found:
+		return nil, fmt.Errorf("%w: kid %q", ErrJWTKeyNotFound, header.KeyID)
+	case !verified:
+		return nil, ErrJWTSignature
+	}
+
+	jwt := &JWT{Algorithm: header.Algorithm, KeyID: header.KeyID}
+	if err := decodeJWTPart(parts[1], &jwt.Claims); err != nil {
+		return nil, err
+	}
+	return jwt, nil
+}
+
+// verifyJWS verifies the signature with the key for the algorithm. Returns false for
+// supported if the key cannot be used with the algorithm.
+func verifyJWS(algorithm string, key crypto.PublicKey, signed, signature []byte) (bool, bool) {
+	digest := sha256.Sum256(signed)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm != JWTAlgorithmRS256 {
+			return false, false
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil, true
+	case *ecdsa.PublicKey:
+		if algorithm != JWTAlgorithmES256 {
+			return false, false
+		}
+		if len(signature) != es256SignatureSize {
+			return false, true
+		}
+		r := new(big.Int).SetBytes(signature[:p256CoordinateSize])
+		s := new(big.Int).SetBytes(signature[p256CoordinateSize:])
+		return ecdsa.Verify(key, digest[:], r, s), true
+	case ed25519.PublicKey:
+		if algorithm != JWTAlgorithmEdDSA {
+			return false, false
+		}
+		return ed25519.Verify(key, signed, signature), true
+	default:
+		return false, false
+	}
+}
+
+func decodeJWTPart(part string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrJWTMalformed, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(value); err != nil {
+		return fmt.Errorf("%w: %w", ErrJWTMalformed, err)
+	}
+	return nil
+}
+
+// ValidateClaims checks that the token was issued by the issuer for the audience and is
+// valid at the time: exp is required, and nbf and iat are checked if present, with one
+// minute of allowed clock skew.
+func (jwt *JWT) ValidateClaims(issuer, audience string, now time.Time) error {
+	if jwt.StringClaim("iss") != issuer {
+		return fmt.Errorf("%w: issuer %q", ErrJWTClaims, jwt.StringClaim("iss"))
+	}
+
+	if !jwt.hasAudience(audience) {
+		return fmt.Errorf("%w: audience does not include %q", ErrJWTClaims, audience)
+	}
+
+	expires, ok := jwt.timeClaim("exp")
+	if !ok || !now.Before(expires.Add(maxClockSkew)) {
+		return fmt.Errorf("%w: expired or missing exp", ErrJWTClaims)
+	}
+
+	if notBefore, ok := jwt.timeClaim("nbf"); ok && now.Add(maxClockSkew).Before(notBefore) {
+		return fmt.Errorf("%w: not yet valid", ErrJWTClaims)
+	}
+
+	if issuedAt, ok := jwt.timeClaim("iat"); ok && now.Add(maxClockSkew).Before(issuedAt) {
+		return fmt.Errorf("%w: issued in the future", ErrJWTClaims)
+	}
+	return nil
+}
+
+// StringClaim returns the claim if it is a string, or an empty string.
+func (jwt *JWT) StringClaim(name string) string {
+	value, _ := jwt.Claims[name].(string) //nolint:errcheck // Non-strings are empty
+	return value
+}
+
+// ID returns the jti claim.
+func (jwt *JWT) ID() string {
+	return jwt.StringClaim("jti")
+}
+
+// ValidUntil returns the last time that ValidateClaims accepts the token: its exp claim
+// plus the allowed clock skew. Returns the zero time if there is no exp claim.
+func (jwt *JWT) ValidUntil() time.Time {
+	expires, ok := jwt.timeClaim("exp")
+	if !ok {
+		return time.Time{}
+	}
+	return expires.Add(maxClockSkew)
+}
+
+func (jwt *JWT) hasAudience(audience string) bool {
+	switch aud := jwt.Claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []any:
+		return slices.Contains(aud, any(audience))
+	default:
+		return false
+	}
+}
+
+func (jwt *JWT) timeClaim(name string) (time.Time, bool) {
+	number, ok := jwt.Claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
@@ -0,0 +1,315 @@
+package dchook_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+var b64 = base64.RawURLEncoding
+
+type jwtKeys struct {
+	rsa     *rsa.PrivateKey
+	ecdsa   *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+	jwks    *dchook.JWKS
+}
+
+func newJWTKeys(t *testing.T) *jwtKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ed25519Key := generateEd25519Key(t)
+
+	ecdhKey, err := ecdsaKey.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaPoint := ecdhKey.Bytes()
+
+	data, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{
+			"kty": "RSA",
+			"kid": "rsa",
+			"alg": dchook.JWTAlgorithmRS256,
+			"use": "sig",
+			"n":   b64.EncodeToString(rsaKey.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			"kty": "EC",
+			"kid": "ec",
+			"crv": "P-256",
+			"x":   b64.EncodeToString(ecdsaPoint[1:33]),
+			"y":   b64.EncodeToString(ecdsaPoint[33:]),
+		},
+		{
+			"kty": "OKP",
+			"kid": "ed",
+			"crv": "Ed25519",
+			"x":   b64.EncodeToString(ed25519Key.Public().(ed25519.PublicKey)),
+		},
+		{"kty": "oct", "kid": "ignored", "k": "c2VjcmV0"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, err := dchook.ParseJWKS(data)
+	if err != nil {
+		t.Fatalf("ParseJWKS() error = %v", err)
+	}
+	return &jwtKeys{rsa: rsaKey, ecdsa: ecdsaKey, ed25519: ed25519Key, jwks: jwks}
+}
+
+// sign returns a compact JWS of the claims signed with the key for the algorithm.
+func (keys *jwtKeys) sign(t *testing.T, algorithm, keyID string, claims any) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch algorithm {
+	case dchook.JWTAlgorithmRS256:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, keys.rsa, crypto.SHA256, digest[:])
+	case dchook.JWTAlgorithmES256:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, keys.ecdsa, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case dchook.JWTAlgorithmEdDSA:
+		signature = ed25519.Sign(keys.ed25519, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(signature)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	t.Parallel()
+
+	keys := newJWTKeys(t)
+	claims := map[string]any{"sub": "repo:example/app:ref:refs/heads/main"}
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr error
+	}{
+		{
+			name:  "RS256",
+			token: func() string { return keys.sign(t, dchook.JWTAlgorithmRS256, "rsa", claims) },
+		},
+		{
+			name:  "ES256",
+			token: func() string { return keys.sign(t, dchook.JWTAlgorithmES256, "ec", claims) },
+		},
+		{
+			name:  "EdDSA",
+			token: func() string { return keys.sign(t, dchook.JWTAlgorithmEdDSA, "ed", claims) },
+		},
+		{
+			name:  "no key ID",
+			token: func() string { return keys.sign(t, dchook.JWTAlgorithmES256, "", claims) },
+		},
+		{
+			name:    "unknown key ID",
+			token:   func() string { return keys.sign(t, dchook.JWTAlgorithmRS256, "old", claims) },
+			wantErr: dchook.ErrJWTKeyNotFound,
+		},
+		{
+			name:    "algorithm for another key",
+			token:   func() string { return keys.sign(t, dchook.JWTAlgorithmEdDSA, "ec", claims) },
+			wantErr: dchook.ErrJWTKeyNotFound,
+		},
+		{
+			name: "none",
+			token: func() string {
+				token := keys.sign(t, dchook.JWTAlgorithmEdDSA, "ed", claims)
+				header := b64.EncodeToString([]byte(`{"alg":"none","kid":"ed"}`))
+				return header + token[strings.Index(token, "."):]
+			},
+			wantErr: dchook.ErrJWTAlgorithm,
+		},
+		{
+			name: "changed claims",
+			token: func() string {
+				parts := strings.Split(keys.sign(t, dchook.JWTAlgorithmRS256, "rsa", claims), ".")
+				parts[1] = b64.EncodeToString([]byte(`{"sub":"repo:attacker/app"}`))
+				return strings.Join(parts, ".")
+			},
+			wantErr: dchook.ErrJWTSignature,
+		},
+		{
+			name:    "malformed",
+			token:   func() string { return "not.a-token" },
+			wantErr: dchook.ErrJWTMalformed,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			token, err := keys.jwks.VerifyJWT(testCase.token())
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("VerifyJWT() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if err == nil && token.StringClaim("sub") != claims["sub"] {
+				t.Errorf("VerifyJWT() sub = %q, want %q", token.StringClaim("sub"), claims["sub"])
+			}
+		})
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", "keys"},
+		{"no keys", `{"keys":[]}`},
+		{"only unsupported keys", `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`},
+		{"short RSA key", `{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`},
+		{"short Ed25519 key", `{"keys":[{"kty":"OKP","crv":"Ed25519","x":"AQAB"}]}`},
+		{
+			"P-256 point not on curve",
+			`{"keys":[{"kty":"EC","crv":"P-256",` +
+				`"x":"AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",` +
+				`"y":"AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}]}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := dchook.ParseJWKS([]byte(testCase.data)); !errors.Is(
+				err,
+				dchook.ErrJWKSInvalid,
+			) {
+				t.Errorf("ParseJWKS() error = %v, want %v", err, dchook.ErrJWKSInvalid)
+			}
+		})
+	}
+}
+
+func TestJWTValidateClaims(t *testing.T) {
+	t.Parallel()
+
+	keys := newJWTKeys(t)
+	now := time.Now()
+	issuer := "https://token.actions.githubusercontent.com"
+
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			claims: map[string]any{"iss": issuer, "aud": "dchook", "exp": now.Unix() + 60},
+		},
+		{
+			name: "audience list",
+			claims: map[string]any{
+				"iss": issuer,
+				"aud": []string{"other", "dchook"},
+				"exp": now.Unix() + 60,
+				"nbf": now.Unix() - 60,
+				"iat": now.Unix(),
+			},
+		},
+		{
+			name: "wrong issuer",
+			claims: map[string]any{
+				"iss": "https://gitlab.com",
+				"aud": "dchook",
+				"exp": now.Unix() + 60,
+			},
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			claims:  map[string]any{"iss": issuer, "aud": "other", "exp": now.Unix() + 60},
+			wantErr: true,
+		},
+		{
+			name:    "missing exp",
+			claims:  map[string]any{"iss": issuer, "aud": "dchook"},
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			claims:  map[string]any{"iss": issuer, "aud": "dchook", "exp": now.Unix() - 120},
+			wantErr: true,
+		},
+		{
+			name: "not yet valid",
+			claims: map[string]any{
+				"iss": issuer,
+				"aud": "dchook",
+				"exp": now.Unix() + 600,
+				"nbf": now.Unix() + 300,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			token, err := keys.jwks.VerifyJWT(
+				keys.sign(t, dchook.JWTAlgorithmEdDSA, "ed", testCase.claims),
+			)
+			if err != nil {
+				t.Fatalf("VerifyJWT() error = %v", err)
+			}
+
+			err = token.ValidateClaims(issuer, "dchook", now)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("ValidateClaims() error = %v, want error %v", err, testCase.wantErr)
+			}
+			if err != nil && !errors.Is(err, dchook.ErrJWTClaims) {
+				t.Errorf("ValidateClaims() error = %v, want %v", err, dchook.ErrJWTClaims)
+			}
+		})
+	}
+}
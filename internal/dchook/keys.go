@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	pemTypePublicKey  = "PUBLIC KEY"
+	pemTypePrivateKey = "PRIVATE KEY"
+)
+
+var (
+	errKeyNotPEM      = errors.New("key file must contain a PEM block")
+	errKeyPEMType     = errors.New("unexpected PEM block type")
+	errKeyNotEd25519  = errors.New("key is not an Ed25519 key")
+	errKeyTrailingPEM = errors.New("key file must contain exactly one PEM block")
+)
+
+// GenerateEd25519Signature signs the payload with an Ed25519 private key.
+// Returns the signature in the format "ed25519:hexsignature", or an empty string if the
+// key is invalid.
+func GenerateEd25519Signature(payload []byte, key ed25519.PrivateKey) string {
+	if len(key) != ed25519.PrivateKeySize {
+		return ""
+	}
+
+	return AlgorithmEd25519 + ":" + hex.EncodeToString(ed25519.Sign(key, payload))
+}
+
+// VerifyEd25519Signature checks if the Ed25519 signature matches the payload.
+// The signature is only accepted if AlgorithmEd25519 is in allowedAlgorithms.
+func VerifyEd25519Signature(
+	payload []byte,
+	signature string,
+	key ed25519.PublicKey,
+	allowedAlgorithms map[string]bool,
+) bool {
+	algorithm, signatureHex := parseSignature(signature)
+	if algorithm != AlgorithmEd25519 || !allowedAlgorithms[algorithm] {
+		return false
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	signatureBytes, err := hex.DecodeString(signatureHex)
+	if err != nil || len(signatureBytes) != ed25519.SignatureSize {
+		return false
+	}
+
+	return ed25519.Verify(key, payload, signatureBytes)
+}
+
+// ParseEd25519PublicKey parses a PEM-encoded PKIX ("PUBLIC KEY") Ed25519 public key, as
+// produced by `openssl pkey -pubout`.
+func ParseEd25519PublicKey(data []byte) (ed25519.PublicKey, error) {
+	der, err := decodeKeyPEM(data, pemTypePublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w (got %T)", errKeyNotEd25519, key)
+	}
+	return publicKey, nil
+}
+
+// ParseEd25519PrivateKey parses a PEM-encoded PKCS #8 ("PRIVATE KEY") Ed25519 private
+// key, as produced by `openssl genpkey -algorithm ed25519`.
+func ParseEd25519PrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	der, err := decodeKeyPEM(data, pemTypePrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w (got %T)", errKeyNotEd25519, key)
+	}
+	return privateKey, nil
+}
+
+// ReadPublicKeyFileStrict reads an Ed25519 public key file, applying the same checks as
+// ReadSecretFileStrict.
+func ReadPublicKeyFileStrict(path string) (ed25519.PublicKey, error) {
+	data, err := readSecretFile(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseEd25519PublicKey([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key file %q: %w", path, err)
+	}
+	return key, nil
+}
+
+// ReadPrivateKeyFileLax reads an Ed25519 private key file, applying the same checks as
+// ReadSecretFileLax.
+func ReadPrivateKeyFileLax(path string) (ed25519.PrivateKey, error) {
+	data, err := readSecretFile(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseEd25519PrivateKey([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key file %q: %w", path, err)
+	}
+	return key, nil
+}
+
+func decodeKeyPEM(data []byte, pemType string) ([]byte, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, errKeyNotPEM
+	}
+
+	if block.Type != pemType {
+		return nil, fmt.Errorf("%w: want %q, got %q", errKeyPEMType, pemType, block.Type)
+	}
+
+	if next, _ := pem.Decode(rest); next != nil {
+		return nil, errKeyTrailingPEM
+	}
+
+	return block.Bytes, nil
+}
@@ -0,0 +1,161 @@
+package dchook_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func generateEd25519Key(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey, privateKey
+}
+
+func encodePEM(t *testing.T, pemType string, key any) []byte {
+	t.Helper()
+	var der []byte
+	var err error
+	if pemType == "PUBLIC KEY" {
+		der, err = x509.MarshalPKIXPublicKey(key)
+	} else {
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der})
+}
+
+func TestVerifyEd25519Signature(t *testing.T) {
+	t.Parallel()
+	publicKey, privateKey := generateEd25519Key(t)
+	otherPublicKey, _ := generateEd25519Key(t)
+	payload := []byte(`{"test":"data"}`)
+	signature := dchook.GenerateEd25519Signature(payload, privateKey)
+	allowedAlgos := map[string]bool{"ed25519": true}
+
+	tests := []struct {
+		name      string
+		payload   []byte
+		signature string
+		key       ed25519.PublicKey
+		allowed   map[string]bool
+		want      bool
+	}{
+		{"valid", payload, signature, publicKey, allowedAlgos, true},
+		{"wrong key", payload, signature, otherPublicKey, allowedAlgos, false},
+		{"wrong payload", []byte(`{}`), signature, publicKey, allowedAlgos, false},
+		{
+			"algorithm not allowed",
+			payload,
+			signature,
+			publicKey,
+			map[string]bool{"sha256": true},
+			false,
+		},
+		{"missing key", payload, signature, nil, allowedAlgos, false},
+		{"not hex", payload, "ed25519:zz", publicKey, allowedAlgos, false},
+		{"truncated", payload, signature[:len(signature)-2], publicKey, allowedAlgos, false},
+		{
+			"hmac signature",
+			payload,
+			dchook.GenerateSignature(payload, "secret", "sha256"),
+			publicKey,
+			map[string]bool{"sha256": true, "ed25519": true},
+			false,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			got := dchook.VerifyEd25519Signature(
+				testCase.payload,
+				testCase.signature,
+				testCase.key,
+				testCase.allowed,
+			)
+			if got != testCase.want {
+				t.Errorf("VerifyEd25519Signature() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureRejectsEd25519(t *testing.T) {
+	t.Parallel()
+	_, privateKey := generateEd25519Key(t)
+	payload := []byte(`{"test":"data"}`)
+	signature := dchook.GenerateEd25519Signature(payload, privateKey)
+
+	if dchook.VerifySignature(payload, signature, "secret", map[string]bool{"ed25519": true}) {
+		t.Error("VerifySignature() accepted an Ed25519 signature")
+	}
+}
+
+func TestReadKeyFiles(t *testing.T) {
+	t.Parallel()
+	publicKey, privateKey := generateEd25519Key(t)
+	dir := t.TempDir()
+
+	publicPath := filepath.Join(dir, "deploy.pub")
+	if err := os.WriteFile(publicPath, encodePEM(t, "PUBLIC KEY", publicKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	privatePath := filepath.Join(dir, "deploy.key")
+	if err := os.WriteFile(
+		privatePath,
+		encodePEM(t, "PRIVATE KEY", privateKey),
+		0o600,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	gotPublic, err := dchook.ReadPublicKeyFileStrict(publicPath)
+	if err != nil {
+		t.Fatalf("ReadPublicKeyFileStrict() error = %v", err)
+	}
+	if !gotPublic.Equal(publicKey) {
+		t.Error("ReadPublicKeyFileStrict() returned a different key")
+	}
+
+	gotPrivate, err := dchook.ReadPrivateKeyFileLax(privatePath)
+	if err != nil {
+		t.Fatalf("ReadPrivateKeyFileLax() error = %v", err)
+	}
+	if !gotPrivate.Equal(privateKey) {
+		t.Error("ReadPrivateKeyFileLax() returned a different key")
+	}
+
+	// A private key is not a public key.
+	if _, err := dchook.ReadPublicKeyFileStrict(privatePath); err == nil {
+		t.Error("ReadPublicKeyFileStrict() accepted a private key")
+	}
+
+	insecurePath := filepath.Join(dir, "insecure.pub")
+	if err := os.WriteFile(
+		insecurePath,
+		encodePEM(t, "PUBLIC KEY", publicKey),
+		0o644,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dchook.ReadPublicKeyFileStrict(insecurePath); err == nil {
+		t.Error("ReadPublicKeyFileStrict() accepted a file with insecure permissions")
+	}
+
+	if _, err := dchook.ParseEd25519PublicKey([]byte("not a key")); err == nil {
+		t.Error("ParseEd25519PublicKey() accepted non-PEM data")
+	}
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// otlpTracesPath is appended to an OTLP endpoint without a path.
+	otlpTracesPath = "/v1/traces"
+	otlpTimeout    = 10 * time.Second
+
+	// otlpStatusError is the OTLP status code of a failed span.
+	otlpStatusError = 2
+
+	instrumentationScope = "github.com/halostatue/dchook"
+)
+
+var (
+	// ErrOTLPEndpoint is returned when an OTLP endpoint is not an http or https URL.
+	ErrOTLPEndpoint = errors.New("invalid OTLP endpoint")
+
+	// ErrOTLPExport is returned when the collector rejects exported spans.
+	ErrOTLPExport = errors.New("OTLP export rejected")
+)
+
+// OTLPExporter exports spans to an OpenTelemetry collector with OTLP/HTTP in the JSON
+// encoding.
+type OTLPExporter struct {
+	url            string
+	serviceName    string
+	serviceVersion string
+	client         *http.Client
+}
+
+// NewOTLPExporter creates an exporter for the OTLP/HTTP endpoint, such as
+// `http://localhost:4318`. Spans are sent to `/v1/traces` unless the endpoint has a
+// path. The service name and version identify the exporting process.
+func NewOTLPExporter(endpoint, serviceName, serviceVersion string) (*OTLPExporter, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrOTLPEndpoint, endpoint)
+	}
+
+	if parsed.Path == "" || parsed.Path == "/" {
+		parsed.Path = otlpTracesPath
+	}
+
+	return &OTLPExporter{
+		url:            parsed.String(),
+		serviceName:    serviceName,
+		serviceVersion: serviceVersion,
+		client:         &http.Client{Timeout: otlpTimeout},
+	}, nil
+}
+
+// URL returns the URL that spans are sent to.
+func (e *OTLPExporter) URL() string {
+	return e.url
+}
+
+// ExportSpans sends the spans in a single request.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return fmt.Errorf("encode spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send OTLP request: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // Best effort close in defer
+
+	//nolint:errcheck // The response body is not used
+	io.Copy(io.Discard, io.LimitReader(resp.Body, MaxPayloadSize))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: status %d", ErrOTLPExport, resp.StatusCode)
+	}
+	return nil
+}
+
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpAttribute struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+func (e *OTLPExporter) encode(spans []SpanData) otlpTraces {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		otlp := otlpSpan{
+			TraceID:           span.SpanContext.TraceID.String(),
+			SpanID:            span.SpanContext.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		}
+
+		if span.ParentSpanID != (SpanID{}) {
+			otlp.ParentSpanID = span.ParentSpanID.String()
+		}
+
+		for _, attribute := range span.Attributes {
+			otlp.Attributes = append(otlp.Attributes, encodeAttribute(attribute))
+		}
+
+		if span.Error != "" {
+			otlp.Status = &otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		encoded = append(encoded, otlp)
+	}
+
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			encodeAttribute(Attribute{Key: "service.name", Value: e.serviceName}),
+			encodeAttribute(Attribute{Key: "service.version", Value: e.serviceVersion}),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: instrumentationScope, Version: e.serviceVersion},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+// encodeAttribute encodes an attribute value as an OTLP AnyValue. 64-bit integers are
+// strings in the JSON encoding.
+func encodeAttribute(attribute Attribute) otlpAttribute {
+	var value map[string]any
+	switch v := attribute.Value.(type) {
+	case bool:
+		value = map[string]any{"boolValue": v}
+	case int:
+		value = map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case string:
+		value = map[string]any{"stringValue": v}
+	default:
+		value = map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+	return otlpAttribute{Key: attribute.Key, Value: value}
+}
@@ -0,0 +1,187 @@
+package dchook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestNewOTLPExporter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "http://localhost:4318", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "http://localhost:4318/", want: "http://localhost:4318/v1/traces"},
+		{
+			endpoint: "https://otel.example.com/otlp/traces",
+			want:     "https://otel.example.com/otlp/traces",
+		},
+		{endpoint: "localhost:4318", wantErr: true},
+		{endpoint: "grpc://localhost:4317", wantErr: true},
+		{endpoint: "http://", wantErr: true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.endpoint, func(t *testing.T) {
+			t.Parallel()
+
+			exporter, err := dchook.NewOTLPExporter(testCase.endpoint, "dchook", "1.2.3")
+			if testCase.wantErr {
+				if !errors.Is(err, dchook.ErrOTLPEndpoint) {
+					t.Errorf("NewOTLPExporter() error = %v, want %v", err, dchook.ErrOTLPEndpoint)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("NewOTLPExporter() error = %v", err)
+			}
+			if exporter.URL() != testCase.want {
+				t.Errorf("URL() = %q, want %q", exporter.URL(), testCase.want)
+			}
+		})
+	}
+}
+
+func TestOTLPExporterExportSpans(t *testing.T) {
+	t.Parallel()
+
+	type anyValue struct {
+		StringValue *string `json:"stringValue"`
+		IntValue    *string `json:"intValue"`
+		BoolValue   *bool   `json:"boolValue"`
+	}
+	type attribute struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []attribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string      `json:"traceId"`
+					SpanID            string      `json:"spanId"`
+					ParentSpanID      string      `json:"parentSpanId"`
+					Name              string      `json:"name"`
+					Kind              int         `json:"kind"`
+					StartTimeUnixNano string      `json:"startTimeUnixNano"`
+					Attributes        []attribute `json:"attributes"`
+					Status            *struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+
+	var path, contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &request)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	exporter, err := dchook.NewOTLPExporter(server.URL, "dchook", "1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := dchook.ParseTraceparent(
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 5)
+	err = exporter.ExportSpans(context.Background(), []dchook.SpanData{{
+		Name:         "docker compose pull",
+		Kind:         dchook.SpanKindInternal,
+		SpanContext:  dchook.SpanContext{TraceID: parent.TraceID, SpanID: dchook.SpanID{1}},
+		ParentSpanID: parent.SpanID,
+		StartTime:    start,
+		EndTime:      start.Add(time.Second),
+		Attributes: []dchook.Attribute{
+			{Key: "process.command_line", Value: "docker compose -f compose.yml pull"},
+			{Key: "process.exit.code", Value: 1},
+			{Key: "dchook.retried", Value: false},
+		},
+		Error: "docker compose command failed",
+	}})
+	if err != nil {
+		t.Fatalf("ExportSpans() error = %v", err)
+	}
+
+	if path != "/v1/traces" || contentType != "application/json" {
+		t.Errorf("request = %s %s, want /v1/traces application/json", path, contentType)
+	}
+
+	resource := request.ResourceSpans[0].Resource.Attributes
+	if resource[0].Key != "service.name" || *resource[0].Value.StringValue != "dchook" {
+		t.Errorf("resource attributes = %+v", resource)
+	}
+
+	span := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		span.SpanID != "0100000000000000" ||
+		span.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("span IDs = %s %s %s", span.TraceID, span.SpanID, span.ParentSpanID)
+	}
+	if span.Name != "docker compose pull" || span.Kind != int(dchook.SpanKindInternal) ||
+		span.StartTimeUnixNano != "1700000000000000005" {
+		t.Errorf("span = %+v", span)
+	}
+	if span.Status == nil || span.Status.Code != 2 ||
+		span.Status.Message != "docker compose command failed" {
+		t.Errorf("span status = %+v", span.Status)
+	}
+	if len(span.Attributes) != 3 ||
+		*span.Attributes[0].Value.StringValue != "docker compose -f compose.yml pull" ||
+		*span.Attributes[1].Value.IntValue != "1" ||
+		*span.Attributes[2].Value.BoolValue {
+		t.Errorf("span attributes = %+v", span.Attributes)
+	}
+}
+
+func TestOTLPExporterRejected(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	exporter, err := dchook.NewOTLPExporter(server.URL, "dchook", "1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := dchook.NewTracer(exporter, nil)
+	_, span := tracer.Start(context.Background(), "deploy", dchook.SpanKindClient)
+	span.End()
+
+	if err := tracer.Shutdown(context.Background()); !errors.Is(err, dchook.ErrOTLPExport) {
+		t.Errorf("Shutdown() error = %v, want %v", err, dchook.ErrOTLPExport)
+	}
+}
@@ -0,0 +1,223 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyHeaderTimeout bounds how long a trusted peer has to send the PROXY header.
+	proxyHeaderTimeout = 10 * time.Second
+
+	// proxyV1MaxLength is the maximum length of a PROXY protocol v1 header, including
+	// the CRLF.
+	proxyV1MaxLength = 107
+
+	proxyV2HeaderLength = 16
+	proxyV2Version      = 0x20
+	proxyV2CommandLocal = 0x00
+	proxyV2CommandProxy = 0x01
+	proxyV2FamilyTCP4   = 0x11
+	proxyV2FamilyTCP6   = 0x21
+	proxyV2TCP4Length   = 12
+	proxyV2TCP6Length   = 36
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrProxyHeader = errors.New("invalid PROXY protocol header")
+
+// NewProxyProtocolListener wraps a listener to read HAProxy PROXY protocol (v1 or v2)
+// headers from connections whose peer is one of the trusted proxies, replacing the remote
+// address of the connection with the source address in the header. Connections from
+// other peers are not parsed and keep their own remote address. Unix socket peers are
+// treated as loopback.
+func NewProxyProtocolListener(inner net.Listener, trusted []netip.Prefix) net.Listener {
+	return &proxyProtocolListener{Listener: inner, trusted: trusted}
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []netip.Prefix
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	peer := netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		peer = addr.AddrPort().Addr().Unmap()
+	}
+
+	for _, prefix := range l.trusted {
+		if prefix.Contains(peer) {
+			return &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+		}
+	}
+	return conn, nil
+}
+
+// proxyProtocolConn reads the PROXY header on first use, in the goroutine serving the
+// connection rather than in Accept.
+type proxyProtocolConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b) //nolint:wrapcheck // Transparent connection wrapper
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remoteAddr
+}
+
+func (c *proxyProtocolConn) readHeader() {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+
+		//nolint:errcheck // A failed deadline surfaces as a read error
+		_ = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		source, err := ReadProxyHeader(c.reader)
+		//nolint:errcheck // A failed deadline surfaces as a read error
+		_ = c.Conn.SetReadDeadline(time.Time{})
+
+		if err != nil {
+			c.err = err
+			c.Conn.Close() //nolint:errcheck,gosec // The connection is unusable
+			return
+		}
+
+		if source.IsValid() {
+			c.remoteAddr = net.TCPAddrFromAddrPort(source)
+		}
+	})
+}
+
+// ReadProxyHeader reads a PROXY protocol v1 or v2 header and returns the source address.
+// Returns the zero AddrPort for LOCAL (v2) and UNKNOWN (v1) headers, which carry no
+// client address, such as proxy health checks.
+func ReadProxyHeader(reader *bufio.Reader) (netip.AddrPort, error) {
+	prefix, err := reader.Peek(len(proxyV2Signature))
+	if err != nil && len(prefix) < len("PROXY ") {
+		return netip.AddrPort{}, fmt.Errorf("%w: %w", ErrProxyHeader, err)
+	}
+
+	if bytes.Equal(prefix, proxyV2Signature) {
+		return readProxyV2Header(reader)
+	}
+
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return readProxyV1Header(reader)
+	}
+	return netip.AddrPort{}, fmt.Errorf("%w: missing header", ErrProxyHeader)
+}
+
+// readProxyV1Header reads a text header such as
+// `PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n`.
+func readProxyV1Header(reader *bufio.Reader) (netip.AddrPort, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return netip.AddrPort{}, fmt.Errorf("%w: %w", ErrProxyHeader, err)
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	text, found := strings.CutSuffix(string(line), "\r\n")
+	if !found {
+		return netip.AddrPort{}, fmt.Errorf("%w: unterminated v1 header", ErrProxyHeader)
+	}
+
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return netip.AddrPort{}, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return netip.AddrPort{}, fmt.Errorf("%w: %q", ErrProxyHeader, text)
+	}
+
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil || addr.Is4() != (fields[1] == "TCP4") {
+		return netip.AddrPort{}, fmt.Errorf("%w: source address %q", ErrProxyHeader, fields[2])
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%w: source port %q", ErrProxyHeader, fields[4])
+	}
+	return netip.AddrPortFrom(addr, uint16(port)), nil
+}
+
+// readProxyV2Header reads a binary header: the signature, version and command, address
+// family, address length, and addresses.
+func readProxyV2Header(reader *bufio.Reader) (netip.AddrPort, error) {
+	header := make([]byte, proxyV2HeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%w: %w", ErrProxyHeader, err)
+	}
+
+	versionCommand, family := header[12], header[13]
+	addresses := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(reader, addresses); err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%w: %w", ErrProxyHeader, err)
+	}
+
+	if versionCommand&0xf0 != proxyV2Version {
+		return netip.AddrPort{}, fmt.Errorf("%w: version %#x", ErrProxyHeader, versionCommand)
+	}
+
+	switch versionCommand & 0x0f {
+	case proxyV2CommandLocal:
+		return netip.AddrPort{}, nil
+	case proxyV2CommandProxy:
+	default:
+		return netip.AddrPort{}, fmt.Errorf("%w: command %#x", ErrProxyHeader, versionCommand)
+	}
+
+	switch family {
+	case proxyV2FamilyTCP4:
+		if len(addresses) < proxyV2TCP4Length {
+			return netip.AddrPort{}, fmt.Errorf("%w: short TCP4 addresses", ErrProxyHeader)
+		}
+		addr := netip.AddrFrom4([4]byte(addresses[0:4]))
+		return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(addresses[8:])), nil
+	case proxyV2FamilyTCP6:
+		if len(addresses) < proxyV2TCP6Length {
+			return netip.AddrPort{}, fmt.Errorf("%w: short TCP6 addresses", ErrProxyHeader)
+		}
+		addr := netip.AddrFrom16([16]byte(addresses[0:16])).Unmap()
+		return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(addresses[32:])), nil
+	default:
+		// Other families (UDP, Unix) carry no usable client IP.
+		return netip.AddrPort{}, nil
+	}
+}
@@ -0,0 +1,159 @@
+package dchook_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+// proxyV2Header builds a PROXY protocol v2 header for a TCP source and destination.
+func proxyV2Header(command byte, source, destination netip.AddrPort) []byte {
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	family := byte(0x11)
+	if source.Addr().Is6() {
+		family = 0x21
+	}
+	header = append(header, 0x20|command, family)
+
+	addresses := append(source.Addr().AsSlice(), destination.Addr().AsSlice()...)
+	addresses = binary.BigEndian.AppendUint16(addresses, source.Port())
+	addresses = binary.BigEndian.AppendUint16(addresses, destination.Port())
+
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	t.Parallel()
+
+	destination := netip.MustParseAddrPort("198.51.100.1:443")
+
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "v1 TCP4",
+			header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			want:   "192.0.2.1:56324",
+		},
+		{
+			name:   "v1 TCP6",
+			header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			want:   "[2001:db8::1]:56324",
+		},
+		{
+			name:   "v1 UNKNOWN",
+			header: []byte("PROXY UNKNOWN\r\n"),
+			want:   "invalid AddrPort",
+		},
+		{
+			name:    "v1 mismatched family",
+			header:  []byte("PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 unterminated",
+			header:  []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 " + strings.Repeat("4", 80)),
+			wantErr: true,
+		},
+		{
+			name:   "v2 PROXY TCP4",
+			header: proxyV2Header(0x01, netip.MustParseAddrPort("192.0.2.1:56324"), destination),
+			want:   "192.0.2.1:56324",
+		},
+		{
+			name: "v2 PROXY TCP6",
+			header: proxyV2Header(
+				0x01,
+				netip.MustParseAddrPort("[2001:db8::1]:56324"),
+				netip.MustParseAddrPort("[2001:db8::2]:443"),
+			),
+			want: "[2001:db8::1]:56324",
+		},
+		{
+			name:   "v2 LOCAL",
+			header: proxyV2Header(0x00, netip.MustParseAddrPort("192.0.2.1:56324"), destination),
+			want:   "invalid AddrPort",
+		},
+		{
+			name:    "missing header",
+			header:  []byte("POST /deploy HTTP/1.1\r\n"),
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			reader := bufio.NewReader(io.MultiReader(
+				bytes.NewReader(testCase.header),
+				strings.NewReader("GET / HTTP/1.1\r\n"),
+			))
+
+			source, err := dchook.ReadProxyHeader(reader)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("ReadProxyHeader() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+			if testCase.wantErr {
+				return
+			}
+
+			if source.String() != testCase.want {
+				t.Errorf("ReadProxyHeader() = %v, want %v", source, testCase.want)
+			}
+
+			rest, err := reader.ReadString('\n')
+			if err != nil || rest != "GET / HTTP/1.1\r\n" {
+				t.Errorf("remaining data = %q, %v", rest, err)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	t.Parallel()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := dchook.NewProxyProtocolListener(inner, dchook.DefaultTrustedProxies)
+	t.Cleanup(func() { listener.Close() }) //nolint:errcheck,gosec // Test cleanup
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() }) //nolint:errcheck,gosec // Test cleanup
+
+	_, err = io.WriteString(client, "PROXY TCP4 203.0.113.5 127.0.0.1 40000 7999\r\nhello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck,gosec // Test cleanup
+
+	if got := conn.RemoteAddr().String(); got != "203.0.113.5:40000" {
+		t.Errorf("RemoteAddr() = %q, want 203.0.113.5:40000", got)
+	}
+
+	buffer := make([]byte, len("hello"))
+	if _, err := io.ReadFull(conn, buffer); err != nil || string(buffer) != "hello" {
+		t.Errorf("Read() = %q, %v, want hello", buffer, err)
+	}
+}
@@ -6,6 +6,14 @@ import (
 	"time"
 )
 
+const (
+	// MaxRequestAge and MaxRequestSkew bound how far in the past or future a signed
+	// request timestamp may be. A sender whose clock is more than MaxRequestSkew ahead
+	// of this host is rejected.
+	MaxRequestAge  = 5 * time.Minute
+	MaxRequestSkew = time.Minute
+)
+
 // RateLimiter tracks request rates and bans for IP addresses.
 type RateLimiter struct {
 	mutex           sync.Mutex
@@ -13,6 +21,7 @@ type RateLimiter struct {
 	failedRequests  map[string]int
 	bannedUntil     map[string]time.Time
 	seenTimestamps  map[int64]time.Time
+	seenNonces      map[string]time.Time // when each nonce may be forgotten
 	successLimit    int
 	successWindow   time.Duration
 	failLimit       int
@@ -32,6 +41,7 @@ func NewRateLimiter(
 		failedRequests:  make(map[string]int),
 		bannedUntil:     make(map[string]time.Time),
 		seenTimestamps:  make(map[int64]time.Time),
+		seenNonces:      make(map[string]time.Time),
 		successLimit:    successLimit,
 		successWindow:   successWindow,
 		failLimit:       failLimit,
@@ -62,10 +72,9 @@ func (limiter *RateLimiter) CheckReplay(timestamp int64) bool {
 	defer limiter.mutex.Unlock()
 
 	now := time.Now()
-	requestTime := time.UnixMicro(timestamp)
 
 	// Check if timestamp is too old or in the future
-	if requestTime.Before(now.Add(-5*time.Minute)) || requestTime.After(now.Add(1*time.Minute)) {
+	if !isFresh(time.UnixMicro(timestamp), now) {
 		return false
 	}
 
@@ -87,6 +96,78 @@ func (limiter *RateLimiter) CheckReplay(timestamp int64) bool {
 	return true
 }
 
+// FreshTimestamp reports whether a timestamp is recent and has not been recorded by
+// CheckReplay, without recording it.
+func (limiter *RateLimiter) FreshTimestamp(timestamp int64) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	_, seen := limiter.seenTimestamps[timestamp]
+	return !seen && isFresh(time.UnixMicro(timestamp), time.Now())
+}
+
+// CheckNonce checks if a nonce, such as a webhook delivery ID, has been seen within the
+// replay window. Unseen nonces are recorded and accepted; empty nonces are rejected.
+func (limiter *RateLimiter) CheckNonce(nonce string) bool {
+	return limiter.CheckNonceUntil(nonce, time.Time{})
+}
+
+// CheckNonceUntil checks a nonce like CheckNonce, but remembers it until the later of
+// until and the end of the replay window. Nonces of credentials that stay valid for
+// longer than the replay window, such as tokens, are remembered until they expire.
+func (limiter *RateLimiter) CheckNonceUntil(nonce string, until time.Time) bool {
+	if nonce == "" {
+		return false
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	if forgetAt, seen := limiter.seenNonces[nonce]; seen && now.Before(forgetAt) {
+		return false
+	}
+
+	// Clean up old nonces
+	for seenNonce, forgetAt := range limiter.seenNonces {
+		if !now.Before(forgetAt) {
+			delete(limiter.seenNonces, seenNonce)
+		}
+	}
+
+	limiter.seenNonces[nonce] = later(until, now.Add(limiter.replayWindow))
+	return true
+}
+
+// SeenNonce reports whether a nonce has been seen within the replay window, without
+// recording it. Requests that may be retried check their nonce with SeenNonce first and
+// record it with CheckNonce once they are accepted.
+func (limiter *RateLimiter) SeenNonce(nonce string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	forgetAt, seen := limiter.seenNonces[nonce]
+	return seen && time.Now().Before(forgetAt)
+}
+
+// CheckMessageReplay checks the created time and nonce of an HTTP message signature. The
+// created time must be recent and the nonce must not have been seen.
+func (limiter *RateLimiter) CheckMessageReplay(created time.Time, nonce string) bool {
+	return isFresh(created, time.Now()) && limiter.CheckNonce(nonce)
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func isFresh(requestTime, now time.Time) bool {
+	return !requestTime.Before(now.Add(-MaxRequestAge)) &&
+		!requestTime.After(now.Add(MaxRequestSkew))
+}
+
 // RecordSuccess records a successful request and returns false if rate limit exceeded.
 func (limiter *RateLimiter) RecordSuccess(ipAddress string) bool {
 	limiter.mutex.Lock()
@@ -115,8 +196,9 @@ func (limiter *RateLimiter) RecordSuccess(ipAddress string) bool {
 	return true
 }
 
-// RecordFailure records a failed request and bans the IP if threshold exceeded.
-func (limiter *RateLimiter) RecordFailure(ipAddress string) {
+// RecordFailure records a failed request and bans the IP if threshold exceeded. Returns
+// true if the IP was banned by this failure.
+func (limiter *RateLimiter) RecordFailure(ipAddress string) bool {
 	limiter.mutex.Lock()
 	defer limiter.mutex.Unlock()
 
@@ -129,5 +211,22 @@ func (limiter *RateLimiter) RecordFailure(ipAddress string) {
 			limiter.banDuration,
 			limiter.failedRequests[ipAddress],
 		)
+		return true
+	}
+	return false
+}
+
+// ActiveBans returns the number of IP addresses that are currently banned.
+func (limiter *RateLimiter) ActiveBans() int {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	active := 0
+	for _, banTime := range limiter.bannedUntil {
+		if now.Before(banTime) {
+			active++
+		}
 	}
+	return active
 }
@@ -44,16 +44,24 @@ func TestRateLimiterBan(t *testing.T) {
 	}
 
 	// First failure
-	limiter.RecordFailure(ipAddress)
+	if limiter.RecordFailure(ipAddress) {
+		t.Error("RecordFailure() should not report a ban after 1 failure")
+	}
 	if limiter.IsBanned(ipAddress) {
 		t.Error("IP should not be banned after 1 failure")
 	}
 
 	// Second failure should trigger ban
-	limiter.RecordFailure(ipAddress)
+	if !limiter.RecordFailure(ipAddress) {
+		t.Error("RecordFailure() should report a ban after 2 failures")
+	}
 	if !limiter.IsBanned(ipAddress) {
 		t.Error("IP should be banned after 2 failures")
 	}
+
+	if bans := limiter.ActiveBans(); bans != 1 {
+		t.Errorf("ActiveBans() = %d, want 1", bans)
+	}
 }
 
 func TestRateLimiterSuccessResetsFails(t *testing.T) {
@@ -106,3 +114,104 @@ func TestCheckReplay(t *testing.T) {
 		t.Error("Future timestamp should be rejected")
 	}
 }
+
+func TestFreshTimestamp(t *testing.T) {
+	t.Parallel()
+	limiter := dchook.NewRateLimiter(1, time.Minute, 2, time.Hour, 10*time.Minute)
+
+	timestamp := time.Now().UnixMicro()
+	if !limiter.FreshTimestamp(timestamp) || !limiter.FreshTimestamp(timestamp) {
+		t.Error("FreshTimestamp should accept a new timestamp without recording it")
+	}
+
+	if !limiter.CheckReplay(timestamp) || limiter.FreshTimestamp(timestamp) {
+		t.Error("FreshTimestamp should reject a recorded timestamp")
+	}
+
+	if limiter.FreshTimestamp(time.Now().Add(-10 * time.Minute).UnixMicro()) {
+		t.Error("FreshTimestamp should reject an old timestamp")
+	}
+}
+
+func TestCheckNonce(t *testing.T) {
+	t.Parallel()
+	limiter := dchook.NewRateLimiter(1, time.Minute, 2, time.Hour, 10*time.Minute)
+
+	if limiter.SeenNonce("72d3162e-cc78-11e3-81ab-4c9367dc0958") {
+		t.Error("New nonce should not be seen")
+	}
+
+	if limiter.SeenNonce("72d3162e-cc78-11e3-81ab-4c9367dc0958") {
+		t.Error("SeenNonce should not record the nonce")
+	}
+
+	if !limiter.CheckNonce("72d3162e-cc78-11e3-81ab-4c9367dc0958") {
+		t.Error("New nonce should be accepted")
+	}
+
+	if !limiter.SeenNonce("72d3162e-cc78-11e3-81ab-4c9367dc0958") {
+		t.Error("Recorded nonce should be seen")
+	}
+
+	if limiter.CheckNonce("72d3162e-cc78-11e3-81ab-4c9367dc0958") {
+		t.Error("Duplicate nonce should be rejected")
+	}
+
+	if !limiter.CheckNonce("a3b1c2d4-cc78-11e3-81ab-4c9367dc0958") {
+		t.Error("Different nonce should be accepted")
+	}
+
+	if limiter.CheckNonce("") {
+		t.Error("Empty nonce should be rejected")
+	}
+}
+
+func TestCheckNonceUntil(t *testing.T) {
+	t.Parallel()
+	limiter := dchook.NewRateLimiter(1, time.Minute, 2, time.Hour, time.Millisecond)
+
+	if !limiter.CheckNonceUntil("token-1", time.Now().Add(time.Hour)) {
+		t.Error("New nonce should be accepted")
+	}
+
+	if !limiter.CheckNonce("nonce-1") {
+		t.Error("New nonce should be accepted")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if limiter.CheckNonceUntil("token-1", time.Now().Add(time.Hour)) {
+		t.Error("Nonce should be remembered until it expires")
+	}
+
+	if !limiter.CheckNonce("nonce-1") {
+		t.Error("Nonce should be forgotten after the replay window")
+	}
+}
+
+func TestCheckMessageReplay(t *testing.T) {
+	t.Parallel()
+	limiter := dchook.NewRateLimiter(1, time.Minute, 2, time.Hour, 10*time.Minute)
+
+	now := time.Now()
+
+	if !limiter.CheckMessageReplay(now, "nonce-1") {
+		t.Error("Recent signature with new nonce should be accepted")
+	}
+
+	if limiter.CheckMessageReplay(now, "nonce-1") {
+		t.Error("Duplicate nonce should be rejected")
+	}
+
+	if limiter.CheckMessageReplay(now, "") {
+		t.Error("Missing nonce should be rejected")
+	}
+
+	if limiter.CheckMessageReplay(now.Add(-10*time.Minute), "nonce-2") {
+		t.Error("Old created time should be rejected")
+	}
+
+	if limiter.CheckMessageReplay(now.Add(2*time.Minute), "nonce-3") {
+		t.Error("Future created time should be rejected")
+	}
+}
@@ -12,12 +12,18 @@ import (
 	"strings"
 )
 
+// keySetFields is the number of fields on a key set line.
+const keySetFields = 2
+
 var (
 	errSecretSymlink         = errors.New("secret file must not be a symlink")
 	errSecretNotAbsolute     = errors.New("secret file must be an absolute path")
 	errSecretForbiddenDir    = errors.New("secret file is in forbidden system directory")
 	errSecretInsecurePerms   = errors.New("secret file has insecure permissions")
 	errSecretInvalidFileType = errors.New("secret file must be regular file or named pipe")
+	errKeySetLine            = errors.New("key set line must be \"key-id secret\"")
+	errKeySetDuplicate       = errors.New("duplicate key ID in key set")
+	errKeySetEmpty           = errors.New("key set contains no keys")
 )
 
 // ReadSecretFileStrict reads and validates a secret file with strict security checks.
@@ -46,6 +52,58 @@ func ReadSecretFileLax(path string) (string, error) {
 	return readSecretFile(path, false)
 }
 
+// ReadKeySetFileStrict reads a key set file holding several named secrets, applying the
+// same checks as ReadSecretFileStrict. See ParseKeySet for the file format.
+func ReadKeySetFileStrict(path string) (map[string]string, error) {
+	data, err := readSecretFile(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := ParseKeySet(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key set file %q: %w", path, err)
+	}
+	return keys, nil
+}
+
+// ParseKeySet parses key set data into a map of key ID to secret.
+//
+// Each non-blank line that does not start with `#` holds a key ID and a secret separated
+// by whitespace. Key IDs must be valid according to ValidateKeyID and unique. Secrets
+// may not contain whitespace.
+func ParseKeySet(data string) (map[string]string, error) {
+	keys := make(map[string]string)
+
+	for number, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != keySetFields {
+			return nil, fmt.Errorf("%w (line %d)", errKeySetLine, number+1)
+		}
+
+		keyID, secret := fields[0], fields[1]
+		if err := ValidateKeyID(keyID); err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+
+		if _, exists := keys[keyID]; exists {
+			return nil, fmt.Errorf("%w: %q (line %d)", errKeySetDuplicate, keyID, number+1)
+		}
+
+		keys[keyID] = secret
+	}
+
+	if len(keys) == 0 {
+		return nil, errKeySetEmpty
+	}
+	return keys, nil
+}
+
 func readSecretFile(path string, requireAbsolute bool) (string, error) {
 	validatedPath, err := validateSecretFile(path, requireAbsolute)
 	if err != nil {
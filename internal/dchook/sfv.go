@@ -0,0 +1,334 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A minimal RFC 8941 Structured Field Values parser for dictionaries whose members are
+// byte sequences or inner lists of strings, with string, token, or integer parameters.
+
+// sfParam is a structured field parameter. The value is an int64, a string, an sfToken,
+// or a bool.
+type sfParam struct {
+	name  string
+	value any
+}
+
+type sfToken string
+
+const maxIntegerDigits = 15
+
+// sfMember is a dictionary member holding either a byte sequence or an inner list.
+type sfMember struct {
+	key    string
+	bytes  []byte
+	items  []string
+	params []sfParam
+}
+
+type sfParser struct {
+	input string
+	pos   int
+}
+
+func parseDictionary(input string) ([]sfMember, error) {
+	parser := &sfParser{input: input}
+	parser.skipSpaces()
+
+	var members []sfMember
+	for !parser.done() {
+		member, err := parser.parseMember()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+
+		parser.skipSpaces()
+		if parser.done() {
+			break
+		}
+
+		if !parser.consume(',') {
+			return nil, fmt.Errorf("%w: expected ','", ErrMessageSignatureMalformed)
+		}
+		parser.skipSpaces()
+
+		if parser.done() {
+			return nil, fmt.Errorf("%w: trailing ','", ErrMessageSignatureMalformed)
+		}
+	}
+	return members, nil
+}
+
+func (p *sfParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *sfParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *sfParser) consume(c byte) bool {
+	if p.peek() == c && !p.done() {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sfParser) skipSpaces() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+}
+
+func (p *sfParser) parseMember() (sfMember, error) {
+	key, err := p.parseKey()
+	if err != nil {
+		return sfMember{}, err
+	}
+
+	member := sfMember{key: key}
+	if !p.consume('=') {
+		return sfMember{}, fmt.Errorf(
+			"%w: member %q has no value",
+			ErrMessageSignatureMalformed,
+			key,
+		)
+	}
+
+	switch p.peek() {
+	case ':':
+		member.bytes, err = p.parseByteSequence()
+	case '(':
+		member.items, err = p.parseInnerList()
+	default:
+		err = fmt.Errorf(
+			"%w: member %q is not a byte sequence or inner list",
+			ErrMessageSignatureMalformed,
+			key,
+		)
+	}
+	if err != nil {
+		return sfMember{}, err
+	}
+
+	member.params, err = p.parseParams()
+	return member, err
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	start := p.pos
+	c := p.peek()
+	if (c < 'a' || c > 'z') && c != '*' {
+		return "", fmt.Errorf("%w: invalid key at %d", ErrMessageSignatureMalformed, p.pos)
+	}
+
+	for !p.done() {
+		c = p.peek()
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			strings.IndexByte("_-.*", c) >= 0 {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos], nil
+}
+
+func (p *sfParser) parseByteSequence() ([]byte, error) {
+	p.pos++ // ':'
+	end := strings.IndexByte(p.input[p.pos:], ':')
+	if end < 0 {
+		return nil, fmt.Errorf("%w: unterminated byte sequence", ErrMessageSignatureMalformed)
+	}
+
+	encoded := p.input[p.pos : p.pos+end]
+	p.pos += end + 1
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid byte sequence: %w", ErrMessageSignatureMalformed, err)
+	}
+	return decoded, nil
+}
+
+func (p *sfParser) parseInnerList() ([]string, error) {
+	p.pos++ // '('
+	items := []string{}
+	for {
+		for p.peek() == ' ' {
+			p.pos++
+		}
+
+		if p.consume(')') {
+			return items, nil
+		}
+
+		if p.peek() != '"' {
+			return nil, fmt.Errorf(
+				"%w: inner list item at %d is not a string",
+				ErrMessageSignatureMalformed,
+				p.pos,
+			)
+		}
+
+		item, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() == ';' {
+			return nil, fmt.Errorf(
+				"%w: component parameters are not supported: %q",
+				ErrMessageSignatureMalformed,
+				item,
+			)
+		}
+		items = append(items, item)
+
+		if c := p.peek(); c != ' ' && c != ')' {
+			return nil, fmt.Errorf("%w: unterminated inner list", ErrMessageSignatureMalformed)
+		}
+	}
+}
+
+func (p *sfParser) parseParams() ([]sfParam, error) {
+	var params []sfParam
+	for p.consume(';') {
+		for p.peek() == ' ' {
+			p.pos++
+		}
+
+		name, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		param := sfParam{name: name, value: true}
+		if p.consume('=') {
+			param.value, err = p.parseBareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+func (p *sfParser) parseBareItem() (any, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		return p.parseString()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseInteger()
+	case c == '?':
+		p.pos++
+		switch {
+		case p.consume('1'):
+			return true, nil
+		case p.consume('0'):
+			return false, nil
+		}
+		return nil, fmt.Errorf("%w: invalid boolean at %d", ErrMessageSignatureMalformed, p.pos)
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '*':
+		start := p.pos
+		for !p.done() && isTokenChar(p.peek()) {
+			p.pos++
+		}
+		return sfToken(p.input[start:p.pos]), nil
+	default:
+		return nil, fmt.Errorf(
+			"%w: unsupported parameter value at %d",
+			ErrMessageSignatureMalformed,
+			p.pos,
+		)
+	}
+}
+
+func (p *sfParser) parseString() (string, error) {
+	p.pos++ // '"'
+	var value strings.Builder
+	for !p.done() {
+		c := p.input[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if p.done() || (p.peek() != '"' && p.peek() != '\\') {
+				return "", fmt.Errorf("%w: invalid escape in string", ErrMessageSignatureMalformed)
+			}
+			value.WriteByte(p.input[p.pos])
+			p.pos++
+		case c == '"':
+			return value.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", fmt.Errorf("%w: invalid character in string", ErrMessageSignatureMalformed)
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("%w: unterminated string", ErrMessageSignatureMalformed)
+}
+
+func (p *sfParser) parseInteger() (int64, error) {
+	start := p.pos
+	p.consume('-')
+	for !p.done() && p.peek() >= '0' && p.peek() <= '9' {
+		p.pos++
+	}
+
+	// Structured field integers have at most 15 digits
+	digits := strings.TrimPrefix(p.input[start:p.pos], "-")
+	if len(digits) == 0 || len(digits) > maxIntegerDigits {
+		return 0, fmt.Errorf("%w: invalid integer at %d", ErrMessageSignatureMalformed, start)
+	}
+	return strconv.ParseInt(p.input[start:p.pos], 10, 64)
+}
+
+func isTokenChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		strings.IndexByte("!#$%&'*+-.^_`|~:/", c) >= 0
+}
+
+// serializeInnerList serializes an inner list of strings with parameters.
+func serializeInnerList(items []string, params []sfParam) string {
+	var out strings.Builder
+	out.WriteByte('(')
+	for i, item := range items {
+		if i > 0 {
+			out.WriteByte(' ')
+		}
+		out.WriteString(serializeString(item))
+	}
+	out.WriteByte(')')
+
+	for _, param := range params {
+		out.WriteString(";" + param.name)
+		switch value := param.value.(type) {
+		case int64:
+			out.WriteString("=" + strconv.FormatInt(value, 10))
+		case string:
+			out.WriteString("=" + serializeString(value))
+		case sfToken:
+			out.WriteString("=" + string(value))
+		case bool:
+			if !value {
+				out.WriteString("=?0")
+			}
+		}
+	}
+	return out.String()
+}
+
+func serializeString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
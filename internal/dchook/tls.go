@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	errCertPoolEmpty       = errors.New("CA file contains no PEM certificates")
+	errCertFileNotAbsolute = errors.New("certificate file must be an absolute path")
+	errCertFileNotRegular  = errors.New("certificate file must be a regular file")
+)
+
+// ReadCertificateStrict reads a PEM certificate chain and its private key. The private
+// key is read with the same checks as ReadSecretFileStrict. The certificate chain is
+// public, so it only needs to be an absolute path to a regular file, which may be a
+// symlink.
+func ReadCertificateStrict(certPath, keyPath string) (*tls.Certificate, error) {
+	return readCertificate(certPath, keyPath, true)
+}
+
+// ReadCertificateLax reads a PEM certificate chain and its private key. The private key
+// is read with the same checks as ReadSecretFileLax. The certificate chain may be a
+// relative path or a symlink to a regular file.
+func ReadCertificateLax(certPath, keyPath string) (*tls.Certificate, error) {
+	return readCertificate(certPath, keyPath, false)
+}
+
+// ReadCertPoolStrict reads a bundle of PEM CA certificates from an absolute path to a
+// regular file, which may be a symlink.
+func ReadCertPoolStrict(path string) (*x509.CertPool, error) {
+	return readCertPool(path, true)
+}
+
+// ReadCertFileStrict reads a file of PEM certificates, such as a certificate chain or a
+// CA bundle, from an absolute path to a regular file, which may be a symlink.
+func ReadCertFileStrict(path string) ([]byte, error) {
+	return readCertFile(path, true)
+}
+
+// ReadCertPoolLax reads a bundle of PEM CA certificates from a regular file, which may
+// be a relative path or a symlink.
+func ReadCertPoolLax(path string) (*x509.CertPool, error) {
+	return readCertPool(path, false)
+}
+
+func readCertificate(certPath, keyPath string, requireAbsolute bool) (*tls.Certificate, error) {
+	certPEM, err := readCertFile(certPath, requireAbsolute)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := readSecretFile(keyPath, requireAbsolute)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := tls.X509KeyPair(certPEM, []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate %q or key %q: %w", certPath, keyPath, err)
+	}
+	return &certificate, nil
+}
+
+func readCertPool(path string, requireAbsolute bool) (*x509.CertPool, error) {
+	data, err := readCertFile(path, requireAbsolute)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%w: %q", errCertPoolEmpty, path)
+	}
+	return pool, nil
+}
+
+// readCertFile reads a file of public certificates. Unlike secret files, certificates
+// may be symlinks (such as certbot's live directory) and readable by anyone.
+func readCertFile(path string, requireAbsolute bool) ([]byte, error) {
+	if requireAbsolute && !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("%w: %q", errCertFileNotAbsolute, path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat certificate file %q: %w", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %q", errCertFileNotRegular, path)
+	}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file %q: %w", path, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,113 @@
+package dchook_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+// writeCertificate writes a self-signed certificate and its private key to the directory
+// and returns their paths.
+func writeCertificate(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	publicKey, privateKey := generateEd25519Key(t)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(nil, template, template, publicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath := filepath.Join(dir, name+".crt")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certPath, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(keyPath, encodePEM(t, "PRIVATE KEY", privateKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestReadCertificateFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certPath, keyPath := writeCertificate(t, dir, "server")
+	_, otherKeyPath := writeCertificate(t, dir, "other")
+
+	certificate, err := dchook.ReadCertificateStrict(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("ReadCertificateStrict() error = %v", err)
+	}
+	if certificate.Leaf == nil || certificate.Leaf.Subject.CommonName != "server" {
+		t.Errorf("ReadCertificateStrict() leaf = %v", certificate.Leaf)
+	}
+
+	if _, err := dchook.ReadCertificateLax(certPath, otherKeyPath); err == nil {
+		t.Error("ReadCertificateLax() accepted a key that does not match the certificate")
+	}
+
+	pool, err := dchook.ReadCertPoolStrict(certPath)
+	if err != nil {
+		t.Fatalf("ReadCertPoolStrict() error = %v", err)
+	}
+	if _, err := certificate.Leaf.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		t.Errorf("certificate does not verify with its own pool: %v", err)
+	}
+
+	if _, err := dchook.ReadCertPoolLax(keyPath); err == nil {
+		t.Error("ReadCertPoolLax() accepted a file without certificates")
+	}
+
+	// Certificates are public: they may be readable by anyone and symlinked.
+	if err := os.Chmod(certPath, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	certLink := filepath.Join(dir, "live.crt")
+	if err := os.Symlink(certPath, certLink); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dchook.ReadCertificateStrict(certLink, keyPath); err != nil {
+		t.Errorf("ReadCertificateStrict() with a symlinked certificate error = %v", err)
+	}
+	if _, err := dchook.ReadCertPoolLax(certLink); err != nil {
+		t.Errorf("ReadCertPoolLax() with a symlinked CA bundle error = %v", err)
+	}
+	if _, err := dchook.ReadCertFileStrict(certLink); err != nil {
+		t.Errorf("ReadCertFileStrict() with a symlinked certificate error = %v", err)
+	}
+	if _, err := dchook.ReadCertFileStrict("server.crt"); err == nil {
+		t.Error("ReadCertFileStrict() accepted a relative path")
+	}
+
+	keyLink := filepath.Join(dir, "live.key")
+	if err := os.Symlink(keyPath, keyLink); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dchook.ReadCertificateStrict(certPath, keyLink); err == nil {
+		t.Error("ReadCertificateStrict() accepted a symlinked key")
+	}
+
+	if err := os.Chmod(keyPath, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dchook.ReadCertificateStrict(certPath, keyPath); err == nil {
+		t.Error("ReadCertificateStrict() accepted a key with insecure permissions")
+	}
+}
@@ -0,0 +1,412 @@
+// SPDX-License-Identifier: Apache-2.0
+package dchook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, with their OTLP values.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+const (
+	// TraceparentHeader is the W3C Trace Context header that propagates the parent span.
+	TraceparentHeader = "Traceparent"
+
+	traceparentVersion = "00"
+	traceFlagSampled   = 0x01
+
+	// spanBatchSize is the number of ended spans that triggers an export before the
+	// export interval.
+	spanBatchSize = 512
+	// spanQueueLimit is the number of ended spans kept while the exporter is failing;
+	// further spans are dropped.
+	spanQueueLimit     = 4 * spanBatchSize
+	spanExportInterval = 5 * time.Second
+)
+
+// ErrTraceparent is returned when a traceparent header value is malformed.
+var ErrTraceparent = errors.New("invalid traceparent")
+
+type (
+	// TraceID identifies a trace.
+	TraceID [16]byte
+	// SpanID identifies a span within a trace.
+	SpanID [8]byte
+	// SpanKind is the role of a span in a trace.
+	SpanKind int
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the propagated identity of a span.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return traceparentVersion + "-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+// (`version-traceid-spanid-flags`). Unknown versions are accepted if the leading fields
+// are valid, as the specification requires.
+func ParseTraceparent(value string) (SpanContext, error) {
+	fields := strings.Split(strings.TrimSpace(value), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" ||
+		(fields[0] == traceparentVersion && len(fields) != 4) {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrTraceparent, value)
+	}
+
+	var sc SpanContext
+	version, err := hex.DecodeString(fields[0])
+	traceID, traceErr := hex.DecodeString(fields[1])
+	spanID, spanErr := hex.DecodeString(fields[2])
+	flags, flagsErr := hex.DecodeString(fields[3])
+	if err != nil || traceErr != nil || spanErr != nil || flagsErr != nil ||
+		len(version) != 1 || len(traceID) != len(sc.TraceID) ||
+		len(spanID) != len(sc.SpanID) || len(flags) != 1 ||
+		fields[1] != strings.ToLower(fields[1]) || fields[2] != strings.ToLower(fields[2]) {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrTraceparent, value)
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&traceFlagSampled != 0
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrTraceparent, value)
+	}
+	return sc, nil
+}
+
+// Attribute is a span attribute. Values are strings, integers, or booleans.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// SpanData is a snapshot of an ended span, as passed to a SpanExporter.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   []Attribute
+	// Error is the error message if the span failed.
+	Error string
+}
+
+// Attribute returns the value of the named attribute, or nil.
+func (d SpanData) Attribute(key string) any {
+	for _, attribute := range d.Attributes {
+		if attribute.Key == key {
+			return attribute.Value
+		}
+	}
+	return nil
+}
+
+// SpanExporter sends ended spans to a tracing backend.
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+}
+
+// Tracer creates spans and exports them in batches in the background. A nil Tracer
+// creates no spans.
+type Tracer struct {
+	exporter SpanExporter
+	onError  func(error)
+	mutex    sync.Mutex
+	queue    []SpanData
+	flush    chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTracer creates a tracer that exports ended spans with the exporter. Export errors
+// in the background are passed to onError, if set.
+func NewTracer(exporter SpanExporter, onError func(error)) *Tracer {
+	tracer := &Tracer{
+		exporter: exporter,
+		onError:  onError,
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go tracer.run()
+	return tracer
+}
+
+// Start starts a span as a child of the span in ctx or, failing that, of a remote span
+// context set with ContextWithRemoteSpanContext. The returned context carries the new
+// span. Returns ctx and a nil span if t is nil.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{tracer: t}
+	span.data.Name = name
+	span.data.Kind = kind
+	span.data.StartTime = time.Now()
+
+	parent := remoteSpanContext(ctx)
+	if parentSpan := SpanFromContext(ctx); parentSpan != nil {
+		parent = parentSpan.SpanContext()
+	}
+
+	if parent.IsValid() {
+		span.data.SpanContext.TraceID = parent.TraceID
+		span.data.SpanContext.Sampled = parent.Sampled
+		span.data.ParentSpanID = parent.SpanID
+	} else {
+		rand.Read(span.data.SpanContext.TraceID[:]) //nolint:errcheck // Never fails
+		span.data.SpanContext.Sampled = true
+	}
+	rand.Read(span.data.SpanContext.SpanID[:]) //nolint:errcheck // Never fails
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Shutdown exports the spans that have ended and stops the background export. Spans
+// ending after Shutdown are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.stopOnce.Do(func() { close(t.stop) })
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+	return t.export(ctx)
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(spanExportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.flush:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), spanExportInterval)
+		if err := t.export(ctx); err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		cancel()
+	}
+}
+
+// export sends the queued spans. On failure, the spans are requeued up to the queue
+// limit.
+func (t *Tracer) export(ctx context.Context) error {
+	t.mutex.Lock()
+	batch := t.queue
+	t.queue = nil
+	t.mutex.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := t.exporter.ExportSpans(ctx, batch); err != nil {
+		t.mutex.Lock()
+		t.queue = append(batch, t.queue...)
+		if len(t.queue) > spanQueueLimit {
+			t.queue = t.queue[len(t.queue)-spanQueueLimit:]
+		}
+		t.mutex.Unlock()
+		return fmt.Errorf("export %d spans: %w", len(batch), err)
+	}
+	return nil
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.queue) >= spanQueueLimit {
+		return
+	}
+	t.queue = append(t.queue, data)
+
+	if len(t.queue) >= spanBatchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Span is an operation in a trace. All methods are safe to call on a nil Span, which
+// records nothing.
+type Span struct {
+	tracer *Tracer
+	mutex  sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// StartSpan starts an internal span as a child of the span in ctx, with the tracer of
+// that span. Returns ctx and a nil span if ctx carries no span.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, SpanKindInternal)
+}
+
+// SpanContext returns the propagated identity of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute sets an attribute on the span. The value must be a string, an integer,
+// or a boolean.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, attribute := range s.data.Attributes {
+		if attribute.Key == key {
+			s.data.Attributes[i].Value = value
+			return
+		}
+	}
+	s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError marks the span as failed with the error.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End ends the span and queues it for export if it is sampled. Only the first call has
+// an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	s.mutex.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+type (
+	spanContextKey       struct{}
+	remoteSpanContextKey struct{}
+)
+
+// ContextWithSpan returns a context carrying the span, so that spans started from it are
+// children of the span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span) //nolint:errcheck // Type assertion
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a context carrying a span context propagated from
+// another process, such as from a traceparent header, as the parent of the next span.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanContextKey{}, sc)
+}
+
+func remoteSpanContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(remoteSpanContextKey{}).(SpanContext) //nolint:errcheck // Type assertion
+	return sc
+}
+
+// InMemoryExporter keeps exported spans in memory, for tests.
+type InMemoryExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+// ExportSpans appends the spans.
+func (e *InMemoryExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Spans returns the exported spans in the order they ended.
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
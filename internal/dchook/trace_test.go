@@ -0,0 +1,207 @@
+package dchook_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/halostatue/dchook/internal/dchook"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		value       string
+		wantSampled bool
+		wantErr     bool
+	}{
+		{
+			name:        "sampled",
+			value:       "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantSampled: true,
+		},
+		{
+			name:  "not sampled",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{
+			name:        "future version with extra field",
+			value:       "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			wantSampled: true,
+		},
+		{
+			name:    "version 00 with extra field",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			wantErr: true,
+		},
+		{
+			name:    "invalid version",
+			value:   "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "zero trace ID",
+			value:   "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "zero span ID",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			wantErr: true,
+		},
+		{
+			name:    "uppercase",
+			value:   "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "short trace ID",
+			value:   "00-4bf92f3577b34da6-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{name: "empty", value: "", wantErr: true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			sc, err := dchook.ParseTraceparent(testCase.value)
+			if testCase.wantErr {
+				if !errors.Is(err, dchook.ErrTraceparent) {
+					t.Errorf("ParseTraceparent() error = %v, want %v", err, dchook.ErrTraceparent)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseTraceparent() error = %v", err)
+			}
+			if sc.Sampled != testCase.wantSampled {
+				t.Errorf("Sampled = %v, want %v", sc.Sampled, testCase.wantSampled)
+			}
+			if got := sc.TraceID.String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("TraceID = %s", got)
+			}
+			if got := sc.SpanID.String(); got != "00f067aa0ba902b7" {
+				t.Errorf("SpanID = %s", got)
+			}
+		})
+	}
+}
+
+func TestSpanContextTraceparent(t *testing.T) {
+	t.Parallel()
+
+	const value = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := dchook.ParseTraceparent(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.Traceparent(); got != value {
+		t.Errorf("Traceparent() = %q, want %q", got, value)
+	}
+}
+
+func TestTracerSpans(t *testing.T) {
+	t.Parallel()
+
+	parent, err := dchook.ParseTraceparent(
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exporter := &dchook.InMemoryExporter{}
+	tracer := dchook.NewTracer(exporter, nil)
+
+	ctx := dchook.ContextWithRemoteSpanContext(context.Background(), parent)
+	ctx, server := tracer.Start(ctx, "POST /deploy", dchook.SpanKindServer)
+	_, child := dchook.StartSpan(ctx, "verify signature")
+	child.SetAttribute("dchook.auth.scheme", "dchook")
+	child.SetAttribute("dchook.auth.scheme", "rfc9421")
+	child.SetError(errors.New("bad signature"))
+	child.End()
+	child.End()
+	server.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+
+	verify, request := spans[0], spans[1]
+	if request.SpanContext.TraceID != parent.TraceID || request.ParentSpanID != parent.SpanID {
+		t.Errorf("server span is not a child of the remote parent: %+v", request)
+	}
+	if request.Kind != dchook.SpanKindServer {
+		t.Errorf("server span kind = %d", request.Kind)
+	}
+	if verify.SpanContext.TraceID != parent.TraceID ||
+		verify.ParentSpanID != request.SpanContext.SpanID {
+		t.Errorf("verify span is not a child of the server span: %+v", verify)
+	}
+	if verify.Kind != dchook.SpanKindInternal || verify.Error != "bad signature" {
+		t.Errorf("verify span = %+v", verify)
+	}
+	if len(verify.Attributes) != 1 || verify.Attribute("dchook.auth.scheme") != "rfc9421" {
+		t.Errorf("verify span attributes = %v", verify.Attributes)
+	}
+}
+
+func TestTracerNotSampled(t *testing.T) {
+	t.Parallel()
+
+	parent, err := dchook.ParseTraceparent(
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exporter := &dchook.InMemoryExporter{}
+	tracer := dchook.NewTracer(exporter, nil)
+
+	ctx := dchook.ContextWithRemoteSpanContext(context.Background(), parent)
+	_, span := tracer.Start(ctx, "POST /deploy", dchook.SpanKindServer)
+	if span.SpanContext().TraceID != parent.TraceID {
+		t.Errorf("unsampled span does not propagate the trace ID")
+	}
+	span.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if spans := exporter.Spans(); len(spans) != 0 {
+		t.Errorf("exported %d unsampled spans", len(spans))
+	}
+}
+
+func TestTracerNil(t *testing.T) {
+	t.Parallel()
+
+	var tracer *dchook.Tracer
+	ctx, span := tracer.Start(context.Background(), "deploy", dchook.SpanKindServer)
+	if span != nil || dchook.SpanFromContext(ctx) != nil {
+		t.Fatal("nil tracer started a span")
+	}
+
+	_, child := dchook.StartSpan(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.SetError(errors.New("failed"))
+	child.End()
+	if child.SpanContext().IsValid() {
+		t.Error("nil span has a valid span context")
+	}
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+}